@@ -0,0 +1,145 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+// bedrockHeaderSize defines the size of the header in front of the NBT
+// data in a Bedrock Edition level.dat file: the storage version and the
+// payload length, both as little endian 32 bit integers.
+const bedrockHeaderSize = 8
+
+// BedrockLevel describes the level.dat file for a Bedrock Edition world.
+//
+// Unlike the Java Edition file, it is not compressed. Its data is little
+// endian and preceded by a small header.
+type BedrockLevel struct {
+	Name                  string  `nbt:"LevelName"`
+	InventoryVersion      string  `nbt:"InventoryVersion"`
+	LastOpenedWithVersion []int32 `nbt:"lastOpenedWithVersion,omitempty"`
+	RandomSeed            int64   `nbt:"RandomSeed"`
+	LastPlayed            int64   `nbt:"LastPlayed"`
+	Time                  int64   `nbt:"Time"`
+	StorageVersion        int32   `nbt:"StorageVersion"`
+	NetworkVersion        int32   `nbt:"NetworkVersion"`
+	GameType              int32   `nbt:"GameType"`
+	Difficulty            int32   `nbt:"Difficulty"`
+	Generator             int32   `nbt:"Generator"`
+	SpawnX                int32   `nbt:"SpawnX"`
+	SpawnY                int32   `nbt:"SpawnY"`
+	SpawnZ                int32   `nbt:"SpawnZ"`
+}
+
+// LoadBedrockLevel loads level data from the given Bedrock Edition
+// level.dat file.
+func LoadBedrockLevel(file string) (*BedrockLevel, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	var l BedrockLevel
+
+	_, err = DecodeBedrockLevel(fd, &l)
+	if err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Save saves level data to the given file, in the Bedrock Edition format.
+// The header holds the level's storage version.
+func (l *BedrockLevel) Save(file string) error {
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	err = EncodeBedrockLevel(fd, l.StorageVersion, l)
+	if err != nil {
+		return err
+	}
+
+	return fd.Close()
+}
+
+// DecodeBedrockLevel reads a Bedrock Edition level.dat from r and decodes
+// its NBT data into v. This validates the header and returns the storage
+// version it holds.
+//
+// Use this instead of LoadBedrockLevel to decode into a custom type.
+func DecodeBedrockLevel(r io.Reader, v interface{}) (int32, error) {
+	var hdr [bedrockHeaderSize]byte
+
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return 0, fmt.Errorf("anvil: bedrock level: read header: %v", err)
+	}
+
+	version := int32(binary.LittleEndian.Uint32(hdr[:4]))
+	size := int32(binary.LittleEndian.Uint32(hdr[4:]))
+
+	if size < 0 {
+		return 0, fmt.Errorf("anvil: bedrock level: invalid payload size %d", size)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(size)+1))
+	if err != nil {
+		return 0, fmt.Errorf("anvil: bedrock level: %v", err)
+	}
+
+	if len(data) != int(size) {
+		return 0, fmt.Errorf("anvil: bedrock level: header declares %d bytes of data, have %d",
+			size, len(data))
+	}
+
+	dec := nbt.NewDecoder(bytes.NewReader(data))
+	dec.SetByteOrder(binary.LittleEndian)
+
+	err = dec.Decode(v)
+	if err != nil {
+		return 0, fmt.Errorf("anvil: bedrock level: %v", err)
+	}
+
+	return version, nil
+}
+
+// EncodeBedrockLevel writes v as a Bedrock Edition level.dat to w, with
+// the given storage version in the header.
+func EncodeBedrockLevel(w io.Writer, version int32, v interface{}) error {
+	var buf bytes.Buffer
+
+	enc := nbt.NewEncoder(&buf)
+	enc.SetByteOrder(binary.LittleEndian)
+
+	err := enc.Encode(v)
+	if err != nil {
+		return fmt.Errorf("anvil: bedrock level: %v", err)
+	}
+
+	var hdr [bedrockHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[:4], uint32(version))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(buf.Len()))
+
+	_, err = w.Write(hdr[:])
+	if err == nil {
+		_, err = w.Write(buf.Bytes())
+	}
+
+	return err
+}
@@ -0,0 +1,25 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+/*
+Package bedrock decodes chunk data stored by Minecraft Bedrock Edition.
+
+Bedrock worlds keep their chunks in a LevelDB database. Every part of a
+chunk, like each 16x16x16 sub chunk of blocks, is stored under its own key.
+The key holds the chunk coordinates, the dimension and a tag which tells
+what kind of data the value holds. Use ParseKey to read it, and
+DecodeSubChunk to decode the block storage of a sub chunk value:
+
+	k, ok := bedrock.ParseKey(key)
+	if !ok || k.Tag != bedrock.TagSubChunk {
+		return
+	}
+
+	sc, err := bedrock.DecodeSubChunk(k, value)
+	...
+	s := sc.Section()
+
+The resulting anvil.Section can be handled like those of Java Edition
+chunks. This package does not read LevelDB itself.
+*/
+package bedrock
@@ -0,0 +1,121 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package bedrock
+
+import "encoding/binary"
+
+// Tag defines the kind of data stored under a chunk key.
+type Tag byte
+
+// Known chunk key tags.
+const (
+	TagData3D          Tag = 0x2b // Heightmap and 3D biomes.
+	TagVersion         Tag = 0x2c // Chunk format version.
+	TagData2D          Tag = 0x2d // Heightmap and 2D biomes, before 1.18.
+	TagSubChunk        Tag = 0x2f // Block storage of a single sub chunk.
+	TagLegacyTerrain   Tag = 0x30 // Terrain of chunks saved before 1.0.
+	TagBlockEntity     Tag = 0x31 // Block entities, as concatenated NBT compounds.
+	TagEntity          Tag = 0x32 // Entities, before 1.18.30.
+	TagPendingTicks    Tag = 0x33 // Pending block ticks.
+	TagLegacyExtraData Tag = 0x34 // Extra block data, before 1.2.13.
+	TagBiomeState      Tag = 0x35 // Biome state.
+	TagFinalizedState  Tag = 0x36 // World generation state.
+	TagRandomTicks     Tag = 0x3a // Pending random ticks.
+	TagChecksums       Tag = 0x3b // Sub chunk checksums.
+	TagBlendingData    Tag = 0x40 // Chunk blending data.
+	TagActorDigest     Tag = 0x41 // Entity digest.
+	TagLegacyVersion   Tag = 0x76 // Chunk format version, before 1.16.100.
+)
+
+// known returns true if t is one of the known chunk key tags.
+func (t Tag) known() bool {
+	switch t {
+	case TagData3D, TagVersion, TagData2D, TagSubChunk, TagLegacyTerrain,
+		TagBlockEntity, TagEntity, TagPendingTicks, TagLegacyExtraData,
+		TagBiomeState, TagFinalizedState, TagRandomTicks, TagChecksums,
+		TagBlendingData, TagActorDigest, TagLegacyVersion:
+		return true
+	}
+
+	return false
+}
+
+// Dimension ids, as used in chunk keys.
+const (
+	Overworld = 0
+	Nether    = 1
+	End       = 2
+)
+
+// Key describes a chunk key. Keys are laid out as follows, with all
+// integers in little endian byte order:
+//
+//	x         int32
+//	z         int32
+//	dimension int32  Only present outside the overworld.
+//	tag       byte
+//	index     int8   Only present for TagSubChunk.
+type Key struct {
+	X, Z      int32 // Chunk coordinates.
+	Dimension int32 // Dimension id.
+	Tag       Tag   // Kind of data held by the value.
+	SubChunk  int8  // Vertical index of the sub chunk, for TagSubChunk.
+}
+
+// ParseKey parses the given LevelDB key. Returns false if it is not a
+// chunk key. Keys of other records, like "~local_player", have different
+// lengths and are rejected.
+func ParseKey(b []byte) (Key, bool) {
+	var k Key
+
+	switch len(b) {
+	case 9, 10, 13, 14:
+	default:
+		return k, false
+	}
+
+	k.X = int32(binary.LittleEndian.Uint32(b[0:]))
+	k.Z = int32(binary.LittleEndian.Uint32(b[4:]))
+	b = b[8:]
+
+	if len(b) >= 5 {
+		k.Dimension = int32(binary.LittleEndian.Uint32(b))
+		b = b[4:]
+	}
+
+	k.Tag = Tag(b[0])
+	if !k.Tag.known() {
+		return Key{}, false
+	}
+
+	// Only sub chunk keys carry an index.
+	if (len(b) == 2) != (k.Tag == TagSubChunk) {
+		return Key{}, false
+	}
+
+	if len(b) == 2 {
+		k.SubChunk = int8(b[1])
+	}
+
+	return k, true
+}
+
+// Bytes returns the LevelDB key for k.
+func (k Key) Bytes() []byte {
+	b := make([]byte, 0, 14)
+	b = binary.LittleEndian.AppendUint32(b, uint32(k.X))
+	b = binary.LittleEndian.AppendUint32(b, uint32(k.Z))
+
+	if k.Dimension != Overworld {
+		b = binary.LittleEndian.AppendUint32(b, uint32(k.Dimension))
+	}
+
+	b = append(b, byte(k.Tag))
+
+	if k.Tag == TagSubChunk {
+		b = append(b, byte(k.SubChunk))
+	}
+
+	return b
+}
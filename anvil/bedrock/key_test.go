@@ -0,0 +1,48 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package bedrock
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []Key{
+		{X: 3, Z: -7, Tag: TagVersion},
+		{X: -1, Z: 2, Tag: TagSubChunk, SubChunk: -4},
+		{X: 10, Z: 20, Dimension: Nether, Tag: TagBlockEntity},
+		{X: 0, Z: -300, Dimension: End, Tag: TagSubChunk, SubChunk: 5},
+	}
+
+	for _, want := range tests {
+		b := want.Bytes()
+
+		have, ok := ParseKey(b)
+		if !ok {
+			t.Fatalf("ParseKey(% x): rejected", b)
+		}
+
+		if have != want {
+			t.Fatalf("ParseKey(% x): have %+v, want %+v", b, have, want)
+		}
+
+		if !bytes.Equal(have.Bytes(), b) {
+			t.Fatalf("Bytes(%+v): have % x, want % x", have, have.Bytes(), b)
+		}
+	}
+
+	invalid := [][]byte{
+		[]byte("~local_player"),
+		{1, 0, 0, 0, 2, 0, 0, 0},
+		{1, 0, 0, 0, 2, 0, 0, 0, byte(TagSubChunk)},
+		{1, 0, 0, 0, 2, 0, 0, 0, byte(TagVersion), 0},
+	}
+
+	for _, b := range invalid {
+		if k, ok := ParseKey(b); ok {
+			t.Fatalf("ParseKey(% x): accepted as %+v", b, k)
+		}
+	}
+}
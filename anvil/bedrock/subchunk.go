@@ -0,0 +1,257 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package bedrock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/jteeuwen/mctools/anvil"
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+// subChunkVolume defines the number of blocks in a sub chunk.
+const subChunkVolume = 16 * 16 * 16
+
+// SubChunk holds the blocks of a 16x16x16 sub chunk.
+type SubChunk struct {
+	// Vertical index of the sub chunk. It spans the blocks from
+	// y = Y*16 to y = Y*16+15.
+	Y int8
+
+	// Block storages. The first holds the blocks themselves. A second
+	// layer, if present, holds the water in waterlogged blocks.
+	Layers []Layer
+}
+
+// Layer holds a single block storage of a sub chunk.
+type Layer struct {
+	// Block states used in this layer. Bedrock stores states with typed
+	// values; these are converted to strings. Numbers, including those
+	// used for boolean states, are written in decimal.
+	Palette []anvil.BlockState
+
+	// Palette index for every block. These are ordered like the blocks
+	// in a Java Edition section: the index for a block is y*256 + z*16 + x.
+	Indices [subChunkVolume]uint16
+}
+
+// State returns the block state at the given coordinates, relative to the
+// sub chunk (0-15).
+func (l *Layer) State(x, y, z int) anvil.BlockState {
+	n := l.Indices[y*256+z*16+x]
+	if int(n) >= len(l.Palette) {
+		return anvil.BlockState{Name: anvil.AirBlock}
+	}
+
+	return l.Palette[n]
+}
+
+// Section converts the first layer of the sub chunk into a paletted Java
+// Edition section. Block names and state properties are taken over as they
+// are; Bedrock and Java Edition do not always use the same ones.
+func (sc *SubChunk) Section() *anvil.Section {
+	s := &anvil.Section{
+		Y:           byte(sc.Y),
+		BlockStates: &anvil.BlockStates{Palette: []anvil.BlockState{{Name: anvil.AirBlock}}},
+	}
+
+	if len(sc.Layers) == 0 {
+		return s
+	}
+
+	l := &sc.Layers[0]
+
+	for i, n := range l.Indices {
+		if b := l.Palette[n]; b.Name != anvil.AirBlock {
+			s.BlockStates.Set(i, b)
+		}
+	}
+
+	return s
+}
+
+// DecodeSubChunk decodes the value stored under a sub chunk key.
+// This supports the paletted formats used since Bedrock 1.2.13, which
+// store their palettes as little endian NBT.
+func DecodeSubChunk(k Key, value []byte) (*SubChunk, error) {
+	sc, err := decodeSubChunk(k, value)
+	if err != nil {
+		return nil, fmt.Errorf("bedrock: sub chunk (%d %d %d): %v", k.X, k.SubChunk, k.Z, err)
+	}
+
+	return sc, nil
+}
+
+func decodeSubChunk(k Key, value []byte) (*SubChunk, error) {
+	if k.Tag != TagSubChunk {
+		return nil, fmt.Errorf("key tag %#x does not describe a sub chunk", byte(k.Tag))
+	}
+
+	r := bytes.NewReader(value)
+	sc := &SubChunk{Y: k.SubChunk}
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	count := byte(1)
+
+	switch version {
+	case 1:
+	case 8, 9:
+		count, err = r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if version == 9 {
+			y, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+
+			sc.Y = int8(y)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+
+	sc.Layers = make([]Layer, count)
+
+	for i := range sc.Layers {
+		err = decodeLayer(r, &sc.Layers[i])
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %v", i, err)
+		}
+	}
+
+	return sc, nil
+}
+
+// decodeLayer reads a single block storage from r.
+func decodeLayer(r *bytes.Reader, l *Layer) error {
+	hdr, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if hdr&1 != 0 {
+		return fmt.Errorf("runtime block ids are not supported")
+	}
+
+	bits := int(hdr >> 1)
+
+	switch bits {
+	case 0, 1, 2, 3, 4, 5, 6, 8, 16:
+	default:
+		return fmt.Errorf("invalid index size of %d bits", bits)
+	}
+
+	// Indices never span multiple words.
+	var words []uint32
+
+	if bits > 0 {
+		perWord := 32 / bits
+		words = make([]uint32, (subChunkVolume+perWord-1)/perWord)
+
+		err = binary.Read(r, binary.LittleEndian, words)
+		if err != nil {
+			return err
+		}
+	}
+
+	var size int32
+
+	err = binary.Read(r, binary.LittleEndian, &size)
+	if err != nil {
+		return err
+	}
+
+	if size < 1 || size > subChunkVolume || (bits == 0 && size != 1) {
+		return fmt.Errorf("invalid palette size %d", size)
+	}
+
+	l.Palette = make([]anvil.BlockState, size)
+
+	dec := nbt.NewDecoder(r)
+	dec.SetByteOrder(binary.LittleEndian)
+
+	for i := range l.Palette {
+		var e paletteEntry
+
+		err = dec.Decode(&e)
+		if err != nil {
+			return fmt.Errorf("palette entry %d: %v", i, err)
+		}
+
+		l.Palette[i] = e.state()
+	}
+
+	if bits == 0 {
+		return nil
+	}
+
+	perWord := 32 / bits
+	mask := uint32(1)<<uint(bits) - 1
+
+	for i := 0; i < subChunkVolume; i++ {
+		n := (words[i/perWord] >> (uint(i%perWord) * uint(bits))) & mask
+		if int(n) >= len(l.Palette) {
+			return fmt.Errorf("block %d: palette index %d out of range", i, n)
+		}
+
+		// Bedrock orders blocks by x, then z, then y.
+		x, z, y := i>>8, (i>>4)&15, i&15
+		l.Indices[y*256+z*16+x] = uint16(n)
+	}
+
+	return nil
+}
+
+// paletteEntry defines a single block state in a sub chunk palette.
+type paletteEntry struct {
+	Name    string       `nbt:"name"`
+	States  nbt.Compound `nbt:"states"`
+	Version int32        `nbt:"version"`
+}
+
+// state converts the palette entry to a Java Edition block state.
+func (e *paletteEntry) state() anvil.BlockState {
+	b := anvil.BlockState{Name: e.Name}
+
+	if len(e.States) == 0 {
+		return b
+	}
+
+	b.Properties = make(map[string]string, len(e.States))
+
+	for k, v := range e.States {
+		b.Properties[k] = stateValue(v)
+	}
+
+	return b
+}
+
+// stateValue returns the string form of a block state value.
+func stateValue(t nbt.Tag) string {
+	switch v := t.(type) {
+	case nbt.String:
+		return string(v)
+	case nbt.Byte:
+		return strconv.Itoa(int(v))
+	case nbt.Short:
+		return strconv.Itoa(int(v))
+	case nbt.Int:
+		return strconv.Itoa(int(v))
+	case nbt.Long:
+		return strconv.FormatInt(int64(v), 10)
+	}
+
+	return fmt.Sprint(t)
+}
@@ -0,0 +1,133 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package bedrock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+// testBlock returns the palette index used for the block at the given
+// coordinates in the test sub chunk.
+func testBlock(x, y, z int) int {
+	switch {
+	case y == 0:
+		return 1
+	case x == z:
+		return 2
+	}
+
+	return 0
+}
+
+// encodeTestSubChunk builds a version 9 sub chunk, whose single layer
+// stores indices using the given number of bits.
+func encodeTestSubChunk(t *testing.T, y int8, bits int) []byte {
+	var buf bytes.Buffer
+
+	buf.Write([]byte{9, 1, byte(y), byte(bits << 1)})
+
+	perWord := 32 / bits
+	words := make([]uint32, (subChunkVolume+perWord-1)/perWord)
+
+	for i := 0; i < subChunkVolume; i++ {
+		x, z, y := i>>8, (i>>4)&15, i&15
+		words[i/perWord] |= uint32(testBlock(x, y, z)) << (uint(i%perWord) * uint(bits))
+	}
+
+	binary.Write(&buf, binary.LittleEndian, words)
+
+	palette := []paletteEntry{
+		{Name: "minecraft:air", States: nbt.Compound{}},
+		{Name: "minecraft:stone", States: nbt.Compound{"stone_type": nbt.String("granite")}},
+		{Name: "minecraft:log", States: nbt.Compound{"pillar_axis": nbt.String("y"), "age": nbt.Int(3)}},
+	}
+
+	binary.Write(&buf, binary.LittleEndian, int32(len(palette)))
+
+	enc := nbt.NewEncoder(&buf)
+	enc.SetByteOrder(binary.LittleEndian)
+
+	for _, e := range palette {
+		err := enc.Encode(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecodeSubChunk(t *testing.T) {
+	k := Key{X: 1, Z: 2, Tag: TagSubChunk}
+
+	for _, bits := range []int{2, 3, 16} {
+		sc, err := DecodeSubChunk(k, encodeTestSubChunk(t, -4, bits))
+		if err != nil {
+			t.Fatalf("bits %d: %v", bits, err)
+		}
+
+		if sc.Y != -4 || len(sc.Layers) != 1 {
+			t.Fatalf("bits %d: have y %d with %d layers", bits, sc.Y, len(sc.Layers))
+		}
+
+		l := &sc.Layers[0]
+
+		if len(l.Palette) != 3 {
+			t.Fatalf("bits %d: have palette %v", bits, l.Palette)
+		}
+
+		log := l.Palette[2]
+		if log.Name != "minecraft:log" || log.Properties["pillar_axis"] != "y" || log.Properties["age"] != "3" {
+			t.Fatalf("bits %d: have state %+v", bits, log)
+		}
+
+		s := sc.Section()
+		if s.Y != byte(0xfc) {
+			t.Fatalf("bits %d: have section y %d", bits, s.Y)
+		}
+
+		for y := 0; y < 16; y++ {
+			for z := 0; z < 16; z++ {
+				for x := 0; x < 16; x++ {
+					want := l.Palette[testBlock(x, y, z)]
+
+					if have := l.State(x, y, z); !have.Equal(want) {
+						t.Fatalf("bits %d: (%d %d %d): have %v, want %v", bits, x, y, z, have, want)
+					}
+
+					if have := s.BlockStates.Get(y*256 + z*16 + x); !have.Equal(want) {
+						t.Fatalf("bits %d: section (%d %d %d): have %v, want %v", bits, x, y, z, have, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeSubChunkInvalid(t *testing.T) {
+	k := Key{Tag: TagSubChunk}
+	valid := encodeTestSubChunk(t, 0, 4)
+
+	tests := [][]byte{
+		{},
+		{7, 1},
+		{9, 1, 0, 4<<1 | 1},
+		{9, 1, 0, 7 << 1},
+		valid[:len(valid)-8],
+	}
+
+	for _, b := range tests {
+		if _, err := DecodeSubChunk(k, b); err == nil {
+			t.Fatalf("DecodeSubChunk(% x): expected an error", b[:min(len(b), 4)])
+		}
+	}
+
+	if _, err := DecodeSubChunk(Key{Tag: TagVersion}, valid); err == nil {
+		t.Fatalf("DecodeSubChunk: expected an error for a version key")
+	}
+}
@@ -0,0 +1,75 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBedrockLevel(t *testing.T) {
+	payload := []byte{
+		0x0a, 0x00, 0x00, // TAG_Compound("")
+		0x08, 0x09, 0x00, 'L', 'e', 'v', 'e', 'l', 'N', 'a', 'm', 'e',
+		0x04, 0x00, 't', 'e', 's', 't',
+		0x03, 0x06, 0x00, 'S', 'p', 'a', 'w', 'n', 'Y',
+		0x40, 0x00, 0x00, 0x00,
+		0x04, 0x0a, 0x00, 'R', 'a', 'n', 'd', 'o', 'm', 'S', 'e', 'e', 'd',
+		0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01,
+		0x00,
+	}
+
+	data := append([]byte{10, 0, 0, 0, byte(len(payload)), 0, 0, 0}, payload...)
+
+	var l BedrockLevel
+	version, err := DecodeBedrockLevel(bytes.NewReader(data), &l)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if version != 10 || l.Name != "test" || l.SpawnY != 64 || l.RandomSeed != 0x0102030405060708 {
+		t.Fatalf("unexpected level: version %d, %+v", version, l)
+	}
+
+	// The header must match the payload.
+	for _, bad := range [][]byte{data[:4], data[:len(data)-1], append(data, 0)} {
+		_, err = DecodeBedrockLevel(bytes.NewReader(bad), &l)
+		if err == nil {
+			t.Fatalf("expected error for %d bytes of input", len(bad))
+		}
+	}
+
+	_, err = DecodeBedrockLevel(bytes.NewReader(data[:len(data)-1]), &l)
+	if err == nil || !strings.Contains(err.Error(), "header declares") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Round trip through a file.
+	file := filepath.Join(t.TempDir(), "level.dat")
+
+	la := &BedrockLevel{
+		Name:                  "test",
+		StorageVersion:        10,
+		LastOpenedWithVersion: []int32{1, 20, 0, 1, 0},
+		RandomSeed:            -123,
+		SpawnY:                32767,
+	}
+
+	err = la.Save(file)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	lb, err := LoadBedrockLevel(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if !reflect.DeepEqual(la, lb) {
+		t.Fatalf("roundtrip mismatch:\nHave: %+v\nWant: %+v", lb, la)
+	}
+}
@@ -0,0 +1,106 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+// BlockEntity describes a block entity, like a chest, sign or mob spawner,
+// by the fields all of them share. X, Y and Z are world block coordinates.
+//
+// Raw holds the remaining, type-specific tags, like the Items of a chest.
+// Since these are kept as generic tags, block entity types which have no
+// struct of their own come through in full.
+type BlockEntity struct {
+	Id  string
+	X   int32
+	Y   int32
+	Z   int32
+	Raw nbt.Compound
+}
+
+// BlockEntities returns the block entities in the chunk, stored as
+// block_entities by Minecraft 1.18+ and as TileEntities before that.
+//
+// Raw is nil for tile entities which were not decoded, like those added
+// to TileEntities in code.
+func (c *Chunk) BlockEntities() []BlockEntity {
+	out := make([]BlockEntity, len(c.TileEntities))
+
+	for i := range c.TileEntities {
+		out[i] = c.TileEntities[i].blockEntity()
+	}
+
+	return out
+}
+
+// BlockEntitiesAt returns the block entities at the given world block
+// coordinates. A valid chunk has at most one for every block.
+func (c *Chunk) BlockEntitiesAt(x, y, z int) []BlockEntity {
+	var out []BlockEntity
+
+	for i := range c.TileEntities {
+		te := &c.TileEntities[i]
+
+		if int(te.X) == x && int(te.Y) == y && int(te.Z) == z {
+			out = append(out, te.blockEntity())
+		}
+	}
+
+	return out
+}
+
+// blockEntity returns the common fields of te, with all other tags in Raw.
+func (te *TileEntity) blockEntity() BlockEntity {
+	be := BlockEntity{Id: te.Id, X: te.X, Y: te.Y, Z: te.Z}
+
+	if te.Raw == nil {
+		return be
+	}
+
+	be.Raw = make(nbt.Compound, len(te.Raw))
+
+	for name, t := range te.Raw {
+		switch name {
+		case "id", "x", "y", "z":
+		default:
+			be.Raw[name] = t
+		}
+	}
+
+	return be
+}
+
+// UnmarshalNBT decodes the tile entity into its fields, and keeps all of
+// its tags in te.Raw.
+func (te *TileEntity) UnmarshalNBT(id nbt.TagId, r io.Reader) error {
+	if id != nbt.TagCompound {
+		return fmt.Errorf("tile entity must be a %s, not %s", nbt.TagCompound, id)
+	}
+
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// The payload lacks the header of the unnamed root compound.
+	data := append([]byte{byte(nbt.TagCompound), 0, 0}, payload...)
+
+	// This type does not have the UnmarshalNBT method.
+	type fields TileEntity
+
+	err = nbt.Unmarshal(bytes.NewReader(data), (*fields)(te))
+	if err != nil {
+		return err
+	}
+
+	te.Raw = nil
+	return nbt.Unmarshal(bytes.NewReader(data), &te.Raw)
+}
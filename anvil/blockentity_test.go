@@ -0,0 +1,107 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+func TestChunkBlockEntities(t *testing.T) {
+	type item struct {
+		Id    string `nbt:"id"`
+		Count int8   `nbt:"Count"`
+		Slot  int8   `nbt:"Slot"`
+	}
+
+	type chest struct {
+		Id    string `nbt:"id"`
+		X     int32  `nbt:"x"`
+		Y     int32  `nbt:"y"`
+		Z     int32  `nbt:"z"`
+		Items []item `nbt:"Items"`
+	}
+
+	type sign struct {
+		Id        string       `nbt:"id"`
+		X         int32        `nbt:"x"`
+		Y         int32        `nbt:"y"`
+		Z         int32        `nbt:"z"`
+		FrontText nbt.Compound `nbt:"front_text"`
+		Waxed     int8         `nbt:"is_waxed"`
+	}
+
+	// Layout of a chunk written by Minecraft 1.20: the block entities
+	// live in a lower case "block_entities" list.
+	raw := struct {
+		DataVersion   int32         `nbt:"DataVersion"`
+		X             int32         `nbt:"xPos"`
+		Z             int32         `nbt:"zPos"`
+		BlockEntities []interface{} `nbt:"block_entities"`
+	}{
+		DataVersion: 3465,
+		X:           -1,
+		Z:           2,
+		BlockEntities: []interface{}{
+			chest{"minecraft:chest", -5, 64, 40, []item{{"minecraft:diamond", 3, 0}}},
+			sign{"minecraft:oak_sign", -6, 70, 41, nbt.Compound{"color": nbt.String("black")}, 1},
+		},
+	}
+
+	cd := ChunkDescriptor{X: 31, Z: 2, scheme: ZLib}
+	if err := cd.encode(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	var c Chunk
+	if err := cd.read(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	list := c.BlockEntities()
+	if len(list) != 2 {
+		t.Fatalf("expected 2 block entities, have %d", len(list))
+	}
+
+	// The typed fields are filled as before.
+	if len(c.TileEntities[0].Items) != 1 || c.TileEntities[0].Items[0].Id != "minecraft:diamond" {
+		t.Fatalf("unexpected chest items %+v", c.TileEntities[0].Items)
+	}
+
+	if be := list[0]; be.Id != "minecraft:chest" || be.X != -5 || be.Y != 64 || be.Z != 40 {
+		t.Fatalf("unexpected chest %+v", be)
+	}
+
+	items, ok := list[0].Raw["Items"].(nbt.List)
+	if !ok || len(items.Items) != 1 {
+		t.Fatalf("unexpected chest items %#v", list[0].Raw["Items"])
+	}
+
+	// Tags without a field of their own are kept as well.
+	want := nbt.Compound{
+		"front_text": nbt.Compound{"color": nbt.String("black")},
+		"is_waxed":   nbt.Byte(1),
+	}
+
+	if !reflect.DeepEqual(list[1].Raw, want) {
+		t.Fatalf("sign mismatch:\nhave: %#v\nwant: %#v", list[1].Raw, want)
+	}
+
+	at := c.BlockEntitiesAt(-6, 70, 41)
+	if len(at) != 1 || at[0].Id != "minecraft:oak_sign" {
+		t.Fatalf("unexpected block entities at sign: %+v", at)
+	}
+
+	if at = c.BlockEntitiesAt(-6, 71, 41); len(at) != 0 {
+		t.Fatalf("unexpected block entities above sign: %+v", at)
+	}
+
+	// Tile entities created in code have no raw tags.
+	c.TileEntities = append(c.TileEntities, TileEntity{Id: "minecraft:furnace"})
+	if be := c.BlockEntities()[2]; be.Id != "minecraft:furnace" || be.Raw != nil {
+		t.Fatalf("unexpected furnace %+v", be)
+	}
+}
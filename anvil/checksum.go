@@ -0,0 +1,149 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// FileChecksum computes a 64 bit FNV-1a checksum over the region file on
+// disk. Changes which have not been saved yet are not included.
+//
+// The checksum covers the timestamp, length prefix, compression scheme
+// and data of every chunk, but not the padding between chunks, nor the
+// location of the chunks in the file. A region which is loaded and saved
+// again without changes keeps its checksum, even if its chunks end up
+// at different offsets.
+//
+// The file is read from start to end, once.
+func (r *Region) FileChecksum() (uint64, error) {
+	var sum uint64
+	var err error
+
+	if r.fsys != nil {
+		sum, err = fsChecksum(r.fsys, r.file)
+	} else {
+		sum, err = fileChecksum(r.file)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("anvil: r(%d %d): checksum: %v", r.X, r.Z, err)
+	}
+
+	return sum, nil
+}
+
+// fileChecksum computes the checksum for the given region file.
+func fileChecksum(file string) (uint64, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+
+	defer fd.Close()
+	return checksum(fd)
+}
+
+// fsChecksum computes the checksum for the given region file in fsys.
+func fsChecksum(fsys fs.FS, name string) (uint64, error) {
+	rs, err := openFS(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rs.Close()
+	return checksum(rs)
+}
+
+// checksum computes the checksum for the given region data.
+func checksum(fd io.ReadSeeker) (uint64, error) {
+	locations, timestamps, err := readHeader(fd)
+	if err != nil {
+		return 0, err
+	}
+
+	type entry struct {
+		index   int
+		offset  int
+		sectors int
+	}
+
+	var chunks []entry
+
+	for n := 0; n < len(locations)/4; n++ {
+		offset, sectors := readOffset(locations, n%ChunksPerRegion, n/ChunksPerRegion)
+		if offset != 0 || sectors != 0 {
+			chunks = append(chunks, entry{n, offset, sectors})
+		}
+	}
+
+	// Visit the chunks in file order, so we only ever read forward.
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].offset < chunks[j].offset
+	})
+
+	br := bufio.NewReaderSize(fd, sectorSize)
+	pos := int64(2 * sectorSize)
+	sums := make(map[int]uint64, len(chunks))
+
+	for _, c := range chunks {
+		start := int64(c.offset) * sectorSize
+		if start < pos {
+			return 0, fmt.Errorf("chunk %d overlaps the previous chunk or header", c.index)
+		}
+
+		_, err = br.Discard(int(start - pos))
+		if err != nil {
+			return 0, err
+		}
+
+		var prefix [4]byte
+		_, err = io.ReadFull(br, prefix[:])
+		if err != nil {
+			return 0, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(prefix[:]))
+		if size+4 > int64(c.sectors)*sectorSize {
+			return 0, fmt.Errorf("chunk %d: length %d exceeds %d sectors", c.index, size, c.sectors)
+		}
+
+		h := fnv.New64a()
+		h.Write(timestamps[c.index*4 : c.index*4+4])
+		h.Write(prefix[:])
+
+		_, err = io.CopyN(h, br, size)
+		if err != nil {
+			return 0, err
+		}
+
+		sums[c.index] = h.Sum64()
+		pos = start + 4 + size
+	}
+
+	// Combine the chunk sums in index order, which does not depend on
+	// the file layout.
+	h := fnv.New64a()
+
+	for n := 0; n < ChunksPerRegion*ChunksPerRegion; n++ {
+		sum, ok := sums[n]
+		if !ok {
+			continue
+		}
+
+		var buf [10]byte
+		binary.BigEndian.PutUint16(buf[:2], uint16(n))
+		binary.BigEndian.PutUint64(buf[2:], sum)
+		h.Write(buf[:])
+	}
+
+	return h.Sum64(), nil
+}
@@ -0,0 +1,75 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileChecksum(t *testing.T) {
+	const file = "../testdata/newworld/region/r.0.0.mca"
+
+	r, err := LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	want, err := r.FileChecksum()
+	if err != nil {
+		t.Fatalf("FileChecksum: %v", err)
+	}
+
+	if have, _ := r.FileChecksum(); have != want {
+		t.Fatalf("checksum not stable: have %x, want %x", have, want)
+	}
+
+	dir, err := ioutil.TempDir("", "anvil-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "r.0.0.mca")
+
+	err = r.SaveAs(dst)
+	if err != nil {
+		t.Fatalf("SaveAs: %v", err)
+	}
+
+	r2, err := LoadRegion(dst)
+	if err != nil {
+		t.Fatalf("Load copy: %v", err)
+	}
+
+	have, err := r2.FileChecksum()
+	if err != nil {
+		t.Fatalf("FileChecksum copy: %v", err)
+	}
+
+	if have != want {
+		t.Fatalf("checksum mismatch after save: have %x, want %x", have, want)
+	}
+
+	xz := r2.Chunks()
+	r2.chunks[chunkIndex(xz[0][0], xz[0][1])].LastModified = time.Unix(1000000000, 0)
+
+	err = r2.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	have, err = r2.FileChecksum()
+	if err != nil {
+		t.Fatalf("FileChecksum modified: %v", err)
+	}
+
+	if have == want {
+		t.Fatalf("checksum unchanged after modification")
+	}
+}
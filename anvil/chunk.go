@@ -3,14 +3,24 @@
 
 package anvil
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // Known chunk compression schemes.
 const (
-	GZip = 1
-	ZLib = 2
+	GZip         = 1
+	ZLib         = 2
+	Uncompressed = 3
+	LZ4          = 4 // Minecraft 1.20.5+, if enabled on the server.
 )
 
+// External is set in the compression scheme of a chunk whose data is too
+// large for the region file. The data is then stored in a separate
+// c.<x>.<z>.mcc file, next to the region.
+const External = 0x80
+
 // Tile Ticks represent block updates that need to happen because they could
 // not happen before the chunk was saved. Examples reasons for tile ticks
 // include redstone circuits needing to continue updating, water and lava
@@ -26,27 +36,49 @@ type TileTick struct {
 	T  int32  `nbt:"t"`
 	P  int32  `nbt:"p"`
 	X  int32  `nbt:"x"`
-	Y  int32  `nbt:"Y"`
-	Z  int32  `nbt:"Z"`
+	Y  int32  `nbt:"y"`
+	Z  int32  `nbt:"z"`
 }
 
 // Chunk represents a single chunk in a region file.
 //
+// DataVersion identifies the version of the game which wrote the chunk. It
+// is stored next to the chunk's Level tag. Minecraft upgrades chunks with
+// an older, or missing, version when it loads them. VersionForDataVersion
+// turns it into a release name.
+//
+// Minecraft 1.18+ no longer uses the Level tag. Chunks are read from either
+// layout, and written in the one matching their DataVersion.
+//
 // Reference: http://minecraft.gamepedia.com/Chunk_format
 type Chunk struct {
 	Entities         []Entity     `nbt:"Entities"`
 	TileEntities     []TileEntity `nbt:"TileEntities"`
 	TileTicks        []TileTick   `nbt:"TileTicks"`
 	Sections         []Section    `nbt:"Sections"`
-	Biomes           []int8       `nbt:"Biomes"`
+	Biomes           []byte       `nbt:"Biomes"`
 	HeightMap        []int32      `nbt:"HeightMap"`
+	Heightmaps       Heightmaps   `nbt:"Heightmaps,omitempty"`
 	LastUpdate       int64        `nbt:"LastUpdate"`
 	InhabitedTime    int64        `nbt:"InhabitedTime"`
 	X                int32        `nbt:"xPos"`
+	Y                int32        `nbt:"yPos,omitempty"`
 	Z                int32        `nbt:"zPos"`
 	V                int8         `nbt:"V"`
 	LightPopulated   bool         `nbt:"LightPopulated"`
 	TerrainPopulated bool         `nbt:"TerrainPopulated"`
+	Status           string       `nbt:"Status,omitempty"`
+	DataVersion      int32        `nbt:"-"`
+}
+
+// Position returns the chunk coordinates stored in the chunk itself. Y is
+// the index of the lowest section, which Minecraft 1.18+ stores as yPos.
+// It is 0 for older chunks.
+//
+// For an intact chunk, x and z match the position of the chunk in its
+// region. Region.ChunkPosition reads only these values.
+func (c *Chunk) Position() (x, y, z int32) {
+	return c.X, c.Y, c.Z
 }
 
 // Init initializes the chunk to a default, empty state.
@@ -58,7 +90,7 @@ func (c *Chunk) Init(x, z int) {
 	c.InhabitedTime = 0
 	c.LightPopulated = true
 	c.TerrainPopulated = true
-	c.Biomes = make([]int8, 256)
+	c.Biomes = make([]byte, 256)
 	c.HeightMap = make([]int32, 256)
 	c.Sections = make([]Section, 0, 16)
 	c.Entities = nil
@@ -100,6 +132,159 @@ func (c *Chunk) Section(y int, create bool) *Section {
 	return s
 }
 
+// SetBlock stores the given block state at the specified coordinates.
+// The x and z coordinates are relative to the chunk (0-15), while y is the
+// absolute height. This may be negative for worlds created by 1.18+.
+//
+// The section is created as a paletted section if it does not exist yet.
+// Existing sections of older versions are converted as described for
+// Section.SetState. Returns false if the coordinates are out of range.
+func (c *Chunk) SetBlock(x, y, z int, b BlockState) bool {
+	if x < 0 || x >= BlocksPerChunk || z < 0 || z >= BlocksPerChunk {
+		return false
+	}
+
+	s := c.paletteSection(y, true)
+	if s == nil {
+		return false
+	}
+
+	return s.SetState(x, mod(y, BlocksPerSection), z, b)
+}
+
+// BlockState returns the block state at the specified block coordinates.
+// The coordinates follow the same rules as SetBlock. This applies to chunks
+// written by Minecraft 1.13+, whose sections hold a block palette.
+//
+// Returns false if the coordinates are out of range or the section holding
+// them has no block states.
+func (c *Chunk) BlockState(x, y, z int) (BlockState, bool) {
+	if x < 0 || x >= BlocksPerChunk || z < 0 || z >= BlocksPerChunk {
+		return BlockState{}, false
+	}
+
+	s := c.paletteSection(y, false)
+	if s == nil {
+		return BlockState{}, false
+	}
+
+	return s.State(x, mod(y, BlocksPerSection), z)
+}
+
+// Biome returns the biome at the specified block coordinates.
+// The coordinates follow the same rules as SetBlock.
+//
+// Returns false if the coordinates are out of range or the section holding
+// them has no biome data.
+func (c *Chunk) Biome(x, y, z int) (string, bool) {
+	if x < 0 || x >= BlocksPerChunk || z < 0 || z >= BlocksPerChunk {
+		return "", false
+	}
+
+	s := c.paletteSection(y, false)
+	if s == nil {
+		return "", false
+	}
+
+	return s.Biome(x/BiomeCellSize, mod(y, BlocksPerSection)/BiomeCellSize, z/BiomeCellSize)
+}
+
+// BiomeAt returns the biome at the specified world block coordinates, as
+// stored by Minecraft 1.18+. Unlike Biome, x and z are absolute, so a map
+// renderer can look up any block without converting its position first.
+//
+// Returns an empty string if the coordinates lie outside the chunk or the
+// section holding them has no biome data.
+func (c *Chunk) BiomeAt(x, y, z int) string {
+	if cx, cz := BlockToChunk(x, z); cx != int(c.X) || cz != int(c.Z) {
+		return ""
+	}
+
+	biome, _ := c.Biome(mod(x, BlocksPerChunk), y, mod(z, BlocksPerChunk))
+	return biome
+}
+
+// SetBiome sets the biome for the 4x4x4 cell holding the specified block
+// coordinates. The coordinates follow the same rules as SetBlock.
+//
+// The section is created as a paletted section if it does not exist yet.
+// Returns false if the coordinates are out of range.
+func (c *Chunk) SetBiome(x, y, z int, biome string) bool {
+	if x < 0 || x >= BlocksPerChunk || z < 0 || z >= BlocksPerChunk {
+		return false
+	}
+
+	s := c.paletteSection(y, true)
+	if s == nil {
+		return false
+	}
+
+	return s.SetBiome(x/BiomeCellSize, mod(y, BlocksPerSection)/BiomeCellSize, z/BiomeCellSize, biome)
+}
+
+// Compact removes unused entries from the block palettes of all sections.
+// Vanilla Minecraft never shrinks a palette, so it will keep growing with
+// every edit made through SetBlock. Compact undoes this bloat without
+// changing any of the blocks themselves.
+//
+// Only sections with BlockStates, as written by Minecraft 1.18+ or changed
+// through SetBlock, are compacted. Refer to Section.Compact.
+func (c *Chunk) Compact() {
+	for i := range c.Sections {
+		c.Sections[i].Compact()
+	}
+}
+
+// paletteSection returns the section holding the given absolute y
+// coordinate. If create is true, an empty, paletted section is added if
+// it does not exist yet.
+func (c *Chunk) paletteSection(y int, create bool) *Section {
+	index := floorDiv(y, BlocksPerSection)
+	if index < math.MinInt8 || index > math.MaxInt8 {
+		return nil
+	}
+
+	for i := range c.Sections {
+		if int8(c.Sections[i].Y) == int8(index) {
+			return &c.Sections[i]
+		}
+	}
+
+	if !create {
+		return nil
+	}
+
+	c.Sections = append(c.Sections, Section{
+		Y: byte(int8(index)),
+		BlockStates: &BlockStates{
+			Palette: []BlockState{{Name: AirBlock}},
+		},
+		Biomes: &BiomePalette{
+			Palette: []string{DefaultBiome},
+		},
+	})
+
+	return &c.Sections[len(c.Sections)-1]
+}
+
+// floorDiv returns a/b, rounded towards negative infinity.
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// mod returns a modulo b, as a value in the range [0, b).
+func mod(a, b int) int {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
 // UpdateHeightmap refills the heightmap with current block data.
 // Each value in the heightmap records the lowest level in each column where
 // the light from the sky is at full strength.
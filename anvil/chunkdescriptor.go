@@ -7,8 +7,12 @@ import (
 	"bytes"
 	"compress/gzip"
 	"compress/zlib"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
+	"sync"
 	"time"
 
 	"github.com/jteeuwen/mctools/anvil/nbt"
@@ -19,77 +23,466 @@ type ChunkDescriptor struct {
 	data         []byte    // Compressed chunk data.
 	LastModified time.Time // Last time thischunk was modified.
 	X, Z         int       // Chunk coordinates in region.
+	sectors      int       // Sector count declared in the region header.
+	offset       int       // First sector in the region file, or 0 if not saved yet.
 	scheme       byte      // Compression scheme.
+	external     bool      // A .mcc file next to the region file holds the data.
+
+	// load reads the data on first use, for regions opened through
+	// LoadRegionLazy. It is nil once the data is in memory. The mutex
+	// lets concurrent readers of the chunk wait for the first one.
+	load func(*ChunkDescriptor) error
+	mu   sync.Mutex
+}
+
+// fill reads the chunk's data, if this has not happened yet.
+func (cd *ChunkDescriptor) fill() error {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	if cd.load == nil {
+		return nil
+	}
+
+	err := cd.load(cd)
+	if err != nil {
+		return err
+	}
+
+	cd.load = nil
+	return nil
 }
 
 // SectorCount returns the number of sectors this chunk occupies.
+// This includes the 5 byte length and compression scheme prefix.
+// Chunks stored in an external file take up a single sector.
+//
+// If the data of a lazily loaded chunk can not be read, this returns the
+// sector count declared in the region header.
 func (cd *ChunkDescriptor) SectorCount() int {
-	return int(math.Ceil(float64(len(cd.data)) / sectorSize))
+	n, err := cd.sectorCount()
+	if err != nil {
+		return cd.sectors
+	}
+
+	return n
+}
+
+// sectorCount is like SectorCount, but returns an error if the chunk's
+// data can not be read.
+func (cd *ChunkDescriptor) sectorCount() (int, error) {
+	external, err := cd.isExternal()
+	if err != nil {
+		return 0, err
+	}
+
+	if external {
+		return 1, nil
+	}
+
+	return int(math.Ceil(float64(len(cd.data)+chunkHeaderSize) / sectorSize)), nil
+}
+
+// isExternal returns true if the chunk's data is stored in a separate
+// .mcc file. This is the case if it does not fit in the maximum number
+// of sectors, or if its external file could not be loaded. Returns an
+// error if the data of a lazily loaded chunk can not be read.
+func (cd *ChunkDescriptor) isExternal() (bool, error) {
+	err := cd.fill()
+	if err != nil {
+		return false, err
+	}
+
+	return cd.scheme&External != 0 || len(cd.data)+chunkHeaderSize > maxChunkSectors*sectorSize, nil
 }
 
 // Read decompresses chunk data into the given structure.
 // Returns false if ther eis no data or the decompression failed.
 func (cd *ChunkDescriptor) Read(c *Chunk) bool {
-	var r io.ReadCloser
-	var err error
+	return cd.read(c) == nil
+}
+
+// read decompresses chunk data into the given structure.
+// Returns an error describing why the data could not be read.
+func (cd *ChunkDescriptor) read(c *Chunk) error {
+	r, err := cd.reader()
+	if err != nil {
+		return err
+	}
+
+	// Clear out existing data, but keep the slices around. The nbt decoder
+	// reuses their backing arrays, so reading many chunks into the same
+	// value produces little garbage.
+	c.Sections = c.Sections[:0]
+	c.Biomes = c.Biomes[:0]
+	c.HeightMap = c.HeightMap[:0]
+	c.Heightmaps = nil
+	c.Entities = c.Entities[:0]
+	c.TileEntities = c.TileEntities[:0]
+	c.TileTicks = c.TileTicks[:0]
+
+	// Only chunks written by Minecraft 1.18+ have a yPos tag.
+	c.Y = 0
+	c.Status = ""
+
+	// Minecraft 1.18+ no longer wraps the chunk in a Level tag, and uses
+	// lower case names for some of its tags. Either layout is decoded
+	// into c.
+	var v struct {
+		DataVersion int32 `nbt:"DataVersion"`
+		Level       *Chunk
+		*Chunk
+		Sections      *[]Section    `nbt:"sections"`
+		BlockEntities *[]TileEntity `nbt:"block_entities"`
+		BlockTicks    *[]TileTick   `nbt:"block_ticks"`
+		ProtoEntities *[]Entity     `nbt:"entities"`
+	}
+	v.Level = c
+	v.Chunk = c
+	v.Sections = &c.Sections
+	v.BlockEntities = &c.TileEntities
+	v.BlockTicks = &c.TileTicks
+	v.ProtoEntities = &c.Entities
+
+	err = nbt.Unmarshal(r, &v)
+	r.Close()
+
+	c.DataVersion = v.DataVersion
+	return err
+}
+
+// reader returns a reader yielding the decompressed chunk data.
+func (cd *ChunkDescriptor) reader() (io.ReadCloser, error) {
+	err := cd.fill()
+	if err != nil {
+		return nil, err
+	}
 
 	buf := bytes.NewBuffer(cd.data)
 
 	switch cd.scheme {
 	case GZip:
-		r, err = gzip.NewReader(buf)
+		return gzip.NewReader(buf)
 	case ZLib:
-		r, err = zlib.NewReader(buf)
-	default:
-		return false
+		return zlib.NewReader(buf)
+	case Uncompressed:
+		return ioutil.NopCloser(buf), nil
+	case LZ4:
+		return nbt.NewCompressedReader(buf, nbt.LZ4)
+	}
+
+	if validScheme(cd.scheme) {
+		return nil, fmt.Errorf("chunk at (%d,%d): data is stored in a missing external file", cd.X, cd.Z)
+	}
+
+	return nil, fmt.Errorf("chunk at (%d,%d): unknown compression %d", cd.X, cd.Z, cd.scheme)
+}
+
+// validScheme returns true if the given compression scheme is one of the
+// known schemes, optionally with the External flag set.
+func validScheme(scheme byte) bool {
+	switch scheme &^ External {
+	case GZip, ZLib, Uncompressed, LZ4:
+		return true
+	}
+
+	return false
+}
+
+// readDataVersion returns the data version of the chunk, without decoding
+// anything else. Returns 0 if the chunk has none.
+func (cd *ChunkDescriptor) readDataVersion() (int32, error) {
+	r, err := cd.reader()
+	if err != nil {
+		return 0, err
+	}
+
+	defer r.Close()
+
+	c, _, err := nbt.NewDecoder(r).Compound()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		name, id := c.Next()
+		if id == nbt.TagEnd {
+			return 0, c.Err()
+		}
+
+		if name == "DataVersion" && id == nbt.TagInt {
+			return c.Int()
+		}
+	}
+}
+
+// readPosition returns the chunk coordinates stored in the chunk, without
+// decoding anything else.
+func (cd *ChunkDescriptor) readPosition() (x, y, z int32, err error) {
+	r, err := cd.reader()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	defer r.Close()
+
+	c, _, err := nbt.NewDecoder(r).Compound()
+	if err != nil {
+		return 0, 0, 0, err
 	}
 
+	return readPosition(c)
+}
+
+// readPosition reads the xPos, yPos and zPos entries from c, or from the
+// Level compound in c, as written before Minecraft 1.18. Returns an error
+// if xPos or zPos is missing.
+func readPosition(c *nbt.CompoundReader) (x, y, z int32, err error) {
+	var found int
+
+	for found < 3 {
+		name, id := c.Next()
+
+		switch {
+		case id == nbt.TagEnd:
+			if c.Err() == nil && found < 2 {
+				return 0, 0, 0, errors.New("chunk has no position")
+			}
+			return x, y, z, c.Err()
+
+		case name == "Level" && id == nbt.TagCompound:
+			level, err := c.Compound()
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			return readPosition(level)
+
+		case name == "xPos" && id == nbt.TagInt:
+			x, err = c.Int()
+			found++
+		case name == "yPos" && id == nbt.TagInt:
+			y, err = c.Int()
+			found++
+		case name == "zPos" && id == nbt.TagInt:
+			z, err = c.Int()
+			found++
+		}
+
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	return x, y, z, nil
+}
+
+// readBiomes adds the names in the biome palettes of the chunk's sections
+// to set. Only the palettes are decoded; everything else is skipped.
+func (cd *ChunkDescriptor) readBiomes(set map[string]bool) error {
+	r, err := cd.reader()
 	if err != nil {
-		return false
+		return err
 	}
 
-	// Clear out existing data; the nbt decoder will append to the existing slices.
-	c.Sections = nil
-	c.Biomes = nil
-	c.HeightMap = nil
-	c.Entities = nil
-	c.TileEntities = nil
-	c.TileTicks = nil
+	defer r.Close()
+
+	type section struct {
+		Biomes struct {
+			Palette []string `nbt:"palette"`
+		} `nbt:"biomes"`
+	}
 
 	var v struct {
-		Level *Chunk
+		Level struct {
+			Sections []section `nbt:"Sections"`
+		}
+		Sections []section `nbt:"sections"` // Minecraft 1.18+
 	}
-	v.Level = c
 
 	err = nbt.Unmarshal(r, &v)
-	r.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range append(v.Level.Sections, v.Sections...) {
+		for _, name := range s.Biomes.Palette {
+			set[name] = true
+		}
+	}
+
+	return nil
+}
 
+// raw returns the decompressed, NBT encoded chunk data.
+func (cd *ChunkDescriptor) raw() ([]byte, error) {
+	r, err := cd.reader()
 	if err != nil {
-		return false
+		return nil, err
 	}
 
-	return true
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// setRaw compresses the given NBT encoded data and stores it as the
+// chunk's data, using the descriptor's compression scheme.
+func (cd *ChunkDescriptor) setRaw(data []byte) error {
+	cd.keepScheme()
+	cd.checkScheme()
+
+	var buf bytes.Buffer
+
+	w, err := nbt.NewCompressedWriter(&buf, nbt.Compression(cd.scheme))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	cd.LastModified = time.Now()
+	cd.data = buf.Bytes()
+	cd.sectors = 0
+	cd.offset = 0
+	return nil
 }
 
 // Write compresses the given chunk and writes the data into the current
-// chunk descriptor.
+// chunk descriptor. The descriptor's compression scheme is retained.
+//
+// The compressed output depends only on the chunk contents. Writing a chunk
+// which was read without modification yields the same bytes as writing it
+// the first time. Note that this means c.LastUpdate is not touched; it is
+// up to the caller to change it when needed.
 func (cd *ChunkDescriptor) Write(c *Chunk) bool {
+	return cd.write(c, 0)
+}
+
+// write compresses the given chunk data. The chunk is stamped with
+// dataVersion, if it has no data version of its own.
+//
+// Chunks with a data version of flatChunkVersion or newer are written in the
+// layout of Minecraft 1.18+. Older ones are wrapped in a Level tag.
+func (cd *ChunkDescriptor) write(c *Chunk, dataVersion int32) bool {
 	cd.LastModified = time.Now()
 
 	c.UpdateHeightmap()
-	c.LastUpdate = cd.LastModified.Unix()
 
-	var buf bytes.Buffer
-	w := zlib.NewWriter(&buf)
+	version := c.DataVersion
+	if version == 0 {
+		version = dataVersion
+	}
+
+	if version >= flatChunkVersion {
+		return cd.encode(newFlatChunk(c, version)) == nil
+	}
 
 	var v struct {
-		Level *Chunk
+		DataVersion int32 `nbt:"DataVersion,omitempty"`
+		Level       *Chunk
 	}
+	v.DataVersion = version
 	v.Level = c
 
-	err := nbt.Marshal(w, v)
-	w.Close()
+	return cd.encode(v) == nil
+}
+
+// flatChunk defines the layout of a chunk written by Minecraft 1.18+. The
+// tags of Chunk which this layout still uses are stored at the top level,
+// some of them under a new name. Biomes, HeightMap, V, LightPopulated and
+// TerrainPopulated are no longer used and left out.
+type flatChunk struct {
+	DataVersion   int32         `nbt:"DataVersion"`
+	X             int32         `nbt:"xPos"`
+	Y             int32         `nbt:"yPos"`
+	Z             int32         `nbt:"zPos"`
+	Status        string        `nbt:"Status,omitempty"`
+	LastUpdate    int64         `nbt:"LastUpdate"`
+	InhabitedTime int64         `nbt:"InhabitedTime"`
+	Sections      []flatSection `nbt:"sections"`
+	BlockEntities []TileEntity  `nbt:"block_entities"`
+	BlockTicks    []TileTick    `nbt:"block_ticks,omitempty"`
+	Entities      []Entity      `nbt:"entities,omitempty"`
+	Heightmaps    Heightmaps    `nbt:"Heightmaps,omitempty"`
+}
+
+// flatSection defines the layout of a section written by Minecraft 1.18+.
+// The numeric block ids are gone, and the light arrays are only present
+// for lit sections.
+type flatSection struct {
+	BlockStates *BlockStates  `nbt:"block_states"`
+	Biomes      *BiomePalette `nbt:"biomes"`
+	BlockLight  []uint8       `nbt:"BlockLight,omitempty"`
+	SkyLight    []uint8       `nbt:"SkyLight,omitempty"`
+	Y           byte          `nbt:"Y"`
+}
+
+// newFlatChunk returns the 1.18+ layout of c, with the given data version.
+func newFlatChunk(c *Chunk, version int32) *flatChunk {
+	sections := make([]flatSection, len(c.Sections))
+	for i := range c.Sections {
+		s := &c.Sections[i]
+		sections[i] = flatSection{
+			BlockStates: s.BlockStates,
+			Biomes:      s.Biomes,
+			BlockLight:  s.BlockLight,
+			SkyLight:    s.SkyLight,
+			Y:           s.Y,
+		}
+	}
+
+	return &flatChunk{
+		DataVersion:   version,
+		X:             c.X,
+		Y:             c.Y,
+		Z:             c.Z,
+		Status:        c.Status,
+		LastUpdate:    c.LastUpdate,
+		InhabitedTime: c.InhabitedTime,
+		Sections:      sections,
+		BlockEntities: c.TileEntities,
+		BlockTicks:    c.TileTicks,
+		Entities:      c.Entities,
+		Heightmaps:    c.Heightmaps,
+	}
+}
+
+// encode compresses the NBT encoding of v into the descriptor, using the
+// descriptor's compression scheme. See checkScheme.
+func (cd *ChunkDescriptor) encode(v interface{}) error {
+	cd.keepScheme()
+	cd.checkScheme()
+
+	var buf bytes.Buffer
+	err := nbt.MarshalCompressed(&buf, v, nbt.Compression(cd.scheme))
 
 	cd.data = buf.Bytes()
-	return err == nil
+	cd.sectors = 0
+	cd.offset = 0
+	return err
+}
+
+// keepScheme reads the data of a lazily loaded chunk which is about to be
+// replaced, so it keeps its compression scheme. The old data is dropped
+// either way.
+func (cd *ChunkDescriptor) keepScheme() {
+	cd.fill()
+	cd.load = nil
+}
+
+// checkScheme replaces an unknown compression scheme with ZLib, which is
+// what Minecraft uses by default.
+func (cd *ChunkDescriptor) checkScheme() {
+	cd.scheme &^= External
+
+	if !validScheme(cd.scheme) {
+		cd.scheme = ZLib
+	}
 }
@@ -0,0 +1,44 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+// ChunkPos defines the absolute coordinates of a single chunk.
+type ChunkPos struct {
+	X int32
+	Z int32
+}
+
+// PackChunkPos packs the given chunk coordinates into a single long,
+// the same way Minecraft does: X in the lower 32 bits, Z in the upper 32.
+//
+// This format is used for force-loaded chunk lists and some POI data.
+func PackChunkPos(x, z int32) int64 {
+	return int64(uint64(uint32(x)) | uint64(uint32(z))<<32)
+}
+
+// UnpackChunkPos returns the X and Z chunk coordinates packed into v.
+// It is the inverse of PackChunkPos.
+func UnpackChunkPos(v int64) (int32, int32) {
+	return int32(uint32(v)), int32(uint32(uint64(v) >> 32))
+}
+
+// BlockToChunk returns the coordinates of the chunk which holds the
+// block at the given world coordinates. Negative coordinates are rounded
+// down, so block -1 lies in chunk -1.
+func BlockToChunk(bx, bz int) (cx, cz int) {
+	return floorDiv(bx, BlocksPerChunk), floorDiv(bz, BlocksPerChunk)
+}
+
+// ChunkToRegion returns the coordinates of the region which holds the
+// chunk at the given world coordinates.
+func ChunkToRegion(cx, cz int) (rx, rz int) {
+	return floorDiv(cx, ChunksPerRegion), floorDiv(cz, ChunksPerRegion)
+}
+
+// ChunkToRegionLocal returns the position of the given chunk within its
+// region, in the range [0, 31]. These are the coordinates accepted by
+// Region.ReadChunk and friends.
+func ChunkToRegionLocal(cx, cz int) (lx, lz int) {
+	return mod(cx, ChunksPerRegion), mod(cz, ChunksPerRegion)
+}
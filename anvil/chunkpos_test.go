@@ -0,0 +1,88 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPackChunkPos(t *testing.T) {
+	tests := []struct {
+		x, z   int32
+		packed int64
+	}{
+		{0, 0, 0},
+		{1, 0, 1},
+		{0, 1, 1 << 32},
+		{-1, 0, 0x00000000ffffffff},
+		{0, -1, -1 << 32},
+		{-1, -1, -1},
+		{5, -7, -7<<32 | 5},
+		{math.MaxInt32, math.MinInt32, math.MinInt64 | math.MaxInt32},
+	}
+
+	for _, tt := range tests {
+		packed := PackChunkPos(tt.x, tt.z)
+		if packed != tt.packed {
+			t.Errorf("PackChunkPos(%d, %d): have %#x, want %#x",
+				tt.x, tt.z, packed, tt.packed)
+		}
+
+		x, z := UnpackChunkPos(packed)
+		if x != tt.x || z != tt.z {
+			t.Errorf("UnpackChunkPos(%#x): have (%d, %d), want (%d, %d)",
+				packed, x, z, tt.x, tt.z)
+		}
+	}
+}
+
+func TestBlockToChunk(t *testing.T) {
+	tests := []struct {
+		bx, bz int
+		cx, cz int
+	}{
+		{0, 0, 0, 0},
+		{15, 16, 0, 1},
+		{-1, -1, -1, -1},
+		{-16, -17, -1, -2},
+		{100, -100, 6, -7},
+	}
+
+	for _, tt := range tests {
+		cx, cz := BlockToChunk(tt.bx, tt.bz)
+		if cx != tt.cx || cz != tt.cz {
+			t.Errorf("BlockToChunk(%d, %d): have (%d, %d), want (%d, %d)",
+				tt.bx, tt.bz, cx, cz, tt.cx, tt.cz)
+		}
+	}
+}
+
+func TestChunkToRegion(t *testing.T) {
+	tests := []struct {
+		cx, cz int
+		rx, rz int
+		lx, lz int
+	}{
+		{0, 0, 0, 0, 0, 0},
+		{31, 32, 0, 1, 31, 0},
+		{-1, -1, -1, -1, 31, 31},
+		{-32, -33, -1, -2, 0, 31},
+		{70, -70, 2, -3, 6, 26},
+	}
+
+	for _, tt := range tests {
+		rx, rz := ChunkToRegion(tt.cx, tt.cz)
+		if rx != tt.rx || rz != tt.rz {
+			t.Errorf("ChunkToRegion(%d, %d): have (%d, %d), want (%d, %d)",
+				tt.cx, tt.cz, rx, rz, tt.rx, tt.rz)
+		}
+
+		lx, lz := ChunkToRegionLocal(tt.cx, tt.cz)
+		if lx != tt.lx || lz != tt.lz {
+			t.Errorf("ChunkToRegionLocal(%d, %d): have (%d, %d), want (%d, %d)",
+				tt.cx, tt.cz, lx, lz, tt.lx, tt.lz)
+		}
+	}
+}
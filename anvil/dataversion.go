@@ -0,0 +1,94 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import "sort"
+
+// flatChunkVersion is the data version of snapshot 21w43a, the first to
+// write chunks without a Level tag. Chunks with this version or a newer
+// one are written in that layout.
+const flatChunkVersion = 2844
+
+// dataVersions maps the data versions of Minecraft releases to their
+// names, in ascending order.
+var dataVersions = []struct {
+	version int32
+	name    string
+}{
+	{169, "1.9"},
+	{175, "1.9.1"},
+	{176, "1.9.2"},
+	{183, "1.9.3"},
+	{184, "1.9.4"},
+	{510, "1.10"},
+	{511, "1.10.1"},
+	{512, "1.10.2"},
+	{819, "1.11"},
+	{921, "1.11.1"},
+	{922, "1.11.2"},
+	{1139, "1.12"},
+	{1241, "1.12.1"},
+	{1343, "1.12.2"},
+	{1519, "1.13"},
+	{1628, "1.13.1"},
+	{1631, "1.13.2"},
+	{1952, "1.14"},
+	{1957, "1.14.1"},
+	{1963, "1.14.2"},
+	{1968, "1.14.3"},
+	{1976, "1.14.4"},
+	{2225, "1.15"},
+	{2227, "1.15.1"},
+	{2230, "1.15.2"},
+	{2566, "1.16"},
+	{2567, "1.16.1"},
+	{2578, "1.16.2"},
+	{2580, "1.16.3"},
+	{2584, "1.16.4"},
+	{2586, "1.16.5"},
+	{2724, "1.17"},
+	{2730, "1.17.1"},
+	{2860, "1.18"},
+	{2865, "1.18.1"},
+	{2975, "1.18.2"},
+	{3105, "1.19"},
+	{3117, "1.19.1"},
+	{3120, "1.19.2"},
+	{3218, "1.19.3"},
+	{3337, "1.19.4"},
+	{3463, "1.20"},
+	{3465, "1.20.1"},
+	{3578, "1.20.2"},
+	{3698, "1.20.3"},
+	{3700, "1.20.4"},
+	{3837, "1.20.5"},
+	{3839, "1.20.6"},
+	{3953, "1.21"},
+	{3955, "1.21.1"},
+	{4080, "1.21.2"},
+	{4082, "1.21.3"},
+	{4189, "1.21.4"},
+}
+
+// VersionForDataVersion returns the name of the Minecraft release with the
+// given data version, like "1.18.2" for 2975. Snapshots and other versions
+// in between releases yield the name of the preceding release with a "+"
+// appended, like "1.18.2+". Returns an empty string for versions before
+// 1.9, which is the first to record a data version.
+func VersionForDataVersion(dv int32) string {
+	i := sort.Search(len(dataVersions), func(i int) bool {
+		return dataVersions[i].version > dv
+	})
+
+	if i == 0 {
+		return ""
+	}
+
+	v := dataVersions[i-1]
+	if v.version == dv {
+		return v.name
+	}
+
+	return v.name + "+"
+}
@@ -0,0 +1,35 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import "testing"
+
+func TestVersionForDataVersion(t *testing.T) {
+	tests := []struct {
+		dv   int32
+		want string
+	}{
+		{0, ""},
+		{168, ""},
+		{169, "1.9"},
+		{1343, "1.12.2"},
+		{1519, "1.13"},
+		{2975, "1.18.2"},
+		{3000, "1.18.2+"},
+		{3465, "1.20.1"},
+		{99999, "1.21.4+"},
+	}
+
+	for _, tt := range tests {
+		if have := VersionForDataVersion(tt.dv); have != tt.want {
+			t.Errorf("VersionForDataVersion(%d): have %q, want %q", tt.dv, have, tt.want)
+		}
+	}
+
+	for i := 1; i < len(dataVersions); i++ {
+		if dataVersions[i].version <= dataVersions[i-1].version {
+			t.Fatalf("data versions out of order at %s", dataVersions[i].name)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Dimension defines the directory, relative to the world root, which
+// holds the region files for a single dimension.
+type Dimension string
+
+// Known dimensions.
+const (
+	DimensionOverworld Dimension = "region"
+	DimensionNether    Dimension = "DIM-1/region"
+	DimensionEnd       Dimension = "DIM1/region"
+)
+
+// Ids of the vanilla dimensions.
+const (
+	OverworldId = "minecraft:overworld"
+	NetherId    = "minecraft:the_nether"
+	EndId       = "minecraft:the_end"
+)
+
+// customDimensions defines the directory, relative to the world root,
+// which holds datapack defined dimensions. Their region files are found
+// in dimensions/<namespace>/<path>/region.
+const customDimensions = "dimensions"
+
+// regionDir defines the name of the directory holding the terrain regions
+// of a dimension.
+const regionDir = "region"
+
+// entitiesDir defines the name of the directory holding the entity regions
+// of a dimension.
+const entitiesDir = "entities"
+
+// poiDir defines the name of the directory holding the point of interest
+// regions of a dimension.
+const poiDir = "poi"
+
+// DimensionById returns the dimension for the given id, like
+// "minecraft:the_nether" or "mypack:mining". Ids without a namespace
+// use the "minecraft" namespace.
+//
+// Returns false if the id is malformed.
+func DimensionById(id string) (Dimension, bool) {
+	switch id {
+	case OverworldId:
+		return DimensionOverworld, true
+	case NetherId:
+		return DimensionNether, true
+	case EndId:
+		return DimensionEnd, true
+	}
+
+	ns, path := "minecraft", id
+	if n := strings.IndexByte(id, ':'); n > -1 {
+		ns, path = id[:n], id[n+1:]
+	}
+
+	if len(ns) == 0 || len(path) == 0 || strings.Contains(ns, "/") {
+		return "", false
+	}
+
+	for _, elem := range strings.Split(path, "/") {
+		if len(elem) == 0 || elem == "." || elem == ".." {
+			return "", false
+		}
+	}
+
+	return Dimension(customDimensions + "/" + ns + "/" + path + "/region"), true
+}
+
+// Id returns the id of the dimension, like "minecraft:overworld".
+// Returns an empty string if the dimension is not known.
+func (d Dimension) Id() string {
+	switch d {
+	case DimensionOverworld:
+		return OverworldId
+	case DimensionNether:
+		return NetherId
+	case DimensionEnd:
+		return EndId
+	}
+
+	path := filepath.ToSlash(string(d))
+	if !strings.HasPrefix(path, customDimensions+"/") || !strings.HasSuffix(path, "/region") {
+		return ""
+	}
+
+	path = strings.TrimSuffix(strings.TrimPrefix(path, customDimensions+"/"), "/region")
+
+	n := strings.IndexByte(path, '/')
+	if n < 1 || n == len(path)-1 {
+		return ""
+	}
+
+	return path[:n] + ":" + path[n+1:]
+}
+
+// Entities returns the directory, relative to the world root, which holds
+// the entity regions of the dimension. Minecraft 1.17+ stores entities
+// there, next to the region directory, rather than in the chunks.
+func (d Dimension) Entities() string {
+	return path.Join(path.Dir(filepath.ToSlash(string(d))), entitiesDir)
+}
+
+// Poi returns the directory, relative to the world root, which holds the
+// point of interest regions of the dimension, like beds and workstations.
+func (d Dimension) Poi() string {
+	return path.Join(path.Dir(filepath.ToSlash(string(d))), poiDir)
+}
+
+// ListDimensions returns all dimensions of the world at root which have
+// a region directory. The vanilla dimensions come first, followed by any
+// datapack defined dimensions, sorted by id.
+func ListDimensions(root string) ([]Dimension, error) {
+	if len(root) == 0 {
+		root = "."
+	}
+
+	return ListDimensionsFS(os.DirFS(root), ".")
+}
+
+// ListDimensionsFS is like ListDimensions, but finds the dimensions of the
+// world at root in fsys. The root is a slash separated path, as used by
+// io/fs.
+func ListDimensionsFS(fsys fs.FS, root string) ([]Dimension, error) {
+	var out []Dimension
+
+	for _, d := range []Dimension{DimensionOverworld, DimensionNether, DimensionEnd} {
+		if isDir(fsys, path.Join(root, string(d))) {
+			out = append(out, d)
+		}
+	}
+
+	base := path.Join(root, customDimensions)
+	if !isDir(fsys, base) {
+		return out, nil
+	}
+
+	var custom []Dimension
+
+	err := fs.WalkDir(fsys, base, func(file string, de fs.DirEntry, err error) error {
+		if err != nil || !de.IsDir() || de.Name() != "region" {
+			return err
+		}
+
+		rel := strings.TrimPrefix(file, base+"/")
+		d := Dimension(customDimensions + "/" + rel)
+		if d.Id() == "" {
+			return nil
+		}
+
+		custom = append(custom, d)
+		return fs.SkipDir
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(custom, func(i, j int) bool {
+		return custom[i].Id() < custom[j].Id()
+	})
+
+	return append(out, custom...), nil
+}
+
+// isDir returns true if the given path exists in fsys and is a directory.
+func isDir(fsys fs.FS, name string) bool {
+	fi, err := fs.Stat(fsys, name)
+	return err == nil && fi.IsDir()
+}
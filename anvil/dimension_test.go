@@ -0,0 +1,92 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDimensionById(t *testing.T) {
+	tests := []struct {
+		id  string
+		dim Dimension
+		ok  bool
+	}{
+		{OverworldId, DimensionOverworld, true},
+		{NetherId, DimensionNether, true},
+		{EndId, DimensionEnd, true},
+		{"mypack:mining", "dimensions/mypack/mining/region", true},
+		{"mypack:deep/caves", "dimensions/mypack/deep/caves/region", true},
+		{"custom", "dimensions/minecraft/custom/region", true},
+		{"", "", false},
+		{"mypack:", "", false},
+		{":mining", "", false},
+		{"my/pack:mining", "", false},
+		{"mypack:../mining", "", false},
+		{"mypack:a//b", "", false},
+	}
+
+	for _, tt := range tests {
+		dim, ok := DimensionById(tt.id)
+		if dim != tt.dim || ok != tt.ok {
+			t.Errorf("%q: have (%q, %v), want (%q, %v)", tt.id, dim, ok, tt.dim, tt.ok)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		want := tt.id
+		if want == "custom" {
+			want = "minecraft:custom"
+		}
+
+		if have := dim.Id(); have != want {
+			t.Errorf("%q: id mismatch: have %q, want %q", tt.id, have, want)
+		}
+	}
+
+	if id := Dimension("foo/region").Id(); id != "" {
+		t.Errorf("unexpected id %q for unknown dimension", id)
+	}
+}
+
+func TestListDimensions(t *testing.T) {
+	root := t.TempDir()
+
+	dirs := []string{
+		string(DimensionOverworld),
+		string(DimensionEnd),
+		"dimensions/zeta/world/region",
+		"dimensions/alpha/deep/caves/region",
+		"dimensions/alpha/broken",
+	}
+
+	for _, d := range dirs {
+		err := os.MkdirAll(filepath.Join(root, d), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	have, err := ListDimensions(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Dimension{
+		DimensionOverworld,
+		DimensionEnd,
+		"dimensions/alpha/deep/caves/region",
+		"dimensions/zeta/world/region",
+	}
+
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("dimensions mismatch:\nhave: %q\nwant: %q", have, want)
+	}
+}
@@ -0,0 +1,66 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+// EntityChunk holds the entities of a single chunk.
+//
+// Minecraft 1.17+ stores entities apart from the chunk's blocks, in region
+// files of their own. Refer to Dimension.Entities for their location. Older
+// versions keep entities in Chunk.Entities instead.
+type EntityChunk struct {
+	Entities    []Entity `nbt:"Entities"`
+	Position    []int32  `nbt:"Position"` // Absolute chunk coordinates: x, z.
+	DataVersion int32    `nbt:"DataVersion"`
+}
+
+// DecodeEntities decodes the entity chunk at the given coordinates. This
+// applies to entity regions. Returns ErrChunkAbsent if the region does not
+// hold the chunk.
+func (r *Region) DecodeEntities(x, z int, ec *EntityChunk) error {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return ErrChunkAbsent
+	}
+
+	err := cd.readEntities(ec)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): read entities: %v", r.X, r.Z, cd.X, cd.Z, err)
+	}
+
+	return nil
+}
+
+// WriteEntities compresses the given entity chunk, so it may later be
+// persisted using Region.Save(). This applies to entity regions.
+func (r *Region) WriteEntities(x, z int, ec *EntityChunk) bool {
+	cd := r.writable(x, z)
+	cd.LastModified = time.Now()
+
+	v := *ec
+	if v.DataVersion == 0 {
+		v.DataVersion = r.dataVersion
+	}
+
+	return cd.encode(&v) == nil
+}
+
+// readEntities decompresses entity chunk data into ec.
+func (cd *ChunkDescriptor) readEntities(ec *EntityChunk) error {
+	r, err := cd.reader()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	*ec = EntityChunk{}
+	return nbt.Unmarshal(r, ec)
+}
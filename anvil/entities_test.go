@@ -0,0 +1,67 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEntities(t *testing.T) {
+	if have := DimensionOverworld.Entities(); have != "entities" {
+		t.Fatalf("overworld entities: have %q", have)
+	}
+
+	if have := DimensionNether.Entities(); have != "DIM-1/entities" {
+		t.Fatalf("nether entities: have %q", have)
+	}
+
+	file := filepath.Join(t.TempDir(), "r.-1.0.mca")
+
+	r, err := CreateRegion(file)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	r.SetDataVersion(3465)
+
+	want := EntityChunk{
+		Entities: []Entity{
+			{Id: "minecraft:cow", Pos: []float64{-20.5, 64, 3.5}},
+			{Id: "minecraft:item", Pos: []float64{-18, 70, 2}},
+		},
+		Position: []int32{-31, 0},
+	}
+
+	if !r.WriteEntities(1, 0, &want) {
+		t.Fatalf("WriteEntities failed")
+	}
+
+	err = r.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	var have EntityChunk
+	err = r.DecodeEntities(1, 0, &have)
+	if err != nil {
+		t.Fatalf("DecodeEntities: %v", err)
+	}
+
+	want.DataVersion = 3465
+
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("entity chunk mismatch:\nhave: %+v\nwant: %+v", have, want)
+	}
+
+	if err := r.DecodeEntities(2, 0, &have); err != ErrChunkAbsent {
+		t.Fatalf("have %v, want %v", err, ErrChunkAbsent)
+	}
+}
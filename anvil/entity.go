@@ -5,6 +5,7 @@ package anvil
 
 import (
 	"github.com/jteeuwen/mctools/anvil/item"
+	"github.com/jteeuwen/mctools/anvil/nbt"
 )
 
 // Modifier defines an attribute modifier.
@@ -80,12 +81,14 @@ type CommandStats struct {
 // TileEntity describes a tile entity.
 //
 // These are part of Chunk descriptors and cover things like mob spawners
-// and chests.
+// and chests. Raw holds all tags of a decoded tile entity, including those
+// without a field here. It is not encoded; the fields are.
 type TileEntity struct {
-	Id string `nbt:"id"`
-	X  int32  `nbt:"x"`
-	Y  int32  `nbt:"y"`
-	Z  int32  `nbt:"z"`
+	Id  string       `nbt:"id"`
+	X   int32        `nbt:"x"`
+	Y   int32        `nbt:"y"`
+	Z   int32        `nbt:"z"`
+	Raw nbt.Compound `nbt:"-"`
 
 	// Chest fields.
 	Lock  string `nbt:"Lock"`
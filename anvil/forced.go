@@ -0,0 +1,84 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"compress/gzip"
+	"os"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+// ForcedChunks describes the data/chunks.dat file for a dimension.
+// It lists all chunks which have been force-loaded through the
+// /forceload command. These chunks are kept loaded at all times,
+// which makes them a common source of server lag.
+type ForcedChunks struct {
+	Chunks      []ChunkPos // Force-loaded chunks.
+	DataVersion int32      // Version of the game which wrote the file.
+}
+
+// forcedChunksData defines the NBT layout of a chunks.dat file.
+// Minecraft stores each chunk position packed into a single long.
+type forcedChunksData struct {
+	Data struct {
+		Forced []int64 `nbt:"Forced"`
+	} `nbt:"data"`
+	DataVersion int32 `nbt:"DataVersion,omitempty"`
+}
+
+// LoadForcedChunks loads the list of force-loaded chunks from the
+// given chunks.dat file.
+func LoadForcedChunks(file string) (*ForcedChunks, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	gz, err := gzip.NewReader(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	defer gz.Close()
+
+	var v forcedChunksData
+	err = nbt.Unmarshal(gz, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := &ForcedChunks{
+		Chunks:      make([]ChunkPos, len(v.Data.Forced)),
+		DataVersion: v.DataVersion,
+	}
+
+	for i, packed := range v.Data.Forced {
+		fc.Chunks[i].X, fc.Chunks[i].Z = UnpackChunkPos(packed)
+	}
+
+	return fc, nil
+}
+
+// Save saves the list of force-loaded chunks to the given file.
+func (fc *ForcedChunks) Save(file string) error {
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	var v forcedChunksData
+	v.DataVersion = fc.DataVersion
+	v.Data.Forced = make([]int64, len(fc.Chunks))
+
+	for i, cp := range fc.Chunks {
+		v.Data.Forced[i] = PackChunkPos(cp.X, cp.Z)
+	}
+
+	return nbt.MarshalGzip(fd, v)
+}
@@ -0,0 +1,38 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestForcedChunksRoundtrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chunks.dat")
+
+	want := &ForcedChunks{
+		Chunks: []ChunkPos{
+			{X: 0, Z: 0},
+			{X: 12, Z: -3},
+			{X: -1, Z: -1},
+			{X: -30000000 / 16, Z: 30000000 / 16},
+		},
+		DataVersion: 3465,
+	}
+
+	err := want.Save(file)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	have, err := LoadForcedChunks(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if !reflect.DeepEqual(want, have) {
+		t.Fatalf("roundtrip mismatch:\nHave: %+v\nWant: %+v", have, want)
+	}
+}
@@ -0,0 +1,206 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+// Names of the heightmaps maintained by RecomputeHeightmaps.
+const (
+	MotionBlocking = "MOTION_BLOCKING"
+	WorldSurface   = "WORLD_SURFACE"
+)
+
+// Heightmaps holds the heightmaps written by Minecraft 1.13+, by name.
+//
+// Each heightmap holds one value for every column in the chunk, ordered by
+// z, then x. A value is the height of the highest matching block in the
+// column plus one, relative to the bottom of the chunk. It is 0 if the
+// column has no matching block. The values are packed into longs, using
+// the smallest number of bits which can hold the chunk height. Values
+// never span multiple longs.
+type Heightmaps map[string][]int64
+
+// RecomputeHeightmaps regenerates the MOTION_BLOCKING and WORLD_SURFACE
+// heightmaps from the current block states. This keeps the heightmaps
+// correct after editing blocks with SetBlock. Other heightmaps are left as
+// they are.
+//
+// WORLD_SURFACE records the highest block which is not air. MOTION_BLOCKING
+// records the highest block for which opaque returns true. If opaque is
+// nil, it matches the same blocks as WORLD_SURFACE.
+//
+// The height of the chunk is taken from its paletted sections. For chunks
+// written by Minecraft, these span the full world height. Chunks without
+// paletted sections are left alone.
+func (c *Chunk) RecomputeHeightmaps(opaque func(BlockState) bool) {
+	if opaque == nil {
+		opaque = func(b BlockState) bool { return !isAir(b.Name) }
+	}
+
+	minY, maxY, ok := c.paletteRange()
+	if !ok {
+		return
+	}
+
+	height := (maxY - minY + 1) * BlocksPerSection
+	bits := bitLength(height)
+	size := packedLen(bits, BlocksPerChunk*BlocksPerChunk)
+
+	if c.Heightmaps == nil {
+		c.Heightmaps = make(Heightmaps)
+	}
+
+	motion := heightmapData(c.Heightmaps[MotionBlocking], size)
+	surface := heightmapData(c.Heightmaps[WorldSurface], size)
+
+	sections := make([]*Section, maxY-minY+1)
+	for i := range c.Sections {
+		if s := &c.Sections[i]; s.BlockStates != nil {
+			sections[int(int8(s.Y))-minY] = s
+		}
+	}
+
+	for z := 0; z < BlocksPerChunk; z++ {
+		for x := 0; x < BlocksPerChunk; x++ {
+			var m, w int
+
+			for y := height - 1; y >= 0 && (m == 0 || w == 0); y-- {
+				s := sections[y/BlocksPerSection]
+				if s == nil {
+					continue
+				}
+
+				b, _ := s.State(x, y%BlocksPerSection, z)
+
+				if w == 0 && !isAir(b.Name) {
+					w = y + 1
+				}
+
+				if opaque(b) {
+					m = y + 1
+				}
+			}
+
+			n := z*BlocksPerChunk + x
+			packIndex(motion, bits, n, m)
+			packIndex(surface, bits, n, w)
+		}
+	}
+
+	c.Heightmaps[MotionBlocking] = motion
+	c.Heightmaps[WorldSurface] = surface
+}
+
+// Heightmap unpacks the named heightmap, like MotionBlocking, into one
+// value per column, at index z*16 + x. The values are stored as described
+// for Heightmaps: add the bottom of the chunk and subtract one to get the y
+// coordinate of the block itself.
+//
+// The bit size follows from the height of the paletted sections, the same
+// way RecomputeHeightmaps picks it. Without those, it is taken from the
+// length of the data. Heightmaps written before Minecraft 1.16 let values
+// span multiple longs, and are read as well.
+//
+// Returns false if the chunk has no such heightmap, or its data does not
+// have a valid length.
+func (c *Chunk) Heightmap(name string) ([256]int16, bool) {
+	var out [256]int16
+
+	data, ok := c.Heightmaps[name]
+	if !ok {
+		return out, false
+	}
+
+	var bits int
+	var spanning bool
+
+	if minY, maxY, ok := c.paletteRange(); ok {
+		bits = bitLength((maxY - minY + 1) * BlocksPerSection)
+		spanning, ok = heightmapLayout(bits, len(data))
+		if !ok {
+			return out, false
+		}
+	} else if bits, spanning, ok = heightmapBits(len(data)); !ok {
+		return out, false
+	}
+
+	for i := range out {
+		if spanning {
+			out[i] = int16(unpackSpanning(data, bits, i))
+		} else {
+			out[i] = int16(unpackIndex(data, bits, i))
+		}
+	}
+
+	return out, true
+}
+
+// heightmapLayout returns whether a heightmap of n longs, holding values of
+// the given bit size, lets values span multiple longs. Returns false if
+// neither layout yields n longs.
+func heightmapLayout(bits, n int) (bool, bool) {
+	const columns = BlocksPerChunk * BlocksPerChunk
+
+	switch n {
+	case packedLen(bits, columns):
+		return false, true
+	case (columns*bits + 63) / 64:
+		return true, true
+	}
+
+	return false, false
+}
+
+// heightmapBits returns the smallest number of bits per value for which a
+// heightmap is packed into n longs, and whether the values span multiple
+// longs. Returns false if no bit size yields n longs.
+func heightmapBits(n int) (int, bool, bool) {
+	for bits := 1; bits <= 16; bits++ {
+		if spanning, ok := heightmapLayout(bits, n); ok {
+			return bits, spanning, true
+		}
+	}
+
+	return 0, false, false
+}
+
+// paletteRange returns the lowest and highest section index of all
+// paletted sections in the chunk. Returns false if there are none.
+func (c *Chunk) paletteRange() (int, int, bool) {
+	var minY, maxY int
+	var ok bool
+
+	for i := range c.Sections {
+		s := &c.Sections[i]
+		if s.BlockStates == nil {
+			continue
+		}
+
+		y := int(int8(s.Y))
+
+		if !ok || y < minY {
+			minY = y
+		}
+
+		if !ok || y > maxY {
+			maxY = y
+		}
+
+		ok = true
+	}
+
+	return minY, maxY, ok
+}
+
+// heightmapData returns a zeroed slice of size longs, reusing data if it
+// has the right size.
+func heightmapData(data []int64, size int) []int64 {
+	if len(data) != size {
+		return make([]int64, size)
+	}
+
+	for i := range data {
+		data[i] = 0
+	}
+
+	return data
+}
@@ -0,0 +1,146 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+func TestRecomputeHeightmaps(t *testing.T) {
+	var c Chunk
+	c.Init(0, 0)
+
+	// Three sections, spanning y -16 to 31.
+	c.SetBlock(0, -16, 0, BlockState{Name: AirBlock})
+	c.SetBlock(0, 31, 0, BlockState{Name: AirBlock})
+
+	c.SetBlock(0, -10, 0, BlockState{Name: "minecraft:stone"})
+	c.SetBlock(0, 20, 0, BlockState{Name: "minecraft:glass"})
+	c.SetBlock(5, 31, 3, BlockState{Name: "minecraft:stone"})
+
+	floor := []int64{1, 2, 3}
+	c.Heightmaps = Heightmaps{"OCEAN_FLOOR": floor}
+
+	c.RecomputeHeightmaps(func(b BlockState) bool {
+		return b.Name == "minecraft:stone"
+	})
+
+	const bits = 6 // Enough for a height of 48.
+
+	type column struct {
+		x, z    int
+		motion  int
+		surface int
+	}
+
+	for _, col := range []column{
+		{0, 0, 7, 37},
+		{5, 3, 48, 48},
+		{1, 0, 0, 0},
+	} {
+		n := col.z*BlocksPerChunk + col.x
+
+		if have := unpackIndex(c.Heightmaps[MotionBlocking], bits, n); have != col.motion {
+			t.Errorf("(%d %d): motion blocking mismatch: have %d, want %d", col.x, col.z, have, col.motion)
+		}
+
+		if have := unpackIndex(c.Heightmaps[WorldSurface], bits, n); have != col.surface {
+			t.Errorf("(%d %d): world surface mismatch: have %d, want %d", col.x, col.z, have, col.surface)
+		}
+	}
+
+	if len(c.Heightmaps[MotionBlocking]) != packedLen(bits, 256) {
+		t.Fatalf("heightmap size mismatch: have %d, want %d",
+			len(c.Heightmaps[MotionBlocking]), packedLen(bits, 256))
+	}
+
+	// Heightmaps survive a round trip, including ones we do not touch.
+	var buf bytes.Buffer
+	err := nbt.Marshal(&buf, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d Chunk
+	err = nbt.Unmarshal(&buf, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c.Heightmaps, d.Heightmaps) {
+		t.Fatalf("heightmap mismatch:\nhave: %v\nwant: %v", d.Heightmaps, c.Heightmaps)
+	}
+
+	if !reflect.DeepEqual(d.Heightmaps["OCEAN_FLOOR"], floor) {
+		t.Fatalf("unrelated heightmap changed: %v", d.Heightmaps["OCEAN_FLOOR"])
+	}
+}
+
+func TestChunkHeightmap(t *testing.T) {
+	var c Chunk
+	c.Init(0, 0)
+
+	c.SetBlock(0, -64, 0, BlockState{Name: "minecraft:bedrock"})
+	c.SetBlock(15, 70, 2, BlockState{Name: "minecraft:stone"})
+	c.SetBlock(3, 319, 9, BlockState{Name: "minecraft:stone"})
+	c.RecomputeHeightmaps(nil)
+
+	if n := len(c.Heightmaps[WorldSurface]); n != 37 {
+		t.Fatalf("unexpected heightmap size %d", n)
+	}
+
+	hm, ok := c.Heightmap(WorldSurface)
+	if !ok {
+		t.Fatal("missing heightmap")
+	}
+
+	for _, col := range []struct{ x, z, want int }{
+		{0, 0, 1},
+		{15, 2, 135},
+		{3, 9, 384},
+		{1, 1, 0},
+	} {
+		if have := hm[col.z*BlocksPerChunk+col.x]; int(have) != col.want {
+			t.Errorf("(%d %d): have %d, want %d", col.x, col.z, have, col.want)
+		}
+	}
+
+	if _, ok := c.Heightmap("OCEAN_FLOOR"); ok {
+		t.Error("expected no OCEAN_FLOOR heightmap")
+	}
+
+	c.Heightmaps["OCEAN_FLOOR"] = make([]int64, 5)
+	if _, ok := c.Heightmap("OCEAN_FLOOR"); ok {
+		t.Error("expected heightmap of invalid size to be rejected")
+	}
+
+	// Chunks written before 1.16 pack 9 bit values back to back, in 36
+	// longs. Without paletted sections, the bit size follows from that.
+	var old Chunk
+	data := make([]int64, 36)
+	for i := 0; i < 256; i++ {
+		v := uint64(i + 200)
+		bit := i * 9
+		data[bit/64] |= int64(v << uint(bit%64))
+		if bit%64 > 64-9 {
+			data[bit/64+1] |= int64(v >> uint(64-bit%64))
+		}
+	}
+	old.Heightmaps = Heightmaps{MotionBlocking: data}
+
+	hm, ok = old.Heightmap(MotionBlocking)
+	if !ok {
+		t.Fatal("missing legacy heightmap")
+	}
+
+	for i := range hm {
+		if int(hm[i]) != i+200 {
+			t.Fatalf("legacy column %d: have %d, want %d", i, hm[i], i+200)
+		}
+	}
+}
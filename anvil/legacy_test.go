@@ -0,0 +1,83 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"testing"
+
+	"github.com/jteeuwen/mctools/anvil/item"
+)
+
+func TestLegacyBlockName(t *testing.T) {
+	tests := []struct {
+		id, data int
+		want     string
+	}{
+		{0, 0, "minecraft:air"},
+		{1, 3, "minecraft:diorite"},
+		{17, 2, "minecraft:birch_log"},
+		{17, 6, "minecraft:birch_log"},
+		{17, 13, "minecraft:spruce_wood"},
+		{18, 9, "minecraft:spruce_leaves"},
+		{35, 14, "minecraft:red_wool"},
+		{44, 9, "minecraft:sandstone_slab"},
+		{145, 8, "minecraft:damaged_anvil"},
+		{159, 8, "minecraft:light_gray_terracotta"},
+		{162, 13, "minecraft:dark_oak_wood"},
+		{219, 0, "minecraft:white_shulker_box"},
+		{253, 0, ""},
+		{-1, 0, ""},
+		{4096, 0, ""},
+	}
+
+	for _, tt := range tests {
+		if have := LegacyBlockName(tt.id, tt.data); have != tt.want {
+			t.Fatalf("LegacyBlockName(%d, %d): have %q, want %q", tt.id, tt.data, have, tt.want)
+		}
+	}
+}
+
+func TestLegacySection(t *testing.T) {
+	var s Section
+	s.Init(0)
+
+	if !s.IsLegacy() {
+		t.Fatalf("expected a legacy section")
+	}
+
+	s.Write(1, 2, 3, &Block{Id: item.Granite})
+	s.Write(4, 5, 6, &Block{Id: item.NewId(35, 11)})
+
+	id, data, ok := s.LegacyBlock(4, 5, 6)
+	if !ok || id != 35 || data != 11 {
+		t.Fatalf("LegacyBlock: have %d:%d (%v), want 35:11", id, data, ok)
+	}
+
+	if _, _, ok := s.LegacyBlock(16, 0, 0); ok {
+		t.Fatalf("LegacyBlock: expected out of range coordinates to fail")
+	}
+
+	bs := s.LegacyBlockStates()
+	if bs == nil {
+		t.Fatalf("LegacyBlockStates: have nil")
+	}
+
+	if have := bs.Get(2*256 + 3*16 + 1).Name; have != "minecraft:granite" {
+		t.Fatalf("have %q, want minecraft:granite", have)
+	}
+
+	if have := bs.Get(5*256 + 6*16 + 4).Name; have != "minecraft:blue_wool" {
+		t.Fatalf("have %q, want minecraft:blue_wool", have)
+	}
+
+	if have := bs.Get(0).Name; have != AirBlock {
+		t.Fatalf("have %q, want %s", have, AirBlock)
+	}
+
+	s.SetState(0, 0, 0, BlockState{Name: "minecraft:stone"})
+
+	if s.IsLegacy() || s.LegacyBlockStates() != nil {
+		t.Fatalf("expected a paletted section")
+	}
+}
@@ -7,6 +7,8 @@ package anvil
 
 import (
 	"compress/gzip"
+	"io"
+	"io/fs"
 	"os"
 
 	"github.com/jteeuwen/mctools/anvil/nbt"
@@ -53,46 +55,183 @@ type GameRules struct {
 	EntityDrops         bool   `nbt:"doEntityDrops"`
 }
 
+// Known world generator types.
+const (
+	GeneratorNoise = "minecraft:noise"
+	GeneratorFlat  = "minecraft:flat"
+	GeneratorDebug = "minecraft:debug"
+)
+
+// FeatureVanilla is the feature flag enabled for every world. Any other
+// entry in Level.EnabledFeatures denotes an experimental feature.
+const FeatureVanilla = "minecraft:vanilla"
+
+// WorldGenSettings describes the world generation settings stored in
+// level.dat by Minecraft 1.16 and later.
+type WorldGenSettings struct {
+	Dimensions       *WorldDimensions `nbt:"dimensions"`
+	Seed             int64            `nbt:"seed"`
+	GenerateFeatures bool             `nbt:"generate_features"`
+	BonusChest       bool             `nbt:"bonus_chest"`
+}
+
+// WorldDimensions holds the generation settings for the vanilla dimensions.
+type WorldDimensions struct {
+	Overworld *DimensionSettings `nbt:"minecraft:overworld"`
+	Nether    *DimensionSettings `nbt:"minecraft:the_nether"`
+	End       *DimensionSettings `nbt:"minecraft:the_end"`
+}
+
+// DimensionSettings describes how a single dimension is generated.
+type DimensionSettings struct {
+	Generator *GeneratorSettings `nbt:"generator"`
+	Type      string             `nbt:"type"`
+}
+
+// GeneratorSettings describes the generator for a single dimension.
+//
+// Depending on the generator type, the settings are either stored as the
+// name of a preset, or as a compound. The former ends up in Settings,
+// the latter in Flat for superflat worlds.
+type GeneratorSettings struct {
+	Flat     *FlatSettings `nbt:"settings"`
+	Type     string        `nbt:"type"`
+	Settings string        `nbt:"settings,omitempty"`
+}
+
+// IsFlat returns true if this describes a superflat generator.
+func (g *GeneratorSettings) IsFlat() bool {
+	return g.Type == GeneratorFlat
+}
+
+// FlatSettings describes the layers and features of a superflat world.
+type FlatSettings struct {
+	Layers   []FlatLayer `nbt:"layers"`
+	Biome    string      `nbt:"biome"`
+	Features bool        `nbt:"features"`
+	Lakes    bool        `nbt:"lakes"`
+}
+
+// FlatLayer describes a single layer of blocks in a superflat world.
+// Layers are listed from the bottom up.
+type FlatLayer struct {
+	Block  string `nbt:"block"`
+	Height int32  `nbt:"height"`
+}
+
 // Level describes the level.dat file for a Minecraft world.
 // It holds general information about a world, like the name,
 // the generator and seed and other things.
 type Level struct {
-	Player               *Player    `nbt:"Player"`
-	Rules                GameRules  `nbt:"GameRules"`
-	Name                 string     `nbt:"LevelName"`
-	GeneratorName        string     `nbt:"generatorName"`
-	GeneratorOptions     string     `nbt:"generatorOptions"`
-	LastPlayed           int64      `nbt:"LastPlayed"`
-	Seed                 int64      `nbt:"RandomSeed"`
-	Time                 int64      `nbt:"Time"`
-	DayTime              int64      `nbt:"DayTime"`
-	SizeOnDisk           int64      `nbt:"SizeOnDisk"`
-	BorderSizeLerpTime   int64      `nbt:"BorderSizeLerpTime"`
-	BorderCenterX        float64    `nbt:"BorderCenterX"`
-	BorderCenterZ        float64    `nbt:"BorderCenterZ"`
-	BorderSize           float64    `nbt:"BorderSize"`
-	BorderSizeLerpTarget float64    `nbt:"BorderSizeLerpTarget"`
-	BorderWarningBlocks  float64    `nbt:"BorderWarningBlocks"`
-	BorderWarningTime    float64    `nbt:"BorderWarningTime"`
-	BorderDamagePerBlock float64    `nbt:"BorderDamagePerBlock"`
-	BorderSafeZone       float64    `nbt:"BorderSafeZone"`
-	GeneratorVersion     int32      `nbt:"generatorVersion"`
-	Version              int32      `nbt:"version"`
-	SpawnX               int32      `nbt:"SpawnX"`
-	SpawnY               int32      `nbt:"SpawnY"`
-	SpawnZ               int32      `nbt:"SpawnZ"`
-	RainTime             int32      `nbt:"rainTime"`
-	ClearWeatherTime     int32      `nbt:"clearWeatherTime"`
-	ThunderTime          int32      `nbt:"thunderTime"`
-	GameMode             GameMode   `nbt:"GameType"`
-	Difficulty           Difficulty `nbt:"Difficulty"`
-	Initialized          bool       `nbt:"initialized"`
-	MapFeatures          bool       `nbt:"MapFeatures"`
-	AllowCommands        bool       `nbt:"allowCommands"`
-	Hardcore             bool       `nbt:"hardcore"`
-	DifficultyLocked     bool       `nbt:"DifficultyLocked"`
-	Raining              bool       `nbt:"raining"`
-	Thundering           bool       `nbt:"thundering"`
+	Player               *Player           `nbt:"Player"`
+	WorldGenSettings     *WorldGenSettings `nbt:"WorldGenSettings"`
+	Rules                GameRules         `nbt:"GameRules"`
+	EnabledFeatures      []string          `nbt:"enabled_features,omitempty"`
+	Name                 string            `nbt:"LevelName"`
+	GeneratorName        string            `nbt:"generatorName"`
+	GeneratorOptions     string            `nbt:"generatorOptions"`
+	LastPlayed           int64             `nbt:"LastPlayed"`
+	RandomSeed           int64             `nbt:"RandomSeed"`
+	Time                 int64             `nbt:"Time"`
+	DayTime              int64             `nbt:"DayTime"`
+	SizeOnDisk           int64             `nbt:"SizeOnDisk"`
+	BorderSizeLerpTime   int64             `nbt:"BorderSizeLerpTime"`
+	BorderCenterX        float64           `nbt:"BorderCenterX"`
+	BorderCenterZ        float64           `nbt:"BorderCenterZ"`
+	BorderSize           float64           `nbt:"BorderSize"`
+	BorderSizeLerpTarget float64           `nbt:"BorderSizeLerpTarget"`
+	BorderWarningBlocks  float64           `nbt:"BorderWarningBlocks"`
+	BorderWarningTime    float64           `nbt:"BorderWarningTime"`
+	BorderDamagePerBlock float64           `nbt:"BorderDamagePerBlock"`
+	BorderSafeZone       float64           `nbt:"BorderSafeZone"`
+	GeneratorVersion     int32             `nbt:"generatorVersion"`
+	Version              int32             `nbt:"version"`
+	SpawnX               int32             `nbt:"SpawnX"`
+	SpawnY               int32             `nbt:"SpawnY"`
+	SpawnZ               int32             `nbt:"SpawnZ"`
+	RainTime             int32             `nbt:"rainTime"`
+	ClearWeatherTime     int32             `nbt:"clearWeatherTime"`
+	ThunderTime          int32             `nbt:"thunderTime"`
+	GameMode             GameMode          `nbt:"GameType"`
+	Difficulty           Difficulty        `nbt:"Difficulty"`
+	Initialized          bool              `nbt:"initialized"`
+	MapFeatures          bool              `nbt:"MapFeatures"`
+	AllowCommands        bool              `nbt:"allowCommands"`
+	Hardcore             bool              `nbt:"hardcore"`
+	DifficultyLocked     bool              `nbt:"DifficultyLocked"`
+	Raining              bool              `nbt:"raining"`
+	Thundering           bool              `nbt:"thundering"`
+}
+
+// Seed returns the world seed.
+//
+// Minecraft 1.16 moved the seed from the top-level RandomSeed value into
+// WorldGenSettings. This returns whichever is used by the level's version.
+// Returns false if the level holds no seed at all.
+func (l *Level) Seed() (int64, bool) {
+	if l.WorldGenSettings != nil {
+		return l.WorldGenSettings.Seed, true
+	}
+
+	// Older versions always write RandomSeed, along with the
+	// level format version.
+	if l.Version != 0 {
+		return l.RandomSeed, true
+	}
+
+	return 0, false
+}
+
+// HasFeature returns true if the given feature flag is enabled for the
+// world. For example "minecraft:bundle" or "minecraft:trade_rebalance".
+func (l *Level) HasFeature(name string) bool {
+	for _, v := range l.EnabledFeatures {
+		if v == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsExperimental returns true if the world has any feature flags enabled,
+// other than the vanilla one. Such worlds may contain blocks and items
+// which are not available in a regular world of the same version.
+//
+// Levels written before Minecraft 1.19.3 have no feature flags.
+func (l *Level) IsExperimental() bool {
+	for _, v := range l.EnabledFeatures {
+		if v != FeatureVanilla {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Generator returns the generator settings for the overworld.
+// Returns nil if the level has no such settings. This is the case for
+// levels written by Minecraft versions before 1.16.
+func (l *Level) Generator() *GeneratorSettings {
+	if l.WorldGenSettings == nil || l.WorldGenSettings.Dimensions == nil {
+		return nil
+	}
+
+	ow := l.WorldGenSettings.Dimensions.Overworld
+	if ow == nil {
+		return nil
+	}
+
+	return ow.Generator
+}
+
+// IsSuperflat returns true if the overworld uses the superflat generator.
+func (l *Level) IsSuperflat() bool {
+	if g := l.Generator(); g != nil {
+		return g.IsFlat()
+	}
+
+	return l.GeneratorName == "flat"
 }
 
 // LoadLevel loads level data from the given level.dat file.
@@ -103,8 +242,24 @@ func LoadLevel(file string) (*Level, error) {
 	}
 
 	defer fd.Close()
+	return decodeLevel(fd)
+}
+
+// LoadLevelFS loads level data from the given file in fsys. The name is
+// a slash separated path, as used by io/fs.
+func LoadLevelFS(fsys fs.FS, name string) (*Level, error) {
+	fd, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return decodeLevel(fd)
+}
 
-	gz, err := gzip.NewReader(fd)
+// decodeLevel reads gzip compressed level data from r.
+func decodeLevel(r io.Reader) (*Level, error) {
+	gz, err := gzip.NewReader(r)
 	if err != nil {
 		return nil, err
 	}
@@ -138,8 +293,5 @@ func (l *Level) Save(file string) error {
 
 	v.Data = l
 
-	gz := gzip.NewWriter(fd)
-	err = nbt.Marshal(gz, v)
-	gz.Close()
-	return err
+	return nbt.MarshalGzip(fd, v)
 }
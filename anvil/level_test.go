@@ -4,6 +4,7 @@
 package anvil
 
 import (
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -31,3 +32,113 @@ func TestLevelRoundtrip(t *testing.T) {
 		t.Fatalf("roundtrip mismatch:\nHave: %+v\nWant: %+v", lb, la)
 	}
 }
+
+func TestLevelSeed(t *testing.T) {
+	tests := []struct {
+		level Level
+		seed  int64
+		ok    bool
+	}{
+		{Level{}, 0, false},
+		{Level{Version: 19133, RandomSeed: -42}, -42, true},
+		{Level{Version: 19133, RandomSeed: 1, WorldGenSettings: &WorldGenSettings{Seed: 7}}, 7, true},
+	}
+
+	for i, tt := range tests {
+		seed, ok := tt.level.Seed()
+		if seed != tt.seed || ok != tt.ok {
+			t.Errorf("%d: have (%d, %v), want (%d, %v)", i, seed, ok, tt.seed, tt.ok)
+		}
+	}
+}
+
+func TestLevelFeatures(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "level.dat")
+
+	la := &Level{
+		Name:            "test",
+		EnabledFeatures: []string{FeatureVanilla, "minecraft:bundle"},
+	}
+
+	err := la.Save(file)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	lb, err := LoadLevel(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if !reflect.DeepEqual(la.EnabledFeatures, lb.EnabledFeatures) {
+		t.Fatalf("features mismatch: have %v, want %v", lb.EnabledFeatures, la.EnabledFeatures)
+	}
+
+	if !lb.HasFeature("minecraft:bundle") || lb.HasFeature("minecraft:trade_rebalance") {
+		t.Fatalf("unexpected feature set: %v", lb.EnabledFeatures)
+	}
+
+	if !lb.IsExperimental() {
+		t.Fatalf("expected experimental world")
+	}
+
+	lb.EnabledFeatures = []string{FeatureVanilla}
+	if lb.IsExperimental() {
+		t.Fatalf("unexpected experimental world")
+	}
+}
+
+func TestLevelGenerator(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "level.dat")
+
+	la := &Level{
+		WorldGenSettings: &WorldGenSettings{
+			Seed: 123,
+			Dimensions: &WorldDimensions{
+				Overworld: &DimensionSettings{
+					Type: "minecraft:overworld",
+					Generator: &GeneratorSettings{
+						Type: GeneratorFlat,
+						Flat: &FlatSettings{
+							Biome: "minecraft:plains",
+							Layers: []FlatLayer{
+								{Block: "minecraft:bedrock", Height: 1},
+								{Block: "minecraft:dirt", Height: 2},
+								{Block: "minecraft:grass_block", Height: 1},
+							},
+						},
+					},
+				},
+				Nether: &DimensionSettings{
+					Type: "minecraft:the_nether",
+					Generator: &GeneratorSettings{
+						Type:     GeneratorNoise,
+						Settings: "minecraft:nether",
+					},
+				},
+			},
+		},
+	}
+
+	err := la.Save(file)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	lb, err := LoadLevel(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if !reflect.DeepEqual(la, lb) {
+		t.Fatalf("roundtrip mismatch:\nHave: %+v\nWant: %+v", lb, la)
+	}
+
+	if !lb.IsSuperflat() {
+		t.Fatalf("expected superflat world")
+	}
+
+	if lb.WorldGenSettings.Dimensions.Nether.Generator.IsFlat() {
+		t.Fatalf("expected noise generator for the nether")
+	}
+}
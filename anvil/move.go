@@ -0,0 +1,220 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+// MoveChunk moves the chunk in slot (srcX, srcZ) to slot (dstX, dstZ) of
+// the same region. Refer to MoveChunkTo for details.
+func (r *Region) MoveChunk(srcX, srcZ, dstX, dstZ int) bool {
+	return r.MoveChunkTo(r, srcX, srcZ, dstX, dstZ)
+}
+
+// MoveChunkTo moves the chunk in slot (srcX, srcZ) of r to slot
+// (dstX, dstZ) of dst. A chunk already in the destination slot is
+// replaced. The changes are persisted by saving both regions.
+//
+// The position tags in the chunk are rewritten to match its new location:
+// the chunk coordinates, the positions of block entities, entities and
+// scheduled ticks. This works on the raw tag tree, so tags the Chunk type
+// does not know about are kept. Structure references are not changed.
+// Chunks in entity regions are supported as well.
+//
+// Returns false if there is no chunk in the source slot, or its data can
+// not be rewritten.
+func (r *Region) MoveChunkTo(dst *Region, srcX, srcZ, dstX, dstZ int) bool {
+	src := chunkIndex(srcX, srcZ)
+
+	cd := r.chunks[src]
+	if cd == nil {
+		return false
+	}
+
+	data, err := cd.raw()
+	if err != nil {
+		return false
+	}
+
+	n := chunkIndex(dstX, dstZ)
+	x := n % ChunksPerRegion
+	z := n / ChunksPerRegion
+
+	data, err = relocate(data, dst.X*ChunksPerRegion+x, dst.Z*ChunksPerRegion+z)
+	if err != nil {
+		return false
+	}
+
+	moved := &ChunkDescriptor{X: x, Z: z, scheme: cd.scheme}
+
+	err = moved.setRaw(data)
+	if err != nil {
+		return false
+	}
+
+	r.chunks[src] = nil
+	dst.chunks[n] = moved
+	return true
+}
+
+// Names of lists whose elements contain block coordinates in their x, y
+// and z tags: block entities and scheduled ticks, as named before and
+// after Minecraft 1.18.
+var positionedLists = []string{
+	"TileEntities", "TileTicks", "LiquidTicks",
+	"block_entities", "block_ticks", "fluid_ticks",
+}
+
+// relocate rewrites the position tags in the NBT encoded chunk data, so it
+// describes the chunk at the given, absolute, chunk coordinates.
+func relocate(data []byte, x, z int) ([]byte, error) {
+	dec := nbt.NewDecoder(bytes.NewReader(data))
+	dec.SetOrdered(true)
+
+	var kv nbt.KeyValue
+
+	err := dec.Decode(&kv)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := kv.Value.(nbt.OrderedCompound)
+	if !ok {
+		return nil, errors.New("chunk data is not a compound")
+	}
+
+	// Chunks written before Minecraft 1.18 keep their data in Level.
+	level := root
+	if t, ok := root.Get("Level"); ok {
+		if c, ok := t.(nbt.OrderedCompound); ok {
+			level = c
+		}
+	}
+
+	var dx, dz int
+
+	if t, ok := level.Get("Position"); ok {
+		// Entity regions store the position as an int array.
+		pos, ok := t.(nbt.IntArray)
+		if !ok || len(pos) != 2 {
+			return nil, errors.New("invalid chunk position")
+		}
+
+		dx, dz = x-int(pos[0]), z-int(pos[1])
+		pos[0], pos[1] = int32(x), int32(z)
+	} else {
+		ox, ok1 := intTag(level, "xPos")
+		oz, ok2 := intTag(level, "zPos")
+		if !ok1 || !ok2 {
+			return nil, errors.New("chunk has no position")
+		}
+
+		dx, dz = x-ox, z-oz
+		setTag(level, "xPos", nbt.Int(x))
+		setTag(level, "zPos", nbt.Int(z))
+	}
+
+	// Offsets in blocks.
+	dx *= BlocksPerChunk
+	dz *= BlocksPerChunk
+
+	for _, name := range positionedLists {
+		for _, c := range compoundList(level, name) {
+			shiftTag(c, "x", dx)
+			shiftTag(c, "z", dz)
+		}
+	}
+
+	for _, c := range compoundList(level, "Entities") {
+		shiftEntity(c, dx, dz)
+	}
+
+	var buf bytes.Buffer
+
+	err = nbt.Marshal(&buf, nbt.KeyValue{Name: kv.Name, Value: root})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// shiftEntity moves the given entity, and any entities riding it, by the
+// given number of blocks.
+func shiftEntity(c nbt.OrderedCompound, dx, dz int) {
+	if t, ok := c.Get("Pos"); ok {
+		if l, ok := t.(nbt.List); ok && len(l.Items) == 3 {
+			if v, ok := l.Items[0].(nbt.Double); ok {
+				l.Items[0] = v + nbt.Double(dx)
+			}
+
+			if v, ok := l.Items[2].(nbt.Double); ok {
+				l.Items[2] = v + nbt.Double(dz)
+			}
+		}
+	}
+
+	// Hanging entities, like paintings, store the block they hang on.
+	shiftTag(c, "TileX", dx)
+	shiftTag(c, "TileZ", dz)
+
+	for _, p := range compoundList(c, "Passengers") {
+		shiftEntity(p, dx, dz)
+	}
+}
+
+// compoundList returns the compound elements of the named list in c.
+func compoundList(c nbt.OrderedCompound, name string) []nbt.OrderedCompound {
+	t, ok := c.Get(name)
+	if !ok {
+		return nil
+	}
+
+	l, ok := t.(nbt.List)
+	if !ok {
+		return nil
+	}
+
+	out := make([]nbt.OrderedCompound, 0, len(l.Items))
+
+	for _, item := range l.Items {
+		if ic, ok := item.(nbt.OrderedCompound); ok {
+			out = append(out, ic)
+		}
+	}
+
+	return out
+}
+
+// intTag returns the value of the named int tag in c.
+func intTag(c nbt.OrderedCompound, name string) (int, bool) {
+	t, ok := c.Get(name)
+	if !ok {
+		return 0, false
+	}
+
+	v, ok := t.(nbt.Int)
+	return int(v), ok
+}
+
+// shiftTag adds delta to the named int tag in c, if it exists.
+func shiftTag(c nbt.OrderedCompound, name string, delta int) {
+	if v, ok := intTag(c, name); ok {
+		setTag(c, name, nbt.Int(v+delta))
+	}
+}
+
+// setTag replaces the value of the named entry in c.
+func setTag(c nbt.OrderedCompound, name string, t nbt.Tag) {
+	for i := range c {
+		if c[i].Name == name {
+			c[i].Value = t
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveChunk(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()[0]
+
+	var c Chunk
+	if !r.ReadChunk(xz[0], xz[1], &c) {
+		t.Fatalf("c(%d %d): read failed", xz[0], xz[1])
+	}
+
+	ox, oz := int(c.X), int(c.Z)
+
+	c.TileEntities = append(c.TileEntities[:0], TileEntity{Id: "minecraft:chest", X: int32(ox*16 + 3), Y: 64, Z: int32(oz*16 + 5)})
+	c.Entities = append(c.Entities[:0], Entity{Id: "minecraft:cow", Pos: []float64{float64(ox*16) + 1.5, 70, float64(oz*16) + 2.5}})
+	c.TileTicks = append(c.TileTicks[:0], TileTick{Id: "minecraft:water", X: int32(ox*16 + 7), Z: int32(oz*16 + 8)})
+
+	if !r.WriteChunk(xz[0], xz[1], &c) {
+		t.Fatalf("c(%d %d): write failed", xz[0], xz[1])
+	}
+
+	check := func(r *Region, x, z int) {
+		var c Chunk
+		if !r.ReadChunk(x, z, &c) {
+			t.Fatalf("c(%d %d): read moved chunk failed", x, z)
+		}
+
+		cx, cz := r.X*ChunksPerRegion+x, r.Z*ChunksPerRegion+z
+
+		if int(c.X) != cx || int(c.Z) != cz {
+			t.Fatalf("position mismatch: have (%d %d), want (%d %d)", c.X, c.Z, cx, cz)
+		}
+
+		if te := c.TileEntities[0]; int(te.X) != cx*16+3 || te.Y != 64 || int(te.Z) != cz*16+5 {
+			t.Fatalf("tile entity at (%d %d %d)", te.X, te.Y, te.Z)
+		}
+
+		if pos := c.Entities[0].Pos; pos[0] != float64(cx*16)+1.5 || pos[1] != 70 || pos[2] != float64(cz*16)+2.5 {
+			t.Fatalf("entity at %v", pos)
+		}
+
+		if tt := c.TileTicks[0]; int(tt.X) != cx*16+7 || int(tt.Z) != cz*16+8 {
+			t.Fatalf("tile tick at (%d %d)", tt.X, tt.Z)
+		}
+	}
+
+	// Find a free slot.
+	dst := -1
+	for n, cd := range r.chunks {
+		if cd == nil {
+			dst = n
+			break
+		}
+	}
+
+	dx, dz := dst%ChunksPerRegion, dst/ChunksPerRegion
+
+	if !r.MoveChunk(xz[0], xz[1], dx, dz) {
+		t.Fatalf("MoveChunk failed")
+	}
+
+	if r.HasChunk(xz[0], xz[1]) {
+		t.Fatalf("source chunk still exists")
+	}
+
+	check(r, dx, dz)
+
+	// Move it into another region.
+	r2, err := CreateRegion(filepath.Join(t.TempDir(), "r.-2.3.mca"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if !r.MoveChunkTo(r2, dx, dz, 31, 0) {
+		t.Fatalf("MoveChunkTo failed")
+	}
+
+	check(r2, 31, 0)
+
+	if r.MoveChunk(dx, dz, 0, 0) {
+		t.Fatalf("moved a missing chunk")
+	}
+}
@@ -0,0 +1,78 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+)
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// marshalsBinary returns true if values of the given type are encoded
+// through encoding.BinaryMarshaler.
+func marshalsBinary(rt reflect.Type) bool {
+	return rt.Implements(binaryMarshalerType) || reflect.PtrTo(rt).Implements(binaryMarshalerType)
+}
+
+// binaryMarshaler returns the encoding.BinaryMarshaler implemented by rv
+// or by a pointer to it. Returns false if there is none.
+func binaryMarshaler(rv reflect.Value) (encoding.BinaryMarshaler, bool) {
+	if !rv.CanInterface() || !marshalsBinary(rv.Type()) {
+		return nil, false
+	}
+
+	if m, ok := rv.Interface().(encoding.BinaryMarshaler); ok {
+		return m, true
+	}
+
+	// The method has a pointer receiver. Values which are not
+	// addressable, like map entries, are copied first.
+	if !rv.CanAddr() {
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		rv = p.Elem()
+	}
+
+	return rv.Addr().Interface().(encoding.BinaryMarshaler), true
+}
+
+// binaryUnmarshaler returns the encoding.BinaryUnmarshaler implemented by
+// a pointer to rv. Returns false if there is none.
+func binaryUnmarshaler(rv reflect.Value) (encoding.BinaryUnmarshaler, bool) {
+	if !rv.CanAddr() || !reflect.PtrTo(rv.Type()).Implements(binaryUnmarshalerType) {
+		return nil, false
+	}
+
+	return rv.Addr().Interface().(encoding.BinaryUnmarshaler), true
+}
+
+// encodeBinary encodes the binary form of m as a TagByteArray.
+func (e *Encoder) encodeBinary(m encoding.BinaryMarshaler, name string, inlist bool) error {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("nbt: %s(%q): %v", TagByteArray, name, err)
+	}
+
+	return e.encodeByteArray(reflect.ValueOf(data), name, inlist)
+}
+
+// decodeBinary reads a TagByteArray and passes its contents to u.
+func (d *Decoder) decodeBinary(name string, u encoding.BinaryUnmarshaler) error {
+	data, err := d.readByteArray(nil)
+	if err != nil {
+		return err
+	}
+
+	err = u.UnmarshalBinary(data)
+	if err != nil {
+		return fmt.Errorf("%s(%q): %v", TagByteArray, name, err)
+	}
+
+	return nil
+}
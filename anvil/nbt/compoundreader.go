@@ -0,0 +1,268 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CompoundReader reads the entries of a single compound tag directly
+// from the underlying stream. It is created by Decoder.Compound.
+//
+// Call Next to advance to the next entry, then use the accessor matching
+// its type to read the value. Values which are not read are skipped by
+// the following call to Next.
+//
+// Reading a value with the wrong accessor returns an error, but leaves
+// the value in place so it can still be read or skipped. Any other error
+// is retained and returned by Err. Once such an error has occurred, Next
+// always reports TagEnd.
+type CompoundReader struct {
+	d       *Decoder
+	child   *CompoundReader // Nested compound being read, if any.
+	name    string          // Name of the current entry.
+	id      TagId           // Type of the current entry.
+	err     error
+	pending bool // Current entry's value has not been read yet.
+	done    bool // End tag has been read.
+}
+
+// Compound reads the header of the root tag, which must be a compound.
+// It returns the root's name, along with a reader for its entries.
+//
+//	c, _, err := dec.Compound()
+//	for name, id := c.Next(); id != nbt.TagEnd; name, id = c.Next() {
+//	    switch name {
+//	    case "id":
+//	        v, err := c.String()
+//	        ...
+//	    }
+//	}
+func (d *Decoder) Compound() (*CompoundReader, string, error) {
+	id, name, err := d.readHeader(TagUnknown)
+	if err != nil {
+		return nil, "", fmt.Errorf("nbt: %v", err)
+	}
+
+	if id != TagCompound {
+		return nil, "", fmt.Errorf("nbt: %s(%q): root is not a compound", id, name)
+	}
+
+	return &CompoundReader{d: d}, name, nil
+}
+
+// Err returns the first error encountered while reading.
+func (c *CompoundReader) Err() error { return c.err }
+
+// Next advances to the next entry in the compound and returns its name
+// and type. Returns TagEnd once all entries have been read, or when an
+// error occurred.
+func (c *CompoundReader) Next() (string, TagId) {
+	if c.done || c.err != nil {
+		return "", TagEnd
+	}
+
+	if !c.skipPending() {
+		return "", TagEnd
+	}
+
+	id, name, err := c.d.readHeader(TagUnknown)
+	if err != nil {
+		c.fail(err)
+		return "", TagEnd
+	}
+
+	if id == TagEnd {
+		c.done = true
+		return "", TagEnd
+	}
+
+	c.id, c.name, c.pending = id, name, true
+	return name, id
+}
+
+// Skip discards the value of the current entry.
+func (c *CompoundReader) Skip() error {
+	c.skipPending()
+	return c.err
+}
+
+// Byte reads the current entry as a TAG_Byte.
+func (c *CompoundReader) Byte() (int8, error) {
+	var v int8
+	err := c.read(TagByte, func() (err error) { v, err = c.d.readByte(); return })
+	return v, err
+}
+
+// Short reads the current entry as a TAG_Short.
+func (c *CompoundReader) Short() (int16, error) {
+	var v int16
+	err := c.read(TagShort, func() (err error) { v, err = c.d.readShort(); return })
+	return v, err
+}
+
+// Int reads the current entry as a TAG_Int.
+func (c *CompoundReader) Int() (int32, error) {
+	var v int32
+	err := c.read(TagInt, func() (err error) { v, err = c.d.readInt(); return })
+	return v, err
+}
+
+// Long reads the current entry as a TAG_Long.
+func (c *CompoundReader) Long() (int64, error) {
+	var v int64
+	err := c.read(TagLong, func() (err error) { v, err = c.d.readLong(); return })
+	return v, err
+}
+
+// Float reads the current entry as a TAG_Float.
+func (c *CompoundReader) Float() (float32, error) {
+	var v float32
+	err := c.read(TagFloat, func() (err error) { v, err = c.d.readFloat(); return })
+	return v, err
+}
+
+// Double reads the current entry as a TAG_Double.
+func (c *CompoundReader) Double() (float64, error) {
+	var v float64
+	err := c.read(TagDouble, func() (err error) { v, err = c.d.readDouble(); return })
+	return v, err
+}
+
+// String reads the current entry as a TAG_String.
+func (c *CompoundReader) String() (string, error) {
+	var v string
+	err := c.read(TagString, func() (err error) { v, err = c.d.readString(); return })
+	return v, err
+}
+
+// ByteArray reads the current entry as a TAG_Byte_Array.
+func (c *CompoundReader) ByteArray() ([]byte, error) {
+	var v []byte
+	err := c.read(TagByteArray, func() (err error) { v, err = c.d.readByteArray(nil); return })
+	return v, err
+}
+
+// IntArray reads the current entry as a TAG_Int_Array.
+func (c *CompoundReader) IntArray() ([]int32, error) {
+	var v []int32
+	err := c.read(TagIntArray, func() (err error) { v, err = c.d.readIntArray(nil); return })
+	return v, err
+}
+
+// LongArray reads the current entry as a TAG_Long_Array.
+func (c *CompoundReader) LongArray() ([]int64, error) {
+	var v []int64
+	err := c.read(TagLongArray, func() (err error) { v, err = c.d.readLongArray(nil); return })
+	return v, err
+}
+
+// Compound returns a reader for the current entry, which must be a
+// TAG_Compound. The nested reader must be used before calling Next on c
+// again; any entries it did not read are skipped at that point.
+func (c *CompoundReader) Compound() (*CompoundReader, error) {
+	if err := c.check(TagCompound); err != nil {
+		return nil, err
+	}
+
+	c.pending = false
+	c.child = &CompoundReader{d: c.d}
+	return c.child, nil
+}
+
+// Value decodes the current entry into v, using the same rules as
+// Decoder.Decode. This can be used for lists or nested structs.
+func (c *CompoundReader) Value(v interface{}) error {
+	if err := c.check(c.id); err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &UnmarshalError{reflect.TypeOf(v)}
+	}
+
+	c.pending = false
+	err := c.d.decode(c.id, c.name, rv)
+	if err != nil {
+		return c.fail(pathError(err, pathName(c.name), c.id, rv.Type().Elem()))
+	}
+
+	return nil
+}
+
+// read reads the current value using fn, if it has the given type.
+func (c *CompoundReader) read(id TagId, fn func() error) error {
+	if err := c.check(id); err != nil {
+		return err
+	}
+
+	c.pending = false
+	if err := fn(); err != nil {
+		return c.fail(err)
+	}
+
+	return nil
+}
+
+// check returns an error if the current entry does not hold an unread
+// value of the given type.
+func (c *CompoundReader) check(id TagId) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	if !c.pending {
+		return fmt.Errorf("nbt: %s(%q): no value to read", c.id, c.name)
+	}
+
+	if c.id != id {
+		return fmt.Errorf("nbt: %s(%q): can not read as %s", c.id, c.name, id)
+	}
+
+	return nil
+}
+
+// skipPending discards any unread value of the current entry, including
+// the remainder of a nested compound. Returns false on error.
+func (c *CompoundReader) skipPending() bool {
+	if c.child != nil {
+		for _, id := c.child.Next(); id != TagEnd; _, id = c.child.Next() {
+		}
+
+		err := c.child.err
+		c.child = nil
+
+		if err != nil {
+			c.err = err
+			return false
+		}
+	}
+
+	if !c.pending {
+		return true
+	}
+
+	c.pending = false
+	if err := c.d.skip(c.id); err != nil {
+		c.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// fail records err as the reader's error, unless one was already set.
+func (c *CompoundReader) fail(err error) error {
+	if _, ok := err.(*DecodeError); ok && c.err == nil {
+		c.err = err
+	}
+
+	if c.err == nil {
+		c.err = fmt.Errorf("nbt: %v", err)
+	}
+
+	return c.err
+}
@@ -0,0 +1,141 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import "reflect"
+
+// CompoundWriter writes the entries of a single compound tag directly to
+// the underlying stream. It is driven by Encoder.Compound.
+//
+// The first write error is retained and all later writes become a no-op.
+// The error is returned by the Encoder.Compound call which created the
+// writer.
+type CompoundWriter struct {
+	e   *Encoder
+	err error
+}
+
+// Compound writes a named compound tag. All entries written to c by fn
+// are streamed directly, without building a struct or tag tree first.
+// The terminating end tag is written once fn returns.
+//
+//	err := enc.Compound("", func(c *nbt.CompoundWriter) {
+//	    c.String("id", "minecraft:stone")
+//	    c.Byte("Count", 1)
+//	})
+func (e *Encoder) Compound(name string, fn func(c *CompoundWriter)) error {
+	c := &CompoundWriter{e: e}
+	c.Compound(name, fn)
+	return c.err
+}
+
+// Err returns the first error encountered while writing.
+func (c *CompoundWriter) Err() error { return c.err }
+
+// Compound writes a nested compound tag.
+func (c *CompoundWriter) Compound(name string, fn func(c *CompoundWriter)) {
+	c.do(func() error {
+		err := c.e.emit(TagCompound, name, false)
+		if err != nil {
+			return err
+		}
+
+		fn(c)
+
+		if c.err != nil {
+			return c.err
+		}
+
+		return c.e.writeU8(uint8(TagEnd))
+	})
+}
+
+// Byte writes a TAG_Byte.
+func (c *CompoundWriter) Byte(name string, v int8) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// Bool writes a boolean as a TAG_Byte.
+func (c *CompoundWriter) Bool(name string, v bool) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// Short writes a TAG_Short.
+func (c *CompoundWriter) Short(name string, v int16) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// Int writes a TAG_Int.
+func (c *CompoundWriter) Int(name string, v int32) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// Long writes a TAG_Long.
+func (c *CompoundWriter) Long(name string, v int64) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// Float writes a TAG_Float.
+func (c *CompoundWriter) Float(name string, v float32) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// Double writes a TAG_Double.
+func (c *CompoundWriter) Double(name string, v float64) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// String writes a TAG_String.
+func (c *CompoundWriter) String(name string, v string) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// ByteArray writes a TAG_Byte_Array.
+func (c *CompoundWriter) ByteArray(name string, v []byte) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// IntArray writes a TAG_Int_Array, or a TAG_List if the encoder writes
+// legacy arrays.
+func (c *CompoundWriter) IntArray(name string, v []int32) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// LongArray writes a TAG_Long_Array, or a TAG_List if the encoder writes
+// legacy arrays.
+func (c *CompoundWriter) LongArray(name string, v []int64) {
+	c.value(name, reflect.ValueOf(v))
+}
+
+// Value writes v using the same rules as Encoder.Encode.
+// This can be used for lists or nested structs.
+func (c *CompoundWriter) Value(name string, v interface{}) {
+	rv := reflect.ValueOf(v)
+
+	if !rv.IsValid() {
+		c.do(func() error {
+			return &MarshalError{Name: name, Type: reflect.TypeOf(v)}
+		})
+		return
+	}
+
+	c.value(name, rv)
+}
+
+func (c *CompoundWriter) value(name string, rv reflect.Value) {
+	c.do(func() error {
+		return c.e.encode(rv, name, false)
+	})
+}
+
+// do runs fn, unless an earlier write already failed.
+func (c *CompoundWriter) do(fn func() error) {
+	if c.err != nil {
+		return
+	}
+
+	if err := fn(); err != nil && c.err == nil {
+		c.err = err
+	}
+}
@@ -0,0 +1,178 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Compression defines a compression scheme for NBT data.
+// The values match the compression schemes used in region files.
+type Compression byte
+
+// Known compression schemes.
+const (
+	GZip         Compression = 1
+	ZLib         Compression = 2
+	Uncompressed Compression = 3
+	LZ4          Compression = 4 // As written by lz4-java's LZ4BlockOutputStream.
+)
+
+// gzipOSUnknown defines the gzip header OS value for an unknown system.
+const gzipOSUnknown = 255
+
+// NewCompressedWriter returns a writer which compresses all data written
+// to it, using the given scheme. The writer must be closed to flush all
+// data to w. Closing it does not close w.
+//
+// GZip output carries no modification time, name or comment and marks the
+// OS as unknown. Writing the same data twice yields identical output.
+func NewCompressedWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case GZip:
+		gz := gzip.NewWriter(w)
+		gz.Header = gzip.Header{OS: gzipOSUnknown}
+		return gz, nil
+
+	case ZLib:
+		return zlib.NewWriter(w), nil
+
+	case Uncompressed:
+		return nopCloser{w}, nil
+
+	case LZ4:
+		return newLZ4Writer(w), nil
+	}
+
+	return nil, fmt.Errorf("nbt: unknown compression scheme %d", c)
+}
+
+// NewCompressedReader returns a reader which decompresses the data read
+// from r, using the given scheme. Closing it does not close r.
+func NewCompressedReader(r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case GZip:
+		return gzip.NewReader(r)
+
+	case ZLib:
+		return zlib.NewReader(r)
+
+	case Uncompressed:
+		return ioutil.NopCloser(r), nil
+
+	case LZ4:
+		return newLZ4Reader(r), nil
+	}
+
+	return nil, fmt.Errorf("nbt: unknown compression scheme %d", c)
+}
+
+// UnmarshalAuto is like Unmarshal, but first determines how the data in r
+// is compressed, and decompresses it as needed. It recognizes gzip, zlib
+// and LZ4 streams, as well as uncompressed data starting with the header
+// of a TAG_Compound. Returns an error for anything else.
+func UnmarshalAuto(r io.Reader, v interface{}) error {
+	br := bufio.NewReader(r)
+
+	c, err := detectCompression(br)
+	if err != nil {
+		return err
+	}
+
+	cr, err := NewCompressedReader(br, c)
+	if err != nil {
+		return err
+	}
+
+	defer cr.Close()
+	return Unmarshal(cr, v)
+}
+
+// detectCompression returns the compression scheme of the data in br,
+// judging by its first few bytes. These are not consumed.
+func detectCompression(br *bufio.Reader) (Compression, error) {
+	head, err := br.Peek(len(lz4Magic))
+	if len(head) == 0 {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, fmt.Errorf("nbt: detect compression: %v", err)
+	}
+
+	switch {
+	case len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b:
+		return GZip, nil
+
+	case len(head) >= 2 && head[0] == 0x78 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0:
+		return ZLib, nil
+
+	case string(head) == lz4Magic:
+		return LZ4, nil
+
+	case head[0] == byte(TagCompound):
+		return Uncompressed, nil
+	}
+
+	return 0, fmt.Errorf("nbt: detect compression: unknown format, starting with % x", head)
+}
+
+// MarshalCompressed translates v into NBT-encoded data, compresses it
+// using the given scheme and writes it to w.
+func MarshalCompressed(w io.Writer, v interface{}, c Compression) error {
+	cw, err := NewCompressedWriter(w, c)
+	if err != nil {
+		return err
+	}
+
+	err = Marshal(cw, v)
+	if err != nil {
+		cw.Close()
+		return err
+	}
+
+	return cw.Close()
+}
+
+// MarshalGzip translates v into NBT-encoded data and writes it to w as a
+// reproducible gzip stream. This is the format used for level.dat and
+// most other .dat files.
+func MarshalGzip(w io.Writer, v interface{}) error {
+	return MarshalCompressed(w, v, GZip)
+}
+
+// SaveTagFile writes the tag tree t to the given file, as the root tag with
+// the given name. The data is compressed using the given scheme. Level.dat
+// and most other .dat files use GZip.
+func SaveTagFile(path string, name string, t Tag, c Compression) error {
+	if t == nil {
+		return fmt.Errorf("nbt: save %q: no tag", path)
+	}
+
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	err = MarshalCompressed(fd, KeyValue{name, t}, c)
+	if err != nil {
+		return err
+	}
+
+	return fd.Close()
+}
+
+// nopCloser adds a no-op Close method to an io.Writer.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -4,6 +4,7 @@
 package nbt
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
@@ -24,35 +25,130 @@ func Unmarshal(r io.Reader, v interface{}) error {
 // Decoder defines a NBT decoder, used to unmarshal uncompressed,
 // NBT formatted data into a Go type.
 type Decoder struct {
-	r       io.Reader // Input stream.
-	scratch [8]byte   // Temporary read buffer.
+	r        io.Reader         // Input stream.
+	order    binary.ByteOrder  // Byte order of numeric values.
+	maxElems int               // Maximum number of elements in a list or array.
+	ordered  bool              // Decode dynamic compounds as OrderedCompound.
+	strict   bool              // Reject tags without a matching struct field.
+	nocase   bool              // Match struct fields case-insensitively.
+	maxDepth int               // Maximum nesting depth of compounds and lists.
+	depth    int               // Current nesting depth.
+	scratch  [8]byte           // Temporary read buffer.
+	strbuf   []byte            // Read buffer for strings, reused between reads.
+	strings  map[string]string // Interned strings; see intern.
+	frames   []tokenFrame      // Open compounds and lists; see Token.
+	rootDone bool              // Token has read the entire root tag.
 }
 
 // NewDecoder creates a new decoder for the given input stream.
-func NewDecoder(r io.Reader) *Decoder { return &Decoder{r: r} }
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, order: binary.BigEndian, maxDepth: DefaultMaxDepth}
+}
+
+// SetByteOrder sets the byte order of numeric values in the input. Java
+// Edition data is big endian, which is the default. Bedrock Edition uses
+// binary.LittleEndian.
+func (d *Decoder) SetByteOrder(order binary.ByteOrder) { d.order = order }
+
+// SetMaxElements sets the maximum number of elements the decoder accepts
+// for a single list or array. Input declaring a larger size yields an
+// error, before any memory is allocated for it. A value <= 0 removes the
+// limit, which is the default.
+//
+// If the input stream reports the number of unread bytes through a
+// Len() int method, as bytes.Reader and bytes.Buffer do, sizes which can
+// not possibly fit in the remaining input are rejected as well. This
+// check always applies. For other streams, memory for a large list or
+// array is allocated as its data arrives, so truncated input can not
+// claim more than it holds.
+func (d *Decoder) SetMaxElements(n int) { d.maxElems = n }
+
+// DefaultMaxDepth defines the default maximum nesting depth of compounds
+// and lists accepted by a decoder.
+const DefaultMaxDepth = 512
+
+// SetMaxDepth sets the maximum nesting depth of compounds and lists the
+// decoder accepts. Deeper input yields an error, rather than recursing
+// until the stack overflows. This guards against malicious input. The
+// default is DefaultMaxDepth, which is well beyond anything Minecraft
+// writes. A value <= 0 removes the limit.
+func (d *Decoder) SetMaxDepth(n int) { d.maxDepth = n }
+
+// enter increments the nesting depth when reading a compound or list.
+// Returns an error if this exceeds the maximum. Each successful call
+// must be followed by a call to leave.
+func (d *Decoder) enter() error {
+	if d.maxDepth > 0 && d.depth >= d.maxDepth {
+		return fmt.Errorf("exceeds maximum nesting depth of %d", d.maxDepth)
+	}
+
+	d.depth++
+	return nil
+}
+
+// leave decrements the nesting depth, once a compound or list has been read.
+func (d *Decoder) leave() { d.depth-- }
+
+// SetOrdered determines how compounds are decoded into a Tag value. By
+// default they become a Compound, which does not retain the order of its
+// entries. If ordered is true, an OrderedCompound is used instead. This
+// applies to nested compounds as well.
+//
+// Values of type OrderedCompound are always decoded in order.
+func (d *Decoder) SetOrdered(ordered bool) { d.ordered = ordered }
+
+// DisallowUnknownTags causes Decode to fail when a compound decoded into
+// a struct holds a tag which matches none of its fields, rather than
+// skipping the tag. The error names the tag and holds its path. This helps
+// to verify that a set of types covers a schema completely. Maps and
+// fields with the `remaining` option still accept any tag.
+func (d *Decoder) DisallowUnknownTags() { d.strict = true }
+
+// SetCaseInsensitive determines how tag names are matched to struct
+// fields. By default, they must match exactly. If insensitive is true, a
+// tag without an exact match is assigned to a field whose name matches it
+// when ignoring case. This applies to the aliases of a field as well.
+func (d *Decoder) SetCaseInsensitive(insensitive bool) { d.nocase = insensitive }
+
+// maxListPrealloc defines the largest number of list elements for which
+// space is allocated up front. Longer lists grow as they are read.
+const maxListPrealloc = 1024
 
 // Decode recursively reads tags and unmarshals them into  the given value.
 func (d *Decoder) Decode(v interface{}) error {
+	_, err := d.DecodeNamed(v)
+	return err
+}
+
+// DecodeNamed is like Decode, but also returns the name of the root tag.
+// This is often empty, but some files use it to identify their contents.
+func (d *Decoder) DecodeNamed(v interface{}) (string, error) {
 	rv := reflect.ValueOf(v)
 
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
-		return &UnmarshalError{reflect.TypeOf(v)}
+		return "", &UnmarshalError{reflect.TypeOf(v)}
 	}
 
-	id, name, err := d.readHeader(tagUnknown)
+	id, name, err := d.readHeader(TagUnknown)
 	if err != nil {
-		return fmt.Errorf("nbt: %v", err)
+		return "", fmt.Errorf("nbt: %v", err)
+	}
+
+	// A KeyValue receives the root tag along with its name.
+	if kv, ok := v.(*KeyValue); ok {
+		kv.Name = name
+		rv = reflect.ValueOf(&kv.Value)
 	}
 
 	err = d.decode(id, name, rv)
 	if err != nil {
-		return fmt.Errorf("nbt: %v", err)
+		return name, pathError(err, "", id, rv.Type().Elem())
 	}
 
-	return nil
+	return name, nil
 }
 
-func (d *Decoder) decode(id tagId, name string, rv reflect.Value) error {
+func (d *Decoder) decode(id TagId, name string, rv reflect.Value) error {
 	// Initialize a new instance of a pointer type if needed.
 	if rv.Kind() == reflect.Ptr {
 		if rv.IsNil() {
@@ -63,14 +159,45 @@ func (d *Decoder) decode(id tagId, name string, rv reflect.Value) error {
 		rv = rv.Elem()
 	}
 
+	if isTag(rv.Type()) {
+		return d.decodeTag(id, name, rv)
+	}
+
+	if id == TagCompound || id == TagList {
+		err := d.enter()
+		if err != nil {
+			return fmt.Errorf("%s(%q): %v", id, name, err)
+		}
+
+		defer d.leave()
+	}
+
+	if u, ok := nbtUnmarshaler(rv); ok {
+		return d.decodeNBT(id, name, u)
+	}
+
+	if id == TagCompound && rv.Kind() == reflect.Interface {
+		return d.decodeRegistered(name, rv)
+	}
+
+	if id == TagList && rv.Type() == anyType {
+		return d.decodeAnyList(name, rv)
+	}
+
+	if id == TagByteArray {
+		if u, ok := binaryUnmarshaler(rv); ok {
+			return d.decodeBinary(name, u)
+		}
+	}
+
 	//fmt.Printf("%s(%q) => %v\n", id, name, rv)
 
 	var err error
 	switch id {
-	case tagList:
+	case TagList:
 		err = d.decodeList(name, rv)
 
-	case tagCompound:
+	case TagCompound:
 		err = d.decodeCompound(name, rv)
 
 	default:
@@ -81,40 +208,143 @@ func (d *Decoder) decode(id tagId, name string, rv reflect.Value) error {
 }
 
 func (d *Decoder) decodeCompound(name string, rv reflect.Value) error {
+	if rv.Kind() == reflect.Map {
+		return d.decodeMap(name, rv)
+	}
+
 	if rv.Kind() != reflect.Struct {
-		return fmt.Errorf("%s(%q): value %v must be a struct", tagCompound, name, rv)
+		return fmt.Errorf("%s(%q): value %v must be a struct", TagCompound, name, rv)
 	}
 
+	// The names of all tags are only kept if some are required.
+	required := requiredFields(rv.Type(), nil)
+	var seen []string
+
 	// Decode until we have a matching TagEnd.
 	for {
-		id, name, err := d.readHeader(tagUnknown)
+		id, name, err := d.readHeader(TagUnknown)
 		if err != nil {
 			return err
 		}
 
-		if id == tagEnd {
+		if id == TagEnd {
 			break
 		}
 
-		fv := readField(rv, name)
+		if len(required) > 0 {
+			seen = append(seen, name)
+		}
 
-		if fv.Kind() == reflect.Invalid {
+		fv, tag := readField(rv, name, id, d.nocase)
+
+		if !fv.IsValid() {
+			fv, tag = remainingField(rv, name)
+		}
+
+		var ft reflect.Type
+
+		switch {
+		case fv.Kind() == reflect.Invalid && d.strict:
+			err = fmt.Errorf("%s(%q): unknown tag for %v", id, name, rv.Type())
+		case fv.Kind() == reflect.Invalid:
 			err = d.skip(id)
-		} else {
-			err = d.decode(id, name, fv)
+		case hasField(tag, "remaining"):
+			err = d.subtree(id, name, fv)
+		case hasField(tag, "stream"):
+			ft, err = fv.Type(), d.stream(id, name, fv)
+		case fv.Type() == listHandlerType:
+			err = d.handleList(id, name, fv)
+		default:
+			ft, err = fv.Type(), d.decode(id, name, fv)
 		}
 
 		if err != nil {
-			return err
+			return pathError(err, pathName(name), id, ft)
+		}
+	}
+
+	for _, ft := range required {
+		if !hasAnyName(ft, seen, d.nocase) {
+			tag := tagField(ft.Tag.Get("nbt"), 0)
+			if len(tag) == 0 {
+				tag = ft.Name
+			}
+
+			return fmt.Errorf("%s(%q): missing required tag %q for field %s", TagCompound, name, tag, ft.Name)
 		}
 	}
 
 	return nil
 }
 
+// requiredFields appends the fields of the given struct type which have
+// the "required" option to out, including those of embedded structs.
+func requiredFields(rt reflect.Type, out []reflect.StructField) []reflect.StructField {
+	for i := 0; i < rt.NumField(); i++ {
+		ft := rt.Field(i)
+
+		if hasField(ft.Tag.Get("nbt"), "required") {
+			out = append(out, ft)
+		}
+
+		if (ft.Anonymous && ft.Type.Kind() == reflect.Struct) || isInline(ft) {
+			out = requiredFields(ft.Type, out)
+		}
+	}
+
+	return out
+}
+
+// hasAnyName returns true if the given struct field has one of the given
+// names. Refer to hasFieldName.
+func hasAnyName(ft reflect.StructField, names []string, fold bool) bool {
+	for _, name := range names {
+		if hasFieldName(ft, name, fold) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeMap decodes a compound into a map with string keys. Every entry is
+// decoded into the map's value type. Existing entries are kept, unless the
+// input holds an entry with the same name.
+func (d *Decoder) decodeMap(name string, rv reflect.Value) error {
+	rt := rv.Type()
+
+	if rt.Key().Kind() != reflect.String {
+		return fmt.Errorf("%s(%q): map %v must have string keys", TagCompound, name, rt)
+	}
+
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(rt))
+	}
+
+	for {
+		id, name, err := d.readHeader(TagUnknown)
+		if err != nil {
+			return err
+		}
+
+		if id == TagEnd {
+			return nil
+		}
+
+		ev := reflect.New(rt.Elem()).Elem()
+
+		err = d.decode(id, name, ev)
+		if err != nil {
+			return pathError(err, pathName(name), id, ev.Type())
+		}
+
+		rv.SetMapIndex(reflect.ValueOf(name).Convert(rt.Key()), ev)
+	}
+}
+
 func (d *Decoder) decodeList(name string, rv reflect.Value) error {
-	if rv.Kind() != reflect.Slice {
-		return fmt.Errorf("%s(%q): value %v must be slice", tagCompound, name, rv)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Errorf("%s(%q): value %v must be slice", TagCompound, name, rv)
 	}
 
 	n, err := d.readByte()
@@ -127,62 +357,141 @@ func (d *Decoder) decodeList(name string, rv reflect.Value) error {
 		return err
 	}
 
-	if size == 0 {
-		return nil
+	id := TagId(n)
+
+	err = d.checkSize(TagList, size, minSize(id))
+	if err != nil {
+		return err
 	}
 
-	id := tagId(n)
 	rt := rv.Type()
 	et := rt.Elem()
 
-	new := reflect.MakeSlice(rt, 0, int(size))
+	if rv.Kind() == reflect.Array {
+		return d.decodeArray(id, name, int(size), rv)
+	}
 
-	for i := 0; i < int(size); i++ {
-		var elem reflect.Value
+	// Reuse the existing elements and backing array where possible.
+	// This keeps the garbage down when decoding into the same value
+	// over and over again.
+	var new reflect.Value
+
+	if rv.Cap() >= int(size) {
+		new = rv.Slice(0, int(size))
+	} else {
+		capacity := int(size)
+		if capacity > maxListPrealloc {
+			capacity = maxListPrealloc
+		}
 
-		if et.Kind() == reflect.Ptr {
-			elem = reflect.New(rt.Elem().Elem())
-			err = d.decode(id, "", elem)
-		} else {
-			elem = reflect.New(rt.Elem())
-			elem = reflect.Indirect(elem)
-			err = d.decode(id, "", reflect.Indirect(elem))
+		if capacity < rv.Len() {
+			capacity = rv.Len()
 		}
 
-		if err != nil {
-			return err
+		new = reflect.MakeSlice(rt, rv.Len(), capacity)
+		reflect.Copy(new, rv)
+	}
+
+	for i := 0; i < int(size); i++ {
+		if i >= new.Len() {
+			new = reflect.Append(new, reflect.Zero(et))
 		}
 
-		new = reflect.Append(new, elem)
+		elem := new.Index(i)
+		reset(elem)
+
+		err = d.decode(id, "", elem)
+		if err != nil {
+			return pathError(err, fmt.Sprintf("[%d]", i), id, et)
+		}
 	}
 
 	rv.Set(new)
 	return nil
 }
 
-func (d *Decoder) decodeValue(id tagId, name string, rv reflect.Value) error {
+// decodeArray decodes the size elements of a list with the given
+// element type into the array rv. Elements beyond the end of the list
+// are set to their zero value. Returns an error if the list does not fit.
+func (d *Decoder) decodeArray(id TagId, name string, size int, rv reflect.Value) error {
+	if size > rv.Len() {
+		return fmt.Errorf("%s(%q): list of %d elements does not fit in %v", TagList, name, size, rv.Type())
+	}
+
+	et := rv.Type().Elem()
+
+	for i := 0; i < rv.Len(); i++ {
+		elem := rv.Index(i)
+		reset(elem)
+
+		if i >= size {
+			continue
+		}
+
+		err := d.decode(id, "", elem)
+		if err != nil {
+			return pathError(err, fmt.Sprintf("[%d]", i), id, et)
+		}
+	}
+
+	return nil
+}
+
+// reset clears rv for reuse by the decoder. Slices are truncated, so
+// their backing arrays can be reused. The fields of structs are reset
+// recursively, unless the struct has unexported fields. Everything else,
+// including pointers, is set to its zero value, so that fields which are
+// absent from the input do not keep stale data.
+func reset(rv reflect.Value) {
+	switch rv.Kind() {
+	case reflect.Slice:
+		rv.SetLen(0)
+
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if !rv.Field(i).CanSet() {
+				rv.Set(reflect.Zero(rv.Type()))
+				return
+			}
+		}
+
+		for i := 0; i < rv.NumField(); i++ {
+			reset(rv.Field(i))
+		}
+
+	default:
+		rv.Set(reflect.Zero(rv.Type()))
+	}
+}
+
+func (d *Decoder) decodeValue(id TagId, name string, rv reflect.Value) error {
 	var value interface{}
 	var err error
 
 	switch id {
-	case tagByte:
+	case TagByte:
 		value, err = d.readByte()
-	case tagShort:
+	case TagShort:
 		value, err = d.readShort()
-	case tagInt:
+	case TagInt:
 		value, err = d.readInt()
-	case tagLong:
+	case TagLong:
 		value, err = d.readLong()
-	case tagFloat:
+	case TagFloat:
 		value, err = d.readFloat()
-	case tagDouble:
+	case TagDouble:
 		value, err = d.readDouble()
-	case tagString:
+	case TagString:
 		value, err = d.readString()
-	case tagByteArray:
-		value, err = d.readByteArray()
-	case tagIntArray:
-		value, err = d.readIntArray()
+	case TagByteArray:
+		buf, _ := reusable(rv).([]byte)
+		value, err = d.readByteArray(buf)
+	case TagIntArray:
+		buf, _ := reusable(rv).([]int32)
+		value, err = d.readIntArray(buf)
+	case TagLongArray:
+		buf, _ := reusable(rv).([]int64)
+		value, err = d.readLongArray(buf)
 	default:
 		err = fmt.Errorf("unsupported value %s for field %q", id, name)
 	}
@@ -194,9 +503,19 @@ func (d *Decoder) decodeValue(id tagId, name string, rv reflect.Value) error {
 	return d.set(id, name, rv, value)
 }
 
+// reusable returns the current value of rv, if it is a slice whose
+// backing array may be reused for decoded data. Returns nil otherwise.
+func reusable(rv reflect.Value) interface{} {
+	if rv.Kind() != reflect.Slice || !rv.CanSet() || rv.Cap() == 0 {
+		return nil
+	}
+
+	return rv.Interface()
+}
+
 // set assigns src to dst if possible.
 // It performs implicit type conversions where applicable.
-func (d *Decoder) set(id tagId, name string, dst reflect.Value, src interface{}) error {
+func (d *Decoder) set(id TagId, name string, dst reflect.Value, src interface{}) error {
 	if !dst.IsValid() {
 		return fmt.Errorf("%s(%q): invalid value for %T", id, name, src)
 	}
@@ -210,6 +529,12 @@ func (d *Decoder) set(id tagId, name string, dst reflect.Value, src interface{})
 	sv := reflect.ValueOf(src)
 	st := sv.Type()
 
+	// NBT has no complex type.
+	switch dt.Kind() {
+	case reflect.Complex64, reflect.Complex128:
+		return fmt.Errorf("%s(%q): unsupported type %v", id, name, dt)
+	}
+
 	if !st.AssignableTo(dt) {
 		sv, err = convert(sv, dt, st)
 		if err != nil {
@@ -221,29 +546,157 @@ func (d *Decoder) set(id tagId, name string, dst reflect.Value, src interface{})
 	return nil
 }
 
-func (d *Decoder) skip(id tagId) error {
-	var err error
+// stream copies the raw payload of an array tag into the io.Writer held
+// by rv, without allocating a slice for it. Int and long array elements
+// are written in the byte order of the input.
+func (d *Decoder) stream(id TagId, name string, rv reflect.Value) error {
+	if rv.Type() != writerType {
+		return fmt.Errorf("%s(%q): stream field must be of type %v", id, name, writerType)
+	}
+
+	if rv.IsNil() {
+		return fmt.Errorf("%s(%q): stream field has no writer", id, name)
+	}
+
+	var width int64
 
 	switch id {
-	case tagList:
-		err = d.skipList()
-	case tagCompound:
-		err = d.skipCompound()
+	case TagByteArray:
+		width = 1
+	case TagIntArray:
+		width = 4
+	case TagLongArray:
+		width = 8
 	default:
-		err = d.skipValue(id)
+		return fmt.Errorf("%s(%q): can not stream non-array value", id, name)
 	}
 
+	size, err := d.readInt()
+	if err != nil {
+		return err
+	}
+
+	err = d.checkSize(id, size, int(width))
+	if err != nil {
+		return err
+	}
+
+	w := rv.Interface().(io.Writer)
+	_, err = io.CopyN(w, d.r, int64(size)*width)
 	return err
 }
 
+// subtree decodes a tag into the value held by the Subtree in rv.
+func (d *Decoder) subtree(id TagId, name string, rv reflect.Value) error {
+	st := rv.Interface().(Subtree)
+
+	if st.Value == nil {
+		return fmt.Errorf("%s(%q): remaining field has no value", id, name)
+	}
+
+	v := reflect.ValueOf(st.Value)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("%s(%q): remaining field value must be a non-nil pointer", id, name)
+	}
+
+	return d.decode(id, name, v)
+}
+
+// handleList passes the elements of a list to the ListHandler in rv.
+func (d *Decoder) handleList(id TagId, name string, rv reflect.Value) error {
+	if id != TagList {
+		return fmt.Errorf("%s(%q): list handler field can not hold a non-list value", id, name)
+	}
+
+	if rv.IsNil() {
+		return fmt.Errorf("%s(%q): list handler field has no handler", id, name)
+	}
+
+	n, err := d.readByte()
+	if err != nil {
+		return err
+	}
+
+	size, err := d.readInt()
+	if err != nil {
+		return err
+	}
+
+	elem := TagId(n)
+
+	err = d.checkSize(TagList, size, minSize(elem))
+	if err != nil {
+		return err
+	}
+
+	fn := rv.Interface().(ListHandler)
+
+	for i := 0; i < int(size); i++ {
+		done := false
+
+		decode := func(v interface{}) error {
+			if done {
+				return fmt.Errorf("%s(%q): element %d has already been decoded", id, name, i)
+			}
+
+			ev := reflect.ValueOf(v)
+			if ev.Kind() != reflect.Ptr || ev.IsNil() {
+				return &UnmarshalError{reflect.TypeOf(v)}
+			}
+
+			done = true
+
+			err := d.decode(elem, "", ev)
+			if err != nil {
+				return pathError(err, fmt.Sprintf("[%d]", i), elem, ev.Type().Elem())
+			}
+
+			return nil
+		}
+
+		err = fn(i, decode)
+		if err != nil {
+			return err
+		}
+
+		if !done {
+			err = d.skip(elem)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *Decoder) skip(id TagId) error {
+	if id != TagList && id != TagCompound {
+		return d.skipValue(id)
+	}
+
+	err := d.enter()
+	if err != nil {
+		return err
+	}
+
+	defer d.leave()
+
+	if id == TagList {
+		return d.skipList()
+	}
+
+	return d.skipCompound()
+}
+
 func (d *Decoder) skipCompound() error {
 	for {
-		id, _, err := d.readHeader(tagUnknown)
+		id, _, err := d.readHeader(TagUnknown)
 		if err != nil {
 			return err
 		}
 
-		if id == tagEnd {
+		if id == TagEnd {
 			break
 		}
 
@@ -267,12 +720,13 @@ func (d *Decoder) skipList() error {
 		return err
 	}
 
-	if size == 0 {
-		return nil
+	err = d.checkSize(TagList, size, minSize(TagId(n)))
+	if err != nil {
+		return err
 	}
 
 	for i := 0; i < int(size); i++ {
-		err = d.skip(tagId(n))
+		err = d.skip(TagId(n))
 		if err != nil {
 			return err
 		}
@@ -280,28 +734,30 @@ func (d *Decoder) skipList() error {
 
 	return nil
 }
-func (d *Decoder) skipValue(id tagId) error {
+func (d *Decoder) skipValue(id TagId) error {
 	var err error
 
 	switch id {
-	case tagByte:
+	case TagByte:
 		_, err = d.readByte()
-	case tagShort:
+	case TagShort:
 		_, err = d.readShort()
-	case tagInt:
+	case TagInt:
 		_, err = d.readInt()
-	case tagLong:
+	case TagLong:
 		_, err = d.readLong()
-	case tagFloat:
+	case TagFloat:
 		_, err = d.readFloat()
-	case tagDouble:
+	case TagDouble:
 		_, err = d.readDouble()
-	case tagString:
+	case TagString:
 		_, err = d.readString()
-	case tagByteArray:
-		_, err = d.readByteArray()
-	case tagIntArray:
-		_, err = d.readIntArray()
+	case TagByteArray:
+		_, err = d.readByteArray(nil)
+	case TagIntArray:
+		_, err = d.readIntArray(nil)
+	case TagLongArray:
+		_, err = d.readLongArray(nil)
 	default:
 		err = fmt.Errorf("unsupported value %s", id)
 	}
@@ -309,25 +765,89 @@ func (d *Decoder) skipValue(id tagId) error {
 	return err
 }
 
+// maxArrayPrealloc defines the largest number of bytes allocated up front
+// for an array, if the size of the remaining input is not known. Longer
+// arrays grow as they are read.
+const maxArrayPrealloc = 1 << 16
+
+// prealloc returns the number of elements to allocate up front for an
+// array of the given size, whose elements take up width bytes. The full
+// size has already been checked against the remaining input if the
+// reader reports its length. Otherwise, it is capped.
+func (d *Decoder) prealloc(size int32, width int) int {
+	if _, ok := d.r.(interface {
+		Len() int
+	}); ok {
+		return int(size)
+	}
+
+	if n := maxArrayPrealloc / width; int(size) > n {
+		return n
+	}
+
+	return int(size)
+}
+
+// checkSize returns an error if the given list or array size is invalid.
+// This is the case if it is negative, exceeds the configured maximum or
+// can not fit in the remaining input. width defines the smallest number
+// of bytes taken up by a single element.
+func (d *Decoder) checkSize(id TagId, size int32, width int) error {
+	if size < 0 {
+		return fmt.Errorf("%s with size < 0", id)
+	}
+
+	if d.maxElems > 0 && int(size) > d.maxElems {
+		return fmt.Errorf("%s with %d elements exceeds limit of %d", id, size, d.maxElems)
+	}
+
+	if l, ok := d.r.(interface {
+		Len() int
+	}); ok && int64(size)*int64(width) > int64(l.Len()) {
+		return fmt.Errorf("%s with %d elements exceeds remaining input", id, size)
+	}
+
+	return nil
+}
+
+// minSize returns the smallest number of bytes a value of the given type
+// takes up in a list.
+func minSize(id TagId) int {
+	switch id {
+	case TagByte, TagCompound:
+		return 1
+	case TagShort, TagString:
+		return 2
+	case TagInt, TagFloat, TagByteArray, TagIntArray, TagLongArray:
+		return 4
+	case TagLong, TagDouble:
+		return 8
+	case TagList:
+		return 5
+	}
+
+	return 0
+}
+
 // readHeader reads the next tag header.
-func (d *Decoder) readHeader(id tagId) (tagId, string, error) {
-	if id != tagUnknown {
+func (d *Decoder) readHeader(id TagId) (TagId, string, error) {
+	if id != TagUnknown {
 		// We're in a list -- type/name already known.
 		return id, "", nil
 	}
 
 	n, err := d.readByte()
 	if err != nil {
-		return tagEnd, "", err
+		return TagEnd, "", err
 	}
 
-	id = tagId(n)
+	id = TagId(n)
 
 	var name string
-	if id != tagEnd {
+	if id != TagEnd {
 		name, err = d.readString()
 		if err != nil {
-			return tagEnd, "", err
+			return TagEnd, "", err
 		}
 	}
 
@@ -341,103 +861,252 @@ func (d *Decoder) readByte() (int8, error) {
 
 func (d *Decoder) readShort() (int16, error) {
 	_, err := io.ReadFull(d.r, d.scratch[:2])
-	return int16(d.scratch[0])<<8 | int16(d.scratch[1]), err
+	return int16(d.order.Uint16(d.scratch[:2])), err
 }
 
 func (d *Decoder) readInt() (int32, error) {
 	_, err := io.ReadFull(d.r, d.scratch[:4])
-	return int32(d.scratch[0])<<24 | int32(d.scratch[1])<<16 |
-		int32(d.scratch[2])<<8 | int32(d.scratch[3]), err
+	return int32(d.order.Uint32(d.scratch[:4])), err
 }
 
 func (d *Decoder) readLong() (int64, error) {
 	_, err := io.ReadFull(d.r, d.scratch[:8])
-	return int64(d.scratch[0])<<56 | int64(d.scratch[1])<<48 |
-		int64(d.scratch[2])<<40 | int64(d.scratch[3])<<32 |
-		int64(d.scratch[4])<<24 | int64(d.scratch[5])<<16 |
-		int64(d.scratch[6])<<8 | int64(d.scratch[7]), err
+	return int64(d.order.Uint64(d.scratch[:8])), err
 }
 
 func (d *Decoder) readFloat() (float32, error) {
 	_, err := io.ReadFull(d.r, d.scratch[:4])
-	v := uint32(d.scratch[0])<<24 | uint32(d.scratch[1])<<16 |
-		uint32(d.scratch[2])<<8 | uint32(d.scratch[3])
-	return math.Float32frombits(v), err
+	return math.Float32frombits(d.order.Uint32(d.scratch[:4])), err
 }
 
 func (d *Decoder) readDouble() (float64, error) {
 	_, err := io.ReadFull(d.r, d.scratch[:8])
-	v := uint64(d.scratch[0])<<56 | uint64(d.scratch[1])<<48 |
-		uint64(d.scratch[2])<<40 | uint64(d.scratch[3])<<32 |
-		uint64(d.scratch[4])<<24 | uint64(d.scratch[5])<<16 |
-		uint64(d.scratch[6])<<8 | uint64(d.scratch[7])
-	return math.Float64frombits(v), err
+	return math.Float64frombits(d.order.Uint64(d.scratch[:8])), err
 }
 
-func (d *Decoder) readByteArray() ([]byte, error) {
+// readByteArray reads a TagByteArray. The capacity of buf is reused
+// if it is large enough to hold the data.
+func (d *Decoder) readByteArray(buf []byte) ([]byte, error) {
 	size, err := d.readInt()
 	if err != nil {
 		return nil, err
 	}
 
-	if size < 0 {
-		return nil, fmt.Errorf("%s with size < 0", tagByteArray)
+	err = d.checkSize(TagByteArray, size, 1)
+	if err != nil {
+		return nil, err
 	}
 
 	if size == 0 {
-		return nil, nil
+		return buf[:0], nil
 	}
 
-	out := make([]byte, size)
-	_, err = io.ReadFull(d.r, out)
-	return out, err
+	out := buf[:0]
+	if cap(out) < int(size) {
+		out = make([]byte, 0, d.prealloc(size, 1))
+	}
+
+	// Grow the slice as data arrives, so truncated input claiming a huge
+	// size does not allocate all of it.
+	for len(out) < int(size) {
+		if len(out) == cap(out) {
+			out = append(out, 0)[:len(out)]
+		}
+
+		n := cap(out)
+		if n > int(size) {
+			n = int(size)
+		}
+
+		_, err = io.ReadFull(d.r, out[len(out):n])
+		if err != nil {
+			return nil, err
+		}
+
+		out = out[:n]
+	}
+
+	return out, nil
 }
 
+// readString reads a TagString. The length prefix is unsigned, so strings
+// can hold up to 65535 bytes.
 func (d *Decoder) readString() (string, error) {
 	size, err := d.readShort()
 	if err != nil {
 		return "", err
 	}
 
-	if size < 0 {
-		return "", fmt.Errorf("%s with size < 0", tagString)
+	if size == 0 {
+		return "", nil
+	}
+
+	n := int(uint16(size))
+	if cap(d.strbuf) < n {
+		d.strbuf = make([]byte, n)
+	}
+
+	buf := d.strbuf[:n]
+
+	_, err = io.ReadFull(d.r, buf)
+	if err != nil {
+		return "", err
+	}
+
+	return d.intern(buf), nil
+}
+
+// Limits for string interning. Together they bound the memory a decoder
+// holds on to.
+const (
+	maxInternLen   = 64   // Longest string which is interned.
+	maxInternCount = 4096 // Largest number of interned strings.
+)
+
+// intern returns the string for the modified UTF-8 data in b. Short strings
+// are kept in a table, so the ones which occur over and over, like tag
+// names, block names and their properties, are only allocated once for
+// every decoder. This holds no reference to b.
+func (d *Decoder) intern(b []byte) string {
+	if len(b) > maxInternLen || needsDecoding(b) {
+		return decodeMUTF8(b)
+	}
+
+	if s, ok := d.strings[string(b)]; ok {
+		return s
+	}
+
+	s := string(b)
+
+	if len(d.strings) < maxInternCount {
+		if d.strings == nil {
+			d.strings = make(map[string]string)
+		}
+
+		d.strings[s] = s
+	}
+
+	return s
+}
+
+// readIntArray reads a TagIntArray. The capacity of buf is reused
+// if it is large enough to hold the data.
+func (d *Decoder) readIntArray(buf []int32) ([]int32, error) {
+	size, err := d.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.checkSize(TagIntArray, size, 4)
+	if err != nil {
+		return nil, err
 	}
 
 	if size == 0 {
-		return "", nil
+		return buf[:0], nil
+	}
+
+	out := buf[:0]
+	if cap(out) < int(size) {
+		out = make([]int32, 0, d.prealloc(size, 4))
+	}
+
+	for i := 0; i < int(size); i++ {
+		v, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, v)
 	}
 
-	out := make([]byte, size)
-	_, err = io.ReadFull(d.r, out)
-	return string(out), err
+	return out, nil
 }
 
-func (d *Decoder) readIntArray() ([]int32, error) {
+// readLongArray reads a TagLongArray. The capacity of buf is reused
+// if it is large enough to hold the data.
+func (d *Decoder) readLongArray(buf []int64) ([]int64, error) {
 	size, err := d.readInt()
 	if err != nil {
 		return nil, err
 	}
 
-	if size < 0 {
-		return nil, fmt.Errorf("%s with size < 0", tagIntArray)
+	err = d.checkSize(TagLongArray, size, 8)
+	if err != nil {
+		return nil, err
 	}
 
 	if size == 0 {
-		return nil, nil
+		return buf[:0], nil
 	}
 
-	out := make([]int32, size)
+	out := buf[:0]
+	if cap(out) < int(size) {
+		out = make([]int64, 0, d.prealloc(size, 8))
+	}
 
 	for i := 0; i < int(size); i++ {
-		out[i], err = d.readInt()
+		v, err := d.readLong()
 		if err != nil {
 			return nil, err
 		}
+
+		out = append(out, v)
 	}
 
 	return out, nil
 }
 
+// writerType defines the type of fields which accept streamed array data.
+var writerType = reflect.TypeOf((*io.Writer)(nil)).Elem()
+
+// listHandlerType defines the type of fields which receive list elements
+// one at a time.
+var listHandlerType = reflect.TypeOf(ListHandler(nil))
+
+// subtreeType defines the type of fields tagged with `remaining`.
+var subtreeType = reflect.TypeOf(Subtree{})
+
+// remainingField finds a Subtree field tagged with `remaining`, which wants
+// to capture the tag with the given name. This searches anonymous, embedded
+// structs the same way readField does.
+//
+// If no match can be found, reflect.Invalid is returned.
+// The second return value holds the field's "nbt" tag.
+func remainingField(rv reflect.Value, name string) (reflect.Value, string) {
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, ""
+	}
+
+	rt := rv.Type()
+
+	for i := 0; i < rv.NumField(); i++ {
+		ft := rt.Field(i)
+		tag := ft.Tag.Get("nbt")
+
+		if ft.Type == subtreeType && hasField(tag, "remaining") {
+			if rv.Field(i).Interface().(Subtree).Name == name {
+				return rv.Field(i), tag
+			}
+			continue
+		}
+
+		if !ft.Anonymous && !isInline(ft) {
+			continue
+		}
+
+		if ret, tag := remainingField(rv.Field(i), name); ret.IsValid() {
+			return ret, tag
+		}
+	}
+
+	return reflect.Value{}, ""
+}
+
 // readField finds a field in the given struct with the specified name
 // and returns its value.
 //
@@ -446,14 +1115,48 @@ func (d *Decoder) readIntArray() ([]int32, error) {
 // If multiple anonymous structs export a field with the same name, the
 // first one we encounter will be used.
 //
+// Multiple fields may use the same name, when a tag can hold different
+// types of data. In this case, the first field whose type fits the given
+// tag type is used. If none of them fit, the first match is returned.
+//
+// If nocase is true and no field matches exactly, names are compared
+// case-insensitively.
+//
 // If no match can be found, reflect.Invalid is returned.
-func readField(rv reflect.Value, name string) reflect.Value {
+// The second return value holds the field's "nbt" tag.
+func readField(rv reflect.Value, name string, id TagId, nocase bool) (reflect.Value, string) {
+	fold := false
+	fv, tag, fits := findField(rv, name, id, false, fold)
+
+	if !fv.IsValid() && nocase {
+		fold = true
+		fv, tag, fits = findField(rv, name, id, false, fold)
+	}
+
+	if fv.IsValid() && !fits {
+		// Look for a better match.
+		if ov, otag, ok := findField(rv, name, id, true, fold); ok {
+			return ov, otag
+		}
+	}
+
+	return fv, tag
+}
+
+// findField returns the first field matching the given name. It also
+// returns whether the field's type fits the given tag type. If strict is
+// true, fields which do not fit are ignored. If fold is true, names are
+// compared case-insensitively.
+func findField(rv reflect.Value, name string, id TagId, strict, fold bool) (reflect.Value, string, bool) {
 	if rv.Kind() == reflect.Ptr {
 		rv = rv.Elem()
 	}
 
 	if rv.Kind() != reflect.Struct {
-		return rv
+		if strict {
+			return reflect.Value{}, "", false
+		}
+		return rv, "", true
 	}
 
 	rt := rv.Type()
@@ -461,39 +1164,96 @@ func readField(rv reflect.Value, name string) reflect.Value {
 	for i := 0; i < rv.NumField(); i++ {
 		ft := rt.Field(i)
 
-		if hasFieldName(ft, name) {
-			return rv.Field(i)
+		if isInline(ft) {
+			ret, tag, fits := findField(rv.Field(i), name, id, strict, fold)
+			if ret.Kind() != reflect.Invalid {
+				return ret, tag, fits
+			}
+			continue
+		}
+
+		if hasFieldName(ft, name, fold) && !hasField(ft.Tag.Get("nbt"), "remaining") {
+			fits := fitsTag(ft.Type, id)
+			if fits || !strict {
+				return rv.Field(i), ft.Tag.Get("nbt"), fits
+			}
 		}
 
 		if !ft.Anonymous {
 			continue
 		}
 
-		ret := readField(rv.Field(i), name)
+		ret, tag, fits := findField(rv.Field(i), name, id, strict, fold)
 		if ret.Kind() != reflect.Invalid {
-			return ret
+			return ret, tag, fits
 		}
 	}
 
-	return reflect.Value{}
+	return reflect.Value{}, "", false
+}
+
+// fitsTag returns true if a value of the given type can hold the given
+// tag type. This only tells compounds apart from other values and is used
+// to pick between fields sharing the same name.
+func fitsTag(rt reflect.Type, id TagId) bool {
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+
+	// Interface fields, including Tag, take anything. The concrete tag
+	// types only take their own.
+	if rt.Kind() == reflect.Interface {
+		return true
+	}
+
+	if isTag(rt) {
+		return reflect.Zero(rt).Interface().(Tag).TagId() == id
+	}
+
+	if id == TagCompound {
+		return rt.Kind() == reflect.Struct || rt.Kind() == reflect.Map
+	}
+
+	return rt.Kind() != reflect.Struct && rt.Kind() != reflect.Map
+}
+
+// isInline returns true if the given struct field has the inline option.
+// The fields of such a struct are stored in the enclosing compound, rather
+// than in a compound of their own. This only applies to fields holding a
+// struct value.
+func isInline(ft reflect.StructField) bool {
+	return ft.Type.Kind() == reflect.Struct && hasField(ft.Tag.Get("nbt"), "inline")
 }
 
 // hasFieldName returns true if the given struct field has the specified name.
-// This first checks for the presence of a matching "nbt" field tag. Otherwise
-// the field name itself is considered.
+// This first checks for the presence of a matching "nbt" field tag, including
+// the names given with the alias option. Otherwise the field name itself is
+// considered. Fields tagged with "-" never match.
 //
-// This is a case-sensitive check.
-func hasFieldName(ft reflect.StructField, name string) bool {
+// This is a case-sensitive check, unless fold is true.
+func hasFieldName(ft reflect.StructField, name string, fold bool) bool {
 	tag := ft.Tag.Get("nbt")
-	elem := strings.Split(tag, ",")
+	if tag == "-" {
+		return false
+	}
 
-	for _, v := range elem {
-		if len(v) > 0 && v == name {
+	for len(tag) > 0 {
+		var v string
+		v, tag, _ = strings.Cut(tag, ",")
+		v = strings.TrimPrefix(v, "alias=")
+
+		if len(v) > 0 && sameName(v, name, fold) {
 			return true
 		}
 	}
 
-	return ft.Name == name
+	return sameName(ft.Name, name, fold)
+}
+
+// sameName returns true if the two names are equal. If fold is true, this
+// ignores case.
+func sameName(a, b string, fold bool) bool {
+	return a == b || (fold && strings.EqualFold(a, b))
 }
 
 // convert tries to convert rv from the source type to destination type.
@@ -502,6 +1262,10 @@ func hasFieldName(ft reflect.StructField, name string) bool {
 //
 // Refer to the "Type compatibility" section in the `nbt` package README.
 func convert(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
+	if src.Kind() == reflect.Slice && dst.Kind() == reflect.Array {
+		return convertArray(rv, dst, src)
+	}
+
 	if src.ConvertibleTo(dst) {
 		return rv.Convert(dst), nil
 	}
@@ -512,6 +1276,12 @@ func convert(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
 			break
 		}
 
+		// Named element types, like []MyInt, can not use the fast paths
+		// below. These are converted element by element.
+		if dst.Elem().PkgPath() != "" {
+			return convertElems(rv, dst, src)
+		}
+
 		switch src.Elem().Kind() {
 		case reflect.Int8:
 			v := rv.Interface().([]int8)
@@ -522,7 +1292,7 @@ func convert(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
 					return reflect.ValueOf(([]uint8)(nil)), nil
 				}
 
-				ptr := (*(*[1<<31 - 1]uint8)(unsafe.Pointer(&v[0])))[:len(v)]
+				ptr := unsafe.Slice((*uint8)(unsafe.Pointer(&v[0])), len(v))
 				return reflect.ValueOf(ptr), nil
 			}
 
@@ -535,7 +1305,7 @@ func convert(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
 					return reflect.ValueOf(([]int8)(nil)), nil
 				}
 
-				ptr := (*(*[1<<31 - 1]int8)(unsafe.Pointer(&v[0])))[:len(v)]
+				ptr := unsafe.Slice((*int8)(unsafe.Pointer(&v[0])), len(v))
 				return reflect.ValueOf(ptr), nil
 			}
 
@@ -548,7 +1318,7 @@ func convert(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
 					return reflect.ValueOf(([]uint32)(nil)), nil
 				}
 
-				ptr := (*(*[1<<31 - 1]uint32)(unsafe.Pointer(&v[0])))[:len(v)]
+				ptr := unsafe.Slice((*uint32)(unsafe.Pointer(&v[0])), len(v))
 				return reflect.ValueOf(ptr), nil
 			}
 
@@ -561,7 +1331,20 @@ func convert(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
 					return reflect.ValueOf(([]int32)(nil)), nil
 				}
 
-				ptr := (*(*[1<<31 - 1]int32)(unsafe.Pointer(&v[0])))[:len(v)]
+				ptr := unsafe.Slice((*int32)(unsafe.Pointer(&v[0])), len(v))
+				return reflect.ValueOf(ptr), nil
+			}
+
+		case reflect.Int64:
+			v := rv.Interface().([]int64)
+
+			switch dst.Elem().Kind() {
+			case reflect.Uint64:
+				if len(v) == 0 {
+					return reflect.ValueOf(([]uint64)(nil)), nil
+				}
+
+				ptr := unsafe.Slice((*uint64)(unsafe.Pointer(&v[0])), len(v))
 				return reflect.ValueOf(ptr), nil
 			}
 		}
@@ -650,3 +1433,61 @@ func convert(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
 
 	return rv, fmt.Errorf("can not convert %v(%v) to %v", src, rv.Interface(), dst)
 }
+
+// convertElems converts the slice rv to the destination slice type by
+// converting each element. This only applies to integer elements of the
+// same size.
+func convertElems(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
+	de, se := dst.Elem(), src.Elem()
+
+	if !isInteger(de) || !isInteger(se) || de.Size() != se.Size() {
+		return rv, fmt.Errorf("can not convert %v to %v", src, dst)
+	}
+
+	out := reflect.MakeSlice(dst, rv.Len(), rv.Len())
+
+	for i := 0; i < rv.Len(); i++ {
+		out.Index(i).Set(rv.Index(i).Convert(de))
+	}
+
+	return out, nil
+}
+
+// convertArray copies the elements of the slice rv into a new array of
+// type dst. A shorter slice leaves the remaining elements zeroed. A longer
+// one yields an error.
+func convertArray(rv reflect.Value, dst, src reflect.Type) (reflect.Value, error) {
+	de, se := dst.Elem(), src.Elem()
+
+	if rv.Len() > dst.Len() {
+		return rv, fmt.Errorf("can not convert %v of length %d to %v", src, rv.Len(), dst)
+	}
+
+	if !se.ConvertibleTo(de) || (se.Kind() == reflect.String) != (de.Kind() == reflect.String) {
+		return rv, fmt.Errorf("can not convert %v to %v", src, dst)
+	}
+
+	out := reflect.New(dst).Elem()
+
+	if se == de {
+		reflect.Copy(out, rv)
+		return out, nil
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		out.Index(i).Set(rv.Index(i).Convert(de))
+	}
+
+	return out, nil
+}
+
+// isInteger returns true if the given type is a signed or unsigned integer.
+func isInteger(rt reflect.Type) bool {
+	switch rt.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
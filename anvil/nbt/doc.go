@@ -34,6 +34,13 @@ appropriate tag names in the NBT data. For example:
 	err = nbt.Unmarshal(gz, &level)
 	...
 
+`UnmarshalAuto` does the same for gzip, zlib, LZ4 and uncompressed input,
+by looking at the first few bytes of the data:
+
+	var level Level
+	err := nbt.UnmarshalAuto(r, &level)
+	...
+
 Data can be re-encoded with the `Marshal` call, or directly through the
 `Encoder` type:
 
@@ -75,15 +82,19 @@ following guidelines.
     TAG_Double     | float64             |
     -----------------------------------------------------------------------
     TAG_Byte_Array | []int8, []uint8     |
+                   | BinaryUnmarshaler   | Passed to UnmarshalBinary()
     -----------------------------------------------------------------------
     TAG_Int_Array  | []int32, []uint32   |
     -----------------------------------------------------------------------
+    TAG_Long_Array | []int64, []uint64   |
+    -----------------------------------------------------------------------
     TAG_String     | string              |
                    | bool                | Parsed using strconv.ParseBool()
     -----------------------------------------------------------------------
     TAG_List       | []T, []*T           |
     -----------------------------------------------------------------------
     Tag_Compound   | T, *T               |
+                   | map[string]T        | All entries must fit T.
     -----------------------------------------------------------------------
 
 Any other, incompatible assignment will result in a parse error.
@@ -98,6 +109,46 @@ the types listed above. For instance:
 		...
 	}
 
+Decode errors are a `*DecodeError`. It holds the path of the failing tag,
+like `Level.Sections[3].BlockStates`, along with the tag's type and the Go
+type it was decoded into.
+
+Tags without a matching struct field are skipped. Call
+`Decoder.DisallowUnknownTags` to treat them as errors instead, which helps
+to confirm that a set of types covers a schema completely.
+
+Types which implement encoding.BinaryMarshaler, like a UUID type, are
+encoded as a TAG_Byte_Array holding their binary form. Such a tag is
+decoded through UnmarshalBinary, if the field implements
+encoding.BinaryUnmarshaler. Tag types and time.Time fields keep the
+encoding described above.
+
+Types can take full control of their encoding by implementing
+`Marshaler` and `Unmarshaler`. This takes precedence over all of the
+above. `MarshalNBT` returns the payload of a tag along with its type;
+`UnmarshalNBT` receives the type and payload of the tag to decode:
+
+	func (s Stamp) MarshalNBT() ([]byte, nbt.TagId, error) {
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], uint64(s.Unix()))
+		return data[:], nbt.TagLong, nil
+	}
+
+	func (s *Stamp) UnmarshalNBT(id nbt.TagId, r io.Reader) error { ... }
+
+A field of type `RawMessage` receives the encoded tag as it is, to be
+decoded later. This helps when the layout of a tag depends on another
+one, like the sections of a chunk on its data version. The encoder
+writes the message back verbatim:
+
+	var v struct {
+		DataVersion int32          `nbt:"DataVersion"`
+		Sections    nbt.RawMessage `nbt:"sections"`
+	}
+	err := nbt.Unmarshal(r, &v)
+	...
+	err = nbt.Unmarshal(bytes.NewReader(v.Sections), &sections)
+
 If the encoder should not output a tag for an empty field, append the
 `omitempty` value to the struct field tag. For example:
 
@@ -106,6 +157,242 @@ If the encoder should not output a tag for an empty field, append the
 	}
 
 If `len(T.Data) == 0`, the encoder will ignore this field and no tag is
-emitted.
+emitted. As with encoding/json, false, 0, nil pointers and interfaces and
+empty strings, slices and maps are considered empty. The decoder leaves
+the field untouched if the tag is absent.
+
+A field tagged with "-" is ignored by both the encoder and the decoder.
+Use "-," for a tag which is actually named "-":
+
+	type T struct {
+		Cache []byte `nbt:"-"`
+	}
+
+Fields with the `required` option must be present in the input. Decoding
+fails with an error naming the missing tag otherwise, rather than leaving
+the field at its zero value:
+
+	type T struct {
+		Version int32 `nbt:"DataVersion,required"`
+	}
+
+The `alias` option names an alternative tag for a field. This helps to
+read data written before a tag was renamed. The decoder accepts either
+name, while the encoder always writes the first one:
+
+	type T struct {
+		Status string `nbt:"Status,alias=TerrainPopulated"`
+	}
+
+Tag names are matched to fields case-sensitively. Call
+`Decoder.SetCaseInsensitive` to fall back to matching them regardless of
+case. An exact match still takes precedence.
+
+The `inline` option stores the fields of a struct-typed field directly
+in the enclosing compound, rather than in a compound of its own. The
+decoder looks for the inlined fields among the tags of the enclosing
+compound in turn:
+
+	type Meta struct {
+		Version int32  `nbt:"DataVersion"`
+		Status  string `nbt:"Status"`
+	}
+
+	type Chunk struct {
+		Meta  Meta  `nbt:",inline"` // DataVersion and Status live next to xPos.
+		X     int32 `nbt:"xPos"`
+	}
+
+Byte slices, `[]byte` or `[]uint8`, are always written as a
+TAG_Byte_Array. Signed byte slices, like `[]int8`, are written as a
+TAG_List of TAG_Byte values. The decoder fills either type from either
+tag, and reads a TAG_Byte_Array into a `[]byte` in one go.
+
+Int and long slices are written as a TAG_Int_Array or TAG_Long_Array. The
+`list` option writes a TAG_List of TAG_Int or TAG_Long values instead. The
+decoder accepts either form:
+
+	type T struct {
+		Pos []int32 `nbt:"Pos,list"`
+	}
+
+Go arrays, like `[16]Section`, are written with exactly their own length,
+as whichever tag a slice of the same type would produce. The decoder
+fills an array from a list or array tag of up to that length, and zeroes
+any elements left over. Longer input yields an error.
+
+Very large arrays can be streamed to an `io.Writer` instead of being
+loaded into memory. Tag the field with the `stream` value and assign a
+writer to it before decoding:
+
+	type T struct {
+		Data io.Writer `nbt:"data,stream"`
+	}
+
+	v := T{Data: file}
+	err := nbt.Unmarshal(r, &v)
+
+The decoder copies the raw array payload into the writer. Int and long
+array elements are written in the byte order of the input, which is big
+endian unless `Decoder.SetByteOrder` says otherwise. This only applies to
+decoding; such fields can not be encoded.
+
+Long lists can be processed one element at a time, without collecting
+them in a slice, through a field of type `ListHandler`. It is called for
+every element, and decides whether and into what to decode it:
+
+	type Chunk struct {
+		Entities nbt.ListHandler `nbt:"Entities"`
+	}
+
+	var e Entity
+	v := Chunk{Entities: func(i int, decode func(interface{}) error) error {
+		err := decode(&e)
+		...
+	}}
+
+Elements which the handler does not decode are skipped. The encoder
+ignores such fields.
+
+Some tags hold different kinds of data, depending on context. In that
+case, multiple fields can use the same name. The decoder assigns a
+compound to the first of these fields which is a struct or a
+map, and any other value to the first one which is not:
+
+	type T struct {
+		Preset string    `nbt:"settings,omitempty"`
+		Custom *Settings `nbt:"settings"`
+	}
+
+A compound whose entries all have the same type can be decoded into a map
+with string keys, like `map[string]string` or `map[string]int32`. Such a
+map is encoded as a compound, with its entries in sorted key order:
+
+	type Rules struct {
+		Rules map[string]string `nbt:"GameRules"`
+	}
+
+If the name of an interesting tag is only known at runtime, a field of
+type `Subtree`, tagged with the `remaining` value, can capture it. Set its
+name and a pointer to the value which should receive the data before
+decoding:
+
+	type T struct {
+		Name  string      `nbt:"name"`
+		Extra nbt.Subtree `nbt:",remaining"`
+	}
+
+	var data []byte
+	v := T{Extra: nbt.Subtree{Name: key, Value: &data}}
+	err := nbt.Unmarshal(r, &v)
+
+The subtree only receives a tag which is not claimed by any other field.
+When encoding, the value is written under the subtree's name, unless the
+name is empty or there is no value.
+
+Data whose structure is not known up front can be decoded into a `Tag`
+value. This yields a tree of the dynamic tag types, like `Int`, `List`
+and `Compound`:
+
+	var v nbt.Tag
+	err := nbt.Unmarshal(r, &v)
+
+	root := v.(nbt.Compound)
+	name := root["LevelName"].(nbt.String)
+
+Struct fields can use these types as well. This is useful when most of
+the data has a known structure, but part of it varies, for instance by
+game version. A field of type `Tag` receives any value; a field of one
+of the concrete tag types only accepts values of that type:
+
+	type Chunk struct {
+		X        int32   `nbt:"xPos"`
+		Z        int32   `nbt:"zPos"`
+		Sections nbt.Tag `nbt:"sections"`
+	}
+
+The encoder writes such fields back as they are. A nil `Tag` is not
+written at all.
+
+Decoding into an `interface{}` or a `map[string]interface{}` yields plain
+Go values instead. Compounds become `map[string]interface{}`, lists become
+`[]interface{}` and all other tags one of `int8`, `int16`, `int32`,
+`int64`, `float32`, `float64`, `string`, `[]byte`, `[]int32` or `[]int64`.
+Each of these maps to a single tag, so encoding the result writes the
+same tag types. Maps do not keep the order
+of their entries, however, nor do empty lists keep their element type.
+With `Decoder.SetOrdered`, an `interface{}` receives an `OrderedCompound`
+or `List` for these instead, which re-encode to the exact input.
+
+Compounds whose type depends on their "id" tag, like entities and block
+entities, can be decoded into an interface value. Register the concrete
+type for each id with `RegisterType`:
+
+	nbt.RegisterType("minecraft:chest", reflect.TypeOf(Chest{}))
+
+	type Chunk struct {
+		BlockEntities []BlockEntity `nbt:"block_entities"`
+	}
+
+A compound whose id has no registered type is only accepted if the
+interface can hold an `OrderedCompound`. An `interface{}` receives it as
+described above.
+
+A `Compound` does not retain the order of its entries. Decode into an
+`OrderedCompound` instead, or call `Decoder.SetOrdered`, to keep them in
+their original order. To keep the name of the root tag as well, decode
+into a `KeyValue`:
+
+	dec := nbt.NewDecoder(r)
+	dec.SetOrdered(true)
+
+	var root nbt.KeyValue
+	err := dec.Decode(&root)
+	...
+	err = nbt.Marshal(w, root)
+
+Encoding such a value yields the same bytes as the input.
+
+The encoder writes tags in a fixed order, which tools can rely on. Struct
+fields are written in declaration order. The fields of an inline struct
+and a `remaining` subtree take the place of their own field. Maps and
+`Compound` values are written in sorted key order, while an
+`OrderedCompound` keeps the order it holds. A struct only reproduces the
+order of the input if its fields are declared in that order and no tags
+are skipped, so use an `OrderedCompound` to re-encode data, like a chunk,
+into the exact bytes Minecraft wrote.
+
+For values of any other type, `Decoder.DecodeNamed` returns the name of
+the root tag and `Encoder.EncodeNamed` sets it.
+
+`SaveTagFile` writes a tag tree to a compressed file in a single step:
+
+	err := nbt.SaveTagFile("level.dat", "", root, nbt.GZip)
+
+`ToJSON` converts NBT data into JSON for other tools. Each value becomes
+an object holding its tag type, like `{"type":"byte","value":1}`, and
+`FromJSON` turns that back into the exact same NBT data. `ToJSONMode`
+with `JSONFlat` writes plain JSON values instead, which loses the tag
+types:
+
+	err := nbt.ToJSONMode(r, os.Stdout, nbt.JSONFlat)
+
+To scan the input without decoding it into a value, read it one element
+at a time with `Decoder.Token`. Compounds and lists yield a `TagStart`,
+followed by their contents and `TagEnd`. Everything else yields a
+`KeyValue`. `Decoder.Skip` discards the remainder of the innermost
+compound or list, and `Decoder.Depth` reports the current nesting level:
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		...
+		if s, ok := tok.(nbt.TagStart); ok && s.Name == "sections" {
+			err = dec.Skip()
+			...
+		}
+	}
 */
 package nbt
@@ -4,12 +4,14 @@
 package nbt
 
 import (
+	"encoding/binary"
+	"fmt"
 	"io"
 	"math"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
-	"unsafe"
 )
 
 // Marshal translates data into uncompressed, NBT-encoded data and writes
@@ -21,13 +23,37 @@ func Marshal(w io.Writer, v interface{}) error {
 
 // Encoder translates a Go type into a stream of NBT encoded data.
 type Encoder struct {
-	w io.Writer
+	w            io.Writer
+	order        binary.ByteOrder // Byte order of numeric values.
+	legacyArrays bool             // Write int and long arrays as lists.
+	canonicalNaN bool             // Write every NaN with the same bit pattern.
 }
 
 // NewEncoder creates a new encoder for the given value.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w}
-}
+	return &Encoder{w: w, order: binary.BigEndian}
+}
+
+// SetByteOrder sets the byte order of numeric values in the output. Java
+// Edition data is big endian, which is the default. Bedrock Edition uses
+// binary.LittleEndian.
+func (e *Encoder) SetByteOrder(order binary.ByteOrder) { e.order = order }
+
+// SetLegacyArraysAsList determines how int and long slices are written.
+// By default they become a TAG_Int_Array or TAG_Long_Array. If legacy is
+// true, they are written as a TAG_List of TAG_Int or TAG_Long values
+// instead. This is for consumers which predate the array tags. Byte
+// slices are always written as a TAG_Byte_Array. Refer to the `list`
+// field option to use a TAG_List for individual fields.
+func (e *Encoder) SetLegacyArraysAsList(legacy bool) { e.legacyArrays = legacy }
+
+// CanonicalizeNaN determines how NaN values of float and double tags are
+// written. Producers use different bit patterns for NaN, so equal data may
+// not encode to identical bytes. If enabled, every NaN is written as the
+// canonical quiet NaN Java uses: 0x7fc00000 for floats and
+// 0x7ff8000000000000 for doubles. This matters for content addressed
+// storage, where equal chunks must hash the same. It is disabled by default.
+func (e *Encoder) CanonicalizeNaN(enabled bool) { e.canonicalNaN = enabled }
 
 // Encode translates v into uncompressed, NBT-encoded data and writes
 // it to the underlying stream.
@@ -38,9 +64,41 @@ func (e *Encoder) Encode(v interface{}) error {
 		return &MarshalError{Type: reflect.TypeOf(v)}
 	}
 
+	// A KeyValue defines the root tag along with its name.
+	switch kv := v.(type) {
+	case KeyValue:
+		return e.encodeKeyValue(kv)
+	case *KeyValue:
+		if kv != nil {
+			return e.encodeKeyValue(*kv)
+		}
+	}
+
 	return e.encode(rv, "", false)
 }
 
+// encodeKeyValue writes the root tag defined by kv. Returns an error if it
+// has no value.
+func (e *Encoder) encodeKeyValue(kv KeyValue) error {
+	if kv.Value == nil {
+		return &MarshalError{Name: kv.Name}
+	}
+
+	return e.encode(reflect.ValueOf(kv.Value), kv.Name, false)
+}
+
+// EncodeNamed is like Encode, but gives the root tag the specified name.
+// Unlike a KeyValue, which has the same effect, v may be of any type.
+func (e *Encoder) EncodeNamed(name string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+
+	if !rv.IsValid() {
+		return &MarshalError{Type: reflect.TypeOf(v)}
+	}
+
+	return e.encode(rv, name, false)
+}
+
 // Encode translates v into uncompressed, NBT-encoded data and writes
 // it to the underlying stream.
 func (e *Encoder) encode(rv reflect.Value, name string, inlist bool) error {
@@ -48,12 +106,38 @@ func (e *Encoder) encode(rv reflect.Value, name string, inlist bool) error {
 		return nil
 	}
 
+	if rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+
+		rv = rv.Elem()
+	}
+
 	rv = reflect.Indirect(rv)
 
+	if rv.CanInterface() {
+		switch t := rv.Interface().(type) {
+		case List, Compound, OrderedCompound:
+			return e.encodeTag(t.(Tag), name, inlist)
+		}
+	}
+
+	if m, ok := nbtMarshaler(rv); ok {
+		return e.encodeNBT(m, name, inlist)
+	}
+
+	if m, ok := binaryMarshaler(rv); ok {
+		return e.encodeBinary(m, name, inlist)
+	}
+
 	switch rv.Kind() {
 	case reflect.Struct:
 		return e.encodeStruct(rv, name, inlist)
 
+	case reflect.Map:
+		return e.encodeMap(rv, name, inlist)
+
 	case reflect.Array, reflect.Slice:
 		return e.encodeSlice(rv, name, inlist)
 
@@ -86,21 +170,62 @@ func (e *Encoder) encode(rv reflect.Value, name string, inlist bool) error {
 }
 
 func (e *Encoder) encodeStruct(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagCompound, name, inlist)
+	err := e.emit(TagCompound, name, inlist)
+	if err != nil {
+		return err
+	}
+
+	err = e.encodeFields(rv)
 	if err != nil {
 		return err
 	}
 
+	return e.writeU8(uint8(TagEnd))
+}
+
+// encodeFields writes the fields of the struct rv as entries of the
+// current compound. Fields with the inline option add their own fields.
+func (e *Encoder) encodeFields(rv reflect.Value) error {
+	var err error
 	rt := rv.Type()
 
 	for i := 0; i < rv.NumField(); i++ {
 		fv := rv.Field(i)
 		ft := rt.Field(i)
 
+		if ft.Tag.Get("nbt") == "-" {
+			continue
+		}
+
 		if hasField(ft.Tag.Get("nbt"), "omitempty") && isEmpty(fv) {
 			continue
 		}
 
+		if isInline(ft) {
+			err = e.encodeFields(fv)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// List handlers only apply to decoding.
+		if ft.Type == listHandlerType {
+			continue
+		}
+
+		// A subtree is emitted under its runtime name.
+		if ft.Type == subtreeType && hasField(ft.Tag.Get("nbt"), "remaining") {
+			st := fv.Interface().(Subtree)
+			if len(st.Name) > 0 && st.Value != nil {
+				err = e.encode(reflect.ValueOf(st.Value), st.Name, false)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
 		fname := tagField(ft.Tag.Get("nbt"), 0)
 		if len(fname) == 0 {
 			fname = ft.Name
@@ -112,13 +237,61 @@ func (e *Encoder) encodeStruct(rv reflect.Value, name string, inlist bool) error
 			fv = reflect.ValueOf(t)
 		}
 
-		err = e.encode(fv, fname, false)
+		// The list option overrides the array tag for this field.
+		if hasField(ft.Tag.Get("nbt"), "list") && isNumericArray(fv.Type()) {
+			err = e.encodeList(fv, fname, false)
+		} else {
+			err = e.encode(fv, fname, false)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isNumericArray returns true if rt is a slice or array which is written
+// as a TAG_Int_Array or TAG_Long_Array.
+func isNumericArray(rt reflect.Type) bool {
+	if rt.Kind() != reflect.Slice && rt.Kind() != reflect.Array {
+		return false
+	}
+
+	switch rt.Elem().Kind() {
+	case reflect.Int32, reflect.Uint32, reflect.Int64, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
+
+// encodeMap encodes a map with string keys as a compound. The entries are
+// written in sorted key order, so the output does not change between runs.
+func (e *Encoder) encodeMap(rv reflect.Value, name string, inlist bool) error {
+	if rv.Type().Key().Kind() != reflect.String {
+		return &MarshalError{Name: name, Type: rv.Type()}
+	}
+
+	err := e.emit(TagCompound, name, inlist)
+	if err != nil {
+		return err
+	}
+
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	for _, k := range keys {
+		err = e.encode(rv.MapIndex(k), k.String(), false)
 		if err != nil {
 			return err
 		}
 	}
 
-	return e.writeU8(uint8(tagEnd))
+	return e.writeU8(uint8(TagEnd))
 }
 
 // isTime returns true if rv is a valid type for time.Time.
@@ -132,22 +305,31 @@ func (e *Encoder) encodeSlice(rv reflect.Value, name string, inlist bool) error
 	rt := rv.Type()
 	et := rt.Elem()
 
+	// Only byte slices form a TAG_Byte_Array. Signed bytes, as in []int8,
+	// are written as a TAG_List of TAG_Byte.
 	switch et.Kind() {
-	case reflect.Int8, reflect.Uint8:
+	case reflect.Uint8:
 		return e.encodeByteArray(rv, name, inlist)
 
 	case reflect.Int32, reflect.Uint32:
+		if e.legacyArrays {
+			return e.encodeList(rv, name, inlist)
+		}
 		return e.encodeIntArray(rv, name, inlist)
 
+	case reflect.Int64, reflect.Uint64:
+		if e.legacyArrays {
+			return e.encodeList(rv, name, inlist)
+		}
+		return e.encodeLongArray(rv, name, inlist)
+
 	default:
 		return e.encodeList(rv, name, inlist)
 	}
-
-	return nil
 }
 
 func (e *Encoder) encodeByteArray(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagByteArray, name, inlist)
+	err := e.emit(TagByteArray, name, inlist)
 	if err != nil {
 		return err
 	}
@@ -161,11 +343,16 @@ func (e *Encoder) encodeByteArray(rv reflect.Value, name string, inlist bool) er
 		return nil
 	}
 
-	data := rv.Interface()
-	out, ok := data.([]uint8)
-	if !ok {
-		i8 := data.([]int8)
-		out = (*(*[1<<31 - 1]uint8)(unsafe.Pointer(&i8[0])))[:len(i8)]
+	var out []byte
+
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+		out = rv.Bytes()
+	} else {
+		out = make([]byte, rv.Len())
+
+		for i := range out {
+			out[i] = byte(rv.Index(i).Uint())
+		}
 	}
 
 	_, err = e.w.Write(out)
@@ -173,7 +360,7 @@ func (e *Encoder) encodeByteArray(rv reflect.Value, name string, inlist bool) er
 }
 
 func (e *Encoder) encodeIntArray(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagIntArray, name, inlist)
+	err := e.emit(TagIntArray, name, inlist)
 	if err != nil {
 		return err
 	}
@@ -188,59 +375,133 @@ func (e *Encoder) encodeIntArray(rv reflect.Value, name string, inlist bool) err
 		return nil
 	}
 
-	data := rv.Interface()
-	out := make([]byte, 0, size*4)
+	out := make([]byte, size*4)
 
-	u32, ok := data.([]uint32)
-	if !ok {
-		i32 := data.([]int32)
-		u32 = (*(*[1<<31 - 1]uint32)(unsafe.Pointer(&i32[0])))[:rv.Len()]
-	}
+	for i := 0; i < rv.Len(); i++ {
+		var v uint32
 
-	for _, v := range u32 {
-		out = append(out, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+		if iv := rv.Index(i); iv.Kind() == reflect.Int32 {
+			v = uint32(iv.Int())
+		} else {
+			v = uint32(iv.Uint())
+		}
+
+		e.order.PutUint32(out[i*4:], v)
 	}
 
 	_, err = e.w.Write(out)
 	return err
 }
 
-func (e *Encoder) encodeList(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagList, name, inlist)
+func (e *Encoder) encodeLongArray(rv reflect.Value, name string, inlist bool) error {
+	err := e.emit(TagLongArray, name, inlist)
 	if err != nil {
 		return err
 	}
 
-	rt := rv.Type()
-	et := rt.Elem()
+	size := uint32(rv.Len())
+	err = e.writeU32(size)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		return nil
+	}
 
-	var id tagId
+	out := make([]byte, size*8)
 
-	switch et.Kind() {
-	case reflect.Ptr:
-		id = tagCompound
+	for i := 0; i < rv.Len(); i++ {
+		var v uint64
 
-	case reflect.Struct:
-		if e.isTime(et) { // Special-case time.Time
-			id = tagLong
+		if iv := rv.Index(i); iv.Kind() == reflect.Int64 {
+			v = uint64(iv.Int())
 		} else {
-			id = tagCompound
+			v = iv.Uint()
+		}
+
+		e.order.PutUint64(out[i*8:], v)
+	}
+
+	_, err = e.w.Write(out)
+	return err
+}
+
+func (e *Encoder) encodeList(rv reflect.Value, name string, inlist bool) error {
+	// Dynamic tags determine the list type themselves.
+	if rv.Type().Elem() == tagType {
+		items := make([]Tag, rv.Len())
+		for i := range items {
+			items[i], _ = rv.Index(i).Interface().(Tag)
 		}
 
-	case reflect.Uint16, reflect.Int16:
-		id = tagShort
+		return e.encodeTagList(List{Items: items}, name, inlist)
+	}
 
-	case reflect.Uint64, reflect.Int64:
-		id = tagLong
+	err := e.emit(TagList, name, inlist)
+	if err != nil {
+		return err
+	}
 
-	case reflect.Float32:
-		id = tagFloat
+	rt := rv.Type()
+	et := rt.Elem()
 
-	case reflect.Float64:
-		id = tagDouble
+	var id TagId
 
-	default:
-		return &MarshalError{Name: name, Type: rt}
+	// Types which marshal themselves report their tag type with every value.
+	// Types with a binary form are written as byte arrays, unless they are
+	// handled by a more specific rule.
+	if marshalsNBT(et) {
+		var ok bool
+		if id, ok = e.elemTag(rv, TagEnd); !ok {
+			return &MarshalError{Name: name, Type: rt}
+		}
+	} else if !e.isTime(et) && marshalsBinary(et) {
+		id = TagByteArray
+	} else {
+		switch et.Kind() {
+		case reflect.Ptr, reflect.Map:
+			id = TagCompound
+
+		case reflect.Interface:
+			// Interface values must all hold the same type of tag, like
+			// the structs decoded through RegisterType.
+			var ok bool
+			if id, ok = e.elemTag(rv, TagCompound); !ok {
+				return &MarshalError{Name: name, Type: rt}
+			}
+
+		case reflect.Struct:
+			if e.isTime(et) { // Special-case time.Time
+				id = TagLong
+			} else {
+				id = TagCompound
+			}
+
+		case reflect.Uint8, reflect.Int8:
+			id = TagByte
+
+		case reflect.Uint16, reflect.Int16:
+			id = TagShort
+
+		case reflect.Uint32, reflect.Int32:
+			id = TagInt
+
+		case reflect.Uint64, reflect.Int64:
+			id = TagLong
+
+		case reflect.Float32:
+			id = TagFloat
+
+		case reflect.Float64:
+			id = TagDouble
+
+		case reflect.String:
+			id = TagString
+
+		default:
+			return &MarshalError{Name: name, Type: rt}
+		}
 	}
 
 	err = e.writeU8(uint8(id))
@@ -275,7 +536,7 @@ func (e *Encoder) encodeList(rv reflect.Value, name string, inlist bool) error {
 }
 
 func (e *Encoder) encodeString(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagString, name, inlist)
+	err := e.emit(TagString, name, inlist)
 	if err != nil {
 		return err
 	}
@@ -284,7 +545,7 @@ func (e *Encoder) encodeString(rv reflect.Value, name string, inlist bool) error
 }
 
 func (e *Encoder) encodeByte(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagByte, name, inlist)
+	err := e.emit(TagByte, name, inlist)
 	if err != nil {
 		return err
 	}
@@ -307,7 +568,7 @@ func (e *Encoder) encodeByte(rv reflect.Value, name string, inlist bool) error {
 }
 
 func (e *Encoder) encodeShort(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagShort, name, inlist)
+	err := e.emit(TagShort, name, inlist)
 	if err != nil {
 		return err
 	}
@@ -324,7 +585,7 @@ func (e *Encoder) encodeShort(rv reflect.Value, name string, inlist bool) error
 }
 
 func (e *Encoder) encodeInt(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagInt, name, inlist)
+	err := e.emit(TagInt, name, inlist)
 	if err != nil {
 		return err
 	}
@@ -341,7 +602,7 @@ func (e *Encoder) encodeInt(rv reflect.Value, name string, inlist bool) error {
 }
 
 func (e *Encoder) encodeLong(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagLong, name, inlist)
+	err := e.emit(TagLong, name, inlist)
 	if err != nil {
 		return err
 	}
@@ -358,7 +619,7 @@ func (e *Encoder) encodeLong(rv reflect.Value, name string, inlist bool) error {
 }
 
 func (e *Encoder) encodeFloat(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagFloat, name, inlist)
+	err := e.emit(TagFloat, name, inlist)
 	if err != nil {
 		return err
 	}
@@ -366,14 +627,14 @@ func (e *Encoder) encodeFloat(rv reflect.Value, name string, inlist bool) error
 }
 
 func (e *Encoder) encodeDouble(rv reflect.Value, name string, inlist bool) error {
-	err := e.emit(tagDouble, name, inlist)
+	err := e.emit(TagDouble, name, inlist)
 	if err != nil {
 		return err
 	}
 	return e.writeF64(rv.Float())
 }
 
-func (e *Encoder) emit(id tagId, name string, inlist bool) error {
+func (e *Encoder) emit(id TagId, name string, inlist bool) error {
 	if inlist {
 		return nil
 	}
@@ -386,23 +647,47 @@ func (e *Encoder) emit(id tagId, name string, inlist bool) error {
 	return e.writeString(name)
 }
 
+// Canonical NaN bit patterns, as written by Java's Float.floatToIntBits
+// and Double.doubleToLongBits.
+const (
+	canonicalNaN32 = 0x7fc00000
+	canonicalNaN64 = 0x7ff8000000000000
+)
+
 func (e *Encoder) writeF32(v float32) error {
 	bits := math.Float32bits(v)
+
+	if e.canonicalNaN && v != v {
+		bits = canonicalNaN32
+	}
+
 	return e.writeU32(bits)
 }
 
 func (e *Encoder) writeF64(v float64) error {
 	bits := math.Float64bits(v)
+
+	if e.canonicalNaN && v != v {
+		bits = canonicalNaN64
+	}
+
 	return e.writeU64(bits)
 }
 
+// writeString writes v in modified UTF-8, preceded by its length.
 func (e *Encoder) writeString(v string) error {
-	err := e.writeI16(int16(len(v)))
+	data := encodeMUTF8(v)
+
+	if len(data) > math.MaxUint16 {
+		return fmt.Errorf("nbt: string of %d bytes exceeds maximum length", len(data))
+	}
+
+	err := e.writeU16(uint16(len(data)))
 	if err != nil {
 		return err
 	}
 
-	_, err = e.w.Write([]byte(v))
+	_, err = e.w.Write(data)
 	return err
 }
 
@@ -416,60 +701,35 @@ func (e *Encoder) writeU8(v uint8) error {
 }
 
 func (e *Encoder) writeI16(v int16) error {
-	_, err := e.w.Write([]byte{byte(v >> 8), byte(v)})
-	return err
+	return e.writeU16(uint16(v))
 }
 
 func (e *Encoder) writeU16(v uint16) error {
-	_, err := e.w.Write([]byte{byte(v >> 8), byte(v)})
+	var buf [2]byte
+	e.order.PutUint16(buf[:], v)
+	_, err := e.w.Write(buf[:])
 	return err
 }
 
 func (e *Encoder) writeI32(v int32) error {
-	_, err := e.w.Write([]byte{
-		byte(v >> 24),
-		byte(v >> 16),
-		byte(v >> 8),
-		byte(v),
-	})
-	return err
+	return e.writeU32(uint32(v))
 }
 
 func (e *Encoder) writeU32(v uint32) error {
-	_, err := e.w.Write([]byte{
-		byte(v >> 24),
-		byte(v >> 16),
-		byte(v >> 8),
-		byte(v),
-	})
+	var buf [4]byte
+	e.order.PutUint32(buf[:], v)
+	_, err := e.w.Write(buf[:])
 	return err
 }
 
 func (e *Encoder) writeI64(v int64) error {
-	_, err := e.w.Write([]byte{
-		byte(v >> 56),
-		byte(v >> 48),
-		byte(v >> 40),
-		byte(v >> 32),
-		byte(v >> 24),
-		byte(v >> 16),
-		byte(v >> 8),
-		byte(v),
-	})
-	return err
+	return e.writeU64(uint64(v))
 }
 
 func (e *Encoder) writeU64(v uint64) error {
-	_, err := e.w.Write([]byte{
-		byte(v >> 56),
-		byte(v >> 48),
-		byte(v >> 40),
-		byte(v >> 32),
-		byte(v >> 24),
-		byte(v >> 16),
-		byte(v >> 8),
-		byte(v),
-	})
+	var buf [8]byte
+	e.order.PutUint64(buf[:], v)
+	_, err := e.w.Write(buf[:])
 	return err
 }
 
@@ -481,10 +741,12 @@ func isEmpty(rv reflect.Value) bool {
 	}
 
 	switch rv.Kind() {
-	case reflect.Ptr:
+	case reflect.Ptr, reflect.Interface:
 		return rv.IsNil()
-	case reflect.Slice, reflect.Array, reflect.Map:
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
 		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return rv.Int() == 0
 	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
@@ -508,10 +770,12 @@ func tagField(tag string, n int) string {
 }
 
 // hasField returns true if the given tag field exists.
+// This is called for every decoded field, so it does not allocate.
 func hasField(tag, value string) bool {
-	elem := strings.Split(tag, ",")
+	for len(tag) > 0 {
+		var v string
+		v, tag, _ = strings.Cut(tag, ",")
 
-	for _, v := range elem {
 		if len(v) > 0 && strings.EqualFold(v, value) {
 			return true
 		}
@@ -6,6 +6,8 @@ package nbt
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 // assert throws a panic if the given error is not nil.
@@ -47,5 +49,59 @@ type MarshalError struct {
 }
 
 func (e *MarshalError) Error() string {
+	if len(e.Name) == 0 {
+		return fmt.Sprintf("nbt: unsupported type %s", e.Type)
+	}
+
 	return fmt.Sprintf("nbt: unsupported type %s(%q)", e.Type, e.Name)
 }
+
+// DecodeError describes a tag which could not be decoded.
+type DecodeError struct {
+	Path string       // Location of the tag, like Level.Sections[3].BlockStates.
+	Tag  TagId        // Type of the tag.
+	Type reflect.Type // Type the tag was decoded into, if known.
+	Err  error        // Reason for the failure.
+}
+
+func (e *DecodeError) Error() string {
+	if len(e.Path) == 0 {
+		return fmt.Sprintf("nbt: %v", e.Err)
+	}
+
+	return fmt.Sprintf("nbt: %s: %v", e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// pathError adds elem to the front of the path of err, which occurred
+// while decoding a tag of the given type into a value of type rt. The
+// element is either a tag name, as returned by pathName, or a list index,
+// like "[3]". If err is not a *DecodeError yet, it becomes one.
+func pathError(err error, elem string, id TagId, rt reflect.Type) error {
+	de, ok := err.(*DecodeError)
+	if !ok {
+		de = &DecodeError{Tag: id, Type: rt, Err: err}
+	}
+
+	switch {
+	case len(de.Path) == 0:
+		de.Path = elem
+	case len(elem) > 0 && de.Path[0] != '[':
+		de.Path = elem + "." + de.Path
+	default:
+		de.Path = elem + de.Path
+	}
+
+	return de
+}
+
+// pathName returns the tag name as an element of a DecodeError path.
+// It is quoted if it would be ambiguous otherwise.
+func pathName(name string) string {
+	if !isPlainName(name) || strings.ContainsAny(name, ".") {
+		return strconv.Quote(name)
+	}
+
+	return name
+}
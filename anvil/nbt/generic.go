@@ -0,0 +1,124 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import "reflect"
+
+// anyType is the type of an empty interface, which receives a generic
+// tree of maps, slices and plain values when decoding.
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// decodeAnyList decodes a list into the empty interface rv. It receives
+// a []interface{}, or a List if the decoder keeps compounds in order.
+func (d *Decoder) decodeAnyList(name string, rv reflect.Value) error {
+	if d.ordered {
+		t, err := d.readList(true)
+		if err != nil {
+			return err
+		}
+
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	items := []interface{}{}
+
+	err := d.decodeList(name, reflect.ValueOf(&items).Elem())
+	if err != nil {
+		return err
+	}
+
+	rv.Set(reflect.ValueOf(items))
+	return nil
+}
+
+// tagId returns the type of tag the encoder writes for rv. Returns
+// false if rv can not be encoded.
+func (e *Encoder) tagId(rv reflect.Value) (TagId, bool) {
+	if !rv.IsValid() {
+		return TagEnd, false
+	}
+
+	rt := rv.Type()
+
+	if t, ok := rv.Interface().(Tag); ok {
+		return t.TagId(), true
+	}
+
+	if m, ok := nbtMarshaler(rv); ok {
+		_, id, err := marshalNBT(m, "")
+		return id, err == nil
+	}
+
+	if marshalsBinary(rt) {
+		return TagByteArray, true
+	}
+
+	switch rt.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return TagEnd, false
+		}
+		return e.tagId(rv.Elem())
+
+	case reflect.Struct, reflect.Map:
+		return TagCompound, true
+
+	case reflect.Array, reflect.Slice:
+		switch rt.Elem().Kind() {
+		case reflect.Uint8:
+			return TagByteArray, true
+		case reflect.Int32, reflect.Uint32:
+			if !e.legacyArrays {
+				return TagIntArray, true
+			}
+		case reflect.Int64, reflect.Uint64:
+			if !e.legacyArrays {
+				return TagLongArray, true
+			}
+		}
+		return TagList, true
+
+	case reflect.String:
+		return TagString, true
+
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return TagByte, true
+
+	case reflect.Int16, reflect.Uint16:
+		return TagShort, true
+
+	case reflect.Int32, reflect.Uint32:
+		return TagInt, true
+
+	case reflect.Int64, reflect.Uint64:
+		return TagLong, true
+
+	case reflect.Float32:
+		return TagFloat, true
+
+	case reflect.Float64:
+		return TagDouble, true
+	}
+
+	return TagEnd, false
+}
+
+// elemTag returns the element type of a list whose elements determine
+// their tag type at runtime. All elements must be written as the same
+// type of tag. Empty lists use the given type.
+func (e *Encoder) elemTag(rv reflect.Value, empty TagId) (TagId, bool) {
+	id := empty
+
+	for i := 0; i < rv.Len(); i++ {
+		iid, ok := e.tagId(rv.Index(i))
+		if !ok || (i > 0 && iid != id) {
+			return TagEnd, false
+		}
+
+		id = iid
+	}
+
+	return id, true
+}
@@ -0,0 +1,479 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// JSONMode defines how ToJSONMode represents NBT values in JSON.
+type JSONMode int
+
+// Known JSON modes.
+const (
+	// JSONTyped writes every value as an object holding its tag type and
+	// value, like {"type":"byte","value":1}. FromJSON reads this back
+	// into the original data.
+	JSONTyped JSONMode = iota
+
+	// JSONFlat writes plain JSON values. Compounds become objects, lists
+	// and arrays become arrays and all numbers become plain numbers. The
+	// tag types and the root name are lost.
+	JSONFlat
+)
+
+// jsonTypes holds the names of the tag types in JSONTyped output.
+var jsonTypes = [...]string{
+	TagEnd:       "end",
+	TagByte:      "byte",
+	TagShort:     "short",
+	TagInt:       "int",
+	TagLong:      "long",
+	TagFloat:     "float",
+	TagDouble:    "double",
+	TagByteArray: "byte_array",
+	TagString:    "string",
+	TagList:      "list",
+	TagCompound:  "compound",
+	TagIntArray:  "int_array",
+	TagLongArray: "long_array",
+}
+
+// ToJSON reads NBT data from r and writes it to w as JSON, using the
+// JSONTyped mode. See ToJSONMode for details.
+func ToJSON(r io.Reader, w io.Writer) error {
+	return ToJSONMode(r, w, JSONTyped)
+}
+
+// ToJSONMode reads NBT data from r and writes it to w as JSON, on a single
+// line followed by a newline. The data may be compressed with any scheme
+// UnmarshalAuto recognizes. Compound entries keep the order of the input.
+//
+// In the JSONTyped mode, each value is an object with a "type" and a
+// "value" field. The type is one of byte, short, int, long, float, double,
+// string, byte_array, int_array, long_array, list or compound. Lists also
+// carry the type of their elements in an "elem" field, which is "end"
+// for an empty list without a type. The root object holds the root name
+// in a "name" field:
+//
+//	{"name":"","type":"compound","value":{
+//		"xPos":{"type":"int","value":-1},
+//		"Pos":{"type":"list","elem":"double","value":[...]}
+//	}}
+//
+// The values of compounds are objects and those of lists are arrays of
+// typed values. Byte, int and long arrays are arrays of plain numbers.
+// Bytes are signed. Non-finite floating point values are written as the
+// strings "NaN", "Infinity" and "-Infinity", in either mode.
+func ToJSONMode(r io.Reader, w io.Writer, mode JSONMode) error {
+	if mode != JSONTyped && mode != JSONFlat {
+		return fmt.Errorf("nbt: json: unknown mode %d", mode)
+	}
+
+	br := bufio.NewReader(r)
+
+	c, err := detectCompression(br)
+	if err != nil {
+		return err
+	}
+
+	cr, err := NewCompressedReader(br, c)
+	if err != nil {
+		return err
+	}
+
+	defer cr.Close()
+
+	dec := NewDecoder(cr)
+	dec.SetOrdered(true)
+
+	var t Tag
+	name, err := dec.DecodeNamed(&t)
+	if err != nil {
+		return err
+	}
+
+	var b []byte
+
+	if mode == JSONTyped {
+		b = append(b, `{"name":`...)
+		b = appendJSONString(b, name)
+		b = append(b, ',')
+		b = append(b, appendTypedJSON(nil, t)[1:]...)
+	} else {
+		b = appendFlatJSON(b, t)
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// appendTypedJSON appends the JSONTyped form of t to b.
+func appendTypedJSON(b []byte, t Tag) []byte {
+	b = append(b, `{"type":"`...)
+	b = append(b, jsonTypes[t.TagId()]...)
+	b = append(b, '"')
+
+	switch t := t.(type) {
+	case List:
+		b = append(b, `,"elem":"`...)
+		b = append(b, jsonTypes[t.Elem]...)
+		b = append(b, `","value":[`...)
+		for i, v := range t.Items {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendTypedJSON(b, v)
+		}
+		b = append(b, ']')
+
+	case OrderedCompound:
+		b = append(b, `,"value":{`...)
+		for i, kv := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, kv.Name)
+			b = appendTypedJSON(append(b, ':'), kv.Value)
+		}
+		b = append(b, '}')
+
+	default:
+		b = appendFlatJSON(append(b, `,"value":`...), t)
+	}
+
+	return append(b, '}')
+}
+
+// appendFlatJSON appends the JSONFlat form of t to b.
+func appendFlatJSON(b []byte, t Tag) []byte {
+	switch t := t.(type) {
+	case Byte:
+		return strconv.AppendInt(b, int64(t), 10)
+	case Short:
+		return strconv.AppendInt(b, int64(t), 10)
+	case Int:
+		return strconv.AppendInt(b, int64(t), 10)
+	case Long:
+		return strconv.AppendInt(b, int64(t), 10)
+	case Float:
+		return appendJSONFloat(b, float64(t), 32)
+	case Double:
+		return appendJSONFloat(b, float64(t), 64)
+	case String:
+		return appendJSONString(b, string(t))
+
+	case ByteArray:
+		b = append(b, '[')
+		for i, v := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendInt(b, int64(int8(v)), 10)
+		}
+		return append(b, ']')
+
+	case IntArray:
+		b = append(b, '[')
+		for i, v := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendInt(b, int64(v), 10)
+		}
+		return append(b, ']')
+
+	case LongArray:
+		b = append(b, '[')
+		for i, v := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendInt(b, v, 10)
+		}
+		return append(b, ']')
+
+	case List:
+		b = append(b, '[')
+		for i, v := range t.Items {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendFlatJSON(b, v)
+		}
+		return append(b, ']')
+
+	case OrderedCompound:
+		b = append(b, '{')
+		for i, kv := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, kv.Name)
+			b = appendFlatJSON(append(b, ':'), kv.Value)
+		}
+		return append(b, '}')
+	}
+
+	return append(b, "null"...)
+}
+
+// appendJSONFloat appends the shortest representation of v, which reads
+// back as the same value of the given size. Non-finite values, which JSON
+// can not represent, are written as strings.
+func appendJSONFloat(b []byte, v float64, bits int) []byte {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return append(appendFloat(append(b, '"'), v, bits), '"')
+	}
+
+	return strconv.AppendFloat(b, v, 'g', -1, bits)
+}
+
+// appendJSONString appends s as a quoted JSON string. Unlike json.Marshal,
+// this leaves the characters <, > and & alone.
+func appendJSONString(b []byte, s string) []byte {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+
+	return append(b, bytes.TrimSuffix(buf.Bytes(), []byte("\n"))...)
+}
+
+// jsonNode holds a single value in JSONTyped form.
+type jsonNode struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Elem  string          `json:"elem"`
+	Value json.RawMessage `json:"value"`
+}
+
+// FromJSON reads JSON data in the JSONTyped form, as written by ToJSON,
+// from r and writes it to w as uncompressed NBT data. Unknown fields,
+// unknown types and values which do not fit their type yield an error.
+func FromJSON(r io.Reader, w io.Writer) error {
+	var root jsonNode
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&root)
+	if err != nil {
+		return fmt.Errorf("nbt: json: %v", err)
+	}
+
+	t, err := root.tag()
+	if err != nil {
+		return fmt.Errorf("nbt: json: %v", err)
+	}
+
+	return NewEncoder(w).EncodeNamed(root.Name, t)
+}
+
+// tag returns the tag described by n.
+func (n *jsonNode) tag() (Tag, error) {
+	id, ok := jsonTagId(n.Type)
+	if !ok || id == TagEnd {
+		return nil, fmt.Errorf("unknown type %q", n.Type)
+	}
+
+	if id != TagList && len(n.Elem) > 0 {
+		return nil, fmt.Errorf("%s can not have an element type", n.Type)
+	}
+
+	switch id {
+	case TagByte:
+		var v int8
+		err := json.Unmarshal(n.Value, &v)
+		return Byte(v), err
+	case TagShort:
+		var v int16
+		err := json.Unmarshal(n.Value, &v)
+		return Short(v), err
+	case TagInt:
+		var v int32
+		err := json.Unmarshal(n.Value, &v)
+		return Int(v), err
+	case TagLong:
+		var v int64
+		err := json.Unmarshal(n.Value, &v)
+		return Long(v), err
+	case TagFloat:
+		v, err := parseJSONFloat(n.Value, 32)
+		return Float(v), err
+	case TagDouble:
+		v, err := parseJSONFloat(n.Value, 64)
+		return Double(v), err
+	case TagString:
+		var v string
+		err := json.Unmarshal(n.Value, &v)
+		return String(v), err
+
+	case TagByteArray:
+		var v []int8
+		err := json.Unmarshal(n.Value, &v)
+		b := make(ByteArray, len(v))
+		for i := range v {
+			b[i] = byte(v[i])
+		}
+		return b, err
+
+	case TagIntArray:
+		var v []int32
+		err := json.Unmarshal(n.Value, &v)
+		return IntArray(v), err
+
+	case TagLongArray:
+		var v []int64
+		err := json.Unmarshal(n.Value, &v)
+		return LongArray(v), err
+
+	case TagList:
+		return n.list()
+	}
+
+	return n.compound()
+}
+
+// list returns the TAG_List described by n.
+func (n *jsonNode) list() (Tag, error) {
+	elem, ok := jsonTagId(n.Elem)
+	if !ok {
+		return nil, fmt.Errorf("unknown element type %q", n.Elem)
+	}
+
+	var items []jsonNode
+
+	err := decodeJSON(n.Value, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	if elem == TagEnd && len(items) > 0 {
+		return nil, fmt.Errorf("list of type end has %d elements", len(items))
+	}
+
+	l := List{Elem: elem, Items: make([]Tag, len(items))}
+
+	for i := range items {
+		if len(items[i].Name) > 0 {
+			return nil, fmt.Errorf("[%d]: list elements can not have a name", i)
+		}
+
+		l.Items[i], err = items[i].tag()
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %v", i, err)
+		}
+
+		if l.Items[i].TagId() != elem {
+			return nil, fmt.Errorf("[%d]: %s in list of %s", i, items[i].Type, n.Elem)
+		}
+	}
+
+	return l, nil
+}
+
+// compound returns the TAG_Compound described by n. The entries keep the
+// order of the input.
+func (n *jsonNode) compound() (Tag, error) {
+	dec := json.NewDecoder(bytes.NewReader(n.Value))
+	dec.DisallowUnknownFields()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("compound value must be an object")
+	}
+
+	c := OrderedCompound{}
+	seen := make(map[string]bool)
+
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		name := tok.(string)
+		if seen[name] {
+			return nil, fmt.Errorf("%s: duplicate name", pathName(name))
+		}
+
+		seen[name] = true
+
+		var child jsonNode
+
+		err = dec.Decode(&child)
+		if err == nil && len(child.Name) > 0 {
+			err = fmt.Errorf("entry can not have a name")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pathName(name), err)
+		}
+
+		t, err := child.tag()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pathName(name), err)
+		}
+
+		c = append(c, KeyValue{name, t})
+	}
+
+	return c, nil
+}
+
+// decodeJSON decodes the JSON data into v, rejecting unknown fields.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// parseJSONFloat parses a floating point value of the given size. Besides
+// numbers, it accepts the strings "NaN", "Infinity" and "-Infinity".
+func parseJSONFloat(data []byte, bits int) (float64, error) {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		switch s {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+
+		return 0, fmt.Errorf("invalid float %q", s)
+	}
+
+	var n json.Number
+	err := json.Unmarshal(data, &n)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(string(n), bits)
+}
+
+// jsonTagId returns the tag type with the given JSONTyped name.
+func jsonTagId(name string) (TagId, bool) {
+	for id, v := range jsonTypes {
+		if v == name {
+			return TagId(id), true
+		}
+	}
+
+	return TagUnknown, false
+}
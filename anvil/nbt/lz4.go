@@ -0,0 +1,483 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"sync"
+)
+
+// This implements the stream format written by lz4-java's
+// LZ4BlockOutputStream, which Minecraft uses for LZ4 compressed chunks.
+// Every block starts with this header:
+//
+//	magic           [8]byte   "LZ4Block"
+//	token           uint8     Method in the high bits, log2(block size)-10 in the low bits.
+//	compressedLen   int32     Little endian.
+//	originalLen     int32     Little endian.
+//	checksum        int32     XXH32 of the original data, masked to 28 bits.
+//
+// The stream ends with an empty block.
+
+const (
+	lz4Magic        = "LZ4Block"
+	lz4HeaderSize   = len(lz4Magic) + 13
+	lz4MethodRaw    = 0x10
+	lz4MethodLZ4    = 0x20
+	lz4BlockLog     = 16
+	lz4BlockSize    = 1 << lz4BlockLog
+	lz4Seed         = 0x9747b28c
+	lz4ChecksumMask = 0xfffffff
+)
+
+var errLZ4Corrupt = errors.New("nbt: corrupt lz4 stream")
+
+// lz4CompressBound returns the largest size n bytes of data can take up
+// once compressed as an LZ4 block. Larger blocks are rejected before their
+// buffer is allocated.
+func lz4CompressBound(n int) int {
+	return n + n/255 + 16
+}
+
+// lz4Reader decompresses an LZ4 block stream.
+type lz4Reader struct {
+	r      io.Reader
+	header [lz4HeaderSize]byte
+	src    []byte // Compressed block.
+	buf    []byte // Decompressed block.
+	pos    int    // Read position in buf.
+	done   bool   // End of the stream has been reached.
+}
+
+// lz4Readers holds readers released by Close, so their block buffers
+// can be reused when decompressing the next chunk.
+var lz4Readers = sync.Pool{
+	New: func() interface{} { return new(lz4Reader) },
+}
+
+func newLZ4Reader(r io.Reader) *lz4Reader {
+	lr := lz4Readers.Get().(*lz4Reader)
+	lr.r = r
+	return lr
+}
+
+func (lr *lz4Reader) Read(p []byte) (int, error) {
+	for lr.pos == len(lr.buf) {
+		if lr.done {
+			return 0, io.EOF
+		}
+
+		err := lr.fill()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, lr.buf[lr.pos:])
+	lr.pos += n
+	return n, nil
+}
+
+// Close releases the reader. It must not be used afterwards.
+func (lr *lz4Reader) Close() error {
+	if lr.r == nil {
+		return nil
+	}
+
+	*lr = lz4Reader{src: lr.src[:0], buf: lr.buf[:0]}
+	lz4Readers.Put(lr)
+	return nil
+}
+
+// fill reads and decompresses the next block.
+func (lr *lz4Reader) fill() error {
+	_, err := io.ReadFull(lr.r, lr.header[:])
+	if err == io.EOF {
+		lr.done = true
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	h := lr.header[:]
+	if string(h[:len(lz4Magic)]) != lz4Magic {
+		return errLZ4Corrupt
+	}
+
+	h = h[len(lz4Magic):]
+	method := h[0] & 0xf0
+	blockSize := 1 << (10 + h[0]&0x0f)
+	compressedLen := int(int32(binary.LittleEndian.Uint32(h[1:])))
+	originalLen := int(int32(binary.LittleEndian.Uint32(h[5:])))
+	checksum := binary.LittleEndian.Uint32(h[9:])
+
+	switch {
+	case originalLen < 0 || compressedLen < 0 || originalLen > blockSize:
+		return errLZ4Corrupt
+	case (originalLen == 0) != (compressedLen == 0):
+		return errLZ4Corrupt
+	case method != lz4MethodRaw && method != lz4MethodLZ4:
+		return errLZ4Corrupt
+	case method == lz4MethodRaw && (originalLen != compressedLen || compressedLen > blockSize):
+		return errLZ4Corrupt
+	case method == lz4MethodLZ4 && compressedLen > lz4CompressBound(blockSize):
+		return errLZ4Corrupt
+	}
+
+	if originalLen == 0 {
+		if checksum != 0 {
+			return errLZ4Corrupt
+		}
+
+		lr.done = true
+		return nil
+	}
+
+	lr.src = resize(lr.src, compressedLen)
+	lr.buf = resize(lr.buf, originalLen)
+	lr.pos = 0
+
+	_, err = io.ReadFull(lr.r, lr.src)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if method == lz4MethodRaw {
+		copy(lr.buf, lr.src)
+	} else if !lz4Decode(lr.buf, lr.src) {
+		return errLZ4Corrupt
+	}
+
+	if xxh32(lr.buf, lz4Seed)&lz4ChecksumMask != checksum {
+		return errLZ4Corrupt
+	}
+
+	return nil
+}
+
+// resize returns a slice of length n, reusing the backing array of b if
+// it is large enough.
+func resize(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+
+	return b[:n]
+}
+
+// lz4Writer compresses data into an LZ4 block stream. Close writes the
+// final block and the end marker.
+type lz4Writer struct {
+	w   io.Writer
+	buf []byte // Pending, uncompressed data.
+	out []byte // Compressed block.
+	err error
+}
+
+func newLZ4Writer(w io.Writer) *lz4Writer {
+	return &lz4Writer{w: w, buf: make([]byte, 0, lz4BlockSize)}
+}
+
+func (lw *lz4Writer) Write(p []byte) (int, error) {
+	var n int
+
+	for len(p) > 0 && lw.err == nil {
+		m := copy(lw.buf[len(lw.buf):cap(lw.buf)], p)
+		lw.buf = lw.buf[:len(lw.buf)+m]
+		p = p[m:]
+		n += m
+
+		if len(lw.buf) == cap(lw.buf) {
+			lw.flush()
+		}
+	}
+
+	return n, lw.err
+}
+
+func (lw *lz4Writer) Close() error {
+	if len(lw.buf) > 0 {
+		lw.flush()
+	}
+
+	if lw.err == nil {
+		lw.writeBlock(lz4MethodRaw, nil, 0, 0)
+	}
+
+	return lw.err
+}
+
+// flush writes the pending data as a single block.
+func (lw *lz4Writer) flush() {
+	lw.out = lz4Encode(lw.out[:0], lw.buf)
+	checksum := xxh32(lw.buf, lz4Seed) & lz4ChecksumMask
+
+	if len(lw.out) < len(lw.buf) {
+		lw.writeBlock(lz4MethodLZ4, lw.out, len(lw.buf), checksum)
+	} else {
+		lw.writeBlock(lz4MethodRaw, lw.buf, len(lw.buf), checksum)
+	}
+
+	lw.buf = lw.buf[:0]
+}
+
+// writeBlock writes a single block with the given payload.
+func (lw *lz4Writer) writeBlock(method byte, data []byte, originalLen int, checksum uint32) {
+	var h [lz4HeaderSize]byte
+	copy(h[:], lz4Magic)
+
+	p := h[len(lz4Magic):]
+	p[0] = method | (lz4BlockLog - 10)
+	binary.LittleEndian.PutUint32(p[1:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(p[5:], uint32(originalLen))
+	binary.LittleEndian.PutUint32(p[9:], checksum)
+
+	_, lw.err = lw.w.Write(h[:])
+	if lw.err == nil && len(data) > 0 {
+		_, lw.err = lw.w.Write(data)
+	}
+}
+
+// lz4Decode decompresses the LZ4 block src into dst, which must have the
+// exact decompressed size. Returns false if src is invalid.
+func lz4Decode(dst, src []byte) bool {
+	var si, di int
+
+	for si < len(src) {
+		token := src[si]
+		si++
+
+		lit := int(token >> 4)
+		if lit == 15 {
+			n, ok := lz4Length(src, &si)
+			if !ok {
+				return false
+			}
+			lit += n
+		}
+
+		if lit > len(src)-si || lit > len(dst)-di {
+			return false
+		}
+
+		copy(dst[di:], src[si:si+lit])
+		si += lit
+		di += lit
+
+		// The last sequence only holds literals.
+		if si == len(src) {
+			break
+		}
+
+		if len(src)-si < 2 {
+			return false
+		}
+
+		offset := int(src[si]) | int(src[si+1])<<8
+		si += 2
+
+		if offset == 0 || offset > di {
+			return false
+		}
+
+		match := int(token & 15)
+		if match == 15 {
+			n, ok := lz4Length(src, &si)
+			if !ok {
+				return false
+			}
+			match += n
+		}
+
+		match += 4
+		if match > len(dst)-di {
+			return false
+		}
+
+		// Matches may overlap the data they produce.
+		if offset >= match {
+			copy(dst[di:di+match], dst[di-offset:])
+		} else {
+			for i := 0; i < match; i++ {
+				dst[di+i] = dst[di-offset+i]
+			}
+		}
+
+		di += match
+	}
+
+	return di == len(dst)
+}
+
+// lz4Length reads the extra bytes of a literal or match length.
+func lz4Length(src []byte, si *int) (int, bool) {
+	var n int
+
+	for *si < len(src) {
+		b := src[*si]
+		*si++
+		n += int(b)
+
+		if b != 255 {
+			return n, true
+		}
+	}
+
+	return 0, false
+}
+
+const (
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5  // Number of bytes at the end which are always literals.
+	lz4MatchLimit   = 12 // No match starts in this many bytes before the end.
+	lz4HashLog      = 14
+	lz4MaxOffset    = 65535
+)
+
+// lz4Encode appends the LZ4 block compressed form of src to dst.
+func lz4Encode(dst, src []byte) []byte {
+	var table [1 << lz4HashLog]int32 // Positions + 1, by hash.
+
+	var anchor int
+	limit := len(src) - lz4MatchLimit
+
+	for i := 0; i < limit; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 2654435761) >> (32 - lz4HashLog)
+
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+
+		n := lz4MinMatch
+		for i+n < len(src)-lz4LastLiterals && src[ref+n] == src[i+n] {
+			n++
+		}
+
+		dst = lz4Sequence(dst, src[anchor:i], i-ref, n)
+		i += n
+		anchor = i
+	}
+
+	return lz4Sequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4Sequence appends a single sequence of literals, followed by a match
+// of the given length. A length of 0 marks the last sequence.
+func lz4Sequence(dst, literals []byte, offset, match int) []byte {
+	var token byte
+
+	if len(literals) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(literals)) << 4
+	}
+
+	if match > 0 {
+		if match-lz4MinMatch >= 15 {
+			token |= 15
+		} else {
+			token |= byte(match - lz4MinMatch)
+		}
+	}
+
+	dst = append(dst, token)
+
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+
+	dst = append(dst, literals...)
+
+	if match == 0 {
+		return dst
+	}
+
+	dst = append(dst, byte(offset), byte(offset>>8))
+
+	if match-lz4MinMatch >= 15 {
+		dst = lz4AppendLength(dst, match-lz4MinMatch-15)
+	}
+
+	return dst
+}
+
+// lz4AppendLength appends the extra bytes of a literal or match length.
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+
+	return append(dst, byte(n))
+}
+
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+)
+
+// xxh32 returns the 32 bit xxHash of data.
+func xxh32(data []byte, seed uint32) uint32 {
+	n := len(data)
+
+	var h uint32
+
+	if len(data) >= 16 {
+		v1 := seed + xxhPrime1 + xxhPrime2
+		v2 := seed + xxhPrime2
+		v3 := seed
+		v4 := seed - xxhPrime1
+
+		for ; len(data) >= 16; data = data[16:] {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint32(data[0:]))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint32(data[4:]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint32(data[8:]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint32(data[12:]))
+		}
+
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) +
+			bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxhPrime5
+	}
+
+	h += uint32(n)
+
+	for ; len(data) >= 4; data = data[4:] {
+		h += binary.LittleEndian.Uint32(data) * xxhPrime3
+		h = bits.RotateLeft32(h, 17) * xxhPrime4
+	}
+
+	for _, b := range data {
+		h += uint32(b) * xxhPrime5
+		h = bits.RotateLeft32(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxhRound(v, lane uint32) uint32 {
+	v += lane * xxhPrime2
+	return bits.RotateLeft32(v, 13) * xxhPrime1
+}
@@ -0,0 +1,182 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Marshaler is implemented by types which encode themselves as a tag.
+// MarshalNBT returns the tag's payload along with its type. The payload
+// excludes the tag header and is written as is, so it must use the byte
+// order of the encoder.
+type Marshaler interface {
+	MarshalNBT() ([]byte, TagId, error)
+}
+
+// Unmarshaler is implemented by types which decode themselves from a
+// tag. UnmarshalNBT receives the tag's type and a reader holding exactly
+// its payload.
+type Unmarshaler interface {
+	UnmarshalNBT(id TagId, r io.Reader) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// marshalsNBT returns true if values of the given type are encoded
+// through Marshaler.
+func marshalsNBT(rt reflect.Type) bool {
+	return rt.Implements(marshalerType) || reflect.PtrTo(rt).Implements(marshalerType)
+}
+
+// nbtMarshaler returns the Marshaler implemented by rv or by a pointer to
+// it. Returns false if there is none.
+func nbtMarshaler(rv reflect.Value) (Marshaler, bool) {
+	if !rv.CanInterface() || !marshalsNBT(rv.Type()) {
+		return nil, false
+	}
+
+	if m, ok := rv.Interface().(Marshaler); ok {
+		return m, true
+	}
+
+	// The method has a pointer receiver. Values which are not
+	// addressable, like map entries, are copied first.
+	if !rv.CanAddr() {
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		rv = p.Elem()
+	}
+
+	return rv.Addr().Interface().(Marshaler), true
+}
+
+// nbtUnmarshaler returns the Unmarshaler implemented by a pointer to rv.
+// Returns false if there is none.
+func nbtUnmarshaler(rv reflect.Value) (Unmarshaler, bool) {
+	if !rv.CanAddr() || !reflect.PtrTo(rv.Type()).Implements(unmarshalerType) {
+		return nil, false
+	}
+
+	return rv.Addr().Interface().(Unmarshaler), true
+}
+
+// marshalNBT calls m.MarshalNBT and checks the tag type it returns.
+func marshalNBT(m Marshaler, name string) ([]byte, TagId, error) {
+	data, id, err := m.MarshalNBT()
+	if err != nil {
+		return nil, TagEnd, fmt.Errorf("nbt: %T(%q): %v", m, name, err)
+	}
+
+	if id == TagEnd || id > TagLongArray {
+		return nil, TagEnd, fmt.Errorf("nbt: %T(%q): invalid tag type %d", m, name, id)
+	}
+
+	return data, id, nil
+}
+
+// encodeNBT writes the tag returned by m.
+func (e *Encoder) encodeNBT(m Marshaler, name string, inlist bool) error {
+	data, id, err := marshalNBT(m, name)
+	if err != nil {
+		return err
+	}
+
+	err = e.emit(id, name, inlist)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(data)
+	return err
+}
+
+// decodeNBT reads the payload of a tag with the given type and passes it
+// to u.
+func (d *Decoder) decodeNBT(id TagId, name string, u Unmarshaler) error {
+	var buf bytes.Buffer
+
+	// The payload's size is only known once it has been read.
+	sub := *d
+	sub.r = io.TeeReader(d.r, &buf)
+
+	err := sub.skip(id)
+	if err != nil {
+		return err
+	}
+
+	err = u.UnmarshalNBT(id, &buf)
+	if err != nil {
+		return fmt.Errorf("%s(%q): %v", id, name, err)
+	}
+
+	return nil
+}
+
+// RawMessage holds a single tag, which the decoder stores without
+// interpreting it. It can be decoded once the type to decode it into is
+// known, like a chunk's sections, whose layout depends on its data
+// version. The encoder writes it back as it is.
+//
+// The message is a complete tag: its type, its name and its payload. The
+// decoder stores it with an empty name. Either way, it can be decoded on
+// its own with Unmarshal. The payload uses the byte order of the decoder
+// which filled it.
+type RawMessage []byte
+
+// TagId returns the type of the tag in m. Returns TagEnd if m is empty.
+func (m RawMessage) TagId() TagId {
+	if len(m) == 0 {
+		return TagEnd
+	}
+
+	return TagId(m[0])
+}
+
+// MarshalNBT returns the payload of the tag in m.
+func (m RawMessage) MarshalNBT() ([]byte, TagId, error) {
+	if len(m) < 3 {
+		return nil, TagEnd, errors.New("raw message has no tag")
+	}
+
+	n := 3 + (int(m[1])<<8 | int(m[2]))
+	if len(m) < n {
+		return nil, TagEnd, errors.New("raw message has a truncated name")
+	}
+
+	return m[n:], m.TagId(), nil
+}
+
+// UnmarshalNBT stores the tag in m, reusing its backing array if possible.
+func (m *RawMessage) UnmarshalNBT(id TagId, r io.Reader) error {
+	// The decoder passes a reader which knows the size of the payload.
+	if l, ok := r.(interface{ Len() int }); ok && cap(*m) >= 3+l.Len() {
+		data := append((*m)[:0], byte(id), 0, 0)[:3+l.Len()]
+
+		_, err := io.ReadFull(r, data[3:])
+		if err != nil {
+			return err
+		}
+
+		*m = data
+		return nil
+	}
+
+	buf := bytes.NewBuffer(append((*m)[:0], byte(id), 0, 0))
+
+	_, err := buf.ReadFrom(r)
+	if err != nil {
+		return err
+	}
+
+	*m = buf.Bytes()
+	return nil
+}
@@ -0,0 +1,156 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// NBT strings use Java's modified UTF-8 encoding. It differs from regular
+// UTF-8 in two ways: the NUL character is encoded as the two bytes
+// 0xc0 0x80, and characters outside the basic multilingual plane are
+// encoded as a UTF-16 surrogate pair, each half taking up three bytes.
+//
+// The conversions below leave any other byte sequence as it is. This
+// keeps data written by non-conforming encoders intact.
+
+// encodeMUTF8 converts s into modified UTF-8.
+func encodeMUTF8(s string) []byte {
+	if !needsEncoding(s) {
+		return []byte(s)
+	}
+
+	out := make([]byte, 0, len(s)+len(s)/2)
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		if c == 0 {
+			out = append(out, 0xc0, 0x80)
+			i++
+			continue
+		}
+
+		if c < 0xf0 {
+			out = append(out, c)
+			i++
+			continue
+		}
+
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			out = append(out, c)
+			i++
+			continue
+		}
+
+		r1, r2 := utf16.EncodeRune(r)
+		out = appendSurrogate(out, r1)
+		out = appendSurrogate(out, r2)
+		i += n
+	}
+
+	return out
+}
+
+// mutf8Len returns the length of s in modified UTF-8.
+func mutf8Len(s string) int {
+	if !needsEncoding(s) {
+		return len(s)
+	}
+
+	n := 0
+
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == 0:
+			n += 2
+			i++
+		case c < 0xf0:
+			n++
+			i++
+		default:
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				n++
+			} else {
+				n += 6
+			}
+			i += size
+		}
+	}
+
+	return n
+}
+
+// decodeMUTF8 converts the modified UTF-8 data in b into a regular string.
+func decodeMUTF8(b []byte) string {
+	if !needsDecoding(b) {
+		return string(b)
+	}
+
+	out := make([]byte, 0, len(b))
+
+	for i := 0; i < len(b); {
+		if b[i] == 0xc0 && i+1 < len(b) && b[i+1] == 0x80 {
+			out = append(out, 0)
+			i += 2
+			continue
+		}
+
+		if r1, ok := readSurrogate(b[i:]); ok {
+			if r2, ok := readSurrogate(b[i+3:]); ok {
+				if r := utf16.DecodeRune(r1, r2); r != utf8.RuneError {
+					out = utf8.AppendRune(out, r)
+					i += 6
+					continue
+				}
+			}
+		}
+
+		out = append(out, b[i])
+		i++
+	}
+
+	return string(out)
+}
+
+// needsEncoding returns true if s holds a NUL byte or a 4-byte sequence.
+func needsEncoding(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 || s[i] >= 0xf0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// needsDecoding returns true if b holds an encoded NUL character or a
+// possible surrogate.
+func needsDecoding(b []byte) bool {
+	for _, c := range b {
+		if c == 0xc0 || c == 0xed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appendSurrogate appends the three byte encoding of the surrogate r.
+func appendSurrogate(b []byte, r rune) []byte {
+	return append(b, 0xe0|byte(r>>12), 0x80|byte(r>>6)&0x3f, 0x80|byte(r)&0x3f)
+}
+
+// readSurrogate reads a three byte encoded surrogate from the start of b.
+func readSurrogate(b []byte) (rune, bool) {
+	if len(b) < 3 || b[0] != 0xed || b[1]&0xc0 != 0x80 || b[2]&0xc0 != 0x80 {
+		return 0, false
+	}
+
+	r := rune(b[0]&0x0f)<<12 | rune(b[1]&0x3f)<<6 | rune(b[2]&0x3f)
+	return r, r >= 0xd800 && r <= 0xdfff
+}
@@ -6,7 +6,20 @@ package nbt
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -210,6 +223,153 @@ func TestList2(t *testing.T) {
 	testRoundtrip(t, &a, &b)
 }
 
+func TestList3(t *testing.T) {
+	var a, b []string
+	a = []string{"minecraft:vanilla", "minecraft:bundle"}
+	testRoundtrip(t, &a, &b)
+}
+
+func TestLegacyArrays(t *testing.T) {
+	type T struct {
+		Bytes []byte   `nbt:"bytes"`
+		Ints  []int32  `nbt:"ints"`
+		Longs []uint64 `nbt:"longs"`
+	}
+
+	a := T{
+		Bytes: []byte{1, 2},
+		Ints:  []int32{-1, 2, 3},
+		Longs: []uint64{1 << 40},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetLegacyArraysAsList(true)
+
+	err := enc.Encode(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v Tag
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := v.(Compound)
+	if _, ok := root["bytes"].(ByteArray); !ok {
+		t.Fatalf("bytes: unexpected type %T", root["bytes"])
+	}
+
+	if l, ok := root["ints"].(List); !ok || l.Elem != TagInt {
+		t.Fatalf("ints: unexpected value %#v", root["ints"])
+	}
+
+	if l, ok := root["longs"].(List); !ok || l.Elem != TagLong {
+		t.Fatalf("longs: unexpected value %#v", root["longs"])
+	}
+
+	// Lists decode into the same slices.
+	var b T
+	err = Unmarshal(&buf, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("roundtrip mismatch:\nhave: %#v\nwant: %#v", b, a)
+	}
+}
+
+func TestListOption(t *testing.T) {
+	type T struct {
+		Ints   []int32  `nbt:"ints"`
+		Pos    []int32  `nbt:"pos,list"`
+		Longs  []int64  `nbt:"longs,list"`
+		Names  []string `nbt:"names,list"`
+		Sorted []uint64 `nbt:"sorted"`
+	}
+
+	a := T{
+		Ints:   []int32{1, 2},
+		Pos:    []int32{-3, 64, 5},
+		Longs:  []int64{1 << 40, -1},
+		Names:  []string{"a"},
+		Sorted: []uint64{7},
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v Tag
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := v.(Compound)
+	want := map[string]TagId{
+		"ints":   TagIntArray,
+		"pos":    TagList,
+		"longs":  TagList,
+		"names":  TagList,
+		"sorted": TagLongArray,
+	}
+
+	for name, id := range want {
+		if have := root[name].TagId(); have != id {
+			t.Fatalf("%s: expected %s, have %s", name, id, have)
+		}
+	}
+
+	var b T
+	err = Unmarshal(&buf, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("roundtrip mismatch:\nhave: %#v\nwant: %#v", b, a)
+	}
+}
+
+func TestListReuseGrow(t *testing.T) {
+	type T struct {
+		Names []string `nbt:"names"`
+	}
+
+	encode := func(n int) []byte {
+		v := T{Names: make([]string, n)}
+		for i := range v.Names {
+			v.Names[i] = fmt.Sprint(i)
+		}
+
+		var buf bytes.Buffer
+		if err := Marshal(&buf, v); err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	// The second list is longer than both the first one and the capacity
+	// it left behind, which exceeds the preallocation limit.
+	var v T
+	for _, n := range []int{1500, 4000} {
+		if err := Unmarshal(bytes.NewReader(encode(n)), &v); err != nil {
+			t.Fatalf("%d elements: %v", n, err)
+		}
+
+		if len(v.Names) != n || v.Names[n-1] != fmt.Sprint(n-1) {
+			t.Fatalf("%d elements: have %d", n, len(v.Names))
+		}
+	}
+}
+
 func TestCompoundList(t *testing.T) {
 	type Data struct {
 		A int8
@@ -246,6 +406,59 @@ func TestCompoundPtrList(t *testing.T) {
 	testRoundtrip(t, &a, &b)
 }
 
+func TestKeyValueNil(t *testing.T) {
+	var buf bytes.Buffer
+
+	for _, v := range []interface{}{KeyValue{Name: "x"}, &KeyValue{Name: "x"}} {
+		err := NewEncoder(&buf).Encode(v)
+		if _, ok := err.(*MarshalError); !ok {
+			t.Fatalf("encode %T: unexpected error %v", v, err)
+		}
+	}
+
+	if _, ok := Fprint(&buf, KeyValue{}).(*MarshalError); !ok {
+		t.Fatal("Fprint: expected a MarshalError")
+	}
+
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected output: % x", buf.Bytes())
+	}
+}
+
+func TestComplex(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := Marshal(&buf, complex128(1+2i))
+	if err == nil || err.Error() != "nbt: unsupported type complex128" {
+		t.Fatalf("encode complex128: unexpected error %v", err)
+	}
+
+	type Data struct {
+		C complex64
+	}
+
+	err = Marshal(&buf, Data{C: 1})
+	if _, ok := err.(*MarshalError); !ok {
+		t.Fatalf("encode complex64 field: unexpected error %v", err)
+	}
+
+	// Decoding into a complex field must fail as well.
+	type Src struct{ C float64 }
+	type Dst struct{ C complex128 }
+
+	buf.Reset()
+	err = Marshal(&buf, Src{C: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dst Dst
+	err = Unmarshal(&buf, &dst)
+	if err == nil || !strings.Contains(err.Error(), "unsupported type complex128") {
+		t.Fatalf("decode complex128: unexpected error %v", err)
+	}
+}
+
 func TestBig(t *testing.T) {
 	var a, b BigTest
 	load(t, big_nbt, &a)
@@ -258,109 +471,747 @@ func TestSmall(t *testing.T) {
 	testRoundtrip(t, &a, &b)
 }
 
-// testRoundtrip encodes <want> and then decodes into <have>.
-// The two should then be equal.
-func testRoundtrip(t *testing.T, want, have interface{}) {
-	var buf bytes.Buffer
+func TestCompoundWriter(t *testing.T) {
+	type Tag struct {
+		Damage int16 `nbt:"Damage"`
+	}
 
-	enc := NewEncoder(&buf)
-	err := enc.Encode(want)
+	type Item struct {
+		Id    string  `nbt:"id"`
+		Count int8    `nbt:"Count"`
+		Tag   Tag     `nbt:"tag"`
+		Data  []int64 `nbt:"data"`
+		Lore  []Tag   `nbt:"lore"`
+	}
+
+	want := Item{
+		Id:    "minecraft:stone",
+		Count: 1,
+		Tag:   Tag{Damage: 3},
+		Data:  []int64{-1, 2},
+		Lore:  []Tag{{1}, {2}},
+	}
+
+	var a, b bytes.Buffer
+
+	err := Marshal(&a, want)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	dec := NewDecoder(&buf)
-	err = dec.Decode(have)
+	err = NewEncoder(&b).Compound("", func(c *CompoundWriter) {
+		c.String("id", "minecraft:stone")
+		c.Byte("Count", 1)
+		c.Compound("tag", func(c *CompoundWriter) {
+			c.Short("Damage", 3)
+		})
+		c.LongArray("data", []int64{-1, 2})
+		c.Value("lore", []Tag{{1}, {2}})
+	})
+
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rw := reflect.ValueOf(want)
-	rw = reflect.Indirect(rw)
-	want = rw.Interface()
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatalf("output mismatch:\nHave: %x\nWant: %x", b.Bytes(), a.Bytes())
+	}
 
-	rh := reflect.ValueOf(have)
-	rh = reflect.Indirect(rh)
-	have = rh.Interface()
+	var have Item
+	err = Unmarshal(&b, &have)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if !reflect.DeepEqual(want, have) {
-		t.Fatalf("roundtrip mismatch:\nHave: %#v\nWant: %#v", have, want)
+		t.Fatalf("decode mismatch:\nHave: %#v\nWant: %#v", have, want)
 	}
 }
 
-type Food struct {
-	Name  string  `nbt:"name"`
-	Value float32 `nbt:"value"`
-}
+func TestCompoundReader(t *testing.T) {
+	var buf bytes.Buffer
 
-type NestedCompound struct {
-	Egg Food `nbt:"egg"`
-	Ham Food `nbt:"ham"`
-}
+	err := NewEncoder(&buf).Compound("root", func(c *CompoundWriter) {
+		c.String("id", "minecraft:zombie")
+		c.Compound("pos", func(c *CompoundWriter) {
+			c.Int("x", 1)
+			c.Int("y", -2)
+		})
+		c.Value("skipped", []float32{1, 2})
+		c.Short("health", 20)
+		c.LongArray("uuid", []int64{3, 4})
+	})
 
-type ListItem struct {
-	Name    string `nbt:"name"`
-	Created int64  `nbt:"created-on"`
-}
+	if err != nil {
+		t.Fatal(err)
+	}
 
-type BigTestData struct {
-	NestedCompound `nbt:"nested compound test"`
-	ListTestCmp    []ListItem `nbt:"listTest (compound)"`
-	ListTestLong   []int64    `nbt:"listTest (long)"`
-	ListTestByte   []byte     `nbt:"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"`
-	StringTest     string     `nbt:"stringTest"`
-	LongTest       int64      `nbt:"longTest"`
-	DoubleTest     float64    `nbt:"doubleTest"`
-	FloatTest      float32    `nbt:"floatTest"`
-	IntTest        int32      `nbt:"intTest"`
-	ShortTest      int16      `nbt:"shortTest"`
-	ByteTest       byte       `nbt:"byteTest"`
+	c, name, err := NewDecoder(&buf).Compound()
+	if err != nil || name != "root" {
+		t.Fatalf("root: %q %v", name, err)
+	}
+
+	var names []string
+	for name, id := c.Next(); id != TagEnd; name, id = c.Next() {
+		names = append(names, name)
+
+		switch name {
+		case "id":
+			if v, err := c.String(); v != "minecraft:zombie" {
+				t.Fatalf("id: %q %v", v, err)
+			}
+
+		case "pos":
+			// Only read the first entry; the rest must be skipped.
+			pos, err := c.Compound()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name, id := pos.Next(); name != "x" || id != TagInt {
+				t.Fatalf("pos: unexpected entry %s(%q)", id, name)
+			}
+
+		case "health":
+			// A type mismatch leaves the value in place.
+			_, err := c.Int()
+			if err == nil {
+				t.Fatal("health: expected type mismatch error")
+			}
+
+			if v, err := c.Short(); v != 20 {
+				t.Fatalf("health: %d %v", v, err)
+			}
+
+		case "uuid":
+			var v []int64
+			if err := c.Value(&v); err != nil || !reflect.DeepEqual(v, []int64{3, 4}) {
+				t.Fatalf("uuid: %v %v", v, err)
+			}
+		}
+	}
+
+	if c.Err() != nil {
+		t.Fatal(c.Err())
+	}
+
+	want := []string{"id", "pos", "skipped", "health", "uuid"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("entry mismatch:\nHave: %v\nWant: %v", names, want)
+	}
 }
 
-type BigTest struct {
-	BigTestData `nbt:"Level"`
+func TestStream(t *testing.T) {
+	type In struct {
+		Name  string  `nbt:"name"`
+		Bytes []byte  `nbt:"bytes"`
+		Longs []int64 `nbt:"longs"`
+	}
+
+	type Out struct {
+		Name  string    `nbt:"name"`
+		Bytes io.Writer `nbt:"bytes,stream"`
+		Longs io.Writer `nbt:"longs,stream"`
+	}
+
+	in := In{
+		Name:  "test",
+		Bytes: []byte{1, 2, 3},
+		Longs: []int64{-1, 0x0102030405060708},
+	}
+
+	var buf, bytesOut, longsOut bytes.Buffer
+
+	err := Marshal(&buf, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := Out{Bytes: &bytesOut, Longs: &longsOut}
+	err = Unmarshal(&buf, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Name != in.Name {
+		t.Fatalf("name mismatch: have %q, want %q", out.Name, in.Name)
+	}
+
+	if !bytes.Equal(bytesOut.Bytes(), in.Bytes) {
+		t.Fatalf("byte array mismatch: have %x", bytesOut.Bytes())
+	}
+
+	want := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	}
+
+	if !bytes.Equal(longsOut.Bytes(), want) {
+		t.Fatalf("long array mismatch: have %x", longsOut.Bytes())
+	}
 }
 
-type SmallTestData struct {
-	Name string `nbt:"name"`
+func TestMarshalGzip(t *testing.T) {
+	v := SmallTest{SmallTestData{Name: "Bananrama"}}
+
+	var a, b bytes.Buffer
+
+	err := MarshalGzip(&a, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = MarshalGzip(&b, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatalf("output differs:\n%x\n%x", a.Bytes(), b.Bytes())
+	}
+
+	// Bytes 4-7 hold the modification time. Byte 9 holds the OS.
+	hdr := a.Bytes()[:10]
+	if !bytes.Equal(hdr[4:8], []byte{0, 0, 0, 0}) || hdr[9] != 255 {
+		t.Fatalf("unexpected gzip header: %x", hdr)
+	}
+
+	var have SmallTest
+	load(t, a.Bytes(), &have)
+
+	if have != v {
+		t.Fatalf("decode mismatch:\nHave: %#v\nWant: %#v", have, v)
+	}
 }
 
-type SmallTest struct {
-	SmallTestData `nbt:"hello world"`
+func TestSharedFieldName(t *testing.T) {
+	type Inner struct {
+		A int32
+	}
+
+	type T struct {
+		Name  string `nbt:"value,omitempty"`
+		Inner *Inner `nbt:"value"`
+	}
+
+	var a, b T
+	a.Name = "test"
+	testRoundtrip(t, &a, &b)
+
+	var c, d T
+	c.Inner = &Inner{A: 12}
+	testRoundtrip(t, &c, &d)
 }
 
-func load(t *testing.T, data []byte, v interface{}) {
-	r, err := gzip.NewReader(bytes.NewBuffer(data))
+func TestSubtree(t *testing.T) {
+	const key = "byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"
+
+	type Level struct {
+		StringTest string  `nbt:"stringTest"`
+		Extra      Subtree `nbt:",remaining"`
+	}
+
+	var want BigTest
+	load(t, big_nbt, &want)
+
+	var data []byte
+	a := Level{Extra: Subtree{Name: key, Value: &data}}
+	load(t, big_nbt, &a)
+
+	if a.StringTest != want.StringTest {
+		t.Fatalf("stringTest mismatch: have %q, want %q", a.StringTest, want.StringTest)
+	}
+
+	if len(data) != 1000 {
+		t.Fatalf("subtree length mismatch: have %d, want 1000", len(data))
+	}
+
+	for n := range data {
+		if v := byte((n*n*255 + n*7) % 100); data[n] != v {
+			t.Fatalf("subtree mismatch at %d: have %d, want %d", n, data[n], v)
+		}
+	}
+
+	// The subtree is encoded under its runtime name.
+	var buf bytes.Buffer
+	err := Marshal(&buf, a)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer r.Close()
+	var other []byte
+	b := Level{Extra: Subtree{Name: key, Value: &other}}
+	err = Unmarshal(&buf, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	err = Unmarshal(r, v)
+	if !reflect.DeepEqual(other, data) {
+		t.Fatalf("roundtrip mismatch:\nhave: %v\nwant: %v", other, data)
+	}
+
+	// A subtree needs somewhere to store the data.
+	err = Marshal(&buf, a)
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	c := Level{Extra: Subtree{Name: key}}
+	err = Unmarshal(&buf, &c)
+	if err == nil || !strings.Contains(err.Error(), "no value") {
+		t.Fatalf("expected missing value error, have %v", err)
+	}
 }
 
-var big_nbt = []byte{
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xed, 0x54,
-	0xcf, 0x4f, 0x1a, 0x41, 0x14, 0x7e, 0xc2, 0x02, 0xcb, 0x96, 0x82, 0xb1,
-	0xc4, 0x10, 0x63, 0xcc, 0xab, 0xb5, 0x84, 0xa5, 0xdb, 0xcd, 0x42, 0x11,
-	0x89, 0xb1, 0x88, 0x16, 0x2c, 0x9a, 0x0d, 0x1a, 0xd8, 0xa8, 0x31, 0x86,
-	0xb8, 0x2b, 0xc3, 0x82, 0x2e, 0xbb, 0x66, 0x77, 0xb0, 0xf1, 0xd4, 0x4b,
-	0x7b, 0x6c, 0x7a, 0xeb, 0x3f, 0xd3, 0x23, 0x7f, 0x43, 0xcf, 0xbd, 0xf6,
-	0xbf, 0xa0, 0xc3, 0x2f, 0x7b, 0x69, 0xcf, 0xbd, 0xf0, 0x32, 0xc9, 0xf7,
-	0xe6, 0xbd, 0x6f, 0xe6, 0x7b, 0x6f, 0x26, 0x79, 0x02, 0x04, 0x54, 0x72,
-	0x4f, 0x2c, 0x0e, 0x78, 0xcb, 0xb1, 0x4d, 0x8d, 0x78, 0xf4, 0xe3, 0x70,
-	0x62, 0x3e, 0x08, 0x7b, 0x1d, 0xc7, 0xa5, 0x93, 0x18, 0x0f, 0x82, 0x47,
-	0xdd, 0xee, 0x84, 0x02, 0x62, 0xb5, 0xa2, 0xaa, 0xc7, 0x78, 0x76, 0x5c,
-	0x57, 0xcb, 0xa8, 0x55, 0x0f, 0x1b, 0xc8, 0xd6, 0x1e, 0x6a, 0x95, 0x86,
-	0x86, 0x0d, 0xad, 0x7e, 0x58, 0x7b, 0x8f, 0x83, 0xcf, 0x83, 0x4f, 0x83,
-	0x6f, 0xcf, 0x03, 0x10, 0x6e, 0x5b, 0x8e, 0x3e, 0xbe, 0xa5, 0x38, 0x4c,
-	0x64, 0xfd, 0x10, 0xea, 0xda, 0x74, 0xa6, 0x23, 0x40, 0xdc, 0x66, 0x2e,
-	0x69, 0xe1, 0xb5, 0xd3, 0xbb, 0x73, 0xfa, 0x76, 0x0b, 0x29, 0xdb, 0x0b,
-	0xe0, 0xef, 0xe8, 0x3d, 0x1e, 0x38, 0x5b, 0xef, 0x11, 0x08, 0x56, 0xf5,
+func TestTagTree(t *testing.T) {
+	r, err := gzip.NewReader(bytes.NewReader(big_nbt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v Tag
+	err = Unmarshal(bytes.NewReader(raw), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, ok := v.(Compound)
+	if !ok {
+		t.Fatalf("unexpected root type %T", v)
+	}
+
+	if have := root["intTest"]; have != Int(2147483647) {
+		t.Fatalf("intTest mismatch: have %v", have)
+	}
+
+	nested := root["nested compound test"].(Compound)
+	if have := nested["egg"].(Compound)["name"]; have != String("Eggbert") {
+		t.Fatalf("egg name mismatch: have %v", have)
+	}
+
+	list := root["listTest (compound)"].(List)
+	if list.Elem != TagCompound || len(list.Items) != 2 {
+		t.Fatalf("listTest mismatch: have %s with %d items", list.Elem, len(list.Items))
+	}
+
+	// An ordered compound with its name re-encodes to the exact input.
+	dec := NewDecoder(bytes.NewReader(raw))
+	dec.SetOrdered(true)
+
+	var kv KeyValue
+	err = dec.Decode(&kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kv.Name != "Level" {
+		t.Fatalf("root name mismatch: have %q", kv.Name)
+	}
+
+	oc := kv.Value.(OrderedCompound)
+	if oc[0].Name != "longTest" {
+		t.Fatalf("unexpected first entry %q", oc[0].Name)
+	}
+
+	var buf bytes.Buffer
+	err = Marshal(&buf, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), raw) {
+		t.Fatalf("ordered roundtrip mismatch")
+	}
+
+	// Tag values can be used as struct fields.
+	type T struct {
+		Data  OrderedCompound `nbt:"data"`
+		Extra Tag             `nbt:"extra"`
+	}
+
+	a := T{
+		Data:  OrderedCompound{{"z", Int(1)}, {"a", List{TagShort, []Tag{Short(1), Short(2)}}}},
+		Extra: Compound{"b": Byte(1), "a": LongArray{1, 2}},
+	}
+
+	var b T
+	testRoundtrip(t, &a, &b)
+
+	a.Extra = List{Items: []Tag{Short(1), Int(2)}}
+	err = Marshal(&buf, a)
+	if err == nil || !strings.Contains(err.Error(), "mixes") {
+		t.Fatalf("expected mixed list error, have %v", err)
+	}
+}
+
+func TestTagSize(t *testing.T) {
+	r, err := gzip.NewReader(bytes.NewReader(big_nbt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ordered := range []bool{false, true} {
+		dec := NewDecoder(bytes.NewReader(raw))
+		dec.SetOrdered(ordered)
+
+		var kv KeyValue
+		err = dec.Decode(&kv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The root tag is preceded by its type and name.
+		want := len(raw) - 1 - 2 - len(kv.Name)
+		if have := kv.Value.EncodedSize(); have != want {
+			t.Fatalf("ordered=%v: encoded size mismatch: have %d, want %d", ordered, have, want)
+		}
+
+		if have := kv.Value.Size(); have < want {
+			t.Fatalf("ordered=%v: memory size %d below encoded size %d", ordered, have, want)
+		}
+	}
+
+	tests := []struct {
+		tag  Tag
+		want int
+	}{
+		{Byte(1), 1},
+		{Long(1), 8},
+		{String(""), 2},
+		{String("a\x00"), 5},
+		{String("\U0001F600"), 8},
+		{ByteArray{1, 2, 3}, 7},
+		{IntArray{1, 2}, 12},
+		{LongArray{1}, 12},
+		{List{TagShort, []Tag{Short(1), Short(2)}}, 9},
+		{Compound{}, 1},
+		{Compound{"a": Int(1), "b": nil}, 9},
+		{OrderedCompound{{"ab", String("c")}}, 9},
+	}
+
+	for _, tt := range tests {
+		if have := tt.tag.EncodedSize(); have != tt.want {
+			t.Errorf("%#v: encoded size mismatch: have %d, want %d", tt.tag, have, tt.want)
+		}
+
+		var buf bytes.Buffer
+		err = NewEncoder(&buf).encodeTag(tt.tag, "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.Len() != tt.want {
+			t.Errorf("%#v: encoder wrote %d bytes, want %d", tt.tag, buf.Len(), tt.want)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	long := strings.Repeat("x", 40000)
+
+	a := KeyValue{
+		Name: "",
+		Value: OrderedCompound{
+			{"", String("empty")},
+			{"a\x00b", String("nul")},
+			{"\xc0\x80", Byte(1)},
+			{"smile \U0001F600", String("\U0001F600\x00")},
+			{"\xff\xfe", String("\xed\xa0\x80")},
+			{long, String(long)},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	// NUL and supplementary characters use modified UTF-8.
+	if bytes.Contains(data, []byte("a\x00b")) || !bytes.Contains(data, []byte("a\xc0\x80b")) {
+		t.Fatalf("NUL byte not encoded as 0xc0 0x80")
+	}
+
+	if !bytes.Contains(data, []byte("\xed\xa0\xbd\xed\xb8\x80")) {
+		t.Fatalf("supplementary character not encoded as surrogate pair")
+	}
+
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.SetOrdered(true)
+
+	var b KeyValue
+	err = dec.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An encoded 0xc0 0x80 key decodes as NUL, like the key before it.
+	want := a.Value.(OrderedCompound)
+	want[2].Name = "\x00"
+
+	if !reflect.DeepEqual(b.Value, want) {
+		t.Fatalf("roundtrip mismatch:\nhave: %q\nwant: %q", b.Value, want)
+	}
+
+	// Strings are limited to 65535 bytes.
+	err = Marshal(&buf, String(strings.Repeat("x", 65536)))
+	if err == nil || !strings.Contains(err.Error(), "maximum length") {
+		t.Fatalf("expected length error, have %v", err)
+	}
+}
+
+func TestTagField(t *testing.T) {
+	type Level struct {
+		Preset   string `nbt:"settings,omitempty"`
+		Settings Tag    `nbt:"settings"`
+		Extra    Tag    `nbt:"extra"`
+		Items    []Tag  `nbt:"items"`
+		Name     string `nbt:"name"`
+	}
+
+	// The compound skips the string field, as it does not fit.
+	a := Level{
+		Settings: Compound{"layers": List{TagInt, []Tag{Int(1), Int(2)}}},
+		Items:    []Tag{Compound{"id": String("a")}, Compound{"id": String("b")}},
+		Name:     "test",
+	}
+
+	var b Level
+	testRoundtrip(t, &a, &b)
+
+	// A plain value ends up in the first field. Nil tags are not written.
+	a = Level{Preset: "minecraft:overworld", Name: "test"}
+	b = Level{}
+	testRoundtrip(t, &a, &b)
+
+	// Concrete tag types only take their own type.
+	var buf bytes.Buffer
+	err := Marshal(&buf, struct {
+		Data List `nbt:"data"`
+	}{List{TagByte, []Tag{Byte(1)}}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c struct {
+		Data Compound `nbt:"data"`
+	}
+
+	err = Unmarshal(&buf, &c)
+	if err == nil || !strings.Contains(err.Error(), "can not assign") {
+		t.Fatalf("expected assignment error, have %v", err)
+	}
+}
+
+func TestMaxElements(t *testing.T) {
+	type T struct {
+		A []float32 `nbt:"a"`
+		B []int64   `nbt:"b"`
+	}
+
+	var buf bytes.Buffer
+
+	err := Marshal(&buf, T{A: []float32{1, 2, 3}, B: []int64{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	var v T
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.SetMaxElements(3)
+	if err = dec.Decode(&v); err != nil {
+		t.Fatalf("decode within limit: %v", err)
+	}
+
+	dec = NewDecoder(bytes.NewReader(data))
+	dec.SetMaxElements(2)
+	if err = dec.Decode(&v); err == nil {
+		t.Fatal("expected error for list exceeding the limit")
+	}
+
+	// Declare a huge long array, without supplying the data.
+	huge := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagLongArray), 0, 1, 'b', 0x7f, 0xff, 0xff, 0xff,
+	}
+
+	err = Unmarshal(bytes.NewReader(huge), &v)
+	if err == nil || !strings.Contains(err.Error(), "exceeds remaining input") {
+		t.Fatalf("expected error for oversized array, have %v", err)
+	}
+}
+
+func TestTruncatedHugeArrays(t *testing.T) {
+	// Each stream declares 2 billion elements and then ends. The reader
+	// hides its length, so the declared size can not be checked up front.
+	prefix := []byte{byte(TagCompound), 0, 0}
+	huge := []byte{0x7f, 0xff, 0xff, 0xff}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"bytes", append([]byte{byte(TagByteArray), 0, 1, 'a'}, huge...)},
+		{"ints", append([]byte{byte(TagIntArray), 0, 1, 'a'}, huge...)},
+		{"longs", append([]byte{byte(TagLongArray), 0, 1, 'a'}, huge...)},
+		{"list", append([]byte{byte(TagList), 0, 1, 'a', byte(TagLong)}, huge...)},
+	}
+
+	targets := []func() interface{}{
+		func() interface{} { return new(interface{}) },
+		func() interface{} { return new(Tag) },
+		func() interface{} {
+			return &struct {
+				A []int64 `nbt:"a"`
+			}{}
+		},
+	}
+
+	for _, tt := range tests {
+		data := append(append(prefix[:len(prefix):len(prefix)], tt.data...), 1, 2, 3)
+
+		for _, target := range targets {
+			v := target()
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			err := Unmarshal(struct{ io.Reader }{bytes.NewReader(data)}, v)
+
+			runtime.ReadMemStats(&after)
+
+			if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+				// Struct fields of the wrong type fail before reading.
+				if _, ok := err.(*DecodeError); !ok {
+					t.Errorf("%s into %T: expected EOF, have %v", tt.name, v, err)
+				}
+			}
+
+			if n := after.TotalAlloc - before.TotalAlloc; n > 1<<22 {
+				t.Errorf("%s into %T: allocated %d bytes", tt.name, v, n)
+			}
+		}
+	}
+}
+
+// testRoundtrip encodes <want> and then decodes into <have>.
+// The two should then be equal.
+func testRoundtrip(t *testing.T, want, have interface{}) {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	err := enc.Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(&buf)
+	err = dec.Decode(have)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := reflect.ValueOf(want)
+	rw = reflect.Indirect(rw)
+	want = rw.Interface()
+
+	rh := reflect.ValueOf(have)
+	rh = reflect.Indirect(rh)
+	have = rh.Interface()
+
+	if !reflect.DeepEqual(want, have) {
+		t.Fatalf("roundtrip mismatch:\nHave: %#v\nWant: %#v", have, want)
+	}
+}
+
+type Food struct {
+	Name  string  `nbt:"name"`
+	Value float32 `nbt:"value"`
+}
+
+type NestedCompound struct {
+	Egg Food `nbt:"egg"`
+	Ham Food `nbt:"ham"`
+}
+
+type ListItem struct {
+	Name    string `nbt:"name"`
+	Created int64  `nbt:"created-on"`
+}
+
+type BigTestData struct {
+	NestedCompound `nbt:"nested compound test"`
+	ListTestCmp    []ListItem `nbt:"listTest (compound)"`
+	ListTestLong   []int64    `nbt:"listTest (long)"`
+	ListTestByte   []byte     `nbt:"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"`
+	StringTest     string     `nbt:"stringTest"`
+	LongTest       int64      `nbt:"longTest"`
+	DoubleTest     float64    `nbt:"doubleTest"`
+	FloatTest      float32    `nbt:"floatTest"`
+	IntTest        int32      `nbt:"intTest"`
+	ShortTest      int16      `nbt:"shortTest"`
+	ByteTest       byte       `nbt:"byteTest"`
+}
+
+type BigTest struct {
+	BigTestData `nbt:"Level"`
+}
+
+type SmallTestData struct {
+	Name string `nbt:"name"`
+}
+
+type SmallTest struct {
+	SmallTestData `nbt:"hello world"`
+}
+
+func load(t *testing.T, data []byte, v interface{}) {
+	r, err := gzip.NewReader(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	err = Unmarshal(r, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var big_nbt = []byte{
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xed, 0x54,
+	0xcf, 0x4f, 0x1a, 0x41, 0x14, 0x7e, 0xc2, 0x02, 0xcb, 0x96, 0x82, 0xb1,
+	0xc4, 0x10, 0x63, 0xcc, 0xab, 0xb5, 0x84, 0xa5, 0xdb, 0xcd, 0x42, 0x11,
+	0x89, 0xb1, 0x88, 0x16, 0x2c, 0x9a, 0x0d, 0x1a, 0xd8, 0xa8, 0x31, 0x86,
+	0xb8, 0x2b, 0xc3, 0x82, 0x2e, 0xbb, 0x66, 0x77, 0xb0, 0xf1, 0xd4, 0x4b,
+	0x7b, 0x6c, 0x7a, 0xeb, 0x3f, 0xd3, 0x23, 0x7f, 0x43, 0xcf, 0xbd, 0xf6,
+	0xbf, 0xa0, 0xc3, 0x2f, 0x7b, 0x69, 0xcf, 0xbd, 0xf0, 0x32, 0xc9, 0xf7,
+	0xe6, 0xbd, 0x6f, 0xe6, 0x7b, 0x6f, 0x26, 0x79, 0x02, 0x04, 0x54, 0x72,
+	0x4f, 0x2c, 0x0e, 0x78, 0xcb, 0xb1, 0x4d, 0x8d, 0x78, 0xf4, 0xe3, 0x70,
+	0x62, 0x3e, 0x08, 0x7b, 0x1d, 0xc7, 0xa5, 0x93, 0x18, 0x0f, 0x82, 0x47,
+	0xdd, 0xee, 0x84, 0x02, 0x62, 0xb5, 0xa2, 0xaa, 0xc7, 0x78, 0x76, 0x5c,
+	0x57, 0xcb, 0xa8, 0x55, 0x0f, 0x1b, 0xc8, 0xd6, 0x1e, 0x6a, 0x95, 0x86,
+	0x86, 0x0d, 0xad, 0x7e, 0x58, 0x7b, 0x8f, 0x83, 0xcf, 0x83, 0x4f, 0x83,
+	0x6f, 0xcf, 0x03, 0x10, 0x6e, 0x5b, 0x8e, 0x3e, 0xbe, 0xa5, 0x38, 0x4c,
+	0x64, 0xfd, 0x10, 0xea, 0xda, 0x74, 0xa6, 0x23, 0x40, 0xdc, 0x66, 0x2e,
+	0x69, 0xe1, 0xb5, 0xd3, 0xbb, 0x73, 0xfa, 0x76, 0x0b, 0x29, 0xdb, 0x0b,
+	0xe0, 0xef, 0xe8, 0x3d, 0x1e, 0x38, 0x5b, 0xef, 0x11, 0x08, 0x56, 0xf5,
 	0xde, 0x5d, 0xdf, 0x0b, 0x40, 0xe0, 0x5e, 0xb7, 0xfa, 0x64, 0xb7, 0x04,
 	0x00, 0x8c, 0x41, 0x4c, 0x73, 0xc6, 0x08, 0x55, 0x4c, 0xd3, 0x20, 0x2e,
 	0x7d, 0xa4, 0xc0, 0xc8, 0xc2, 0x10, 0xb3, 0xba, 0xde, 0x58, 0x0b, 0x53,
@@ -396,3 +1247,2455 @@ var small_nbt = []byte{
 	0x4b, 0xcc, 0x2b, 0x4a, 0xcc, 0x4d, 0x64, 0x00, 0x00, 0x77, 0xda, 0x5c,
 	0x3a, 0x21, 0x00, 0x00, 0x00,
 }
+
+func TestLexNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"123", int32(123)},
+		{"+123", int32(123)},
+		{"-123", int32(-123)},
+		{"12b", int8(12)},
+		{"-12B", int8(-12)},
+		{"12s", int16(12)},
+		{"12S", int16(12)},
+		{"12l", int64(12)},
+		{"12L", int64(12)},
+		{"0xFF", int32(255)},
+		{"0Xff", int32(255)},
+		{"-0x10", int32(-16)},
+		{"0xFFFFFFFF", int32(-1)},
+		{"0x7fs", int16(127)},
+		{"0xFFFFS", int16(-1)},
+		{"0x1l", int64(1)},
+		{"0xAB", int32(0xab)},
+		{"1.5", 1.5},
+		{".5", 0.5},
+		{".5d", 0.5},
+		{"1.", 1.0},
+		{"1e3", 1000.0},
+		{"1E-3", 0.001},
+		{"1d", 1.0},
+		{"1.5D", 1.5},
+		{"1f", float32(1)},
+		{"+1.0e3f", float32(1000)},
+		{"-2.5F", float32(-2.5)},
+	}
+
+	for _, tt := range tests {
+		have, ok, err := lexNumber(tt.in, 0)
+		if err != nil || !ok {
+			t.Errorf("%q: unexpected failure: %v %v", tt.in, ok, err)
+			continue
+		}
+
+		if have != tt.want {
+			t.Errorf("%q: have %T(%v), want %T(%v)", tt.in, have, have, tt.want, tt.want)
+		}
+	}
+}
+
+func TestLexNumberInvalid(t *testing.T) {
+	// Tokens which are not numbers at all.
+	for _, in := range []string{"", "abc", "+", "-", ".", "true", ".x", "e5", "--1"} {
+		if _, ok, err := lexNumber(in, 0); ok || err != nil {
+			t.Errorf("%q: expected non-number, have %v %v", in, ok, err)
+		}
+	}
+
+	// Malformed numbers.
+	tests := []struct {
+		in     string
+		offset int
+	}{
+		{"1.2.3", 13},
+		{"1e", 11},
+		{"1e+", 13},
+		{"1.5b", 13},
+		{"128b", 10},
+		{"40000s", 10},
+		{"3000000000", 10},
+		{"1_000", 11},
+		{"0x", 12},
+		{"0xG1", 12},
+		{"0x1FFFFs", 10},
+		{"12x", 12},
+		{"1.2.3f", 13},
+		{"1e400", 10},
+		{"1e39f", 10},
+	}
+
+	for _, tt := range tests {
+		_, ok, err := lexNumber(tt.in, 10)
+		if !ok || err == nil {
+			t.Errorf("%q: expected error, have %v %v", tt.in, ok, err)
+			continue
+		}
+
+		se, isSyntax := err.(*SyntaxError)
+		if !isSyntax || se.Offset != tt.offset {
+			t.Errorf("%q: have error %v, want offset %d", tt.in, err, tt.offset)
+		}
+	}
+}
+
+func TestFprint(t *testing.T) {
+	type level struct {
+		XPos   int32  `nbt:"xPos"`
+		ZPos   int32  `nbt:"zPos"`
+		Status string `nbt:"Status"`
+	}
+
+	type chunk struct {
+		Level level
+	}
+
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{Byte(-1), "-1b"},
+		{Short(2), "2s"},
+		{Int(3), "3"},
+		{Long(100), "100L"},
+		{Float(1.5), "1.5f"},
+		{Double(1.5), "1.5d"},
+		{Float(3), "3f"},
+		{Double(math.Inf(-1)), "-Infinityd"},
+		{String(`a"b\c`), `'a"b\\c'`},
+		{String(`'"`), `"'\""`},
+		{ByteArray{1, 0xff}, "[B;1b,-1b]"},
+		{IntArray{1, -2}, "[I;1,-2]"},
+		{LongArray{}, "[L;]"},
+		{List{Elem: TagShort, Items: []Tag{Short(1), Short(2)}}, "[1s,2s]"},
+		{Compound{"b": Int(1), "a": String("x"), "weird key": List{Elem: TagEnd}}, `{a:"x",b:1,"weird key":[]}`},
+		{OrderedCompound{{"z", Byte(1)}, {"a:b", Compound{}}}, `{z:1b,"a:b":{}}`},
+		{KeyValue{"root", Compound{"id": String("minecraft:stone")}}, `{id:"minecraft:stone"}`},
+		{chunk{level{0, -1, "full"}}, `{Level:{xPos:0,zPos:-1,Status:"full"}}`},
+		{map[string][]int64{"Heights": {1, 2}}, "{Heights:[L;1L,2L]}"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+
+		err := Fprint(&buf, tt.in)
+		if err != nil {
+			t.Errorf("%v: %v", tt.in, err)
+			continue
+		}
+
+		if have := buf.String(); have != tt.want {
+			t.Errorf("%v: have %s, want %s", tt.in, have, tt.want)
+		}
+	}
+
+	// Numbers read back as the same type and value.
+	for _, tag := range []Tag{Byte(-128), Short(-32768), Int(-1 << 31), Long(-1 << 63), Float(1e-7), Double(-2.5e300)} {
+		var buf bytes.Buffer
+
+		err := Fprint(&buf, tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		have, ok, err := lexNumber(buf.String(), 0)
+		if !ok || err != nil {
+			t.Fatalf("%s: %v %v", buf.String(), ok, err)
+		}
+
+		hv := reflect.ValueOf(have)
+		if hv.Kind() != reflect.ValueOf(tag).Kind() || hv.Convert(reflect.TypeOf(tag)).Interface() != tag {
+			t.Errorf("%s: have %T %v, want %v", buf.String(), have, have, tag)
+		}
+	}
+
+	if err := Fprint(ioutil.Discard, make(chan int)); err == nil {
+		t.Fatalf("expected error for unsupported value")
+	}
+}
+
+func TestParseSNBT(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{`{CustomName:'{"text":"Bob"}',Health:20.0f}`, map[string]interface{}{
+			"CustomName": `{"text":"Bob"}`,
+			"Health":     float32(20),
+		}},
+		{`"123"`, "123"},
+		{`'123'`, "123"},
+		{`123`, int32(123)},
+		{`abc`, "abc"},
+		{`minecraft.stone-1`, "minecraft.stone-1"},
+		{`"a\"b\\c'd"`, `a"b\c'd`},
+		{`'it\'s'`, "it's"},
+		{`[1b,2s,3L,4f,5d,6.5]`, nil},
+		{`[1b, 2b, true, false]`, []interface{}{int8(1), int8(2), int8(1), int8(0)}},
+		{` { "a b" : [ ] , c : { } } `, map[string]interface{}{
+			"a b": []interface{}{},
+			"c":   map[string]interface{}{},
+		}},
+		{`[B;1b,-1b]`, []byte{1, 0xff}},
+		{`[I; 1, -2, 0xff]`, []int32{1, -2, 255}},
+		{`[L;]`, []int64{}},
+		{`[[1],["a"]]`, []interface{}{[]interface{}{int32(1)}, []interface{}{"a"}}},
+		{`[{id:"a"},{id:"b"}]`, []interface{}{
+			map[string]interface{}{"id": "a"},
+			map[string]interface{}{"id": "b"},
+		}},
+	}
+
+	for _, tt := range tests {
+		have, err := ParseSNBT(tt.in)
+
+		if tt.want == nil {
+			if _, ok := err.(*SyntaxError); !ok {
+				t.Errorf("%s: expected syntax error, have %v %v", tt.in, have, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%s: have %#v, want %#v", tt.in, have, tt.want)
+		}
+	}
+
+	// Malformed input.
+	invalid := []struct {
+		in     string
+		offset int
+	}{
+		{``, 0},
+		{`{a:1`, 4},
+		{`{a:1,}`, 5},
+		{`{a 1}`, 3},
+		{`[1,"a"]`, 3},
+		{`[B;1,2]`, 3},
+		{`"abc`, 0},
+		{`"a\nb"`, 3},
+		{`{a:1} x`, 6},
+		{`{a:1.5b}`, 6},
+		{`[1,]`, 3},
+	}
+
+	for _, tt := range invalid {
+		_, err := ParseSNBT(tt.in)
+
+		se, ok := err.(*SyntaxError)
+		if !ok || se.Offset != tt.offset {
+			t.Errorf("%s: have %v, want syntax error at offset %d", tt.in, err, tt.offset)
+		}
+	}
+
+	// Fprint output parses back into the same values.
+	in := Compound{
+		"name":  String(`"quoted" and 'single'`),
+		"pos":   List{Elem: TagDouble, Items: []Tag{Double(1.5), Double(-2)}},
+		"ids":   IntArray{1, 2},
+		"bytes": ByteArray{3},
+		"n":     Long(-5),
+	}
+
+	var buf bytes.Buffer
+	if err := Fprint(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := ParseSNBT(buf.String())
+	if err != nil {
+		t.Fatalf("%s: %v", buf.String(), err)
+	}
+
+	want := map[string]interface{}{
+		"name":  `"quoted" and 'single'`,
+		"pos":   []interface{}{1.5, -2.0},
+		"ids":   []int32{1, 2},
+		"bytes": []byte{3},
+		"n":     int64(-5),
+	}
+
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("have %#v, want %#v", have, want)
+	}
+}
+
+func TestNamedRoot(t *testing.T) {
+	type level struct {
+		X int32
+		Y string
+	}
+
+	in := level{X: 5, Y: "a"}
+
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).EncodeNamed("hello world", in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if want := "\x0a\x00\x0bhello world"; !bytes.HasPrefix(data, []byte(want)) {
+		t.Fatalf("unexpected header % x", data)
+	}
+
+	var out level
+	name, err := NewDecoder(bytes.NewReader(data)).DecodeNamed(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "hello world" || out != in {
+		t.Fatalf("have %q %+v, want %q %+v", name, out, "hello world", in)
+	}
+
+	var kv KeyValue
+	name, err = NewDecoder(bytes.NewReader(data)).DecodeNamed(&kv)
+	if err != nil || name != kv.Name || kv.Name != "hello world" {
+		t.Fatalf("have %q %q %v", name, kv.Name, err)
+	}
+
+	if err = NewEncoder(&buf).EncodeNamed("x", nil); err == nil {
+		t.Fatalf("expected error for nil value")
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	type section struct {
+		Y           int8
+		BlockStates []int64
+	}
+
+	type chunk struct {
+		Level struct {
+			Sections []section
+			Data     map[string]int32
+		}
+	}
+
+	sections := List{Elem: TagCompound}
+	for i := 0; i < 4; i++ {
+		sections.Items = append(sections.Items, Compound{"Y": Byte(i), "BlockStates": LongArray{1}})
+	}
+
+	tests := []struct {
+		level Compound
+		path  string
+		tag   TagId
+		typ   reflect.Type
+	}{
+		{
+			Compound{"Sections": List{Elem: TagCompound, Items: append(append([]Tag{}, sections.Items[:3]...),
+				Compound{"BlockStates": Compound{}})}},
+			"Level.Sections[3].BlockStates", TagCompound, reflect.TypeOf([]int64(nil)),
+		},
+		{
+			Compound{"Sections": List{Elem: TagString, Items: []Tag{String("x")}}},
+			"Level.Sections[0]", TagString, reflect.TypeOf(section{}),
+		},
+		{
+			Compound{"Data": Compound{"a.b": String("x")}},
+			`Level.Data."a.b"`, TagString, reflect.TypeOf(int32(0)),
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Marshal(&buf, Compound{"Level": tt.level}); err != nil {
+			t.Fatal(err)
+		}
+
+		var c chunk
+		err := Unmarshal(&buf, &c)
+
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			t.Errorf("%s: expected *DecodeError, have %T %v", tt.path, err, err)
+			continue
+		}
+
+		if de.Path != tt.path || de.Tag != tt.tag || de.Type != tt.typ {
+			t.Errorf("have %s %s %v, want %s %s %v", de.Path, de.Tag, de.Type, tt.path, tt.tag, tt.typ)
+		}
+
+		if want := "nbt: " + tt.path + ": "; !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("error %q does not start with %q", err, want)
+		}
+	}
+}
+
+func TestUnmarshalAuto(t *testing.T) {
+	type small struct {
+		Name string `nbt:"name"`
+	}
+
+	want := small{Name: "Bananrama"}
+
+	for _, c := range []Compression{GZip, ZLib, Uncompressed, LZ4} {
+		var buf bytes.Buffer
+
+		err := MarshalCompressed(&buf, want, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var have small
+		err = UnmarshalAuto(&buf, &have)
+		if err != nil || have != want {
+			t.Errorf("compression %d: have %+v %v, want %+v", c, have, err, want)
+		}
+	}
+
+	// The gzip fixtures decode as they do through gzip.NewReader.
+	var big, bigAuto BigTest
+	load(t, big_nbt, &big)
+	if err := UnmarshalAuto(bytes.NewReader(big_nbt), &bigAuto); err != nil {
+		t.Errorf("big_nbt: %v", err)
+	} else if !reflect.DeepEqual(bigAuto, big) {
+		t.Errorf("big_nbt: decode mismatch:\nhave: %#v\nwant: %#v", bigAuto, big)
+	}
+
+	var smallFix, smallAuto SmallTest
+	load(t, small_nbt, &smallFix)
+	if err := UnmarshalAuto(bytes.NewReader(small_nbt), &smallAuto); err != nil || smallAuto != smallFix {
+		t.Errorf("small_nbt: have %+v %v, want %+v", smallAuto, err, smallFix)
+	}
+
+	for _, in := range [][]byte{{}, {0x08, 0x00}, {0x78, 0x00}, []byte("plain text")} {
+		var have small
+		if err := UnmarshalAuto(bytes.NewReader(in), &have); err == nil || !strings.Contains(err.Error(), "detect compression") {
+			t.Errorf("% x: expected detection error, have %v", in, err)
+		}
+	}
+}
+
+func TestDisallowUnknownTags(t *testing.T) {
+	type section struct {
+		Y int8
+	}
+
+	type level struct {
+		Sections []section
+		Other    map[string]int32
+		Rest     Subtree `nbt:"remaining"`
+	}
+
+	type chunk struct {
+		Level level
+	}
+
+	var extra int32
+
+	in := Compound{"Level": Compound{
+		"Sections": List{Elem: TagCompound, Items: []Tag{
+			Compound{"Y": Byte(0)},
+			Compound{"Y": Byte(1), "BlockLight": ByteArray{1}},
+		}},
+		"Other": Compound{"anything": Int(1)},
+		"Extra": Int(5),
+	}}
+
+	var buf bytes.Buffer
+	if err := Marshal(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	decode := func(strict bool) error {
+		c := chunk{Level: level{Rest: Subtree{Name: "Extra", Value: &extra}}}
+
+		dec := NewDecoder(bytes.NewReader(data))
+		if strict {
+			dec.DisallowUnknownTags()
+		}
+
+		return dec.Decode(&c)
+	}
+
+	if err := decode(false); err != nil {
+		t.Fatalf("lax: %v", err)
+	}
+
+	err := decode(true)
+
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Path != "Level.Sections[1].BlockLight" {
+		t.Fatalf("strict: expected error for Level.Sections[1].BlockLight, have %v", err)
+	}
+
+	if !strings.Contains(err.Error(), `TagByteArray("BlockLight"): unknown tag`) {
+		t.Fatalf("strict: unexpected error %q", err)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	// nested returns a root compound holding n nested compounds,
+	// or lists of compounds if list is true.
+	nested := func(n int, list bool) []byte {
+		data := []byte{byte(TagCompound), 0, 0}
+
+		for i := 0; i < n; i++ {
+			if list {
+				data = append(data, byte(TagList), 0, 1, 'a', byte(TagCompound), 0, 0, 0, 1)
+			} else {
+				data = append(data, byte(TagCompound), 0, 1, 'a')
+			}
+		}
+
+		return append(data, bytes.Repeat([]byte{byte(TagEnd)}, n+1)...)
+	}
+
+	type empty struct{}
+
+	decoders := map[string]func(*Decoder) error{
+		"interface": func(d *Decoder) error { var v interface{}; return d.Decode(&v) },
+		"tag":       func(d *Decoder) error { var v Tag; return d.Decode(&v) },
+		"ordered":   func(d *Decoder) error { var v OrderedCompound; return d.Decode(&v) },
+		"map":       func(d *Decoder) error { var v map[string]interface{}; return d.Decode(&v) },
+		"skip":      func(d *Decoder) error { var v empty; return d.Decode(&v) },
+		"token": func(d *Decoder) error {
+			for {
+				_, err := d.Token()
+				if err == io.EOF {
+					return nil
+				}
+
+				if err != nil {
+					return err
+				}
+			}
+		},
+	}
+
+	for name, decode := range decoders {
+		for _, list := range []bool{false, true} {
+			err := decode(NewDecoder(bytes.NewReader(nested(10000, list))))
+			if err == nil || !strings.Contains(err.Error(), "maximum nesting depth of 512") {
+				t.Errorf("%s, list=%v: expected depth error, have %v", name, list, err)
+			}
+
+			// The root compound counts as well.
+			n := DefaultMaxDepth - 1
+			if list {
+				n = (DefaultMaxDepth - 1) / 2
+			}
+
+			if err = decode(NewDecoder(bytes.NewReader(nested(n, list)))); err != nil {
+				t.Errorf("%s, list=%v: %v", name, list, err)
+			}
+
+			if err = decode(NewDecoder(bytes.NewReader(nested(n+1, list)))); err == nil {
+				t.Errorf("%s, list=%v: expected depth error for %d levels", name, list, n+1)
+			}
+
+			dec := NewDecoder(bytes.NewReader(nested(1000, list)))
+			dec.SetMaxDepth(0)
+
+			if err = decode(dec); err != nil {
+				t.Errorf("%s, list=%v: unlimited: %v", name, list, err)
+			}
+		}
+	}
+}
+
+// FuzzDecode checks that no input makes the decoder panic. Malformed
+// data must always yield an error.
+func FuzzDecode(f *testing.F) {
+	type inner struct {
+		N int8    `nbt:"n"`
+		F float64 `nbt:"f"`
+	}
+
+	type target struct {
+		Id    string             `nbt:"id"`
+		Pos   []int32            `nbt:"pos"`
+		Bytes []byte             `nbt:"bytes"`
+		Longs []int64            `nbt:"longs"`
+		Items []inner            `nbt:"items"`
+		Ptr   *inner             `nbt:"ptr"`
+		Map   map[string]int16   `nbt:"map"`
+		Any   interface{}        `nbt:"any"`
+		Names []string           `nbt:"names"`
+		Tags  map[string]Tag     `nbt:"tags"`
+		Fixed [3]int64           `nbt:"fixed"`
+		Deep  map[string][]inner `nbt:"deep"`
+	}
+
+	seeds := []interface{}{
+		target{
+			Id:    "a",
+			Pos:   []int32{1, 2, 3},
+			Bytes: []byte{4, 5},
+			Longs: []int64{6},
+			Items: []inner{{1, 2}, {3, 4}},
+			Ptr:   &inner{5, 6},
+			Map:   map[string]int16{"x": 7},
+			Any:   int32(8),
+			Names: []string{"b", "c"},
+			Tags:  map[string]Tag{"s": String("d")},
+			Fixed: [3]int64{9, 10, 11},
+			Deep:  map[string][]inner{"e": {{12, 13}}},
+		},
+		KeyValue{"root", OrderedCompound{
+			{"list", List{TagList, []Tag{List{TagCompound, []Tag{OrderedCompound{}}}}}},
+			{"ints", List{TagInt, []Tag{Int(1)}}},
+		}},
+	}
+
+	for _, v := range seeds {
+		var buf bytes.Buffer
+		if err := Marshal(&buf, v); err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(buf.Bytes())
+	}
+
+	f.Add([]byte{byte(TagCompound), 0, 0, byte(TagString), 0, 1, 'a', 0xff, 0xff})
+	f.Add([]byte{byte(TagCompound), 0, 0, byte(TagList), 0, 1, 'a', byte(TagEnd), 0, 0, 0, 1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v target
+		Unmarshal(bytes.NewReader(data), &v)
+
+		var generic interface{}
+		Unmarshal(bytes.NewReader(data), &generic)
+
+		var tag Tag
+		Unmarshal(struct{ io.Reader }{bytes.NewReader(data)}, &tag)
+
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.SetOrdered(true)
+		dec.DisallowUnknownTags()
+		dec.Decode(&tag)
+
+		dec = NewDecoder(bytes.NewReader(data))
+		dec.SetByteOrder(binary.LittleEndian)
+		dec.Decode(&generic)
+
+		dec = NewDecoder(bytes.NewReader(data))
+		for i := 0; i < len(data); i++ {
+			if _, err := dec.Token(); err != nil {
+				break
+			}
+		}
+
+		dec = NewDecoder(bytes.NewReader(data))
+		if c, _, err := dec.Compound(); err == nil {
+			walkCompound(c)
+		}
+
+		UnmarshalAuto(bytes.NewReader(data), &generic)
+	})
+}
+
+// walkCompound reads every tag in c, descending into nested compounds.
+func walkCompound(c *CompoundReader) {
+	for {
+		_, id := c.Next()
+		switch id {
+		case TagEnd:
+			return
+		case TagCompound:
+			sub, err := c.Compound()
+			if err != nil {
+				return
+			}
+			walkCompound(sub)
+		default:
+			var v interface{}
+			if c.Value(&v) != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestInline(t *testing.T) {
+	type meta struct {
+		Version int32  `nbt:"DataVersion,required"`
+		Status  string `nbt:"Status"`
+	}
+
+	type pos struct {
+		X int32 `nbt:"x"`
+		Z int32 `nbt:"z"`
+	}
+
+	type T struct {
+		Meta  meta   `nbt:",inline"`
+		Pos   pos    `nbt:"pos"`
+		Name  string `nbt:"name"`
+		Extra pos    `nbt:"extra,inline"`
+	}
+
+	want := T{
+		Meta:  meta{Version: 3465, Status: "full"},
+		Pos:   pos{1, 2},
+		Name:  "a",
+		Extra: pos{3, 4},
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Inlined fields sit next to the others, while pos keeps its own
+	// compound.
+	var tree OrderedCompound
+	err = NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, kv := range tree {
+		names = append(names, kv.Name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"DataVersion", "Status", "pos", "name", "x", "z"}) {
+		t.Fatalf("unexpected tags %v", names)
+	}
+
+	var have T
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &have)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("value mismatch:\nhave: %+v\nwant: %+v", have, want)
+	}
+
+	// Required fields of inlined structs are checked as well.
+	var partial bytes.Buffer
+	err = Marshal(&partial, struct {
+		Name string `nbt:"name"`
+	}{"b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Unmarshal(bytes.NewReader(partial.Bytes()), &have)
+	if err == nil || !strings.Contains(err.Error(), "DataVersion") {
+		t.Fatalf("expected missing DataVersion error, have %v", err)
+	}
+}
+
+func TestFixedArrays(t *testing.T) {
+	type item struct {
+		Id    string `nbt:"id"`
+		Count int8   `nbt:"Count"`
+	}
+
+	type T struct {
+		Ints  [4]int32 `nbt:"ints"`
+		List  [4]int32 `nbt:"list,list"`
+		Items [3]item  `nbt:"items"`
+	}
+
+	want := T{
+		Ints:  [4]int32{1, 2, 3, 4},
+		List:  [4]int32{5, 6, 7, 8},
+		Items: [3]item{{"a", 1}, {"b", 2}, {}},
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Arrays are written with exactly their own length, including
+	// zero-valued elements.
+	var tree Compound
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ints, ok := tree["ints"].(IntArray); !ok || len(ints) != 4 {
+		t.Fatalf("unexpected ints tag %#v", tree["ints"])
+	}
+
+	if list, ok := tree["list"].(List); !ok || list.Elem != TagInt || len(list.Items) != 4 {
+		t.Fatalf("unexpected list tag %#v", tree["list"])
+	}
+
+	if items, ok := tree["items"].(List); !ok || items.Elem != TagCompound || len(items.Items) != 3 {
+		t.Fatalf("unexpected items tag %#v", tree["items"])
+	}
+
+	// Stale data in the target is overwritten.
+	have := T{Items: [3]item{{}, {}, {"stale", 9}}}
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &have)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("value mismatch:\nhave: %+v\nwant: %+v", have, want)
+	}
+
+	// Shorter input leaves the remaining elements zeroed.
+	var short bytes.Buffer
+	err = Marshal(&short, struct {
+		Ints  []int32 `nbt:"ints"`
+		List  []int32 `nbt:"list,list"`
+		Items []item  `nbt:"items"`
+	}{[]int32{1}, []int32{2, 3}, []item{{"c", 3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have = want
+	err = Unmarshal(bytes.NewReader(short.Bytes()), &have)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = T{
+		Ints:  [4]int32{1},
+		List:  [4]int32{2, 3},
+		Items: [3]item{{"c", 3}},
+	}
+
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("short value mismatch:\nhave: %+v\nwant: %+v", have, want)
+	}
+
+	// Longer input does not fit.
+	long := []interface{}{
+		struct {
+			Ints []int32 `nbt:"ints"`
+		}{[]int32{1, 2, 3, 4, 5}},
+		struct {
+			List []int32 `nbt:"list,list"`
+		}{[]int32{1, 2, 3, 4, 5}},
+		struct {
+			Items []item `nbt:"items"`
+		}{make([]item, 4)},
+	}
+
+	for _, v := range long {
+		buf.Reset()
+		err = Marshal(&buf, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = Unmarshal(bytes.NewReader(buf.Bytes()), &have)
+		if err == nil {
+			t.Fatalf("expected error for %+v", v)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	root := KeyValue{"Level", OrderedCompound{
+		{"b", Byte(-1)},
+		{"s", Short(300)},
+		{"i", Int(-70000)},
+		{"l", Long(math.MaxInt64)},
+		{"f", Float(0.1)},
+		{"d", Double(math.Inf(-1))},
+		{"str", String("a\"<b>")},
+		{"bytes", ByteArray{1, 0xff}},
+		{"ints", IntArray{1, 2}},
+		{"longs", LongArray{}},
+		{"empty", List{TagEnd, []Tag{}}},
+		{"nested", List{TagList, []Tag{List{TagShort, []Tag{Short(1)}}}}},
+		{"items", List{TagCompound, []Tag{OrderedCompound{{"z", Byte(1)}, {"a", Byte(2)}}}}},
+	}}
+
+	var nbt bytes.Buffer
+	err := Marshal(&nbt, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The typed form reads back into the same data.
+	var typed bytes.Buffer
+	err = ToJSON(bytes.NewReader(nbt.Bytes()), &typed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !json.Valid(typed.Bytes()) {
+		t.Fatalf("invalid JSON: %s", typed.Bytes())
+	}
+
+	if !strings.HasPrefix(typed.String(), `{"name":"Level","type":"compound","value":{"b":{"type":"byte","value":-1},`) {
+		t.Fatalf("unexpected typed output %s", typed.Bytes())
+	}
+
+	var back bytes.Buffer
+	err = FromJSON(bytes.NewReader(typed.Bytes()), &back)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(back.Bytes(), nbt.Bytes()) {
+		t.Fatalf("round trip mismatch:\nhave: % x\nwant: % x", back.Bytes(), nbt.Bytes())
+	}
+
+	// Compressed input is recognized.
+	var gz bytes.Buffer
+	err = MarshalGzip(&gz, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fromGzip bytes.Buffer
+	err = ToJSON(&gz, &fromGzip)
+	if err != nil || fromGzip.String() != typed.String() {
+		t.Fatalf("gzip mismatch: %v\n%s", err, fromGzip.Bytes())
+	}
+
+	// The flat form only has plain values.
+	var flat bytes.Buffer
+	err = ToJSONMode(bytes.NewReader(nbt.Bytes()), &flat, JSONFlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"b":-1,"s":300,"i":-70000,"l":9223372036854775807,"f":0.1,"d":"-Infinity",` +
+		`"str":"a\"<b>","bytes":[1,-1],"ints":[1,2],"longs":[],"empty":[],` +
+		`"nested":[[1]],"items":[{"z":1,"a":2}]}` + "\n"
+
+	if flat.String() != want {
+		t.Fatalf("flat mismatch:\nhave: %s\nwant: %s", flat.Bytes(), want)
+	}
+
+	if FromJSON(bytes.NewReader(flat.Bytes()), ioutil.Discard) == nil {
+		t.Fatal("expected error for flat input")
+	}
+
+	invalid := []string{
+		`{"type":"byte","value":128}`,
+		`{"type":"int","value":1.5}`,
+		`{"type":"word","value":1}`,
+		`{"type":"byte","value":1,"extra":true}`,
+		`{"type":"list","elem":"int","value":[{"type":"byte","value":1}]}`,
+		`{"type":"compound","value":{"a":{"type":"int","value":1},"a":{"type":"int","value":2}}}`,
+		`{"type":"compound","value":{"a":{"name":"x","type":"int","value":1}}}`,
+		`{"type":"float","value":"Inf"}`,
+	}
+
+	for _, s := range invalid {
+		if FromJSON(strings.NewReader(s), ioutil.Discard) == nil {
+			t.Fatalf("expected error for %s", s)
+		}
+	}
+}
+
+func TestEncodeOrder(t *testing.T) {
+	type meta struct {
+		Version int32  `nbt:"DataVersion"`
+		Status  string `nbt:"Status"`
+	}
+
+	type T struct {
+		Z    int32           `nbt:"zPos"`
+		Meta meta            `nbt:",inline"`
+		X    int32           `nbt:"xPos"`
+		Map  map[string]int8 `nbt:"map"`
+		Tree Compound        `nbt:"tree"`
+		Rest Subtree         `nbt:",remaining"`
+		Last string          `nbt:"last"`
+	}
+
+	v := T{
+		Z:    1,
+		Meta: meta{3465, "full"},
+		X:    2,
+		Map:  map[string]int8{"c": 1, "a": 2, "b": 3},
+		Tree: Compound{"y": Byte(1), "x": Byte(2)},
+		Rest: Subtree{Name: "extra", Value: Byte(3)},
+		Last: "z",
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tree OrderedCompound
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(c OrderedCompound) []string {
+		var out []string
+		for _, kv := range c {
+			out = append(out, kv.Name)
+		}
+		return out
+	}
+
+	want := []string{"zPos", "DataVersion", "Status", "xPos", "map", "tree", "extra", "last"}
+	if have := names(tree); !reflect.DeepEqual(have, want) {
+		t.Fatalf("field order mismatch:\nhave: %v\nwant: %v", have, want)
+	}
+
+	if have := names(tree[4].Value.(OrderedCompound)); !reflect.DeepEqual(have, []string{"a", "b", "c"}) {
+		t.Fatalf("map order mismatch: %v", have)
+	}
+
+	if have := names(tree[5].Value.(OrderedCompound)); !reflect.DeepEqual(have, []string{"x", "y"}) {
+		t.Fatalf("compound order mismatch: %v", have)
+	}
+
+	// Encoding is stable, and an OrderedCompound keeps its order.
+	for i := 0; i < 10; i++ {
+		var again bytes.Buffer
+		err = Marshal(&again, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+			t.Fatalf("encoding %d differs", i)
+		}
+
+		again.Reset()
+		err = Marshal(&again, tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+			t.Fatalf("encoding %d of ordered compound differs", i)
+		}
+	}
+}
+
+func TestFieldAliases(t *testing.T) {
+	type old struct {
+		Populated string `nbt:"TerrainPopulated"`
+		Version   int32  `nbt:"dataversion"`
+		Name      string `nbt:"name"`
+		Title     string `nbt:"NAME"`
+	}
+
+	type current struct {
+		Status  string `nbt:"Status,alias=TerrainPopulated"`
+		Version int32  `nbt:"DataVersion,required"`
+		Name    string `nbt:"Name"`
+		Title   string `nbt:"NAME"`
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, old{"full", 3465, "lower", "upper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	// Exact matching by default, so the required field is missing.
+	var v current
+	err = Unmarshal(bytes.NewReader(data), &v)
+	if err == nil || !strings.Contains(err.Error(), "DataVersion") {
+		t.Fatalf("expected missing DataVersion, have %v", err)
+	}
+
+	v = current{}
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.SetCaseInsensitive(true)
+	err = dec.Decode(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := current{"full", 3465, "lower", "upper"}
+	if v != want {
+		t.Fatalf("value mismatch:\nhave: %+v\nwant: %+v", v, want)
+	}
+
+	// The encoder writes the primary name.
+	buf.Reset()
+	err = Marshal(&buf, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tree map[string]interface{}
+	err = Unmarshal(&buf, &tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tree["Status"] != "full" || tree["DataVersion"] != int32(3465) {
+		t.Fatalf("unexpected tags: %v", tree)
+	}
+
+	if _, ok := tree["TerrainPopulated"]; ok {
+		t.Fatalf("alias was encoded: %v", tree)
+	}
+}
+
+func TestByteSlices(t *testing.T) {
+	type T struct {
+		B []byte `nbt:"b"`
+		S []int8 `nbt:"s"`
+	}
+
+	want := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagByteArray), 0, 1, 'b', 0, 0, 0, 3, 1, 2, 0xff,
+		byte(TagList), 0, 1, 's', byte(TagByte), 0, 0, 0, 2, 0xff, 1,
+		byte(TagEnd),
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, T{B: []byte{1, 2, 0xff}, S: []int8{-1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("encoding mismatch:\nhave: % x\nwant: % x", buf.Bytes(), want)
+	}
+
+	// Decoding a byte array into a []byte reads straight into its
+	// backing array.
+	backing := make([]byte, 8)
+	v := T{B: backing[:0]}
+
+	err = Unmarshal(bytes.NewReader(want), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(v.B, []byte{1, 2, 0xff}) || !reflect.DeepEqual(v.S, []int8{-1, 1}) {
+		t.Fatalf("decoding mismatch: %+v", v)
+	}
+
+	if &v.B[0] != &backing[0] {
+		t.Fatal("byte array was not read into the existing slice")
+	}
+
+	// Either tag fills either slice type.
+	var swapped struct {
+		B []int8 `nbt:"b"`
+		S []byte `nbt:"s"`
+	}
+
+	err = Unmarshal(bytes.NewReader(want), &swapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(swapped.B, []int8{1, 2, -1}) || !reflect.DeepEqual(swapped.S, []byte{0xff, 1}) {
+		t.Fatalf("swapped decoding mismatch: %+v", swapped)
+	}
+}
+
+func TestMaps(t *testing.T) {
+	type Pos struct {
+		X int32 `nbt:"x"`
+		Z int32 `nbt:"z"`
+	}
+
+	type T struct {
+		Rules  map[string]string `nbt:"rules"`
+		Scores map[string]int32  `nbt:"scores"`
+		Spawns map[string]*Pos   `nbt:"spawns"`
+	}
+
+	a := T{
+		Rules:  map[string]string{"doDaylightCycle": "true", "randomTickSpeed": "3"},
+		Scores: map[string]int32{"b": 2, "a": -1, "c": 3},
+		Spawns: map[string]*Pos{"home": {1, 2}},
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries are written in sorted order.
+	var v OrderedCompound
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scores, _ := v.Get("scores")
+	want := OrderedCompound{{"a", Int(-1)}, {"b", Int(2)}, {"c", Int(3)}}
+	if !reflect.DeepEqual(scores, want) {
+		t.Fatalf("scores mismatch:\nhave: %#v\nwant: %#v", scores, want)
+	}
+
+	var b T
+	err = Unmarshal(&buf, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("roundtrip mismatch:\nhave: %#v\nwant: %#v", b, a)
+	}
+
+	// Values must fit the map's value type.
+	var c struct {
+		Rules map[string]int32 `nbt:"rules"`
+	}
+
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &c)
+	if err == nil {
+		t.Fatalf("expected error decoding strings into map[string]int32")
+	}
+
+	err = Marshal(&buf, map[int]string{1: "a"})
+	if err == nil {
+		t.Fatalf("expected error encoding map with non-string keys")
+	}
+
+	// Nested maps, and dynamically typed values.
+	nested := map[string]map[string]string{
+		"mod": {"version": "1.2", "author": "x"},
+		"cfg": {},
+	}
+
+	meta := map[string]interface{}{
+		"count": int32(3),
+		"tags":  []interface{}{"a", "b"},
+		"owner": map[string]interface{}{"id": int64(9)},
+	}
+
+	for _, in := range []interface{}{nested, meta} {
+		var first, second bytes.Buffer
+
+		if err = Marshal(&first, in); err != nil {
+			t.Fatal(err)
+		}
+
+		// Sorted keys make the output deterministic.
+		if err = Marshal(&second, in); err != nil || !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("%T: output differs between runs: %v", in, err)
+		}
+
+		out := reflect.New(reflect.TypeOf(in))
+		if err = Unmarshal(&first, out.Interface()); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(out.Elem().Interface(), in) {
+			t.Fatalf("roundtrip mismatch:\nhave: %#v\nwant: %#v", out.Elem().Interface(), in)
+		}
+	}
+}
+
+func TestListHandler(t *testing.T) {
+	type Entity struct {
+		Id  string `nbt:"id"`
+		Age int32  `nbt:"age"`
+	}
+
+	type In struct {
+		Name     string   `nbt:"name"`
+		Entities []Entity `nbt:"entities"`
+		Tail     int32    `nbt:"tail"`
+	}
+
+	in := In{
+		Name: "chunk",
+		Entities: []Entity{
+			{"minecraft:cow", 1},
+			{"minecraft:pig", 2},
+			{"minecraft:sheep", 3},
+		},
+		Tail: 42,
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Out struct {
+		Name     string      `nbt:"name"`
+		Entities ListHandler `nbt:"entities"`
+		Tail     int32       `nbt:"tail"`
+	}
+
+	// Decode every other element, reusing the same value.
+	var have []Entity
+	var e Entity
+
+	out := Out{Entities: func(i int, decode func(interface{}) error) error {
+		if i%2 == 1 {
+			return nil
+		}
+
+		err := decode(&e)
+		if err != nil {
+			return err
+		}
+
+		if decode(&e) == nil {
+			t.Fatalf("element %d decoded twice", i)
+		}
+
+		have = append(have, e)
+		return nil
+	}}
+
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Entity{in.Entities[0], in.Entities[2]}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("entity mismatch:\nhave: %v\nwant: %v", have, want)
+	}
+
+	if out.Name != in.Name || out.Tail != in.Tail {
+		t.Fatalf("field mismatch: have %q %d, want %q %d", out.Name, out.Tail, in.Name, in.Tail)
+	}
+
+	// Handler errors abort decoding.
+	stop := errors.New("stop")
+	out = Out{Entities: func(int, func(interface{}) error) error { return stop }}
+
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &out)
+	if err == nil || !strings.Contains(err.Error(), "stop") {
+		t.Fatalf("expected handler error, have %v", err)
+	}
+
+	// The handler is not encoded.
+	buf.Reset()
+	err = Marshal(&buf, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveTagFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nbt-save")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "level.dat")
+	want := Compound{
+		"Data": Compound{
+			"LevelName": String("world"),
+			"Time":      Long(1234),
+		},
+	}
+
+	err = SaveTagFile(file, "root", want, GZip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var have KeyValue
+	load(t, data, &have)
+
+	if have.Name != "root" || !reflect.DeepEqual(have.Value, Tag(want)) {
+		t.Fatalf("decode mismatch:\nhave: %q %#v\nwant: %q %#v", have.Name, have.Value, "root", want)
+	}
+
+	if SaveTagFile(file, "root", nil, GZip) == nil {
+		t.Fatalf("expected error saving nil tag")
+	}
+
+	if SaveTagFile(file, "root", want, Compression(9)) == nil {
+		t.Fatalf("expected error for unknown compression")
+	}
+}
+
+type testBlockEntity interface {
+	EntityId() string
+}
+
+type testChest struct {
+	Id    string   `nbt:"id"`
+	Items []string `nbt:"Items"`
+}
+
+func (c *testChest) EntityId() string { return c.Id }
+
+type testSign struct {
+	Text string `nbt:"Text"`
+	Id   string `nbt:"id"`
+}
+
+func (s testSign) EntityId() string { return s.Id }
+
+func TestRegisterType(t *testing.T) {
+	RegisterType("test:chest", reflect.TypeOf(testChest{}))
+	RegisterType("test:sign", reflect.TypeOf(testSign{}))
+
+	type T struct {
+		Entities []testBlockEntity `nbt:"block_entities"`
+		Single   testBlockEntity   `nbt:"single"`
+		Any      interface{}       `nbt:"any"`
+	}
+
+	a := T{
+		Entities: []testBlockEntity{
+			&testChest{Id: "test:chest", Items: []string{"a", "b"}},
+			testSign{Id: "test:sign", Text: "hello"},
+		},
+		Single: testSign{Id: "test:sign", Text: "single"},
+		Any:    &testChest{Id: "test:chest"},
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b T
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A value is used, rather than a pointer, where it fits.
+	a.Any = testChest{Id: "test:chest"}
+
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("roundtrip mismatch:\nhave: %#v\nwant: %#v", b, a)
+	}
+
+	// Unknown ids can only be decoded into interfaces which hold a map or
+	// a tag.
+	data := Compound{
+		"any":    Compound{"id": String("test:unknown")},
+		"single": Compound{"id": String("test:unknown")},
+	}
+
+	buf.Reset()
+	err = Marshal(&buf, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c struct {
+		Any interface{} `nbt:"any"`
+	}
+
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Any.(map[string]interface{}); !ok {
+		t.Fatalf("unexpected value for unknown id: %#v", c.Any)
+	}
+
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.SetOrdered(true)
+
+	err = dec.Decode(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Any.(OrderedCompound); !ok {
+		t.Fatalf("unexpected ordered value for unknown id: %#v", c.Any)
+	}
+
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &b)
+	if err == nil || !strings.Contains(err.Error(), "test:unknown") {
+		t.Fatalf("expected error for unknown id, have %v", err)
+	}
+}
+
+// testVersion has a two byte binary form.
+type testVersion struct {
+	Major, Minor uint8
+}
+
+func (v testVersion) MarshalBinary() ([]byte, error) {
+	return []byte{v.Major, v.Minor}, nil
+}
+
+func (v *testVersion) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return errors.New("invalid version")
+	}
+
+	v.Major, v.Minor = data[0], data[1]
+	return nil
+}
+
+func TestBinaryMarshaler(t *testing.T) {
+	type T struct {
+		Version  testVersion            `nbt:"version"`
+		Pointer  *testVersion           `nbt:"pointer"`
+		Versions []testVersion          `nbt:"versions"`
+		ByName   map[string]testVersion `nbt:"by_name"`
+		Time     time.Time              `nbt:"time"`
+	}
+
+	a := T{
+		Version:  testVersion{1, 2},
+		Pointer:  &testVersion{3, 4},
+		Versions: []testVersion{{5, 6}, {7, 8}},
+		ByName:   map[string]testVersion{"a": {9, 10}},
+		Time:     time.Unix(1234567890, 0),
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b T
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("roundtrip mismatch:\nhave: %#v\nwant: %#v", b, a)
+	}
+
+	var c Compound
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Compound{
+		"version":  ByteArray{1, 2},
+		"pointer":  ByteArray{3, 4},
+		"versions": List{Elem: TagByteArray, Items: []Tag{ByteArray{5, 6}, ByteArray{7, 8}}},
+		"by_name":  Compound{"a": ByteArray{9, 10}},
+		"time":     Long(1234567890),
+	}
+
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("tag mismatch:\nhave: %#v\nwant: %#v", c, want)
+	}
+
+	// Errors from UnmarshalBinary are passed on.
+	buf.Reset()
+	err = Marshal(&buf, Compound{"version": ByteArray{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &b)
+	if err == nil || !strings.Contains(err.Error(), "invalid version") {
+		t.Fatalf("expected error for invalid version, have %v", err)
+	}
+}
+
+// testStamp encodes a time like the encoder does for time.Time fields.
+type testStamp struct {
+	time.Time
+}
+
+func (s testStamp) MarshalNBT() ([]byte, TagId, error) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(s.Unix()))
+	return data[:], TagLong, nil
+}
+
+func (s *testStamp) UnmarshalNBT(id TagId, r io.Reader) error {
+	if id != TagLong {
+		return fmt.Errorf("can not read time from %s", id)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.Time = time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	return nil
+}
+
+func TestMarshaler(t *testing.T) {
+	type Std struct {
+		Time  time.Time   `nbt:"time"`
+		Times []time.Time `nbt:"times"`
+	}
+
+	type Custom struct {
+		Time  testStamp            `nbt:"time"`
+		Times []testStamp          `nbt:"times"`
+		Ptr   *testStamp           `nbt:"ptr,omitempty"`
+		Map   map[string]testStamp `nbt:"map,omitempty"`
+	}
+
+	t1, t2 := time.Unix(1234567890, 0), time.Unix(42, 0)
+
+	var want bytes.Buffer
+	err := Marshal(&want, Std{t1, []time.Time{t1, t2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var have bytes.Buffer
+	err = Marshal(&have, Custom{Time: testStamp{t1}, Times: []testStamp{{t1}, {t2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(want.Bytes(), have.Bytes()) {
+		t.Fatalf("custom encoding differs from time.Time")
+	}
+
+	a := Custom{
+		Time:  testStamp{t2},
+		Times: []testStamp{{t1}},
+		Ptr:   &testStamp{t1},
+		Map:   map[string]testStamp{"a": {t2}},
+	}
+
+	var buf bytes.Buffer
+	err = Marshal(&buf, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b Custom
+	err = Unmarshal(&buf, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("roundtrip mismatch:\nhave: %#v\nwant: %#v", b, a)
+	}
+
+	// Errors from UnmarshalNBT are passed on.
+	buf.Reset()
+	err = Marshal(&buf, Compound{"time": String("now")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Unmarshal(&buf, &b)
+	if err == nil || !strings.Contains(err.Error(), "can not read time") {
+		t.Fatalf("expected an UnmarshalNBT error, have %v", err)
+	}
+}
+
+func TestRawMessage(t *testing.T) {
+	type section struct {
+		Y      int8    `nbt:"Y"`
+		States []int64 `nbt:"BlockStates"`
+	}
+
+	type chunk struct {
+		DataVersion int32     `nbt:"DataVersion"`
+		Sections    []section `nbt:"sections"`
+		Status      string    `nbt:"Status"`
+	}
+
+	in := chunk{
+		DataVersion: 2975,
+		Sections:    []section{{-4, []int64{1, 2}}, {0, []int64{3}}},
+		Status:      "minecraft:full",
+	}
+
+	var want bytes.Buffer
+	err := Marshal(&want, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type lazy struct {
+		DataVersion int32      `nbt:"DataVersion"`
+		Sections    RawMessage `nbt:"sections"`
+		Status      RawMessage `nbt:"Status"`
+	}
+
+	backing := make(RawMessage, 0, 256)
+	v := lazy{Sections: backing}
+
+	err = Unmarshal(bytes.NewReader(want.Bytes()), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Sections.TagId() != TagList || v.Status.TagId() != TagString {
+		t.Fatalf("unexpected tag types %v %v", v.Sections.TagId(), v.Status.TagId())
+	}
+
+	if &v.Sections[0] != &backing[:1][0] {
+		t.Fatal("raw message was not read into the existing slice")
+	}
+
+	// The message decodes on its own, once its type is known.
+	var sections []section
+	err = Unmarshal(bytes.NewReader(v.Sections), &sections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(sections, in.Sections) {
+		t.Fatalf("sections mismatch: %+v", sections)
+	}
+
+	// It is written back verbatim.
+	var have bytes.Buffer
+	err = Marshal(&have, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(have.Bytes(), want.Bytes()) {
+		t.Fatalf("encoding mismatch:\nhave: % x\nwant: % x", have.Bytes(), want.Bytes())
+	}
+
+	// Lists of messages take the type of their elements.
+	var list struct {
+		Sections []RawMessage `nbt:"sections"`
+	}
+
+	err = Unmarshal(bytes.NewReader(want.Bytes()), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Sections) != 2 {
+		t.Fatalf("expected 2 sections, have %d", len(list.Sections))
+	}
+
+	var s section
+	err = Unmarshal(bytes.NewReader(list.Sections[1]), &s)
+	if err != nil || !reflect.DeepEqual(s, in.Sections[1]) {
+		t.Fatalf("section mismatch: %+v %v", s, err)
+	}
+
+	have.Reset()
+	err = Marshal(&have, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var back chunk
+	err = Unmarshal(bytes.NewReader(have.Bytes()), &back)
+	if err != nil || !reflect.DeepEqual(back.Sections, in.Sections) {
+		t.Fatalf("list roundtrip mismatch: %+v %v", back, err)
+	}
+
+	// Named tags, as written by Encoder.EncodeNamed, are accepted too.
+	var named bytes.Buffer
+	err = NewEncoder(&named).EncodeNamed("root", in.Sections[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have.Reset()
+	err = Marshal(&have, struct {
+		S RawMessage `nbt:"s"`
+	}{named.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wrapped struct {
+		S section `nbt:"s"`
+	}
+
+	err = Unmarshal(bytes.NewReader(have.Bytes()), &wrapped)
+	if err != nil || !reflect.DeepEqual(wrapped.S, in.Sections[0]) {
+		t.Fatalf("named message mismatch: %+v %v", wrapped, err)
+	}
+
+	for _, m := range []RawMessage{nil, {byte(TagInt), 0, 5, 'a'}} {
+		err = Marshal(ioutil.Discard, struct{ M RawMessage }{m})
+		if err == nil {
+			t.Fatalf("expected error for message % x", []byte(m))
+		}
+	}
+}
+
+func TestSkipField(t *testing.T) {
+	type T struct {
+		Name  string `nbt:"name"`
+		Cache string `nbt:"-"`
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, T{Name: "a", Cache: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c Compound
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Compound{"name": String("a")}); !reflect.DeepEqual(c, want) {
+		t.Fatalf("have %#v, want %#v", c, want)
+	}
+
+	// A tag named like the field is not decoded into it either.
+	buf.Reset()
+	err = Marshal(&buf, Compound{"name": String("a"), "Cache": String("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v T
+	err = Unmarshal(bytes.NewReader(buf.Bytes()), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "a" || v.Cache != "" {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+}
+
+// benchmarkChunk builds a chunk with paletted sections, which are dominated
+// by repeated strings: block names, property names and values, along with
+// the tag names themselves.
+func benchmarkChunk() Compound {
+	blocks := []string{"stone", "dirt", "grass_block", "oak_log", "oak_leaves", "water", "granite", "andesite"}
+	sections := List{Elem: TagCompound}
+
+	for y := -4; y < 20; y++ {
+		palette := List{Elem: TagCompound}
+
+		for i, name := range blocks {
+			state := Compound{"Name": String("minecraft:" + name)}
+			if i%2 == 0 {
+				state["Properties"] = Compound{
+					"axis":        String("y"),
+					"waterlogged": String("false"),
+				}
+			}
+
+			palette.Items = append(palette.Items, state)
+		}
+
+		sections.Items = append(sections.Items, Compound{
+			"Y":            Byte(y),
+			"block_states": Compound{"palette": palette, "data": LongArray(make([]int64, 256))},
+			"biomes":       Compound{"palette": List{Elem: TagString, Items: []Tag{String("minecraft:plains"), String("minecraft:forest")}}},
+		})
+	}
+
+	return Compound{
+		"DataVersion": Int(3465),
+		"Status":      String("minecraft:full"),
+		"sections":    sections,
+	}
+}
+
+// BenchmarkDecodeStrings decodes a region's worth of chunks, each through a
+// new decoder, like anvil.Region does.
+func BenchmarkDecodeStrings(b *testing.B) {
+	var buf bytes.Buffer
+	err := Marshal(&buf, benchmarkChunk())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	type state struct {
+		Name       string            `nbt:"Name"`
+		Properties map[string]string `nbt:"Properties,omitempty"`
+	}
+
+	type chunk struct {
+		Status   string `nbt:"Status"`
+		Sections []struct {
+			Y           int8 `nbt:"Y"`
+			BlockStates struct {
+				Palette []state `nbt:"palette"`
+				Data    []int64 `nbt:"data"`
+			} `nbt:"block_states"`
+			Biomes struct {
+				Palette []string `nbt:"palette"`
+			} `nbt:"biomes"`
+		} `nbt:"sections"`
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)) * 1024)
+
+	var c chunk
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1024; j++ {
+			err = Unmarshal(bytes.NewReader(data), &c)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestOmitEmpty(t *testing.T) {
+	type Inner struct {
+		A int32
+	}
+
+	type T struct {
+		Name    string            `nbt:"name,omitempty"`
+		Items   []string          `nbt:"items,omitempty"`
+		Inner   *Inner            `nbt:"inner,omitempty"`
+		Flag    bool              `nbt:"flag,omitempty"`
+		Count   int32             `nbt:"count,omitempty"`
+		Props   map[string]string `nbt:"props,omitempty"`
+		Any     interface{}       `nbt:"any,omitempty"`
+		Kept    string            `nbt:"kept"`
+		Ignored string            `nbt:"-"`
+		Dash    int8              `nbt:"-,"`
+	}
+
+	tests := []struct {
+		in   T
+		tags []string
+	}{
+		{T{}, []string{"kept", "-"}},
+		{T{Ignored: "x"}, []string{"kept", "-"}},
+		{T{Name: "a", Flag: true, Count: 2}, []string{"name", "flag", "count", "kept", "-"}},
+		{T{Items: []string{"a"}, Inner: &Inner{}, Any: int32(0)}, []string{"items", "inner", "any", "kept", "-"}},
+		{T{Items: []string{}, Props: map[string]string{}}, []string{"kept", "-"}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := Marshal(&buf, tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var kv KeyValue
+		err = NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&kv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tags []string
+		for name := range kv.Value.(Compound) {
+			tags = append(tags, name)
+		}
+
+		sort.Strings(tags)
+		sort.Strings(tt.tags)
+
+		if !reflect.DeepEqual(tags, tt.tags) {
+			t.Fatalf("%#v: expected tags %q, have %q", tt.in, tt.tags, tags)
+		}
+
+		// Omitted fields are left untouched by the decoder.
+		v := T{Name: "b", Ignored: "y"}
+		err = Unmarshal(&buf, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := tt.in
+		want.Ignored = "y"
+		if len(want.Name) == 0 {
+			want.Name = "b"
+		}
+
+		if len(want.Items) == 0 {
+			want.Items = nil
+		}
+
+		if want.Props != nil && len(want.Props) == 0 {
+			want.Props = nil
+		}
+
+		if !reflect.DeepEqual(v, want) {
+			t.Fatalf("decode mismatch:\nhave: %#v\nwant: %#v", v, want)
+		}
+	}
+}
+
+func TestCanonicalizeNaN(t *testing.T) {
+	type T struct {
+		F float32 `nbt:"f"`
+		D float64 `nbt:"d"`
+		L []Tag   `nbt:"l"`
+	}
+
+	const (
+		nan32 = 0xffc00001
+		nan64 = 0x7ff0000000000042
+	)
+
+	v := T{
+		F: math.Float32frombits(nan32),
+		D: math.Float64frombits(nan64),
+		L: []Tag{Double(math.Float64frombits(nan64))},
+	}
+
+	for _, canonical := range []bool{false, true} {
+		var buf bytes.Buffer
+
+		enc := NewEncoder(&buf)
+		enc.CanonicalizeNaN(canonical)
+
+		err := enc.Encode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var have T
+		err = Unmarshal(&buf, &have)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want32, want64 := uint32(nan32), uint64(nan64)
+		if canonical {
+			want32, want64 = canonicalNaN32, canonicalNaN64
+		}
+
+		if bits := math.Float32bits(have.F); bits != want32 {
+			t.Fatalf("canonical %v: float bits %#x, want %#x", canonical, bits, want32)
+		}
+
+		if bits := math.Float64bits(have.D); bits != want64 {
+			t.Fatalf("canonical %v: double bits %#x, want %#x", canonical, bits, want64)
+		}
+
+		if bits := math.Float64bits(float64(have.L[0].(Double))); bits != want64 {
+			t.Fatalf("canonical %v: list bits %#x, want %#x", canonical, bits, want64)
+		}
+	}
+}
+
+func TestRequiredFields(t *testing.T) {
+	type Base struct {
+		Id string `nbt:"id,required"`
+	}
+
+	type T struct {
+		Base
+		Version int32  `nbt:"DataVersion,required"`
+		Name    string `nbt:"name"`
+	}
+
+	tests := []struct {
+		in      Compound
+		missing string
+	}{
+		{Compound{"id": String("a"), "DataVersion": Int(3465)}, ""},
+		{Compound{"id": String("a"), "DataVersion": Int(0), "name": String("b")}, ""},
+		{Compound{"id": String("a"), "name": String("b")}, "DataVersion"},
+		{Compound{"DataVersion": Int(1)}, "id"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := Marshal(&buf, tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var v T
+		err = Unmarshal(&buf, &v)
+
+		switch {
+		case len(tt.missing) == 0 && err != nil:
+			t.Fatalf("%v: %v", tt.in, err)
+		case len(tt.missing) > 0 && (err == nil || !strings.Contains(err.Error(), strconv.Quote(tt.missing))):
+			t.Fatalf("%v: expected an error naming %q, have %v", tt.in, tt.missing, err)
+		}
+	}
+
+	// Nested compounds are checked too.
+	var buf bytes.Buffer
+	err := Marshal(&buf, Compound{"inner": Compound{"id": String("a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v struct {
+		Inner T `nbt:"inner"`
+	}
+
+	err = Unmarshal(&buf, &v)
+	if err == nil || !strings.Contains(err.Error(), `"DataVersion"`) {
+		t.Fatalf("expected an error naming DataVersion, have %v", err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	root := KeyValue{"root", OrderedCompound{
+		{"id", String("a")},
+		{"items", List{TagCompound, []Tag{
+			OrderedCompound{{"n", Byte(1)}},
+			OrderedCompound{{"n", Byte(2)}},
+		}}},
+		{"pos", List{TagInt, []Tag{Int(3), Int(4)}}},
+		{"skip", OrderedCompound{
+			{"deep", List{TagList, []Tag{List{TagLong, []Tag{Long(5)}}}}},
+		}},
+		{"end", Short(6)},
+	}}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	type token struct {
+		tok   Token
+		depth int
+	}
+
+	want := []token{
+		{TagStart{Type: TagCompound, Name: "root"}, 1},
+		{KeyValue{"id", String("a")}, 1},
+		{TagStart{Type: TagList, Name: "items", Elem: TagCompound, Len: 2}, 2},
+		{TagStart{Type: TagCompound}, 3},
+		{KeyValue{"n", Byte(1)}, 3},
+		{TagEnd, 2},
+		{TagStart{Type: TagCompound}, 3},
+		{KeyValue{"n", Byte(2)}, 3},
+		{TagEnd, 2},
+		{TagEnd, 1},
+		{TagStart{Type: TagList, Name: "pos", Elem: TagInt, Len: 2}, 2},
+		{KeyValue{"", Int(3)}, 2},
+		{KeyValue{"", Int(4)}, 2},
+		{TagEnd, 1},
+		{TagStart{Type: TagCompound, Name: "skip"}, 2},
+		{TagStart{Type: TagList, Name: "deep", Elem: TagList, Len: 1}, 3},
+		{TagStart{Type: TagList, Elem: TagLong, Len: 1}, 4},
+		{KeyValue{"", Long(5)}, 4},
+		{TagEnd, 3},
+		{TagEnd, 2},
+		{TagEnd, 1},
+		{KeyValue{"end", Short(6)}, 1},
+		{TagEnd, 0},
+	}
+
+	dec := NewDecoder(bytes.NewReader(data))
+	for i, w := range want {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("token %d: %v", i, err)
+		}
+
+		if !reflect.DeepEqual(tok, w.tok) || dec.Depth() != w.depth {
+			t.Fatalf("token %d: expected %#v at depth %d, have %#v at depth %d",
+				i, w.tok, w.depth, tok, dec.Depth())
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := dec.Token(); err != io.EOF {
+			t.Fatalf("expected io.EOF, have %v", err)
+		}
+	}
+
+	// Skip the remainder of "items" and the whole of "skip".
+	dec = NewDecoder(bytes.NewReader(data))
+	var names []string
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch tok := tok.(type) {
+		case TagStart:
+			names = append(names, tok.Name)
+
+			if tok.Name == "skip" {
+				err = dec.Skip()
+			}
+		case KeyValue:
+			names = append(names, tok.Name)
+
+			if tok.Name == "n" {
+				err = dec.Skip() // Ends the element.
+				if err == nil {
+					err = dec.Skip() // Ends "items".
+				}
+			}
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := []string{"root", "id", "items", "", "n", "pos", "", "", "skip", "end"}
+	if !reflect.DeepEqual(names, expect) {
+		t.Fatalf("expected %q, have %q", expect, names)
+	}
+
+	// Truncated input is reported as such.
+	dec = NewDecoder(bytes.NewReader(data[:len(data)-4]))
+	for err == nil {
+		_, err = dec.Token()
+	}
+
+	if err == io.EOF || !strings.Contains(err.Error(), io.ErrUnexpectedEOF.Error()) {
+		t.Fatalf("expected an unexpected EOF, have %v", err)
+	}
+}
+
+func TestDecodeGeneric(t *testing.T) {
+	// Entries are in sorted order, which a map yields when encoded.
+	in := Compound{
+		"byte":   Byte(-1),
+		"bytes":  ByteArray{1, 2},
+		"double": Double(0.5),
+		"float":  Float(1.5),
+		"int":    Int(3),
+		"ints":   IntArray{4, 5},
+		"list":   List{TagShort, []Tag{Short(6), Short(7)}},
+		"long":   Long(8),
+		"longs":  LongArray{9},
+		"nested": Compound{"items": List{TagCompound, []Tag{Compound{"s": String("x")}}}},
+		"short":  Short(10),
+		"string": String("y"),
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(&buf, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	var v interface{}
+	err = Unmarshal(bytes.NewReader(data), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"byte":   int8(-1),
+		"bytes":  []byte{1, 2},
+		"double": 0.5,
+		"float":  float32(1.5),
+		"int":    int32(3),
+		"ints":   []int32{4, 5},
+		"list":   []interface{}{int16(6), int16(7)},
+		"long":   int64(8),
+		"longs":  []int64{9},
+		"nested": map[string]interface{}{
+			"items": []interface{}{map[string]interface{}{"s": "x"}},
+		},
+		"short":  int16(10),
+		"string": "y",
+	}
+
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("generic mismatch:\nhave: %#v\nwant: %#v", v, want)
+	}
+
+	var m map[string]interface{}
+	err = Unmarshal(bytes.NewReader(data), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("map mismatch:\nhave: %#v\nwant: %#v", m, want)
+	}
+
+	buf.Reset()
+	err = Marshal(&buf, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("encoded map differs from input")
+	}
+
+	// Ordered trees retain the order of entries and the type of empty
+	// lists as well.
+	ordered := OrderedCompound{
+		{"z", Byte(1)},
+		{"empty", List{TagInt, nil}},
+		{"a", OrderedCompound{{"y", String("y")}, {"b", Short(2)}}},
+	}
+
+	buf.Reset()
+	err = Marshal(&buf, KeyValue{"", ordered})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = buf.Bytes()
+
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.SetOrdered(true)
+
+	v = nil
+	err = dec.Decode(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	err = Marshal(&buf, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("encoded ordered tree differs from input")
+	}
+
+	// Lists of interface values must hold a single type of tag.
+	err = Marshal(&buf, map[string]interface{}{"l": []interface{}{int32(1), "a"}})
+	if err == nil {
+		t.Fatalf("expected an error for mixed list elements")
+	}
+}
+
+func TestXXH32(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint32
+	}{
+		{"", 0x02cc5d05},
+		{"a", 0x550d7456},
+		{"abc", 0x32d153ff},
+		{"Nobody inspects the spammish repetition", 0xe2293b2f},
+	}
+
+	for _, tt := range tests {
+		if have := xxh32([]byte(tt.in), 0); have != tt.want {
+			t.Fatalf("%q: expected %08x, have %08x", tt.in, tt.want, have)
+		}
+	}
+}
+
+func TestLZ4(t *testing.T) {
+	random := make([]byte, 100000)
+	for i := range random {
+		random[i] = byte(i * i * 7 % 251)
+	}
+
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("abcabcabcabcabcabc"),
+		bytes.Repeat([]byte("minecraft:stone"), 20000),
+		random,
+	}
+
+	for _, in := range inputs {
+		var buf bytes.Buffer
+		w, err := NewCompressedWriter(&buf, LZ4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = w.Write(in)
+		if err == nil {
+			err = w.Close()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()
+
+		r, err := NewCompressedReader(bytes.NewReader(data), LZ4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		have, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", len(in), err)
+		}
+
+		if !bytes.Equal(have, in) {
+			t.Fatalf("%d bytes: roundtrip mismatch", len(in))
+		}
+
+		// Damaged data fails the checksum.
+		if len(in) > 0 {
+			data[lz4HeaderSize+len(data[lz4HeaderSize:])/2] ^= 1
+
+			_, err = ioutil.ReadAll(newLZ4Reader(bytes.NewReader(data)))
+			if err == nil {
+				t.Fatalf("%d bytes: expected an error for damaged data", len(in))
+			}
+		}
+	}
+
+	// A hand made block: three literals, a match of ten and the final
+	// five literals.
+	want := []byte("abcabcabcabcabcabc")
+	block := []byte{0x36, 'a', 'b', 'c', 3, 0, 0x50, 'b', 'c', 'a', 'b', 'c'}
+
+	var buf bytes.Buffer
+	lw := newLZ4Writer(&buf)
+	lw.writeBlock(lz4MethodLZ4, block, len(want), xxh32(want, lz4Seed)&lz4ChecksumMask)
+	lw.writeBlock(lz4MethodRaw, nil, 0, 0)
+
+	have, err := ioutil.ReadAll(newLZ4Reader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(have, want) {
+		t.Fatalf("expected %q, have %q", want, have)
+	}
+
+	// Block sizes beyond what the block can hold are rejected up front.
+	for _, tc := range []struct {
+		method             byte
+		compressed, length uint32
+	}{
+		{lz4MethodLZ4, 0x7fffffff, 10},
+		{lz4MethodLZ4, lz4BlockSize + lz4BlockSize/255 + 17, lz4BlockSize},
+		{lz4MethodRaw, lz4BlockSize + 1, lz4BlockSize + 1},
+	} {
+		hdr := append([]byte(lz4Magic), tc.method|(lz4BlockLog-10))
+		hdr = binary.LittleEndian.AppendUint32(hdr, tc.compressed)
+		hdr = binary.LittleEndian.AppendUint32(hdr, tc.length)
+		hdr = binary.LittleEndian.AppendUint32(hdr, 0)
+
+		_, err = ioutil.ReadAll(newLZ4Reader(bytes.NewReader(hdr)))
+		if err != errLZ4Corrupt {
+			t.Errorf("method %#x, %d bytes: have %v, want %v", tc.method, tc.compressed, err, errLZ4Corrupt)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// DiscriminatorName defines the name of the tag which selects the type a
+// compound is decoded into, when decoded into an interface value.
+// See RegisterType.
+const DiscriminatorName = "id"
+
+// registry maps discriminator values to the types registered for them.
+var registry struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// RegisterType registers the type to decode compounds with the given id
+// into. This applies to compounds decoded into an interface value, other
+// than Tag. The compound's "id" tag selects the type, which must implement
+// the interface, either as a value or as a pointer:
+//
+//	type BlockEntity interface { ... }
+//
+//	type Chest struct {
+//		Id    string `nbt:"id"`
+//		Items []Item `nbt:"Items"`
+//	}
+//
+//	nbt.RegisterType("minecraft:chest", reflect.TypeOf(Chest{}))
+//
+//	type Chunk struct {
+//		BlockEntities []BlockEntity `nbt:"block_entities"`
+//	}
+//
+// If the type only implements the interface as a pointer, the interface
+// receives a pointer to the new value. Registering an id again replaces
+// the previous type. It is safe to call this from multiple goroutines.
+func RegisterType(id string, rt reflect.Type) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registry.types == nil {
+		registry.types = make(map[string]reflect.Type)
+	}
+
+	registry.types[id] = rt
+}
+
+// registeredType returns the type registered for the given id.
+func registeredType(id string) (reflect.Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	rt, ok := registry.types[id]
+	return rt, ok
+}
+
+// decodeRegistered decodes a compound into the interface value rv. The
+// compound is first read as a whole, so its discriminator can be found
+// regardless of where it appears. It is then decoded into a new value of
+// the registered type.
+//
+// If no type is registered for the compound, an empty interface receives
+// it as a map[string]interface{}, or as an OrderedCompound if the decoder
+// keeps compounds in order. Other interfaces receive the OrderedCompound,
+// provided they can hold it.
+func (d *Decoder) decodeRegistered(name string, rv reflect.Value) error {
+	t, err := d.readOrderedCompound()
+	if err != nil {
+		return err
+	}
+
+	oc := t.(OrderedCompound)
+
+	var id string
+	if v, ok := oc.Get(DiscriminatorName); ok {
+		if s, ok := v.(String); ok {
+			id = string(s)
+		}
+	}
+
+	rt, ok := registeredType(id)
+	if !ok {
+		// An empty interface receives a map, unless compounds are to be
+		// kept in order.
+		if rv.Type() == anyType && !d.ordered {
+			m := make(map[string]interface{})
+
+			err = d.redecode(oc, name, reflect.ValueOf(m))
+			if err != nil {
+				return err
+			}
+
+			rv.Set(reflect.ValueOf(m))
+			return nil
+		}
+
+		if reflect.TypeOf(oc).AssignableTo(rv.Type()) {
+			rv.Set(reflect.ValueOf(oc))
+			return nil
+		}
+
+		return fmt.Errorf("%s(%q): no type registered for id %q", TagCompound, name, id)
+	}
+
+	pv := reflect.New(rt)
+
+	var nv reflect.Value
+	switch {
+	case rt.AssignableTo(rv.Type()):
+		nv = pv.Elem()
+	case pv.Type().AssignableTo(rv.Type()):
+		nv = pv
+	default:
+		return fmt.Errorf("%s(%q): type %v registered for id %q does not implement %v",
+			TagCompound, name, rt, id, rv.Type())
+	}
+
+	err = d.redecode(oc, name, pv)
+	if err != nil {
+		return err
+	}
+
+	rv.Set(nv)
+	return nil
+}
+
+// redecode decodes a compound which has already been read into rv, by
+// running its data through a decoder with the same settings.
+func (d *Decoder) redecode(oc OrderedCompound, name string, rv reflect.Value) error {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	enc.SetByteOrder(d.order)
+
+	err := enc.encodeOrdered(oc, "", true)
+	if err != nil {
+		return err
+	}
+
+	sub := *d
+	sub.r = &buf
+	sub.frames = nil
+	sub.depth-- // The compound has been entered already.
+
+	return sub.decode(TagCompound, name, rv)
+}
@@ -0,0 +1,788 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError describes malformed SNBT input.
+type SyntaxError struct {
+	Offset int    // Byte offset in the input at which the error occurred.
+	Msg    string // Description of the error.
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("nbt: snbt: offset %d: %s", e.Offset, e.Msg)
+}
+
+// Fprint writes v to w as SNBT, the stringified NBT used in Minecraft
+// commands, like {Level:{xPos:0,zPos:-1}}. The output is written on a
+// single line, without any whitespace.
+//
+// The value v is either a Tag, or any value accepted by Encoder.Encode,
+// which is encoded first. A KeyValue prints its value; SNBT has no root
+// names. Compound entries are written in order for an OrderedCompound and
+// for structs, and sorted by name otherwise.
+//
+// Numbers carry the type suffix of their tag: 1b, 2s, 3, 4L, 1.5f and 1.5d.
+// Arrays are written as [B;1b,2b], [I;1,2] and [L;1L,2L]. Strings are
+// quoted, as are names which contain anything other than letters, digits
+// and the characters "_-.+". Non-finite floating point values are written
+// as NaNd, Infinityd and -Infinityd, which Minecraft can not read back.
+func Fprint(w io.Writer, v interface{}) error {
+	t, ok := v.(Tag)
+
+	if !ok {
+		if kv, isKV := v.(KeyValue); isKV {
+			t, ok = kv.Value, kv.Value != nil
+		}
+	}
+
+	if !ok {
+		var buf bytes.Buffer
+
+		err := NewEncoder(&buf).Encode(v)
+		if err != nil {
+			return err
+		}
+
+		dec := NewDecoder(&buf)
+		dec.SetOrdered(true)
+
+		err = dec.Decode(&t)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(appendSNBT(nil, t))
+	return err
+}
+
+// appendSNBT appends the SNBT form of t to b.
+func appendSNBT(b []byte, t Tag) []byte {
+	switch t := t.(type) {
+	case Byte:
+		return append(strconv.AppendInt(b, int64(t), 10), 'b')
+	case Short:
+		return append(strconv.AppendInt(b, int64(t), 10), 's')
+	case Int:
+		return strconv.AppendInt(b, int64(t), 10)
+	case Long:
+		return append(strconv.AppendInt(b, int64(t), 10), 'L')
+	case Float:
+		return append(appendFloat(b, float64(t), 32), 'f')
+	case Double:
+		return append(appendFloat(b, float64(t), 64), 'd')
+	case String:
+		return appendQuoted(b, string(t))
+
+	case ByteArray:
+		b = append(b, "[B;"...)
+		for i, v := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(strconv.AppendInt(b, int64(int8(v)), 10), 'b')
+		}
+		return append(b, ']')
+
+	case IntArray:
+		b = append(b, "[I;"...)
+		for i, v := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendInt(b, int64(v), 10)
+		}
+		return append(b, ']')
+
+	case LongArray:
+		b = append(b, "[L;"...)
+		for i, v := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(strconv.AppendInt(b, v, 10), 'L')
+		}
+		return append(b, ']')
+
+	case List:
+		b = append(b, '[')
+		for i, v := range t.Items {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendSNBT(b, v)
+		}
+		return append(b, ']')
+
+	case Compound:
+		names := make([]string, 0, len(t))
+		for name := range t {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		b = append(b, '{')
+		for i, name := range names {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendEntry(b, name, t[name])
+		}
+		return append(b, '}')
+
+	case OrderedCompound:
+		b = append(b, '{')
+		for i, kv := range t {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendEntry(b, kv.Name, kv.Value)
+		}
+		return append(b, '}')
+	}
+
+	return b
+}
+
+// appendEntry appends a single compound entry to b.
+func appendEntry(b []byte, name string, t Tag) []byte {
+	if isPlainName(name) {
+		b = append(b, name...)
+	} else {
+		b = appendQuoted(b, name)
+	}
+
+	return appendSNBT(append(b, ':'), t)
+}
+
+// appendFloat appends the shortest representation of v, which reads back
+// as the same value of the given size.
+func appendFloat(b []byte, v float64, bits int) []byte {
+	switch {
+	case math.IsNaN(v):
+		return append(b, "NaN"...)
+	case math.IsInf(v, 1):
+		return append(b, "Infinity"...)
+	case math.IsInf(v, -1):
+		return append(b, "-Infinity"...)
+	}
+
+	return strconv.AppendFloat(b, v, 'g', -1, bits)
+}
+
+// appendQuoted appends s as a quoted string. It uses double quotes,
+// unless s contains a double quote, but no single quote.
+func appendQuoted(b []byte, s string) []byte {
+	quote := byte('"')
+	if strings.IndexByte(s, '"') > -1 && strings.IndexByte(s, '\'') == -1 {
+		quote = '\''
+	}
+
+	b = append(b, quote)
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == quote || s[i] == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+
+	return append(b, quote)
+}
+
+// isPlainName returns true if name can be written without quotes.
+func isPlainName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isPlain(name[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPlain returns true if c may appear in an unquoted string.
+func isPlain(c byte) bool {
+	return isDigit(c) || isLetter(c) || c == '_' || c == '-' || c == '.' || c == '+'
+}
+
+// ParseSNBT parses the SNBT text s, as used in Minecraft commands, like
+// {CustomName:'{"text":"Bob"}',Health:20.0f}. It returns the same tree
+// of values Decoder.Decode yields for an empty interface:
+//
+//	{a:1,b:2}      map[string]interface{}
+//	[1,2]          []interface{}
+//	[B;1b,2b]      []byte
+//	[I;1,2]        []int32
+//	[L;1L,2L]      []int64
+//	"a", 'a', a    string
+//	true, false    int8, with the value 1 or 0
+//
+// Numbers become an int8, int16, int32, int64, float32 or float64,
+// depending on their type suffix: 1b, 2s, 3, 4L, 1.5f, and 1.5d or 1.5.
+// Suffixes are case-insensitive and integers may be written in hexadecimal,
+// like 0xFF. Quoted strings are never numbers: "123" is a string.
+// Within them, a backslash escapes the quote character or a backslash.
+//
+// All elements of a list must have the same type. Whitespace between
+// tokens is ignored. Malformed input yields a *SyntaxError.
+func ParseSNBT(s string) (interface{}, error) {
+	p := snbtParser{s: s}
+
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q after value", p.s[p.pos])
+	}
+
+	return v, nil
+}
+
+// snbtParser holds the state of ParseSNBT.
+type snbtParser struct {
+	s   string
+	pos int // Byte offset of the next unread character.
+}
+
+func (p *snbtParser) errorf(msg string, argv ...interface{}) error {
+	return &SyntaxError{Offset: p.pos, Msg: fmt.Sprintf(msg, argv...)}
+}
+
+// skipSpace skips all whitespace at the current position.
+func (p *snbtParser) skipSpace() {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next character, after any whitespace, without
+// consuming it. Returns 0 at the end of the input.
+func (p *snbtParser) peek() byte {
+	p.skipSpace()
+
+	if p.pos == len(p.s) {
+		return 0
+	}
+
+	return p.s[p.pos]
+}
+
+// expect consumes the character c, after any whitespace.
+func (p *snbtParser) expect(c byte) error {
+	switch have := p.peek(); {
+	case have == c:
+		p.pos++
+		return nil
+	case have == 0:
+		return p.errorf("expected %q, found end of input", c)
+	default:
+		return p.errorf("expected %q, found %q", c, have)
+	}
+}
+
+// value parses a single value of any type.
+func (p *snbtParser) value() (interface{}, error) {
+	switch c := p.peek(); c {
+	case 0:
+		return nil, p.errorf("unexpected end of input")
+	case '{':
+		return p.compound()
+	case '[':
+		return p.list()
+	case '"', '\'':
+		return p.quoted()
+	}
+
+	pos := p.pos
+	tok := p.plain()
+
+	if len(tok) == 0 {
+		return nil, p.errorf("unexpected %q", p.s[p.pos])
+	}
+
+	v, ok, err := lexNumber(tok, pos)
+	switch {
+	case err != nil:
+		return nil, err
+	case ok:
+		return v, nil
+	}
+
+	switch tok {
+	case "true":
+		return int8(1), nil
+	case "false":
+		return int8(0), nil
+	}
+
+	return tok, nil
+}
+
+// plain reads an unquoted string, which may be empty.
+func (p *snbtParser) plain() string {
+	start := p.pos
+
+	for p.pos < len(p.s) && isPlain(p.s[p.pos]) {
+		p.pos++
+	}
+
+	return p.s[start:p.pos]
+}
+
+// quoted reads a string in single or double quotes.
+func (p *snbtParser) quoted() (string, error) {
+	start := p.pos
+	quote := p.s[p.pos]
+	p.pos++
+
+	var b strings.Builder
+
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+
+		switch c {
+		case quote:
+			return b.String(), nil
+
+		case '\\':
+			if p.pos == len(p.s) {
+				break
+			}
+
+			c = p.s[p.pos]
+			if c != '\\' && c != '"' && c != '\'' {
+				return "", p.errorf("invalid escape sequence \"\\%c\"", c)
+			}
+
+			p.pos++
+		}
+
+		b.WriteByte(c)
+	}
+
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+// compound parses a compound into a map.
+func (p *snbtParser) compound() (interface{}, error) {
+	p.pos++ // '{'
+
+	m := make(map[string]interface{})
+
+	if p.peek() == '}' {
+		p.pos++
+		return m, nil
+	}
+
+	for {
+		var name string
+		var err error
+
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			name, err = p.quoted()
+		case isPlain(c):
+			name = p.plain()
+		case c == 0:
+			err = p.errorf("expected name, found end of input")
+		default:
+			err = p.errorf("expected name, found %q", c)
+		}
+
+		if err == nil {
+			err = p.expect(':')
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		m[name], err = p.value()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+
+		return m, p.expect('}')
+	}
+}
+
+// list parses a list or one of the array types.
+func (p *snbtParser) list() (interface{}, error) {
+	p.pos++ // '['
+
+	if p.pos+1 < len(p.s) && p.s[p.pos+1] == ';' {
+		switch p.s[p.pos] {
+		case 'B', 'I', 'L':
+			return p.array(p.s[p.pos])
+		}
+	}
+
+	items := []interface{}{}
+
+	if p.peek() == ']' {
+		p.pos++
+		return items, nil
+	}
+
+	for {
+		pos := p.pos
+
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(items) > 0 && reflect.TypeOf(v) != reflect.TypeOf(items[0]) {
+			p.pos = pos
+			p.skipSpace()
+			return nil, p.errorf("list element of type %T, want %T", v, items[0])
+		}
+
+		items = append(items, v)
+
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+
+		return items, p.expect(']')
+	}
+}
+
+// array parses the elements of a byte, int or long array, whose type
+// prefix starts at the current position.
+func (p *snbtParser) array(kind byte) (interface{}, error) {
+	p.pos += 2 // Type and ';'.
+
+	bs := []byte{}
+	ints := []int32{}
+	longs := []int64{}
+
+	for n := 0; ; n++ {
+		if c := p.peek(); c == ']' && n == 0 {
+			p.pos++
+			break
+		}
+
+		pos := p.pos
+
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+
+		switch kind {
+		case 'B':
+			var b int8
+			if b, ok = v.(int8); ok {
+				bs = append(bs, byte(b))
+			}
+		case 'I':
+			var i int32
+			if i, ok = v.(int32); ok {
+				ints = append(ints, i)
+			}
+		case 'L':
+			var l int64
+			if l, ok = v.(int64); ok {
+				longs = append(longs, l)
+			}
+		}
+
+		if !ok {
+			p.pos = pos
+			p.skipSpace()
+			return nil, p.errorf("invalid element of type %T in %c array", v, kind)
+		}
+
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+
+		err = p.expect(']')
+		if err != nil {
+			return nil, err
+		}
+
+		break
+	}
+
+	switch kind {
+	case 'B':
+		return bs, nil
+	case 'I':
+		return ints, nil
+	}
+
+	return longs, nil
+}
+
+// lexNumber parses the unquoted SNBT token tok as a number. The token
+// starts at byte offset pos in the input, which is used for error
+// reporting.
+//
+// The following forms are accepted. Type suffixes are case-insensitive.
+//
+//	123, +123, -123    TAG_Int
+//	123b, 123s, 123l   TAG_Byte, TAG_Short, TAG_Long
+//	0xFF, 0x1Fs, 0xAl  Hexadecimal TAG_Int, TAG_Short, TAG_Long
+//	1.5, .5, 1e3, 1.   TAG_Double
+//	1.5d, 1d           TAG_Double
+//	+1.0e3f, 1f        TAG_Float
+//
+// Hexadecimal values may use the full unsigned range of their type and
+// wrap around into negative numbers. Since b is a hexadecimal digit, there
+// are no hexadecimal TAG_Byte values. Underscores are not accepted.
+//
+// Returns false if the token does not look like a number at all, in which
+// case it should be treated as an unquoted string. If it looks like a
+// number, but is malformed, a *SyntaxError is returned.
+func lexNumber(tok string, pos int) (interface{}, bool, error) {
+	if !isNumeric(tok) {
+		return nil, false, nil
+	}
+
+	fail := func(off int, msg string, argv ...interface{}) (interface{}, bool, error) {
+		return nil, true, &SyntaxError{
+			Offset: pos + off,
+			Msg:    fmt.Sprintf("malformed number %q: %s", tok, fmt.Sprintf(msg, argv...)),
+		}
+	}
+
+	i := 0
+	neg := false
+
+	if tok[i] == '+' || tok[i] == '-' {
+		neg = tok[i] == '-'
+		i++
+	}
+
+	body := tok[i:]
+
+	// Hexadecimal integers.
+	if len(body) > 1 && body[0] == '0' && (body[1] == 'x' || body[1] == 'X') {
+		digits := body[2:]
+		bits := 32
+
+		if n := len(digits); n > 0 {
+			switch digits[n-1] {
+			case 's', 'S':
+				bits, digits = 16, digits[:n-1]
+			case 'l', 'L':
+				bits, digits = 64, digits[:n-1]
+			}
+		}
+
+		if len(digits) == 0 {
+			return fail(i+2, "missing hexadecimal digits")
+		}
+
+		for j := 0; j < len(digits); j++ {
+			if !isHexDigit(digits[j]) {
+				return fail(i+2+j, "invalid hexadecimal digit %q", digits[j])
+			}
+		}
+
+		v, err := strconv.ParseUint(digits, 16, bits)
+		if err != nil {
+			return fail(i, "value out of range")
+		}
+
+		if neg {
+			v = -v
+		}
+
+		switch bits {
+		case 16:
+			return int16(v), true, nil
+		case 64:
+			return int64(v), true, nil
+		}
+
+		return int32(v), true, nil
+	}
+
+	// Decimal numbers. Find the type suffix first.
+	suffix := byte(0)
+	if n := len(tok); isLetter(tok[n-1]) {
+		suffix = lower(tok[n-1])
+		tok = tok[:n-1]
+	}
+
+	// Validate the digits, decimal point and exponent.
+	var digits, dot, exp bool
+
+	for j := i; j < len(tok); j++ {
+		c := tok[j]
+
+		switch {
+		case isDigit(c):
+			digits = true
+
+		case c == '.':
+			if dot || exp {
+				return fail(j, "unexpected '.'")
+			}
+			dot = true
+
+		case c == 'e' || c == 'E':
+			if exp || !digits {
+				return fail(j, "unexpected %q", c)
+			}
+
+			exp = true
+
+			if j+1 < len(tok) && (tok[j+1] == '+' || tok[j+1] == '-') {
+				j++
+			}
+
+			if j+1 >= len(tok) || !isDigit(tok[j+1]) {
+				return fail(j+1, "missing exponent digits")
+			}
+
+		default:
+			return fail(j, "unexpected %q", c)
+		}
+	}
+
+	if !digits {
+		return fail(i, "missing digits")
+	}
+
+	isFloat := dot || exp
+
+	switch suffix {
+	case 0:
+		if isFloat {
+			return parseFloat(tok, 64, fail)
+		}
+		return parseInt(tok, 32, fail)
+
+	case 'f':
+		return parseFloat(tok, 32, fail)
+
+	case 'd':
+		return parseFloat(tok, 64, fail)
+
+	case 'b', 's', 'l':
+		if isFloat {
+			return fail(len(tok), "integer suffix %q on a decimal value", suffix)
+		}
+
+		switch suffix {
+		case 'b':
+			return parseInt(tok, 8, fail)
+		case 's':
+			return parseInt(tok, 16, fail)
+		}
+
+		return parseInt(tok, 64, fail)
+	}
+
+	return fail(len(tok), "unknown type suffix %q", suffix)
+}
+
+// failFunc reports a malformed number at the given offset in the token.
+type failFunc func(off int, msg string, argv ...interface{}) (interface{}, bool, error)
+
+// parseInt parses tok as a signed decimal integer of the given size.
+func parseInt(tok string, bits int, fail failFunc) (interface{}, bool, error) {
+	v, err := strconv.ParseInt(tok, 10, bits)
+	if err != nil {
+		return fail(0, "value out of range")
+	}
+
+	switch bits {
+	case 8:
+		return int8(v), true, nil
+	case 16:
+		return int16(v), true, nil
+	case 64:
+		return v, true, nil
+	}
+
+	return int32(v), true, nil
+}
+
+// parseFloat parses tok as a floating point value of the given size.
+// Values which do not fit in the type are rejected.
+func parseFloat(tok string, bits int, fail failFunc) (interface{}, bool, error) {
+	v, err := strconv.ParseFloat(tok, bits)
+	if err != nil || math.IsInf(v, 0) {
+		return fail(0, "value out of range")
+	}
+
+	if bits == 32 {
+		return float32(v), true, nil
+	}
+
+	return v, true, nil
+}
+
+// isNumeric returns true if tok looks like a number: an optional sign,
+// followed by a digit, or by a decimal point and a digit.
+func isNumeric(tok string) bool {
+	if strings.HasPrefix(tok, "+") || strings.HasPrefix(tok, "-") {
+		tok = tok[1:]
+	}
+
+	if len(tok) == 0 {
+		return false
+	}
+
+	if isDigit(tok[0]) {
+		return true
+	}
+
+	return len(tok) > 1 && tok[0] == '.' && isDigit(tok[1])
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -5,22 +5,44 @@
 
 package nbt
 
-// tagId describes a type oftag.
-type tagId uint8
+// TagId describes a type of tag.
+type TagId uint8
 
 // Known tag types
 const (
-	tagEnd       tagId = 0x0
-	tagByte      tagId = 0x1
-	tagShort     tagId = 0x2
-	tagInt       tagId = 0x3
-	tagLong      tagId = 0x4
-	tagFloat     tagId = 0x5
-	tagDouble    tagId = 0x6
-	tagByteArray tagId = 0x7
-	tagString    tagId = 0x8
-	tagList      tagId = 0x9
-	tagCompound  tagId = 0xa
-	tagIntArray  tagId = 0xb
-	tagUnknown   tagId = 0xc
+	TagEnd       TagId = 0x0
+	TagByte      TagId = 0x1
+	TagShort     TagId = 0x2
+	TagInt       TagId = 0x3
+	TagLong      TagId = 0x4
+	TagFloat     TagId = 0x5
+	TagDouble    TagId = 0x6
+	TagByteArray TagId = 0x7
+	TagString    TagId = 0x8
+	TagList      TagId = 0x9
+	TagCompound  TagId = 0xa
+	TagIntArray  TagId = 0xb
+	TagLongArray TagId = 0xc
+
+	// TagUnknown is not a real tag type. It marks a tag whose type has not
+	// been read yet, so it lies outside the range of valid ids. It used to
+	// be 0xc, which is now taken by TAG_Long_Array.
+	TagUnknown TagId = 0xff
 )
+
+// Subtree captures a single tag from a compound, whose name is only known
+// at runtime. Assign it to a struct field tagged with the `remaining` value
+// and set Name before decoding. See the package documentation for details.
+type Subtree struct {
+	Name  string      // Name of the tag to capture.
+	Value interface{} // Pointer to the value which receives the tag data.
+}
+
+// ListHandler receives the elements of a list one at a time, instead of
+// having them collected into a slice. Assign it to the struct field which
+// matches the list, before decoding. It is called with the index of each
+// element and a function which decodes the element into the value v
+// points to. An element which is not decoded is skipped.
+//
+// Decoding stops with the handler's error if it returns one.
+type ListHandler func(i int, decode func(v interface{}) error) error
@@ -5,18 +5,18 @@ package nbt
 import "fmt"
 
 const (
-	_TagId_name_0 = "TagEndTagByteTagShortTagIntTagLongTagFloatTagDoubleTagByteArrayTagStringTagListTagCompoundTagIntArray"
+	_TagId_name_0 = "TagEndTagByteTagShortTagIntTagLongTagFloatTagDoubleTagByteArrayTagStringTagListTagCompoundTagIntArrayTagLongArray"
 	_TagId_name_1 = "TagUnknown"
 )
 
 var (
-	_TagId_index_0 = [...]uint8{6, 13, 21, 27, 34, 42, 51, 63, 72, 79, 90, 101}
+	_TagId_index_0 = [...]uint8{6, 13, 21, 27, 34, 42, 51, 63, 72, 79, 90, 101, 113}
 	_TagId_index_1 = [...]uint8{10}
 )
 
-func (i tagId) String() string {
+func (i TagId) String() string {
 	switch {
-	case 0 <= i && i <= 11:
+	case 0 <= i && i <= 12:
 		lo := uint8(0)
 		if i > 0 {
 			lo = _TagId_index_0[i-1]
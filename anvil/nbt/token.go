@@ -0,0 +1,191 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"fmt"
+	"io"
+)
+
+// Token holds a single element read by Decoder.Token. It is one of:
+//
+//	TagStart   The start of a compound or list.
+//	KeyValue   A named value in a compound, or an unnamed list element.
+//	           Value holds one of the non-container Tag types.
+//	TagId      Always TagEnd; marks the end of a compound or list.
+type Token interface{}
+
+// TagStart marks the start of a compound or list. Elem and Len are only
+// set for lists and hold the type and number of their elements.
+type TagStart struct {
+	Type TagId
+	Name string // Empty for list elements and, usually, the root.
+	Elem TagId
+	Len  int
+}
+
+// tokenFrame defines an open compound or list, read by Token.
+type tokenFrame struct {
+	id   TagId // TagCompound or TagList.
+	elem TagId // Element type of a list.
+	left int   // Number of unread list elements.
+}
+
+// Token returns the next element in the input stream, without decoding
+// the full value. Compounds and lists yield a TagStart, followed by
+// their contents and a matching TagEnd. All other tags yield a KeyValue.
+// Returns io.EOF once the root tag has been read completely.
+//
+//	for {
+//	    tok, err := dec.Token()
+//	    if err == io.EOF {
+//	        break
+//	    }
+//	    ...
+//	}
+//
+// Use Depth to find the current nesting level and Skip to discard the
+// contents of a compound or list. Token should not be mixed with calls
+// to Decode or Compound.
+func (d *Decoder) Token() (Token, error) {
+	if len(d.frames) == 0 {
+		if d.rootDone {
+			return nil, io.EOF
+		}
+
+		id, name, err := d.readHeader(TagUnknown)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("nbt: %v", err)
+		}
+
+		if id == TagEnd {
+			return nil, fmt.Errorf("nbt: unexpected %s at root", id)
+		}
+
+		return d.token(id, name)
+	}
+
+	f := &d.frames[len(d.frames)-1]
+
+	if f.id == TagList {
+		if f.left == 0 {
+			return d.endToken(), nil
+		}
+
+		f.left--
+		return d.token(f.elem, "")
+	}
+
+	id, name, err := d.readHeader(TagUnknown)
+	if err != nil {
+		return nil, fmt.Errorf("nbt: %v", unexpectedEOF(err))
+	}
+
+	if id == TagEnd {
+		return d.endToken(), nil
+	}
+
+	return d.token(id, name)
+}
+
+// Depth returns the number of compounds and lists which have been
+// started by Token, but not yet ended. It is 1 while reading the
+// entries of the root compound.
+func (d *Decoder) Depth() int { return len(d.frames) }
+
+// Skip discards the remaining contents of the innermost compound or list
+// started by Token, including its end. Calling it directly after Token
+// returned a TagStart skips that entire value; Token then continues with
+// the tag which follows it. Skip does nothing at depth 0.
+func (d *Decoder) Skip() error {
+	if len(d.frames) == 0 {
+		return nil
+	}
+
+	f := d.frames[len(d.frames)-1]
+
+	var err error
+	if f.id == TagList {
+		for ; f.left > 0 && err == nil; f.left-- {
+			err = d.skip(f.elem)
+		}
+	} else {
+		err = d.skipCompound()
+	}
+
+	if err != nil {
+		return fmt.Errorf("nbt: %v", unexpectedEOF(err))
+	}
+
+	d.endToken()
+	return nil
+}
+
+// token reads the payload of a tag with the given type and name.
+func (d *Decoder) token(id TagId, name string) (Token, error) {
+	if (id == TagCompound || id == TagList) && d.maxDepth > 0 && len(d.frames) >= d.maxDepth {
+		return nil, fmt.Errorf("nbt: %s(%q): exceeds maximum nesting depth of %d", id, name, d.maxDepth)
+	}
+
+	switch id {
+	case TagCompound:
+		d.frames = append(d.frames, tokenFrame{id: id})
+		return TagStart{Type: id, Name: name}, nil
+
+	case TagList:
+		n, err := d.readByte()
+		if err != nil {
+			return nil, fmt.Errorf("nbt: %v", unexpectedEOF(err))
+		}
+
+		size, err := d.readInt()
+		if err != nil {
+			return nil, fmt.Errorf("nbt: %v", unexpectedEOF(err))
+		}
+
+		elem := TagId(n)
+		err = d.checkSize(TagList, size, minSize(elem))
+		if err != nil {
+			return nil, fmt.Errorf("nbt: %s(%q): %v", id, name, err)
+		}
+
+		d.frames = append(d.frames, tokenFrame{id: id, elem: elem, left: int(size)})
+		return TagStart{Type: id, Name: name, Elem: elem, Len: int(size)}, nil
+	}
+
+	t, err := d.readTag(id, false)
+	if err != nil {
+		return nil, fmt.Errorf("nbt: %s(%q): %v", id, name, unexpectedEOF(err))
+	}
+
+	if len(d.frames) == 0 {
+		d.rootDone = true
+	}
+
+	return KeyValue{name, t}, nil
+}
+
+// endToken closes the innermost compound or list.
+func (d *Decoder) endToken() Token {
+	d.frames = d.frames[:len(d.frames)-1]
+	if len(d.frames) == 0 {
+		d.rootDone = true
+	}
+
+	return TagEnd
+}
+
+// unexpectedEOF replaces io.EOF with io.ErrUnexpectedEOF. Token only
+// encounters the former in the middle of a tag.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,467 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package nbt
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Tag is a dynamically typed NBT value. It is used to decode data whose
+// structure is not known up front. Every tag type has a matching Go type:
+//
+//	TAG_Byte       Byte
+//	TAG_Short      Short
+//	TAG_Int        Int
+//	TAG_Long       Long
+//	TAG_Float      Float
+//	TAG_Double     Double
+//	TAG_Byte_Array ByteArray
+//	TAG_String     String
+//	TAG_List       List
+//	TAG_Compound   Compound, OrderedCompound
+//	TAG_Int_Array  IntArray
+//	TAG_Long_Array LongArray
+type Tag interface {
+	// TagId returns the type of the tag.
+	TagId() TagId
+
+	// Size returns the approximate number of bytes of memory taken up
+	// by the tag, including any nested tags. This is meant for memory
+	// accounting, like bounding the size of a cache.
+	Size() int
+
+	// EncodedSize returns the number of bytes the tag's payload takes up
+	// when encoded. This excludes the tag type and name, which precede
+	// the payload in a compound.
+	EncodedSize() int
+}
+
+type (
+	Byte      int8
+	Short     int16
+	Int       int32
+	Long      int64
+	Float     float32
+	Double    float64
+	ByteArray []byte
+	String    string
+	IntArray  []int32
+	LongArray []int64
+)
+
+func (Byte) TagId() TagId      { return TagByte }
+func (Short) TagId() TagId     { return TagShort }
+func (Int) TagId() TagId       { return TagInt }
+func (Long) TagId() TagId      { return TagLong }
+func (Float) TagId() TagId     { return TagFloat }
+func (Double) TagId() TagId    { return TagDouble }
+func (ByteArray) TagId() TagId { return TagByteArray }
+func (String) TagId() TagId    { return TagString }
+func (IntArray) TagId() TagId  { return TagIntArray }
+func (LongArray) TagId() TagId { return TagLongArray }
+
+// Sizes of Go values, used for memory accounting.
+const (
+	sliceOverhead  = 24 // Slice header.
+	stringOverhead = 16 // String header.
+	tagOverhead    = 16 // Interface value holding a Tag.
+	mapOverhead    = 48 // Map header and buckets, approximated.
+)
+
+func (Byte) Size() int        { return 1 }
+func (Short) Size() int       { return 2 }
+func (Int) Size() int         { return 4 }
+func (Long) Size() int        { return 8 }
+func (Float) Size() int       { return 4 }
+func (Double) Size() int      { return 8 }
+func (t ByteArray) Size() int { return sliceOverhead + len(t) }
+func (t String) Size() int    { return stringOverhead + len(t) }
+func (t IntArray) Size() int  { return sliceOverhead + len(t)*4 }
+func (t LongArray) Size() int { return sliceOverhead + len(t)*8 }
+
+func (Byte) EncodedSize() int        { return 1 }
+func (Short) EncodedSize() int       { return 2 }
+func (Int) EncodedSize() int         { return 4 }
+func (Long) EncodedSize() int        { return 8 }
+func (Float) EncodedSize() int       { return 4 }
+func (Double) EncodedSize() int      { return 8 }
+func (t ByteArray) EncodedSize() int { return 4 + len(t) }
+func (t String) EncodedSize() int    { return 2 + mutf8Len(string(t)) }
+func (t IntArray) EncodedSize() int  { return 4 + len(t)*4 }
+func (t LongArray) EncodedSize() int { return 4 + len(t)*8 }
+
+// List holds a list of tags, which all have the same type.
+type List struct {
+	Elem  TagId // Type of the list elements.
+	Items []Tag // List elements.
+}
+
+func (List) TagId() TagId { return TagList }
+
+func (l List) Size() int {
+	n := 1 + sliceOverhead + cap(l.Items)*tagOverhead
+
+	for _, t := range l.Items {
+		n += tagSize(t)
+	}
+
+	return n
+}
+
+func (l List) EncodedSize() int {
+	n := 1 + 4 // Element type and length.
+
+	for _, t := range l.Items {
+		n += t.EncodedSize()
+	}
+
+	return n
+}
+
+// Compound holds a compound tag. The order of its entries is not kept;
+// they are encoded in sorted order.
+type Compound map[string]Tag
+
+func (Compound) TagId() TagId { return TagCompound }
+
+func (c Compound) Size() int {
+	n := mapOverhead
+
+	for k, t := range c {
+		n += stringOverhead + len(k) + tagOverhead + tagSize(t)
+	}
+
+	return n
+}
+
+func (c Compound) EncodedSize() int {
+	n := 1 // TagEnd.
+
+	for k, t := range c {
+		n += entrySize(k, t)
+	}
+
+	return n
+}
+
+// KeyValue defines a single entry in an OrderedCompound.
+type KeyValue struct {
+	Name  string
+	Value Tag
+}
+
+// OrderedCompound holds a compound tag, whose entries are kept in the
+// order in which they appear in the input. Encoding it yields the entries
+// in the same order.
+type OrderedCompound []KeyValue
+
+func (OrderedCompound) TagId() TagId { return TagCompound }
+
+func (c OrderedCompound) Size() int {
+	n := sliceOverhead + (cap(c)-len(c))*(stringOverhead+tagOverhead)
+
+	for _, kv := range c {
+		n += stringOverhead + len(kv.Name) + tagOverhead + tagSize(kv.Value)
+	}
+
+	return n
+}
+
+func (c OrderedCompound) EncodedSize() int {
+	n := 1 // TagEnd.
+
+	for _, kv := range c {
+		n += entrySize(kv.Name, kv.Value)
+	}
+
+	return n
+}
+
+// tagSize returns the memory size of t, which may be nil.
+func tagSize(t Tag) int {
+	if t == nil {
+		return 0
+	}
+
+	return t.Size()
+}
+
+// entrySize returns the encoded size of a named compound entry.
+// Entries without a value are not encoded.
+func entrySize(name string, t Tag) int {
+	if t == nil {
+		return 0
+	}
+
+	return 1 + 2 + mutf8Len(name) + t.EncodedSize()
+}
+
+// Get returns the value of the first entry with the given name.
+// Returns false if there is no such entry.
+func (c OrderedCompound) Get(name string) (Tag, bool) {
+	for _, kv := range c {
+		if kv.Name == name {
+			return kv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+var (
+	tagType     = reflect.TypeOf((*Tag)(nil)).Elem()
+	orderedType = reflect.TypeOf(OrderedCompound(nil))
+)
+
+// isTag returns true if values of the given type are decoded as a
+// dynamic tag tree.
+func isTag(rt reflect.Type) bool {
+	return rt == tagType || (rt.Kind() != reflect.Ptr && rt.Implements(tagType))
+}
+
+// decodeTag decodes a tag into rv, which is either a Tag interface or
+// one of the concrete tag types.
+func (d *Decoder) decodeTag(id TagId, name string, rv reflect.Value) error {
+	ordered := d.ordered || rv.Type() == orderedType
+
+	t, err := d.readTag(id, ordered)
+	if err != nil {
+		return err
+	}
+
+	tv := reflect.ValueOf(t)
+	if !tv.Type().AssignableTo(rv.Type()) {
+		return fmt.Errorf("%s(%q): can not assign to %v", id, name, rv.Type())
+	}
+
+	rv.Set(tv)
+	return nil
+}
+
+// readTag reads the payload of a tag with the given type. Compounds are
+// read into an OrderedCompound if ordered is true.
+func (d *Decoder) readTag(id TagId, ordered bool) (Tag, error) {
+	switch id {
+	case TagByte:
+		v, err := d.readByte()
+		return Byte(v), err
+	case TagShort:
+		v, err := d.readShort()
+		return Short(v), err
+	case TagInt:
+		v, err := d.readInt()
+		return Int(v), err
+	case TagLong:
+		v, err := d.readLong()
+		return Long(v), err
+	case TagFloat:
+		v, err := d.readFloat()
+		return Float(v), err
+	case TagDouble:
+		v, err := d.readDouble()
+		return Double(v), err
+	case TagString:
+		v, err := d.readString()
+		return String(v), err
+	case TagByteArray:
+		v, err := d.readByteArray(nil)
+		return ByteArray(v), err
+	case TagIntArray:
+		v, err := d.readIntArray(nil)
+		return IntArray(v), err
+	case TagLongArray:
+		v, err := d.readLongArray(nil)
+		return LongArray(v), err
+	case TagList, TagCompound:
+		err := d.enter()
+		if err != nil {
+			return nil, err
+		}
+
+		defer d.leave()
+
+		switch {
+		case id == TagList:
+			return d.readList(ordered)
+		case ordered:
+			return d.readOrderedCompound()
+		}
+
+		return d.readCompound()
+	}
+
+	return nil, fmt.Errorf("unsupported value %s", id)
+}
+
+func (d *Decoder) readList(ordered bool) (Tag, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := d.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	elem := TagId(n)
+
+	err = d.checkSize(TagList, size, minSize(elem))
+	if err != nil {
+		return nil, err
+	}
+
+	prealloc := int(size)
+	if prealloc > maxListPrealloc {
+		prealloc = maxListPrealloc
+	}
+
+	l := List{Elem: elem, Items: make([]Tag, 0, prealloc)}
+
+	for i := 0; i < int(size); i++ {
+		t, err := d.readTag(elem, ordered)
+		if err != nil {
+			return nil, err
+		}
+
+		l.Items = append(l.Items, t)
+	}
+
+	return l, nil
+}
+
+func (d *Decoder) readCompound() (Tag, error) {
+	c := make(Compound)
+
+	for {
+		id, name, err := d.readHeader(TagUnknown)
+		if err != nil {
+			return nil, err
+		}
+
+		if id == TagEnd {
+			return c, nil
+		}
+
+		c[name], err = d.readTag(id, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *Decoder) readOrderedCompound() (Tag, error) {
+	c := OrderedCompound{}
+
+	for {
+		id, name, err := d.readHeader(TagUnknown)
+		if err != nil {
+			return nil, err
+		}
+
+		if id == TagEnd {
+			return c, nil
+		}
+
+		t, err := d.readTag(id, true)
+		if err != nil {
+			return nil, err
+		}
+
+		c = append(c, KeyValue{name, t})
+	}
+}
+
+// encodeTag encodes the given dynamic tag. Tag types which are plain
+// numbers, strings or arrays are handled by the regular encoder.
+func (e *Encoder) encodeTag(t Tag, name string, inlist bool) error {
+	switch t := t.(type) {
+	case List:
+		return e.encodeTagList(t, name, inlist)
+
+	case Compound:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		kv := make(OrderedCompound, len(keys))
+		for i, k := range keys {
+			kv[i] = KeyValue{k, t[k]}
+		}
+
+		return e.encodeOrdered(kv, name, inlist)
+
+	case OrderedCompound:
+		return e.encodeOrdered(t, name, inlist)
+	}
+
+	return e.encode(reflect.ValueOf(t), name, inlist)
+}
+
+func (e *Encoder) encodeTagList(l List, name string, inlist bool) error {
+	elem := l.Elem
+
+	for _, t := range l.Items {
+		if t == nil {
+			return fmt.Errorf("nbt: list %q has nil elements", name)
+		}
+
+		if elem == TagEnd {
+			elem = t.TagId()
+		}
+
+		if t.TagId() != elem {
+			return fmt.Errorf("nbt: list %q mixes %s and %s elements", name, elem, t.TagId())
+		}
+	}
+
+	err := e.emit(TagList, name, inlist)
+	if err != nil {
+		return err
+	}
+
+	err = e.writeU8(uint8(elem))
+	if err != nil {
+		return err
+	}
+
+	err = e.writeU32(uint32(len(l.Items)))
+	if err != nil {
+		return err
+	}
+
+	for _, t := range l.Items {
+		err = e.encodeTag(t, "", true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Encoder) encodeOrdered(c OrderedCompound, name string, inlist bool) error {
+	err := e.emit(TagCompound, name, inlist)
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range c {
+		if kv.Value == nil {
+			continue
+		}
+
+		err = e.encodeTag(kv.Value, kv.Name, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.writeU8(uint8(TagEnd))
+}
@@ -0,0 +1,444 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+// AirBlock defines the name of the default block state.
+const AirBlock = "minecraft:air"
+
+// DefaultBiome defines the name of the biome assigned to new sections.
+const DefaultBiome = "minecraft:plains"
+
+const (
+	// sectionVolume defines the number of blocks stored in a single section.
+	sectionVolume = BlocksPerSection * BlocksPerChunk * BlocksPerChunk
+
+	// BiomeCellSize defines the edge length, in blocks, of the cubes
+	// which share a single biome.
+	BiomeCellSize = 4
+
+	// biomeCells defines the number of biome cells in a single section.
+	biomeCells = (BlocksPerSection / BiomeCellSize) *
+		(BlocksPerChunk / BiomeCellSize) * (BlocksPerChunk / BiomeCellSize)
+
+	// minBlockBits defines the smallest number of bits used to store a
+	// block state index, as long as the palette has more than one entry.
+	minBlockBits = 4
+)
+
+// BlockState describes a single entry in a section's block palette.
+type BlockState struct {
+	Name       string            `nbt:"Name"`
+	Properties map[string]string `nbt:"Properties,omitempty"`
+}
+
+// Equal returns true if both block states have the same name and properties.
+func (b BlockState) Equal(o BlockState) bool {
+	if b.Name != o.Name || len(b.Properties) != len(o.Properties) {
+		return false
+	}
+
+	for k, v := range b.Properties {
+		if ov, ok := o.Properties[k]; !ok || ov != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BlockStates holds the paletted block data for a section, as written by
+// Minecraft 1.18+.
+//
+// Each block in the section is stored as an index into the palette.
+// These indices are packed into Data, using the smallest number of bits
+// (but at least 4) which can address every palette entry. Indices never
+// span multiple longs. If the palette holds only a single entry, Data is
+// empty and every block has that state.
+type BlockStates struct {
+	Palette []BlockState `nbt:"palette"`
+	Data    []int64      `nbt:"data,omitempty"`
+}
+
+// Bits returns the number of bits used for each packed palette index.
+func (b *BlockStates) Bits() int {
+	return blockBits(len(b.Palette))
+}
+
+// Get returns the block state at the given index.
+// The index is computed as y*256 + z*16 + x.
+func (b *BlockStates) Get(index int) BlockState {
+	if len(b.Palette) == 0 {
+		return BlockState{Name: AirBlock}
+	}
+
+	n := unpackIndex(b.Data, b.Bits(), index)
+	if n >= len(b.Palette) {
+		return BlockState{Name: AirBlock}
+	}
+
+	return b.Palette[n]
+}
+
+// Set assigns the given block state to the given index.
+// The state is added to the palette if it does not exist yet. The packed
+// data is widened as necessary.
+func (b *BlockStates) Set(index int, s BlockState) {
+	if index < 0 || index >= sectionVolume {
+		return
+	}
+
+	if len(b.Palette) == 0 {
+		b.Palette = []BlockState{{Name: AirBlock}}
+	}
+
+	n := -1
+	for i := range b.Palette {
+		if b.Palette[i].Equal(s) {
+			n = i
+			break
+		}
+	}
+
+	if n == -1 {
+		old := b.Bits()
+		b.Palette = append(b.Palette, s)
+		n = len(b.Palette) - 1
+		b.Data = repack(b.Data, old, b.Bits(), sectionVolume, nil)
+	}
+
+	if bits := b.Bits(); bits > 0 {
+		packIndex(b.Data, bits, index, n)
+	}
+}
+
+// Compact rebuilds the palette so it only holds states which are
+// actually referenced by a block. Duplicate entries are merged.
+// The packed data is remapped and stored with the smallest possible number
+// of bits.
+func (b *BlockStates) Compact() {
+	if len(b.Palette) == 0 {
+		return
+	}
+
+	bits := b.Bits()
+
+	used := make([]bool, len(b.Palette))
+	for i := 0; i < sectionVolume; i++ {
+		n := unpackIndex(b.Data, bits, i)
+		if n < len(b.Palette) {
+			used[n] = true
+		}
+	}
+
+	// Build the new palette in the order of the old one,
+	// keeping only the first of any identical entries.
+	remap := make([]int, len(b.Palette))
+	palette := make([]BlockState, 0, len(b.Palette))
+
+	for i := range b.Palette {
+		if !used[i] {
+			continue
+		}
+
+		n := -1
+		for j := range palette {
+			if palette[j].Equal(b.Palette[i]) {
+				n = j
+				break
+			}
+		}
+
+		if n == -1 {
+			palette = append(palette, b.Palette[i])
+			n = len(palette) - 1
+		}
+
+		remap[i] = n
+	}
+
+	if len(palette) == 0 {
+		palette = append(palette, b.Palette[0])
+	}
+
+	b.Data = repack(b.Data, bits, blockBits(len(palette)), sectionVolume, remap)
+	b.Palette = palette
+}
+
+// isAir returns true if the given block state name describes one of the
+// air blocks.
+func isAir(name string) bool {
+	switch name {
+	case AirBlock, "minecraft:cave_air", "minecraft:void_air":
+		return true
+	}
+
+	return false
+}
+
+// BiomePalette holds the paletted biome data for a section, as written by
+// Minecraft 1.18+.
+//
+// Biomes are stored for cubes of 4x4x4 blocks, so a section holds 64 of
+// them. Each is stored as an index into the palette, packed into Data
+// using the smallest number of bits which can address every palette entry.
+// Unlike block states, there is no lower bound to this size. Sections with
+// a single biome have only one palette entry and no data.
+type BiomePalette struct {
+	Palette []string `nbt:"palette"`
+	Data    []int64  `nbt:"data,omitempty"`
+}
+
+// Bits returns the number of bits used for each packed palette index.
+func (b *BiomePalette) Bits() int {
+	return biomeBits(len(b.Palette))
+}
+
+// Get returns the biome at the given cell index.
+// The index is computed as y*16 + z*4 + x, in biome cells.
+func (b *BiomePalette) Get(index int) string {
+	if len(b.Palette) == 0 {
+		return DefaultBiome
+	}
+
+	n := unpackIndex(b.Data, b.Bits(), index)
+	if n >= len(b.Palette) {
+		return b.Palette[0]
+	}
+
+	return b.Palette[n]
+}
+
+// Set assigns the given biome to the given cell index.
+// The biome is added to the palette if needed. Afterwards, entries which
+// are no longer used are removed. If the whole section ends up with a
+// single biome, the palette collapses to that entry without any data.
+func (b *BiomePalette) Set(index int, biome string) {
+	if index < 0 || index >= biomeCells {
+		return
+	}
+
+	if len(b.Palette) == 0 {
+		b.Palette = []string{DefaultBiome}
+	}
+
+	n := -1
+	for i := range b.Palette {
+		if b.Palette[i] == biome {
+			n = i
+			break
+		}
+	}
+
+	if n == -1 {
+		old := b.Bits()
+		b.Palette = append(b.Palette, biome)
+		n = len(b.Palette) - 1
+		b.Data = repack(b.Data, old, b.Bits(), biomeCells, nil)
+	}
+
+	if bits := b.Bits(); bits > 0 {
+		packIndex(b.Data, bits, index, n)
+	}
+
+	b.compact()
+}
+
+// compact removes unused palette entries and repacks the data.
+func (b *BiomePalette) compact() {
+	bits := b.Bits()
+
+	used := make([]bool, len(b.Palette))
+	for i := 0; i < biomeCells; i++ {
+		if n := unpackIndex(b.Data, bits, i); n < len(used) {
+			used[n] = true
+		}
+	}
+
+	remap := make([]int, len(b.Palette))
+	palette := make([]string, 0, len(b.Palette))
+
+	for i := range b.Palette {
+		if used[i] {
+			remap[i] = len(palette)
+			palette = append(palette, b.Palette[i])
+		}
+	}
+
+	if len(palette) == len(b.Palette) {
+		return
+	}
+
+	b.Data = repack(b.Data, bits, biomeBits(len(palette)), biomeCells, remap)
+	b.Palette = palette
+}
+
+// biomeBits returns the number of bits needed to store an index into a
+// biome palette with n entries.
+func biomeBits(n int) int {
+	if n <= 1 {
+		return 0
+	}
+
+	return bitLength(n - 1)
+}
+
+// blockBits returns the number of bits needed to store an index into a
+// block palette with n entries.
+func blockBits(n int) int {
+	if n <= 1 {
+		return 0
+	}
+
+	bits := bitLength(n - 1)
+	if bits < minBlockBits {
+		bits = minBlockBits
+	}
+
+	return bits
+}
+
+// bitLength returns the number of bits needed to represent v.
+func bitLength(v int) int {
+	var n int
+
+	for v > 0 {
+		n++
+		v >>= 1
+	}
+
+	return n
+}
+
+// packedLen returns the number of longs needed to pack count values of
+// the given bit size, without letting values span multiple longs.
+func packedLen(bits, count int) int {
+	if bits == 0 {
+		return 0
+	}
+
+	perLong := 64 / bits
+	return (count + perLong - 1) / perLong
+}
+
+// unpackIndex returns the n'th value of the given bit size from data.
+// Returns 0 if the data does not hold the requested value.
+func unpackIndex(data []int64, bits, n int) int {
+	if bits == 0 || n < 0 {
+		return 0
+	}
+
+	perLong := 64 / bits
+	i := n / perLong
+
+	if i >= len(data) {
+		return 0
+	}
+
+	shift := uint(n%perLong) * uint(bits)
+	mask := uint64(1)<<uint(bits) - 1
+	return int((uint64(data[i]) >> shift) & mask)
+}
+
+// packIndex stores v as the n'th value of the given bit size in data.
+func packIndex(data []int64, bits, n, v int) {
+	if bits == 0 || n < 0 {
+		return
+	}
+
+	perLong := 64 / bits
+	i := n / perLong
+
+	if i >= len(data) {
+		return
+	}
+
+	shift := uint(n%perLong) * uint(bits)
+	mask := uint64(1)<<uint(bits) - 1
+	d := uint64(data[i]) &^ (mask << shift)
+	data[i] = int64(d | (uint64(v)&mask)<<shift)
+}
+
+// unpackLegacyIndex returns the n'th palette index from the block states
+// of a section written by Minecraft 1.13 - 1.17, whose palette has the
+// given number of entries. These always use at least 4 bits per index,
+// even for a single entry palette.
+//
+// Up to 1.15, indices span multiple longs. Minecraft 1.16 changed this to
+// the padded layout used by unpackIndex. The two differ in the number of
+// longs needed for a full section, unless the bit size divides 64, in
+// which case they are identical.
+func unpackLegacyIndex(data []int64, entries, n int) int {
+	bits := bitLength(entries - 1)
+	if bits < minBlockBits {
+		bits = minBlockBits
+	}
+
+	if len(data) == packedLen(bits, sectionVolume) {
+		return unpackIndex(data, bits, n)
+	}
+
+	return unpackSpanning(data, bits, n)
+}
+
+// unpackSpanning returns the n'th value of the given bit size from data,
+// where values are packed back to back and may span two longs.
+// Returns 0 if the data does not hold the requested value.
+func unpackSpanning(data []int64, bits, n int) int {
+	if bits == 0 || n < 0 {
+		return 0
+	}
+
+	start := n * bits
+	i, shift := start/64, uint(start%64)
+
+	if i >= len(data) {
+		return 0
+	}
+
+	mask := uint64(1)<<uint(bits) - 1
+	v := uint64(data[i]) >> shift
+
+	if int(shift)+bits > 64 {
+		if i+1 >= len(data) {
+			return 0
+		}
+
+		v |= uint64(data[i+1]) << (64 - shift)
+	}
+
+	return int(v & mask)
+}
+
+// repack converts a data set of count values from one bit size to another.
+// If remap is not nil, every value v is replaced by remap[v].
+func repack(data []int64, from, to, count int, remap []int) []int64 {
+	if from == to && remap == nil {
+		if len(data) == 0 && to > 0 {
+			return make([]int64, packedLen(to, count))
+		}
+		return data
+	}
+
+	if to == 0 {
+		return nil
+	}
+
+	out := make([]int64, packedLen(to, count))
+
+	for i := 0; i < count; i++ {
+		v := unpackIndex(data, from, i)
+
+		if remap != nil {
+			if v < len(remap) {
+				v = remap[v]
+			} else {
+				v = 0
+			}
+		}
+
+		packIndex(out, to, i, v)
+	}
+
+	return out
+}
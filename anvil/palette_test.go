@@ -0,0 +1,552 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+func TestChunkCompact(t *testing.T) {
+	var c Chunk
+	c.Init(0, 0)
+
+	states := []BlockState{
+		{Name: "minecraft:stone"},
+		{Name: "minecraft:dirt"},
+		{Name: "minecraft:oak_log", Properties: map[string]string{"axis": "x"}},
+		{Name: "minecraft:oak_log", Properties: map[string]string{"axis": "y"}},
+		{Name: "minecraft:glass"},
+	}
+
+	// Fill the bottom two sections with a mix of states, then overwrite
+	// most of it again, leaving a bunch of unused palette entries behind.
+	for y := -16; y < 16; y++ {
+		for x := 0; x < BlocksPerChunk; x++ {
+			for z := 0; z < BlocksPerChunk; z++ {
+				c.SetBlock(x, y, z, states[(x+y+z+32)%len(states)])
+			}
+		}
+	}
+
+	for y := -16; y < 16; y++ {
+		for x := 0; x < BlocksPerChunk; x++ {
+			for z := 0; z < BlocksPerChunk; z++ {
+				if x > 0 {
+					c.SetBlock(x, y, z, BlockState{Name: "minecraft:stone"})
+				}
+			}
+		}
+	}
+
+	want := make(map[[3]int]BlockState)
+	for y := -16; y < 16; y++ {
+		for x := 0; x < BlocksPerChunk; x++ {
+			for z := 0; z < BlocksPerChunk; z++ {
+				want[[3]int{x, y, z}] = blockAt(t, &c, x, y, z)
+			}
+		}
+	}
+
+	before := make([]int, len(c.Sections))
+	for i := range c.Sections {
+		before[i] = len(c.Sections[i].BlockStates.Palette)
+	}
+
+	c.Compact()
+
+	for i := range c.Sections {
+		bs := c.Sections[i].BlockStates
+
+		if len(bs.Palette) >= before[i] {
+			t.Errorf("section %d: palette did not shrink: have %d, had %d",
+				i, len(bs.Palette), before[i])
+		}
+
+		if len(bs.Data) != packedLen(bs.Bits(), sectionVolume) {
+			t.Errorf("section %d: data length mismatch: have %d, want %d",
+				i, len(bs.Data), packedLen(bs.Bits(), sectionVolume))
+		}
+	}
+
+	for pos, b := range want {
+		have := blockAt(t, &c, pos[0], pos[1], pos[2])
+		if !have.Equal(b) {
+			t.Fatalf("block mismatch at %v:\nWant: %+v\nHave: %+v", pos, b, have)
+		}
+	}
+}
+
+func TestCompactUniform(t *testing.T) {
+	var bs BlockStates
+
+	for i := 0; i < sectionVolume; i++ {
+		bs.Set(i, BlockState{Name: "minecraft:dirt"})
+	}
+
+	bs.Compact()
+
+	if len(bs.Palette) != 1 || bs.Palette[0].Name != "minecraft:dirt" {
+		t.Fatalf("unexpected palette: %+v", bs.Palette)
+	}
+
+	if len(bs.Data) != 0 {
+		t.Fatalf("expected no data for single entry palette; have %d longs", len(bs.Data))
+	}
+}
+
+func TestCompactLegacy(t *testing.T) {
+	palette := []BlockState{{Name: AirBlock}, {Name: "minecraft:stone"}, {Name: "minecraft:dirt"}}
+	data := append([]int64{0x10}, make([]int64, 255)...)
+
+	s := Section{Palette: palette, PackedStates: data}
+	s.Compact()
+
+	if !reflect.DeepEqual(s.Palette, palette) || !reflect.DeepEqual(s.PackedStates, data) || s.BlockStates != nil {
+		t.Fatalf("legacy section was changed: %+v", s)
+	}
+
+	// Once converted, the unused entry is removed.
+	s.SetState(2, 0, 0, BlockState{Name: "minecraft:stone"})
+	s.Compact()
+
+	if len(s.BlockStates.Palette) != 2 {
+		t.Fatalf("unexpected palette: %+v", s.BlockStates.Palette)
+	}
+
+	for x, want := range []string{AirBlock, "minecraft:stone", "minecraft:stone", AirBlock} {
+		if b, _ := s.State(x, 0, 0); b.Name != want {
+			t.Errorf("block %d: have %q, want %q", x, b.Name, want)
+		}
+	}
+}
+
+func blockAt(t *testing.T, c *Chunk, x, y, z int) BlockState {
+	s := c.paletteSection(y, false)
+	if s == nil {
+		t.Fatalf("missing section for y=%d", y)
+	}
+
+	b, ok := s.State(x, mod(y, BlocksPerSection), z)
+	if !ok {
+		t.Fatalf("no block state at %d %d %d", x, y, z)
+	}
+
+	return b
+}
+
+func TestChunkSetBiome(t *testing.T) {
+	var c Chunk
+	c.Init(0, 0)
+
+	biomes := []string{"minecraft:desert", "minecraft:forest", "minecraft:river"}
+
+	for y := -16; y < 16; y += BiomeCellSize {
+		for x := 0; x < BlocksPerChunk; x += BiomeCellSize {
+			for z := 0; z < BlocksPerChunk; z += BiomeCellSize {
+				if !c.SetBiome(x, y, z, biomes[(x+y+z+48)/BiomeCellSize%len(biomes)]) {
+					t.Fatalf("SetBiome(%d %d %d) failed", x, y, z)
+				}
+			}
+		}
+	}
+
+	for y := -16; y < 16; y++ {
+		for x := 0; x < BlocksPerChunk; x++ {
+			for z := 0; z < BlocksPerChunk; z++ {
+				cx, cy, cz := x&^3, floorDiv(y, BiomeCellSize)*BiomeCellSize, z&^3
+				want := biomes[(cx+cy+cz+48)/BiomeCellSize%len(biomes)]
+
+				if have, ok := c.Biome(x, y, z); !ok || have != want {
+					t.Fatalf("Biome(%d %d %d): have %q, want %q", x, y, z, have, want)
+				}
+			}
+		}
+	}
+
+	s := c.paletteSection(0, false)
+	if len(s.Biomes.Palette) != len(biomes) || s.Biomes.Bits() != 2 || len(s.Biomes.Data) != 2 {
+		t.Fatalf("unexpected palette: %+v", s.Biomes)
+	}
+
+	// Painting the whole section with a single biome collapses the palette.
+	for y := 0; y < BlocksPerSection; y += BiomeCellSize {
+		for x := 0; x < BlocksPerChunk; x += BiomeCellSize {
+			for z := 0; z < BlocksPerChunk; z += BiomeCellSize {
+				c.SetBiome(x, y, z, "minecraft:ocean")
+			}
+		}
+	}
+
+	if len(s.Biomes.Palette) != 1 || s.Biomes.Palette[0] != "minecraft:ocean" || s.Biomes.Data != nil {
+		t.Fatalf("palette not collapsed: %+v", s.Biomes)
+	}
+
+	if c.SetBiome(16, 0, 0, "minecraft:ocean") {
+		t.Fatalf("SetBiome accepted out of range coordinates")
+	}
+}
+
+func TestChunkBlockState(t *testing.T) {
+	var c Chunk
+	c.Init(0, 0)
+
+	stone := BlockState{Name: "minecraft:stone"}
+	log := BlockState{Name: "minecraft:oak_log", Properties: map[string]string{"axis": "z"}}
+
+	c.SetBlock(1, -3, 2, stone)
+	c.SetBlock(15, 40, 15, log)
+
+	tests := []struct {
+		x, y, z int
+		want    BlockState
+		ok      bool
+	}{
+		{1, -3, 2, stone, true},
+		{15, 40, 15, log, true},
+		{0, -3, 0, BlockState{Name: AirBlock}, true},
+		{16, 40, 15, BlockState{}, false},
+		{0, 100, 0, BlockState{}, false},
+	}
+
+	for _, tt := range tests {
+		have, ok := c.BlockState(tt.x, tt.y, tt.z)
+		if ok != tt.ok || !have.Equal(tt.want) {
+			t.Errorf("BlockState(%d, %d, %d): have %+v %v, want %+v %v",
+				tt.x, tt.y, tt.z, have, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLegacyPalette(t *testing.T) {
+	palette := make([]BlockState, 17)
+	for i := range palette {
+		palette[i] = BlockState{Name: fmt.Sprintf("minecraft:block_%d", i)}
+	}
+	palette[0].Name = AirBlock
+
+	index := func(i int) int { return (i * 7) % len(palette) }
+
+	// 17 entries need 5 bits per index. Minecraft 1.13 - 1.15 packs these
+	// back to back, spanning longs.
+	spanning := make([]int64, sectionVolume*5/64)
+	for i := 0; i < sectionVolume; i++ {
+		v := index(i)
+		for b := 0; b < 5; b++ {
+			if v&(1<<uint(b)) != 0 {
+				bit := i*5 + b
+				spanning[bit/64] |= 1 << uint(bit%64)
+			}
+		}
+	}
+
+	// Minecraft 1.16 and 1.17 pad each long instead.
+	padded := make([]int64, packedLen(5, sectionVolume))
+	for i := 0; i < sectionVolume; i++ {
+		packIndex(padded, 5, i, index(i))
+	}
+
+	var air int
+	for i := 0; i < sectionVolume; i++ {
+		if index(i) == 0 {
+			air++
+		}
+	}
+
+	for _, data := range [][]int64{spanning, padded} {
+		s := Section{Palette: palette, PackedStates: data}
+
+		for i := 0; i < sectionVolume; i++ {
+			x, y, z := i%16, i/256, (i/16)%16
+
+			have, ok := s.State(x, y, z)
+			if !ok || have.Name != palette[index(i)].Name {
+				t.Fatalf("%d longs: block %d: have %q, want %q",
+					len(data), i, have.Name, palette[index(i)].Name)
+			}
+		}
+
+		if n := s.BlockCount(); n != sectionVolume-air {
+			t.Errorf("%d longs: block count: have %d, want %d", len(data), n, sectionVolume-air)
+		}
+	}
+
+	// A single entry palette still uses 4 bits per index.
+	s := Section{
+		Palette:      []BlockState{{Name: "minecraft:stone"}},
+		PackedStates: make([]int64, 256),
+	}
+
+	if b, ok := s.State(3, 4, 5); !ok || b.Name != "minecraft:stone" {
+		t.Errorf("single entry palette: have %+v %v", b, ok)
+	}
+}
+
+func TestLegacySectionDecode(t *testing.T) {
+	var buf bytes.Buffer
+
+	// Section as written by Minecraft 1.13 - 1.17.
+	type legacy struct {
+		Y           byte         `nbt:"Y"`
+		Palette     []BlockState `nbt:"Palette"`
+		BlockStates []int64      `nbt:"BlockStates"`
+	}
+
+	err := nbt.Marshal(&buf, legacy{
+		Y:           2,
+		Palette:     []BlockState{{Name: AirBlock}, {Name: "minecraft:stone"}},
+		BlockStates: append([]int64{0x10}, make([]int64, 255)...),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s Section
+	if err = nbt.Unmarshal(&buf, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.BlockStates != nil || len(s.PackedStates) != 256 || len(s.Palette) != 2 {
+		t.Fatalf("unexpected section: %+v", s)
+	}
+
+	if b, _ := s.State(1, 0, 0); b.Name != "minecraft:stone" {
+		t.Errorf("block 1: have %q", b.Name)
+	}
+
+	if b, _ := s.State(0, 0, 0); b.Name != AirBlock {
+		t.Errorf("block 0: have %q", b.Name)
+	}
+}
+
+func TestSetBlockLegacy(t *testing.T) {
+	stone := BlockState{Name: "minecraft:stone"}
+	dirt := BlockState{Name: "minecraft:dirt"}
+
+	palette := make([]BlockState, 17)
+	for i := range palette {
+		palette[i] = BlockState{Name: fmt.Sprintf("minecraft:block_%d", i)}
+	}
+
+	index := func(i int) int { return (i * 7) % 18 } // Index 17 is out of range.
+
+	// 5 bits per index, spanning longs, as written by Minecraft 1.13 - 1.15.
+	spanning := make([]int64, sectionVolume*5/64)
+	for i := 0; i < sectionVolume; i++ {
+		v := index(i)
+		for b := 0; b < 5; b++ {
+			if v&(1<<uint(b)) != 0 {
+				bit := i*5 + b
+				spanning[bit/64] |= 1 << uint(bit%64)
+			}
+		}
+	}
+
+	blocks := make([]uint8, sectionVolume)
+	for i := range blocks {
+		blocks[i] = 1
+	}
+
+	var c Chunk
+	c.Sections = []Section{
+		{Y: 0, Palette: []BlockState{stone}, PackedStates: make([]int64, 256)},
+		{Y: 1, Palette: palette, PackedStates: spanning},
+		{Y: 2, Blocks: blocks, Data: make([]uint8, sectionVolume/2)},
+	}
+
+	want := []func(i int) string{
+		func(int) string { return stone.Name },
+		func(i int) string {
+			if n := index(i); n < len(palette) {
+				return palette[n].Name
+			}
+			return AirBlock
+		},
+		func(int) string { return stone.Name },
+	}
+
+	for sy := range c.Sections {
+		if !c.SetBlock(0, sy*16, 0, dirt) {
+			t.Fatalf("section %d: SetBlock failed", sy)
+		}
+
+		s := &c.Sections[sy]
+		if s.BlockStates == nil || s.Palette != nil || s.PackedStates != nil || s.Blocks != nil || s.Data != nil {
+			t.Fatalf("section %d: not converted: %+v", sy, s)
+		}
+
+		for i := 0; i < sectionVolume; i++ {
+			x, y, z := i%16, i/256, (i/16)%16
+
+			name := want[sy](i)
+			if i == 0 {
+				name = dirt.Name
+			}
+
+			if have, ok := c.BlockState(x, sy*16+y, z); !ok || have.Name != name {
+				t.Fatalf("section %d: block %d: have %q %v, want %q", sy, i, have.Name, ok, name)
+			}
+		}
+	}
+}
+
+// flatChunkFixture returns a chunk descriptor holding a chunk in the layout
+// written by Minecraft 1.18.2.
+func flatChunkFixture(t *testing.T) *ChunkDescriptor {
+	type section struct {
+		Y           int8         `nbt:"Y"`
+		BlockStates BlockStates  `nbt:"block_states"`
+		Biomes      BiomePalette `nbt:"biomes"`
+	}
+
+	// Layout of a chunk written by Minecraft 1.18.2: there is no Level
+	// tag, and the sections live in a lower case "sections" list.
+	raw := struct {
+		DataVersion int32     `nbt:"DataVersion"`
+		X           int32     `nbt:"xPos"`
+		Y           int32     `nbt:"yPos"`
+		Z           int32     `nbt:"zPos"`
+		Status      string    `nbt:"Status"`
+		Sections    []section `nbt:"sections"`
+	}{
+		DataVersion: 2975,
+		X:           -1,
+		Y:           -4,
+		Z:           2,
+		Status:      "minecraft:full",
+		Sections: []section{
+			{
+				Y:           -4,
+				BlockStates: BlockStates{Palette: []BlockState{{Name: "minecraft:deepslate"}}},
+				Biomes:      BiomePalette{Palette: []string{"minecraft:deep_ocean"}},
+			},
+			{
+				Y:           0,
+				BlockStates: BlockStates{Palette: []BlockState{{Name: AirBlock}}},
+				Biomes: BiomePalette{
+					Palette: []string{"minecraft:plains", "minecraft:river"},
+					Data:    []int64{0x2}, // Cell x=1, y=0, z=0 is a river.
+				},
+			},
+		},
+	}
+
+	cd := &ChunkDescriptor{X: -1, Z: 2, scheme: ZLib}
+	if err := cd.encode(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	return cd
+}
+
+func TestChunkBiomeAt(t *testing.T) {
+	cd := flatChunkFixture(t)
+
+	var c Chunk
+	if err := cd.read(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DataVersion != 2975 || c.X != -1 || c.Z != 2 || len(c.Sections) != 2 {
+		t.Fatalf("unexpected chunk: version %d, pos %d %d, %d sections",
+			c.DataVersion, c.X, c.Z, len(c.Sections))
+	}
+
+	tests := []struct {
+		x, y, z int
+		want    string
+	}{
+		{-16, -64, 32, "minecraft:deep_ocean"}, // Single entry palette, no data.
+		{-1, -49, 47, "minecraft:deep_ocean"},
+		{-16, 0, 32, "minecraft:plains"},
+		{-12, 3, 35, "minecraft:river"},
+		{-9, 0, 32, "minecraft:river"},
+		{-8, 0, 32, "minecraft:plains"},
+		{-12, 4, 32, "minecraft:plains"},
+		{-16, 16, 32, ""}, // No section.
+		{0, 0, 32, ""},    // Next chunk over.
+		{-16, 0, 48, ""},
+	}
+
+	for _, tt := range tests {
+		if have := c.BiomeAt(tt.x, tt.y, tt.z); have != tt.want {
+			t.Errorf("BiomeAt(%d, %d, %d): have %q, want %q", tt.x, tt.y, tt.z, have, tt.want)
+		}
+	}
+
+	if b, ok := c.BlockState(0, -64, 0); !ok || b.Name != "minecraft:deepslate" {
+		t.Errorf("BlockState(0, -64, 0): have %+v %v", b, ok)
+	}
+}
+
+func TestChunkWriteFlat(t *testing.T) {
+	var c Chunk
+	if err := flatChunkFixture(t).read(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	cd := ChunkDescriptor{X: -1, Z: 2, scheme: ZLib}
+	if !cd.Write(&c) {
+		t.Fatal("write failed")
+	}
+
+	data, err := cd.raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tree map[string]interface{}
+	if err = nbt.Unmarshal(bytes.NewReader(data), &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"Level", "Sections", "Biomes", "HeightMap", "TileEntities"} {
+		if _, ok := tree[name]; ok {
+			t.Errorf("unexpected tag %q", name)
+		}
+	}
+
+	if tree["DataVersion"] != int32(2975) || tree["yPos"] != int32(-4) || tree["Status"] != "minecraft:full" {
+		t.Errorf("unexpected tags: %v", tree)
+	}
+
+	sections, ok := tree["sections"].([]interface{})
+	if !ok {
+		t.Errorf("sections missing: %v", tree)
+	}
+
+	for _, v := range sections {
+		section, _ := v.(map[string]interface{})
+		for _, name := range []string{"Blocks", "Data", "BlockLight", "SkyLight"} {
+			if _, ok := section[name]; ok {
+				t.Errorf("unexpected section tag %q", name)
+			}
+		}
+	}
+
+	if _, ok := tree["block_entities"]; !ok {
+		t.Errorf("block_entities missing: %v", tree)
+	}
+
+	var again Chunk
+	if err = cd.read(&again); err != nil {
+		t.Fatal(err)
+	}
+
+	if again.DataVersion != 2975 || again.Y != -4 || again.Status != "minecraft:full" || len(again.Sections) != 2 {
+		t.Fatalf("unexpected chunk: %+v", again)
+	}
+
+	if b := again.BiomeAt(-12, 3, 35); b != "minecraft:river" {
+		t.Errorf("biome: have %q", b)
+	}
+
+	if b, ok := again.BlockState(0, -64, 0); !ok || b.Name != "minecraft:deepslate" {
+		t.Errorf("BlockState(0, -64, 0): have %+v %v", b, ok)
+	}
+}
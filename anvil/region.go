@@ -4,14 +4,24 @@
 package anvil
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"io/ioutil"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
 )
 
 const (
@@ -32,17 +42,28 @@ const (
 
 	// Defines the byte size of a single sector.
 	sectorSize = 4096
+
+	// Defines the byte size of the length and compression scheme
+	// which precede every chunk's data.
+	chunkHeaderSize = 5
+
+	// Defines the largest number of sectors a chunk can take up in the
+	// region file. Larger chunks are stored in a separate .mcc file.
+	maxChunkSectors = 255
 )
 
 // RegionCoords returns the x and z coordinates associated with the
 // given region file.
 //
 // The name should be in the form 'r.<X>.<Z>.mca', where X and Z are
-// the signed integer coordinates.
+// the signed integer coordinates. It may be preceded by a directory, using
+// either forward slashes, as in io/fs paths, or the OS path separator.
 //
 // Returns false if the coordinates could not be determined.
 func RegionCoords(name string) (int, int, bool) {
-	_, name = filepath.Split(name)
+	if n := strings.LastIndexAny(name, "/"+string(filepath.Separator)); n > -1 {
+		name = name[n+1:]
+	}
 
 	elem := strings.Split(name, ".")
 	if len(elem) != 4 {
@@ -54,12 +75,149 @@ func RegionCoords(name string) (int, int, bool) {
 	return int(x), int(z), ex == nil && ez == nil
 }
 
+// RegionKind defines the type of data held by the chunks in a region.
+// All kinds share the same file format, but their chunks use different
+// NBT schemas.
+type RegionKind uint8
+
+// Known region kinds.
+const (
+	RegionTerrain  RegionKind = iota // Block data, in region/. Decodes into Chunk.
+	RegionEntities                   // Entities, in entities/. Decodes into EntityChunk.
+	RegionPoi                        // Points of interest, in poi/.
+)
+
+func (k RegionKind) String() string {
+	switch k {
+	case RegionTerrain:
+		return "Terrain"
+	case RegionEntities:
+		return "Entities"
+	case RegionPoi:
+		return "Poi"
+	}
+
+	return fmt.Sprintf("RegionKind(%d)", k)
+}
+
+// RegionKindOf returns the kind of region stored in the given file. This
+// is determined by the name of the directory holding it, which is either
+// "entities", "poi" or, for terrain, anything else. Like RegionCoords, it
+// accepts both slash and OS separated paths.
+func RegionKindOf(name string) RegionKind {
+	const separators = "/" + string(filepath.Separator)
+
+	n := strings.LastIndexAny(name, separators)
+	if n == -1 {
+		return RegionTerrain
+	}
+
+	dir := name[:n]
+	if n = strings.LastIndexAny(dir, separators); n > -1 {
+		dir = dir[n+1:]
+	}
+
+	switch dir {
+	case entitiesDir:
+		return RegionEntities
+	case poiDir:
+		return RegionPoi
+	}
+
+	return RegionTerrain
+}
+
+// RegionInfo is like RegionCoords, but also determines the dimension and
+// kind of the region stored in the given file, judging by the directories
+// holding it. The file must be in a "region", "entities" or "poi"
+// directory, as in "world/DIM-1/entities/r.0.0.mca".
+//
+// The dimension is returned as its id, like "minecraft:the_nether" or
+// "mypack:mining" for a datapack dimension. Files outside of DIM-1, DIM1
+// and dimensions/<namespace>/<path> belong to the overworld.
+func RegionInfo(name string) (dim string, kind RegionKind, x, z int, err error) {
+	x, z, ok := RegionCoords(name)
+	if !ok {
+		return "", 0, 0, 0, fmt.Errorf("anvil: region info %q: invalid file name", name)
+	}
+
+	dirs := strings.Split(path.Dir(filepath.ToSlash(name)), "/")
+	n := len(dirs) - 1
+
+	switch dirs[n] {
+	case regionDir:
+		kind = RegionTerrain
+	case entitiesDir:
+		kind = RegionEntities
+	case poiDir:
+		kind = RegionPoi
+	default:
+		return "", 0, 0, 0, fmt.Errorf("anvil: region info %q: not in a region, entities or poi directory", name)
+	}
+
+	return dimensionOf(dirs[:n]), kind, x, z, nil
+}
+
+// dimensionOf returns the id of the dimension stored in the directory
+// made up of the given path elements.
+func dimensionOf(dirs []string) string {
+	if n := len(dirs); n > 0 {
+		switch dirs[n-1] {
+		case "DIM-1":
+			return NetherId
+		case "DIM1":
+			return EndId
+		}
+	}
+
+	// The path of a datapack dimension may have several elements, so look
+	// for the innermost dimensions directory followed by at least two.
+	for i := len(dirs) - 3; i >= 0; i-- {
+		if dirs[i] != customDimensions {
+			continue
+		}
+
+		id := dirs[i+1] + ":" + strings.Join(dirs[i+2:], "/")
+		if _, ok := DimensionById(id); ok {
+			return id
+		}
+	}
+
+	return OverworldId
+}
+
+// ErrChunkAbsent is returned when reading a chunk which is not present
+// in its region, because it has not been generated yet.
+var ErrChunkAbsent = errors.New("anvil: chunk not present")
+
 // A region describes chunks with block data in a Minecraft world.
+//
+// Methods which only read chunks, like ReadChunk, DecodeChunk, ExportChunk
+// and ChunkLengths, are safe for concurrent use, as long as every goroutine
+// decodes into a Chunk of its own. Every read works on an independent
+// reader over the chunk's compressed data; lazily loaded regions read that
+// data from the file once, under a lock. Methods which change the region,
+// like WriteChunk, DeleteChunk and Save, must not run at the same time as
+// any other call.
 type Region struct {
-	file   string                 // Input file for this region.
-	chunks [1024]*ChunkDescriptor // Chunk definitions in this region.
-	X      int                    // Region's X coordinate.
-	Z      int                    // Region's Z coordinate.
+	file        string                 // Input file for this region.
+	fsys        fs.FS                  // File system holding file, if not the OS.
+	chunks      [1024]*ChunkDescriptor // Chunk definitions in this region.
+	size        int                    // Minimum file size in sectors, set by Grow.
+	dataVersion int32                  // Data version for written chunks without one.
+	compression byte                   // Compression scheme for written chunks, if set.
+	kind        RegionKind             // Type of data held by the chunks.
+	fileSize    int                    // Size of the file in sectors, as last loaded or saved.
+	fd          *os.File               // Open file of a lazily loaded region.
+	X           int                    // Region's X coordinate.
+	Z           int                    // Region's Z coordinate.
+}
+
+// NewRegion returns an empty region with the given region coordinates. It
+// exists only in memory until it is written with SaveAs, as it has no file
+// of its own; Save returns an error.
+func NewRegion(x, z int) *Region {
+	return &Region{X: x, Z: z}
 }
 
 // CreateRegion creates an empty region file at the given location.
@@ -71,174 +229,1111 @@ func CreateRegion(file string) (*Region, error) {
 		return nil, fmt.Errorf("anvil: create region: invalid file name")
 	}
 
-	fd, err := os.Create(file)
+	fd, err := os.Create(file)
+	if err != nil {
+		return nil, fmt.Errorf("anvil: create region: %v", err)
+	}
+
+	var buf [sectorSize * 2]byte
+	_, err = fd.Write(buf[:])
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("anvil: create region: %v", err)
+	}
+
+	fd.Close()
+
+	return LoadRegion(file)
+}
+
+// LoadRegion opens a region from the given file. All chunk data is read
+// into memory and the file is closed again before LoadRegion returns, so
+// the region holds no open files. Use LoadRegionLazy to read chunks from
+// the file as they are needed instead.
+func LoadRegion(file string) (*Region, error) {
+	rx, rz, ok := RegionCoords(file)
+	if !ok {
+		return nil, fmt.Errorf("anvil: open region: invalid file %q", file)
+	}
+
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("anvil: r(%d %d): %v", rx, rz, err)
+	}
+
+	defer fd.Close()
+
+	r := &Region{
+		file: file,
+		kind: RegionKindOf(file),
+		X:    rx,
+		Z:    rz,
+	}
+
+	return r, r.load(fd)
+}
+
+// LoadRegionLazy opens a region from the given file, but only reads its
+// header. The data of a chunk is read from the file when it is first
+// needed, and then kept in memory. This saves time and memory for tools
+// which only look at a few chunks of each region.
+//
+// The file stays open until Region.Close is called. Any call which needs
+// chunk data that was not read yet fails after that, including Save and
+// SaveAs, which read all remaining chunks first.
+func LoadRegionLazy(file string) (*Region, error) {
+	rx, rz, ok := RegionCoords(file)
+	if !ok {
+		return nil, fmt.Errorf("anvil: open region: invalid file %q", file)
+	}
+
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("anvil: r(%d %d): %v", rx, rz, err)
+	}
+
+	r := &Region{
+		file: file,
+		kind: RegionKindOf(file),
+		fd:   fd,
+		X:    rx,
+		Z:    rz,
+	}
+
+	err = r.load(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Close closes the file held open by a region loaded through
+// LoadRegionLazy. Chunks which were read before remain available. For
+// other regions, which hold no open files, Close does nothing.
+func (r *Region) Close() error {
+	if r.fd == nil {
+		return nil
+	}
+
+	err := r.fd.Close()
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d): %v", r.X, r.Z, err)
+	}
+
+	return nil
+}
+
+// LoadRegionFS opens a region from the given file in fsys. The name is
+// a slash separated path, as used by io/fs. If the file does not support
+// seeking, it is read into memory first.
+//
+// Such a region is read-only: Region.Save returns an error. Use
+// Region.SaveAs to write it to a file on disk.
+func LoadRegionFS(fsys fs.FS, name string) (*Region, error) {
+	rx, rz, ok := RegionCoords(name)
+	if !ok {
+		return nil, fmt.Errorf("anvil: open region: invalid file %q", name)
+	}
+
+	rs, err := openFS(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("anvil: r(%d %d): %v", rx, rz, err)
+	}
+
+	defer rs.Close()
+
+	r := &Region{
+		file: name,
+		fsys: fsys,
+		kind: RegionKindOf(name),
+		X:    rx,
+		Z:    rz,
+	}
+
+	return r, r.load(rs)
+}
+
+// readSeekCloser is a file which supports seeking.
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// openFS opens the given file in fsys for seeking. Files which can not
+// seek themselves are read into memory.
+func openFS(fsys fs.FS, name string) (readSeekCloser, error) {
+	fd, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if rs, ok := fd.(readSeekCloser); ok {
+		return rs, nil
+	}
+
+	data, err := ioutil.ReadAll(fd)
+	fd.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+// nopCloser adds a no-op Close method to an io.ReadSeeker.
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// load reads all chunk descriptors from the given region data.
+func (r *Region) load(rs io.ReadSeeker) error {
+	// Read header data.
+	locations, timestamps, err := readHeader(rs)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d): read header: %v", r.X, r.Z, err)
+	}
+
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d): %v", r.X, r.Z, err)
+	}
+
+	r.fileSize = int((end + sectorSize - 1) / sectorSize)
+
+	// Load up all valid chunk descriptors.
+	for x := 0; x < ChunksPerRegion; x++ {
+		for z := 0; z < ChunksPerRegion; z++ {
+			offset, sectors := readOffset(locations, x, z)
+
+			// If either are zero, this chunk has not been generated yet.
+			if offset == 0 && sectors == 0 {
+				continue
+			}
+
+			n := chunkIndex(x, z)
+
+			if r.fd != nil {
+				r.chunks[n] = r.lazyChunk(x, z, offset, sectors, timestamps)
+				continue
+			}
+
+			r.chunks[n], err = readChunk(rs, x, z, offset, sectors, timestamps)
+			if err == nil && r.chunks[n].scheme&External != 0 {
+				err = r.readExternal(r.chunks[n])
+			}
+
+			if err != nil {
+				return fmt.Errorf("anvil: r(%d %d) c(%d %d): read chunk: %v",
+					r.X, r.Z, x, z, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// lazyChunk returns a descriptor for the given chunk, which reads its data
+// from the region's open file on first use.
+func (r *Region) lazyChunk(x, z, offset, sectors int, timestamps []byte) *ChunkDescriptor {
+	cd := &ChunkDescriptor{
+		X:            x,
+		Z:            z,
+		LastModified: readTimestamp(timestamps, x, z),
+		sectors:      sectors,
+		offset:       offset,
+	}
+
+	cd.load = func(cd *ChunkDescriptor) error {
+		// Chunks may be read concurrently, so they do not share the
+		// file's read offset.
+		rs := io.NewSectionReader(r.fd, 0, math.MaxInt64)
+
+		err := cd.readData(rs, offset)
+		if err == nil && cd.scheme&External != 0 {
+			err = r.readExternal(cd)
+		}
+
+		if err != nil {
+			cd.data = nil
+		}
+
+		return err
+	}
+
+	return cd
+}
+
+// Kind returns the type of data held by the region's chunks, as derived
+// from the name of the directory it was loaded from.
+func (r *Region) Kind() RegionKind { return r.kind }
+
+// Save writes all region data to the underlying file.
+// Returns an error if the region was loaded through LoadRegionFS, or made
+// by NewRegion.
+func (r *Region) Save() error {
+	if r.fsys != nil {
+		return fmt.Errorf("anvil: r(%d %d): region is read-only", r.X, r.Z)
+	}
+
+	if len(r.file) == 0 {
+		return fmt.Errorf("anvil: r(%d %d): region has no file, use SaveAs", r.X, r.Z)
+	}
+
+	return r.SaveAs(r.file)
+}
+
+// SaveAs writes all region data to the given file, rather than the file
+// the region was loaded from. Subsequent calls to Save still write to the
+// original file.
+func (r *Region) SaveAs(file string) error {
+	// Creating the file may truncate the one a lazy region reads from.
+	for _, cd := range r.chunks {
+		if cd == nil {
+			continue
+		}
+
+		err := cd.fill()
+		if err != nil {
+			return fmt.Errorf("anvil: r(%d %d) c(%d %d): read chunk: %v", r.X, r.Z, cd.X, cd.Z, err)
+		}
+	}
+
+	fd, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d): %v", r.X, r.Z, err)
+	}
+
+	defer fd.Close()
+
+	err = writeHeader(fd, r.chunks[:])
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d): %v", r.X, r.Z, err)
+	}
+
+	offset := 2 // Skip first two offsets for header data.
+
+	for _, cd := range r.chunks {
+		if cd == nil {
+			continue
+		}
+
+		err = r.writeExternal(filepath.Dir(file), cd)
+		if err != nil {
+			return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, cd.X, cd.Z, err)
+		}
+
+		err = writeChunk(fd, cd, offset)
+		if err != nil {
+			return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, cd.X, cd.Z, err)
+		}
+
+		cd.sectors, err = cd.sectorCount()
+		if err != nil {
+			return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, cd.X, cd.Z, err)
+		}
+
+		cd.offset = offset
+		offset += cd.sectors
+	}
+
+	// Keep the space reserved through Region.Grow.
+	if offset < r.size {
+		err = fd.Truncate(int64(r.size) * sectorSize)
+		if err != nil {
+			return fmt.Errorf("anvil: r(%d %d): %v", r.X, r.Z, err)
+		}
+
+		offset = r.size
+	}
+
+	r.fileSize = offset
+	return fd.Close()
+}
+
+// externalName returns the name of the file holding the data of the given
+// chunk, if it is too large for the region file.
+func (r *Region) externalName(cd *ChunkDescriptor) string {
+	x := r.X*ChunksPerRegion + cd.X&(ChunksPerRegion-1)
+	z := r.Z*ChunksPerRegion + cd.Z&(ChunksPerRegion-1)
+	return fmt.Sprintf("c.%d.%d.mcc", x, z)
+}
+
+// readExternal loads the data of a chunk with the External flag from its
+// .mcc file, next to the region file. If that file does not exist, the
+// chunk keeps the flag, so saving the region retains the reference.
+func (r *Region) readExternal(cd *ChunkDescriptor) error {
+	var data []byte
+	var err error
+
+	if r.fsys != nil {
+		data, err = fs.ReadFile(r.fsys, path.Join(path.Dir(r.file), r.externalName(cd)))
+	} else {
+		data, err = ioutil.ReadFile(filepath.Join(filepath.Dir(r.file), r.externalName(cd)))
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	cd.data = data
+	cd.scheme &^= External
+	cd.external = true
+	return nil
+}
+
+// writeExternal writes the data of a chunk which does not fit in the
+// region file to its .mcc file in dir. If the chunk was stored in such a
+// file next to the region file before, but fits in the region now, the
+// file is removed when saving in place. A copy saved elsewhere leaves it
+// alone, since the region file on disk still refers to it.
+func (r *Region) writeExternal(dir string, cd *ChunkDescriptor) error {
+	if cd.scheme&External != 0 {
+		return nil // The external file is missing.
+	}
+
+	file := filepath.Join(dir, r.externalName(cd))
+	inPlace := sameDir(dir, filepath.Dir(r.file))
+
+	external, err := cd.isExternal()
+	if err != nil {
+		return err
+	}
+
+	if external {
+		if inPlace {
+			cd.external = true
+		}
+		return ioutil.WriteFile(file, cd.data, 0644)
+	}
+
+	if !cd.external || !inPlace {
+		return nil
+	}
+
+	cd.external = false
+
+	err = os.Remove(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// sameDir returns true if a and b name the same directory.
+func sameDir(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	aa, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+
+	ab, err := filepath.Abs(b)
+	return err == nil && aa == ab
+}
+
+// Grow extends the region file by the given number of sectors, which are
+// then free for chunks to use. A tool which is about to add many chunks
+// can call this up front, so the file does not have to grow with every
+// save.
+//
+// Region.Save writes all chunks back to back, so they take up the free
+// space as they are added. The file never shrinks below the size set by
+// Grow, even if chunks are removed, until Compact is called.
+func (r *Region) Grow(sectors int) error {
+	if sectors <= 0 {
+		return nil
+	}
+
+	if r.fsys != nil {
+		return fmt.Errorf("anvil: r(%d %d): region is read-only", r.X, r.Z)
+	}
+
+	fi, err := os.Stat(r.file)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d): grow: %v", r.X, r.Z, err)
+	}
+
+	size := int((fi.Size() + sectorSize - 1) / sectorSize)
+	if used := r.usedSectors(); size < used {
+		size = used
+	}
+
+	if size < r.size {
+		size = r.size
+	}
+
+	size += sectors
+
+	err = os.Truncate(r.file, int64(size)*sectorSize)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d): grow: %v", r.X, r.Z, err)
+	}
+
+	r.size = size
+	r.fileSize = size
+	return nil
+}
+
+// Compact writes the region to its file with all chunks packed tightly
+// from the first sector after the header, and releases any space reserved
+// through Grow. The location table is rebuilt and all timestamps are kept.
+//
+// Region.Save packs chunks the same way, so files it writes only grow
+// past their minimum size through Grow. Compact is meant for files which
+// were fragmented by other tools, or which no longer need the reserved
+// space. Returns the same errors as Save.
+func (r *Region) Compact() error {
+	if r.fsys != nil || len(r.file) == 0 {
+		return r.Save()
+	}
+
+	r.size = 0
+	return r.SaveAs(r.file)
+}
+
+// RegionStats describes how the chunks of a region are laid out in its
+// file. See Region.Stats.
+type RegionStats struct {
+	Chunks         int    // Number of chunks present in the region.
+	FileSectors    int    // Size of the file in sectors, including the 2 header sectors.
+	UsedSectors    int    // Sectors holding the header or chunk data.
+	FreeSectors    int    // Sectors holding neither: gaps between chunks and space at the end.
+	External       int    // Chunks stored in .mcc files, as far as they have been read.
+	Largest        [2]int // Coordinates of the chunk taking up the most sectors.
+	LargestSectors int    // Number of sectors taken up by Largest.
+}
+
+// Stats returns statistics about the region file, as it was last loaded or
+// saved. It follows the location table, so after editing a region it does
+// not reflect changes until the next Save. Chunks written since then have
+// no place in the file yet; they are only counted in Chunks.
+//
+// FreeSectors shows how much space Compact would reclaim, not counting
+// space reserved through Grow.
+func (r *Region) Stats() RegionStats {
+	var st RegionStats
+
+	st.FileSectors = r.fileSize
+	used := make([]bool, r.fileSize)
+
+	for i := 0; i < 2 && i < len(used); i++ {
+		used[i] = true
+	}
+
+	for _, cd := range r.chunks {
+		if cd == nil {
+			continue
+		}
+
+		st.Chunks++
+
+		if cd.offset == 0 {
+			continue
+		}
+
+		// This does not read the data of lazily loaded chunks.
+		if cd.external || cd.scheme&External != 0 {
+			st.External++
+		}
+
+		if cd.sectors > st.LargestSectors {
+			st.Largest = [2]int{cd.X, cd.Z}
+			st.LargestSectors = cd.sectors
+		}
+
+		for n := cd.offset; n < cd.offset+cd.sectors && n < len(used); n++ {
+			used[n] = true
+		}
+	}
+
+	for _, ok := range used {
+		if ok {
+			st.UsedSectors++
+		}
+	}
+
+	st.FreeSectors = st.FileSectors - st.UsedSectors
+	return st
+}
+
+// usedSectors returns the number of sectors needed to save the region,
+// including the header.
+func (r *Region) usedSectors() int {
+	n := 2
+
+	for _, cd := range r.chunks {
+		if cd != nil {
+			n += cd.SectorCount()
+		}
+	}
+
+	return n
+}
+
+// Clear removes all blocks and all chunks from the region.
+// Note that Region.Save() must be called to persist these changes.
+func (r *Region) Clear() {
+	for i := range r.chunks {
+		if r.chunks[i] == nil {
+			continue
+		}
+
+		r.chunks[i].X = 0
+		r.chunks[i].Z = 0
+		r.chunks[i].scheme = 0
+		r.chunks[i].data = nil
+		r.chunks[i].load = nil
+		r.chunks[i] = nil
+	}
+}
+
+// ChunkLen returns the number of /valid/ chunks in this region.
+// Meaning chunks which have actually been generated and have data.
+func (r *Region) ChunkLen() int {
+	var count int
+
+	for _, cd := range r.chunks {
+		if cd != nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Chunks yields a list of chunk X/Z cooridnates for all valid chunks in
+// this region. There are a maximum of 1024 chunks per region.
+func (r *Region) Chunks() [][2]int {
+	out := make([][2]int, 0, len(r.chunks))
+
+	for _, cd := range r.chunks {
+		if cd != nil {
+			out = append(out, [2]int{cd.X, cd.Z})
+		}
+	}
+
+	return out
+}
+
+// ChangedSince yields the X/Z coordinates of all valid chunks in this
+// region which were modified after t. This only looks at the timestamps
+// in the region header; no chunk data is decompressed.
+//
+// Timestamps are stored with a resolution of one second.
+func (r *Region) ChangedSince(t time.Time) [][2]int {
+	var out [][2]int
+
+	for _, cd := range r.chunks {
+		if cd != nil && cd.LastModified.After(t) {
+			out = append(out, [2]int{cd.X, cd.Z})
+		}
+	}
+
+	return out
+}
+
+// ChunkTimestamp returns the time at which the given chunk was last
+// modified, as stored in the region header. WriteChunk sets it to the
+// current time. Returns false if the chunk does not exist.
+func (r *Region) ChunkTimestamp(x, z int) (time.Time, bool) {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return time.Time{}, false
+	}
+
+	return cd.LastModified, true
+}
+
+// SetChunkTimestamp sets the time at which the given chunk was last
+// modified. It is persisted by the next call to Save, with a resolution
+// of one second. Returns false if the chunk does not exist.
+func (r *Region) SetChunkTimestamp(x, z int, t time.Time) bool {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return false
+	}
+
+	cd.LastModified = t
+	return true
+}
+
+// HasChunk returns true if the given chunk exists in this region.
+// That is, it has been generated and contains data. It takes the same
+// chunk coordinates as ReadChunk. This only looks at the location table,
+// so it is cheap, even for a region loaded with LoadRegionLazy.
+func (r *Region) HasChunk(x, z int) bool {
+	n := chunkIndex(x, z)
+	return r.chunks[n] != nil
+}
+
+// DeleteChunk removes the given chunk from the region. Its location in
+// the header is cleared on the next save, and since Region.Save writes
+// all remaining chunks back to back, the sectors it used are taken up by
+// the chunks which follow it, or by chunks added later. An external .mcc
+// file holding the chunk's data is left in place.
+//
+// Returns false if the chunk does not exist.
+func (r *Region) DeleteChunk(x, z int) bool {
+	n := chunkIndex(x, z)
+	if r.chunks[n] == nil {
+		return false
+	}
+
+	r.chunks[n] = nil
+	return true
+}
+
+// ChunkLengths returns the size of the given chunk, as recorded in the
+// region file. The first value is the length prefix stored in front of
+// the chunk data, which includes the compression scheme byte. The second
+// is the number of 4KiB sectors reserved for the chunk in the header.
+//
+// A chunk whose payload, plus its 4 byte length prefix, does not fit in
+// the reserved sectors indicates a corrupt region file. For chunks which
+// were written since the region was loaded or saved, the sector count is
+// the one the next call to Save will write. Chunks stored in an external
+// .mcc file have a length of 1, for the compression scheme alone.
+//
+// Returns false if the chunk does not exist, or the data of a lazily
+// loaded chunk can not be read.
+func (r *Region) ChunkLengths(x, z int) (payloadLen int, sectorLen int, ok bool) {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return 0, 0, false
+	}
+
+	external, err := cd.isExternal()
 	if err != nil {
-		return nil, fmt.Errorf("anvil: create region: %v", err)
+		return 0, 0, false
 	}
 
-	var buf [sectorSize * 2]byte
-	_, err = fd.Write(buf[:])
-	if err != nil {
-		fd.Close()
-		return nil, fmt.Errorf("anvil: create region: %v", err)
+	sectorLen = cd.sectors
+	if sectorLen == 0 {
+		sectorLen = cd.SectorCount()
 	}
 
-	fd.Close()
+	if external {
+		return 1, sectorLen, true
+	}
 
-	return LoadRegion(file)
+	return len(cd.data) + 1, sectorLen, true
 }
 
-// LoadRegion opens a region from the given file.
-func LoadRegion(file string) (*Region, error) {
-	var err error
-
-	rx, rz, ok := RegionCoords(file)
-	if !ok {
-		return nil, fmt.Errorf("anvil: open region: invalid file %q", file)
+// ReadChunkStats returns the size of the given chunk's data, as stored and
+// after decompression, along with its compression scheme. The data is
+// decompressed to find its length, but it is not decoded. Unusually large
+// chunks often hold huge numbers of entities or block entities.
+//
+// Returns false if the chunk does not exist, or its data can not be
+// decompressed. The stored length and the scheme are set in the latter case.
+func (r *Region) ReadChunkStats(x, z int) (compressedLen, decompressedLen int, compression byte, ok bool) {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return 0, 0, 0, false
 	}
 
-	fd, err := os.Open(file)
+	rc, err := cd.reader()
 	if err != nil {
-		return nil, fmt.Errorf("anvil: r(%d %d): %v", rx, rz, err)
+		return len(cd.data), 0, cd.scheme, false
 	}
 
-	defer fd.Close()
+	n, err := io.Copy(ioutil.Discard, rc)
+	rc.Close()
 
-	// Read header data.
-	locations, timestamps, err := readHeader(fd)
 	if err != nil {
-		return nil, fmt.Errorf("anvil: r(%d %d): read header: %v", rx, rz, err)
+		return len(cd.data), 0, cd.scheme, false
 	}
 
-	r := &Region{
-		file: file,
-		X:    rx,
-		Z:    rz,
+	return len(cd.data), int(n), cd.scheme, true
+}
+
+// ReadChunk reads chunk data for the given coordinates into the specified
+// structure.
+//
+// The slices already held by c are reused where possible. This keeps
+// allocations down when reading many chunks into the same value. Note that
+// this overwrites any data those slices point to.
+//
+// Returns false if there is no valid chunk available, or the chunk data can
+// not be decompressed.
+func (r *Region) ReadChunk(x, z int, c *Chunk) bool {
+	n := chunkIndex(x, z)
+
+	if r.chunks[n] == nil {
+		return false
 	}
 
-	// Load up all valid chunk descriptors.
-	for x := 0; x < ChunksPerRegion; x++ {
-		for z := 0; z < ChunksPerRegion; z++ {
-			offset, sectors := readOffset(locations, x, z)
+	return r.chunks[n].Read(c)
+}
 
-			// If either are zero, this chunk has not been generated yet.
-			if offset == 0 && sectors == 0 {
-				continue
-			}
+// DecodeChunk is like ReadChunk, but returns an error describing why the
+// chunk could not be read. Returns ErrChunkAbsent if the region does not
+// hold the chunk.
+func (r *Region) DecodeChunk(x, z int, c *Chunk) error {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return ErrChunkAbsent
+	}
 
-			n := chunkIndex(x, z)
-			r.chunks[n], err = readChunk(fd, x, z, offset, timestamps)
-			if err != nil {
-				return nil, fmt.Errorf("anvil: r(%d %d) c(%d %d): read chunk: %v",
-					rx, rz, x, z, err)
-			}
-		}
+	err := cd.read(c)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): read chunk: %v", r.X, r.Z, cd.X, cd.Z, err)
 	}
 
-	return r, nil
+	return nil
 }
 
-// Save writes all region data to the underlying file.
-func (r *Region) Save() error {
-	fd, err := os.Create(r.file)
+// ChunkDataVersion returns the data version of the given chunk, which
+// identifies the version of the game that wrote it. Pass it to
+// VersionForDataVersion for a readable name. Only this one value is
+// decoded; the rest of the chunk is skipped. Returns 0 for chunks written
+// before Minecraft 1.9, which have no data version.
+//
+// Returns ErrChunkAbsent if the region does not hold the chunk.
+func (r *Region) ChunkDataVersion(x, z int) (int32, error) {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return 0, ErrChunkAbsent
+	}
+
+	v, err := cd.readDataVersion()
 	if err != nil {
-		return fmt.Errorf("anvil: r(%d %d): %v", r.X, r.Z, err)
+		return 0, fmt.Errorf("anvil: r(%d %d) c(%d %d): read data version: %v", r.X, r.Z, cd.X, cd.Z, err)
 	}
 
-	defer fd.Close()
+	return v, nil
+}
 
-	err = writeHeader(fd, r.chunks[:])
+// ChunkPosition returns the chunk coordinates stored in the given chunk.
+// Only these values are decoded; the rest of the chunk is skipped. X and z
+// are absolute chunk coordinates, so for an intact chunk they match the
+// region's coordinates times 32, plus the chunk's position in the region.
+// Refer to Chunk.Position for y.
+//
+// Returns ErrChunkAbsent if the region does not hold the chunk.
+func (r *Region) ChunkPosition(x, z int) (cx, cy, cz int32, err error) {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return 0, 0, 0, ErrChunkAbsent
+	}
+
+	cx, cy, cz, err = cd.readPosition()
 	if err != nil {
-		return fmt.Errorf("anvil: r(%d %d): %v", r.X, r.Z, err)
+		return 0, 0, 0, fmt.Errorf("anvil: r(%d %d) c(%d %d): read position: %v", r.X, r.Z, cd.X, cd.Z, err)
 	}
 
-	offset := 2 // Skip first two offsets for header data.
+	return cx, cy, cz, nil
+}
 
+// BiomeSet adds the name of every biome used by the chunks in this region
+// to set. This only reads the biome palettes of paletted sections, as
+// written by Minecraft 1.18+, so it is a lot cheaper than decoding every
+// chunk. Note that a palette may hold biomes which are no longer used.
+func (r *Region) BiomeSet(set map[string]bool) error {
 	for _, cd := range r.chunks {
 		if cd == nil {
 			continue
 		}
 
-		err = writeChunk(fd, cd, offset)
+		err := cd.readBiomes(set)
 		if err != nil {
-			return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, cd.X, cd.Z, err)
+			return fmt.Errorf("anvil: r(%d %d) c(%d %d): read biomes: %v", r.X, r.Z, cd.X, cd.Z, err)
 		}
-
-		offset += cd.SectorCount()
 	}
 
 	return nil
 }
 
-// Clear removes all blocks and all chunks from the region.
-// Note that Region.Save() must be called to persist these changes.
-func (r *Region) Clear() {
-	for i := range r.chunks {
-		if r.chunks[i] == nil {
+// EachChunk calls fn for every valid chunk in this region, in the order in
+// which they are stored in the region header.
+//
+// A chunk which can not be decoded does not abort the iteration. Instead,
+// fn receives a nil chunk along with the decode error, so the caller can
+// decide whether to continue. Iteration stops as soon as fn returns a
+// non-nil error, which is then returned by EachChunk.
+//
+// The x and z values are the chunk coordinates as stored in the region.
+func (r *Region) EachChunk(fn func(x, z int, c *Chunk, err error) error) error {
+	for _, cd := range r.chunks {
+		if cd == nil {
 			continue
 		}
 
-		r.chunks[i].X = 0
-		r.chunks[i].Z = 0
-		r.chunks[i].scheme = 0
-		r.chunks[i].data = nil
-		r.chunks[i] = nil
-	}
-}
+		var c Chunk
 
-// ChunkLen returns the number of /valid/ chunks in this region.
-// Meaning chunks which have actually been generated and have data.
-func (r *Region) ChunkLen() int {
-	var count int
+		err := cd.read(&c)
+		if err != nil {
+			err = fn(cd.X, cd.Z, nil, fmt.Errorf("anvil: r(%d %d) c(%d %d): read chunk: %v",
+				r.X, r.Z, cd.X, cd.Z, err))
+		} else {
+			err = fn(cd.X, cd.Z, &c, nil)
+		}
 
-	for _, cd := range r.chunks {
-		if cd != nil {
-			count++
+		if err != nil {
+			return err
 		}
 	}
 
-	return count
+	return nil
 }
 
-// Chunks yields a list of chunk X/Z cooridnates for all valid chunks in
-// this region. There are a maximum of 1024 chunks per region.
-func (r *Region) Chunks() [][2]int {
-	out := make([][2]int, 0, len(r.chunks))
+// WalkChunks calls fn for every valid chunk in this region, in the order in
+// which they are stored in the region header. Unlike EachChunk, all chunks
+// are decoded into the same value, as described for ReadChunk. fn must
+// therefore not retain c, or any of the slices it holds, past the call.
+//
+// Iteration stops at the first chunk which can not be decoded, or as soon
+// as fn returns a non-nil error. That error is then returned by WalkChunks.
+func (r *Region) WalkChunks(fn func(x, z int, c *Chunk) error) error {
+	var c Chunk
 
 	for _, cd := range r.chunks {
-		if cd != nil {
-			out = append(out, [2]int{cd.X, cd.Z})
+		if cd == nil {
+			continue
+		}
+
+		err := cd.read(&c)
+		if err != nil {
+			return fmt.Errorf("anvil: r(%d %d) c(%d %d): read chunk: %v", r.X, r.Z, cd.X, cd.Z, err)
+		}
+
+		err = fn(cd.X, cd.Z, &c)
+		if err != nil {
+			return err
 		}
 	}
 
-	return out
+	return nil
 }
 
-// HasChunk returns true if the given chunk exists in this region.
-// That is, it has been generated and contains data.
-func (r *Region) HasChunk(x, z int) bool {
+// ExportChunk writes the NBT data for the given chunk to the specified
+// file, as a gzip compressed .nbt file. This is the same format as used
+// for level.dat, so the file can be inspected with external NBT tools.
+//
+// The data is exported as-is, without decoding it into a Chunk first.
+func (r *Region) ExportChunk(x, z int, file string) error {
 	n := chunkIndex(x, z)
-	return r.chunks[n] != nil
+
+	if r.chunks[n] == nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): chunk does not exist", r.X, r.Z, x, z)
+	}
+
+	data, err := r.chunks[n].raw()
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, x, z, err)
+	}
+
+	fd, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, x, z, err)
+	}
+
+	defer fd.Close()
+
+	w, err := nbt.NewCompressedWriter(fd, nbt.GZip)
+	if err == nil {
+		_, err = w.Write(data)
+	}
+
+	if err == nil {
+		err = w.Close()
+	}
+
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, x, z, err)
+	}
+
+	return nil
 }
 
-// ReadChunk reads chunk data for the given coordinates into the specified
-// structure.
+// ImportChunk reads a gzip compressed .nbt file, as written by ExportChunk,
+// and stores its contents as the data for the given chunk. The chunk is
+// created if it does not exist yet.
+//
+// The data is stored as-is, but it must be a valid NBT compound.
+// Note that Region.Save() must be called to persist these changes.
+func (r *Region) ImportChunk(x, z int, file string) error {
+	data, err := readGzipFile(file)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, x, z, err)
+	}
+
+	// Make sure this is well-formed NBT, before we store it.
+	err = checkCompound(data)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, x, z, err)
+	}
+
+	err = r.writable(x, z).setRaw(data)
+	if err != nil {
+		return fmt.Errorf("anvil: r(%d %d) c(%d %d): %v", r.X, r.Z, x, z, err)
+	}
+
+	return nil
+}
+
+// checkCompound returns an error if data does not hold a well-formed
+// NBT compound.
+func checkCompound(data []byte) error {
+	c, _, err := nbt.NewDecoder(bytes.NewReader(data)).Compound()
+	if err != nil {
+		return err
+	}
+
+	for _, id := c.Next(); id != nbt.TagEnd; _, id = c.Next() {
+	}
+
+	return c.Err()
+}
+
+// ReadChunkRaw returns the decompressed NBT data of the given chunk, without
+// decoding it, along with the compression scheme it is stored with. The
+// scheme lacks the External flag, so it can be passed to WriteChunkRaw.
 //
 // Returns false if there is no valid chunk available, or the chunk data can
 // not be decompressed.
-func (r *Region) ReadChunk(x, z int, c *Chunk) bool {
-	n := chunkIndex(x, z)
+func (r *Region) ReadChunkRaw(x, z int) ([]byte, byte, bool) {
+	cd := r.chunks[chunkIndex(x, z)]
+	if cd == nil {
+		return nil, 0, false
+	}
 
-	if r.chunks[n] == nil {
+	data, err := cd.raw()
+	if err != nil {
+		return nil, 0, false
+	}
+
+	return data, cd.scheme &^ External, true
+}
+
+// WriteChunkRaw stores the given NBT data for the specified chunk, as it
+// is, compressed with the given scheme. This skips the decoding and
+// encoding which WriteChunk needs, so tags the Chunk type does not know
+// about are kept. The chunk is created if it does not exist yet.
+//
+// Note that Region.Save() must be called to persist these changes.
+//
+// Returns false if the scheme is unknown or data is not a well-formed
+// NBT compound.
+func (r *Region) WriteChunkRaw(x, z int, data []byte, scheme byte) bool {
+	if scheme&External != 0 || !validScheme(scheme) || checkCompound(data) != nil {
 		return false
 	}
 
-	return r.chunks[n].Read(c)
+	cd := r.writable(x, z)
+	cd.keepScheme()
+	cd.scheme = scheme
+
+	return cd.setRaw(data) == nil
+}
+
+// readGzipFile returns the decompressed contents of the given file.
+func readGzipFile(file string) ([]byte, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	gz, err := gzip.NewReader(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// ReadChunksParallel decodes all valid chunks in this region, using the
+// given number of workers, and calls fn for each of them. If a chunk can
+// not be decoded, fn receives a nil chunk and the decode error.
+//
+// The workers read chunks concurrently, as described for Region. fn is
+// called from multiple goroutines at the same time and must be safe for
+// concurrent use. ReadChunksParallel returns once all chunks have been
+// handled.
+func (r *Region) ReadChunksParallel(workers int, fn func(x, z int, c *Chunk, err error)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	queue := make(chan *ChunkDescriptor)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for cd := range queue {
+				var c Chunk
+
+				err := cd.read(&c)
+				if err != nil {
+					fn(cd.X, cd.Z, nil, fmt.Errorf("anvil: r(%d %d) c(%d %d): read chunk: %v",
+						r.X, r.Z, cd.X, cd.Z, err))
+				} else {
+					fn(cd.X, cd.Z, &c, nil)
+				}
+			}
+		}()
+	}
+
+	for _, cd := range r.chunks {
+		if cd != nil {
+			queue <- cd
+		}
+	}
+
+	close(queue)
+	wg.Wait()
 }
 
+// SetDataVersion sets the data version stamped into chunks written through
+// WriteChunk and WriteEntities, if they have none of their own. This keeps
+// Minecraft from upgrading generated chunks as if they were written by an
+// old version of the game. A version of 0 leaves such chunks without one,
+// which is the default.
+func (r *Region) SetDataVersion(v int32) { r.dataVersion = v }
+
 // WriteChunk writes compresses the given chunk data, so it may later be
-// persisted using Region.Save().
+// persisted using Region.Save(). Refer to SetDataVersion for the data
+// version written along with it. The data version also determines the
+// layout of the chunk; see Chunk.
 func (r *Region) WriteChunk(x, z int, c *Chunk) bool {
+	return r.writable(x, z).write(c, r.dataVersion)
+}
+
+// SetCompression sets the compression scheme for chunks written from now
+// on: GZip, ZLib, Uncompressed or LZ4. By default, new chunks use ZLib, as
+// Minecraft does, and existing chunks keep the scheme they were read with.
+// Returns an error for any other scheme.
+func (r *Region) SetCompression(scheme byte) error {
+	if scheme&External != 0 || !validScheme(scheme) {
+		return fmt.Errorf("anvil: r(%d %d): unknown compression %d", r.X, r.Z, scheme)
+	}
+
+	r.compression = scheme
+	return nil
+}
+
+// writable returns the descriptor for the given chunk, which is about to
+// be written. It is created if the chunk does not exist yet.
+func (r *Region) writable(x, z int) *ChunkDescriptor {
 	n := chunkIndex(x, z)
 
 	if r.chunks[n] == nil {
@@ -249,7 +1344,11 @@ func (r *Region) WriteChunk(x, z int, c *Chunk) bool {
 		}
 	}
 
-	return r.chunks[n].Write(c)
+	if r.compression != 0 {
+		r.chunks[n].scheme = r.compression
+	}
+
+	return r.chunks[n]
 }
 
 // writeHeader writes header data into the given writer.
@@ -263,7 +1362,11 @@ func writeHeader(w io.WriteSeeker, set []*ChunkDescriptor) error {
 			continue
 		}
 
-		sectors := cd.SectorCount()
+		sectors, err := cd.sectorCount()
+		if err != nil {
+			return err
+		}
+
 		writeOffset(locations[:], cd.X, cd.Z, offset, sectors)
 		writeTimestamp(timestamps[:], cd.X, cd.Z, cd.LastModified)
 
@@ -308,7 +1411,8 @@ func readHeader(r io.ReadSeeker) ([]byte, []byte, error) {
 	return locations[:], timestamps[:], nil
 }
 
-// writeChunk writes a chunk to the given stream.
+// writeChunk writes a chunk to the given stream. Chunks stored in an
+// external file only get their header, with the External flag set.
 func writeChunk(w io.WriteSeeker, cd *ChunkDescriptor, offset int) error {
 	// Jump to chunk sector.
 	_, err := w.Seek(int64(offset)*sectorSize, 0)
@@ -316,14 +1420,34 @@ func writeChunk(w io.WriteSeeker, cd *ChunkDescriptor, offset int) error {
 		return err
 	}
 
-	// Write compressed data size.
-	err = writeU32(w, uint32(len(cd.data)))
+	external, err := cd.isExternal()
+	if err != nil {
+		return err
+	}
+
+	if external {
+		err = writeU32(w, 1)
+		if err != nil {
+			return err
+		}
+
+		err = writeU8(w, cd.scheme|External)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(make([]byte, sectorSize-chunkHeaderSize))
+		return err
+	}
+
+	// Write compressed data size. This includes the compression scheme.
+	err = writeU32(w, uint32(len(cd.data)+1))
 	if err != nil {
 		return err
 	}
 
 	// Write compression scheme.
-	err = writeU8(w, ZLib)
+	err = writeU8(w, cd.scheme)
 	if err != nil {
 		return err
 	}
@@ -335,41 +1459,58 @@ func writeChunk(w io.WriteSeeker, cd *ChunkDescriptor, offset int) error {
 	}
 
 	// Pad data
-	padding := (cd.SectorCount() * sectorSize) - len(cd.data)
+	sectors, err := cd.sectorCount()
+	if err != nil {
+		return err
+	}
+
+	padding := (sectors * sectorSize) - len(cd.data) - chunkHeaderSize
 	_, err = w.Write(make([]byte, padding))
 	return err
 }
 
 // readChunk reads a chunk from the given stream.
-func readChunk(r io.ReadSeeker, x, z, offset int, timestamps []byte) (*ChunkDescriptor, error) {
+func readChunk(r io.ReadSeeker, x, z, offset, sectors int, timestamps []byte) (*ChunkDescriptor, error) {
 	cd := &ChunkDescriptor{
 		X:            x,
 		Z:            z,
 		LastModified: readTimestamp(timestamps, x, z),
+		sectors:      sectors,
+		offset:       offset,
 	}
 
+	return cd, cd.readData(r, offset)
+}
+
+// readData reads the compression scheme and compressed data of the chunk,
+// which starts at the given sector in r.
+func (cd *ChunkDescriptor) readData(r io.ReadSeeker, offset int) error {
 	// Jump to chunk sector.
 	_, err := r.Seek(int64(offset)*sectorSize, 0)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Read compressed data size.
+	// Read compressed data size. This includes the compression scheme.
 	size, err := readU32(r)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if size < 1 {
+		return fmt.Errorf("invalid chunk size %d", size)
 	}
 
 	// Read compression scheme.
 	cd.scheme, err = readU8(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Read compressed data.
-	cd.data = make([]byte, size)
+	cd.data = make([]byte, size-1)
 	_, err = io.ReadFull(r, cd.data)
-	return cd, err
+	return err
 }
 
 // readTimestamp returns the time at which the given chunk was last modified.
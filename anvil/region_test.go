@@ -4,10 +4,22 @@
 package anvil
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
 )
 
 type regionCoordTest struct {
@@ -28,11 +40,80 @@ func TestRegionCoords(t *testing.T) {
 		{In: "/a/b/r.-1.2.mca", X: -1, Z: 2},
 		{In: "a/b/r.-1.2.mca", X: -1, Z: 2},
 		{In: "/a/b/x.-1.2.mca", X: -1, Z: 2},
+		{In: "saves/my.world/region/r.3.-4.mca", X: 3, Z: -4},
+		{In: "saves/world/entities/r.3.-4.mca", X: 3, Z: -4},
+		{In: "saves/world/DIM-1/poi/r.-3.4.mca", X: -3, Z: 4},
 	} {
 		testRegionCoords(t, rct)
 	}
 }
 
+func TestRegionKindOf(t *testing.T) {
+	tests := []struct {
+		in   string
+		kind RegionKind
+	}{
+		{"r.0.0.mca", RegionTerrain},
+		{"region/r.0.0.mca", RegionTerrain},
+		{"world/entities/r.0.0.mca", RegionEntities},
+		{"/world/DIM1/poi/r.0.0.mca", RegionPoi},
+		{filepath.Join("world", "DIM-1", "entities", "r.0.0.mca"), RegionEntities},
+		{"world/poi/sub/r.0.0.mca", RegionTerrain},
+		{"entities.mca", RegionTerrain},
+	}
+
+	for _, tt := range tests {
+		if kind := RegionKindOf(tt.in); kind != tt.kind {
+			t.Errorf("RegionKindOf(%q): have %v, want %v", tt.in, kind, tt.kind)
+		}
+	}
+
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if r.Kind() != RegionTerrain {
+		t.Fatalf("kind mismatch: have %v, want %v", r.Kind(), RegionTerrain)
+	}
+}
+
+func TestRegionInfo(t *testing.T) {
+	tests := []struct {
+		in   string
+		dim  string
+		kind RegionKind
+		x, z int
+		ok   bool
+	}{
+		{"world/region/r.1.-2.mca", OverworldId, RegionTerrain, 1, -2, true},
+		{"/saves/world/entities/r.0.0.mca", OverworldId, RegionEntities, 0, 0, true},
+		{"region/r.0.0.mca", OverworldId, RegionTerrain, 0, 0, true},
+		{"world/DIM-1/region/r.-1.0.mca", NetherId, RegionTerrain, -1, 0, true},
+		{"world/DIM-1/entities/r.0.0.mca", NetherId, RegionEntities, 0, 0, true},
+		{"world/DIM1/poi/r.0.3.mca", EndId, RegionPoi, 0, 3, true},
+		{"world/dimensions/mypack/mining/region/r.0.0.mca", "mypack:mining", RegionTerrain, 0, 0, true},
+		{"world/dimensions/mypack/deep/caves/poi/r.0.0.mca", "mypack:deep/caves", RegionPoi, 0, 0, true},
+		{filepath.Join("world", "DIM-1", "poi", "r.2.2.mca"), NetherId, RegionPoi, 2, 2, true},
+		{"r.0.0.mca", "", 0, 0, 0, false},
+		{"world/backup/r.0.0.mca", "", 0, 0, 0, false},
+		{"world/region/level.dat", "", 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		dim, kind, x, z, err := RegionInfo(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("RegionInfo(%q): unexpected error state: %v", tt.in, err)
+			continue
+		}
+
+		if dim != tt.dim || kind != tt.kind || x != tt.x || z != tt.z {
+			t.Errorf("RegionInfo(%q): have (%q, %v, %d, %d), want (%q, %v, %d, %d)",
+				tt.in, dim, kind, x, z, tt.dim, tt.kind, tt.x, tt.z)
+		}
+	}
+}
+
 func testRegionCoords(t *testing.T, rc regionCoordTest) {
 	x, z, err := RegionCoords(rc.In)
 	if err != !rc.Err {
@@ -182,6 +263,118 @@ func TestRegionRoundtrip(t *testing.T) {
 	}
 }
 
+// TestRegionByteRoundtrip ensures that saving an unmodified region, or
+// re-writing unmodified chunks, yields byte-identical data.
+func TestRegionByteRoundtrip(t *testing.T) {
+	const File1 = "../testdata/newworld/region/r.0.0.mca"
+	File2 := filepath.Join(t.TempDir(), "r.0.0.mca")
+
+	ra, err := LoadRegion(File1)
+	if err != nil {
+		t.Fatalf("Load 1: %v", err)
+	}
+
+	// Re-write every chunk twice, once using each compression scheme.
+	for _, scheme := range []byte{GZip, ZLib} {
+		for _, cd := range ra.chunks {
+			if cd == nil {
+				continue
+			}
+
+			var c Chunk
+			if !cd.Read(&c) {
+				t.Fatalf("read c(%d %d)", cd.X, cd.Z)
+			}
+
+			cd.scheme = scheme
+			if !cd.Write(&c) {
+				t.Fatalf("write c(%d %d)", cd.X, cd.Z)
+			}
+
+			want := cd.data
+
+			if !cd.Read(&c) || !cd.Write(&c) {
+				t.Fatalf("rewrite c(%d %d)", cd.X, cd.Z)
+			}
+
+			if !bytes.Equal(want, cd.data) {
+				t.Fatalf("c(%d %d): scheme %d: rewritten data differs", cd.X, cd.Z, scheme)
+			}
+		}
+	}
+
+	ra.file = File2
+	if err = ra.Save(); err != nil {
+		t.Fatalf("Save 2: %v", err)
+	}
+
+	want, err := ioutil.ReadFile(File2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rb, err := LoadRegion(File2)
+	if err != nil {
+		t.Fatalf("Load 2: %v", err)
+	}
+
+	if err = rb.Save(); err != nil {
+		t.Fatalf("Save 3: %v", err)
+	}
+
+	have, err := ioutil.ReadFile(File2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(want, have) {
+		t.Fatalf("region file changed after no-op roundtrip")
+	}
+}
+
+// TestChunkTagOrder ensures that chunk data decoded into an ordered tag
+// tree re-encodes to the exact payload Minecraft wrote.
+func TestChunkTagOrder(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cd := range r.chunks {
+		if cd == nil {
+			continue
+		}
+
+		rc, err := cd.reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dec := nbt.NewDecoder(bytes.NewReader(want))
+		dec.SetOrdered(true)
+
+		var root nbt.KeyValue
+		if err = dec.Decode(&root); err != nil {
+			t.Fatalf("c(%d %d): %v", cd.X, cd.Z, err)
+		}
+
+		var have bytes.Buffer
+		if err = nbt.Marshal(&have, root); err != nil {
+			t.Fatalf("c(%d %d): %v", cd.X, cd.Z, err)
+		}
+
+		if !bytes.Equal(have.Bytes(), want) {
+			t.Fatalf("c(%d %d): re-encoded payload differs", cd.X, cd.Z)
+		}
+	}
+}
+
 // copyFile copies file src to file dst.
 func copyFile(dst, src string) bool {
 	fs, err := os.Open(src)
@@ -201,3 +394,1686 @@ func copyFile(dst, src string) bool {
 	_, err = io.Copy(fd, fs)
 	return err == nil
 }
+
+func TestEachChunk(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()
+	if len(xz) < 2 {
+		t.Fatalf("need at least two chunks, have %d", len(xz))
+	}
+
+	// Corrupt a single chunk.
+	bad := r.chunks[chunkIndex(xz[1][0], xz[1][1])]
+	bad.data = []byte{1, 2, 3}
+
+	var good, failed int
+	err = r.EachChunk(func(x, z int, c *Chunk, err error) error {
+		if err != nil {
+			if c != nil || x != bad.X || z != bad.Z {
+				t.Errorf("unexpected failure c(%d %d): %v", x, z, err)
+			}
+
+			failed++
+			return nil
+		}
+
+		good++
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("EachChunk: %v", err)
+	}
+
+	if failed != 1 || good != len(xz)-1 {
+		t.Fatalf("chunk count mismatch: have %d good, %d failed; want %d good, 1 failed",
+			good, failed, len(xz)-1)
+	}
+
+	// A callback error stops the iteration.
+	var calls int
+	stop := errors.New("stop")
+
+	err = r.EachChunk(func(x, z int, c *Chunk, err error) error {
+		calls++
+		return stop
+	})
+
+	if err != stop || calls != 1 {
+		t.Fatalf("iteration not stopped: have %v after %d calls", err, calls)
+	}
+}
+
+func TestWalkChunks(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()
+	if len(xz) < 2 {
+		t.Fatalf("need at least two chunks, have %d", len(xz))
+	}
+
+	var seen [][2]int
+	var first *Chunk
+
+	err = r.WalkChunks(func(x, z int, c *Chunk) error {
+		if first == nil {
+			first = c
+		} else if c != first {
+			t.Errorf("c(%d %d): chunk value not reused", x, z)
+		}
+
+		if int(c.X)&31 != x || int(c.Z)&31 != z {
+			t.Errorf("c(%d %d): unexpected position %d %d", x, z, c.X, c.Z)
+		}
+
+		seen = append(seen, [2]int{x, z})
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("WalkChunks: %v", err)
+	}
+
+	if !reflect.DeepEqual(seen, xz) {
+		t.Fatalf("chunk listing mismatch:\nWant: %v\nHave: %v", xz, seen)
+	}
+
+	// A callback error stops the iteration.
+	var calls int
+	stop := errors.New("stop")
+
+	err = r.WalkChunks(func(x, z int, c *Chunk) error {
+		calls++
+		return stop
+	})
+
+	if err != stop || calls != 1 {
+		t.Fatalf("iteration not stopped: have %v after %d calls", err, calls)
+	}
+
+	// So does a chunk which can not be decoded.
+	bad := r.chunks[chunkIndex(xz[1][0], xz[1][1])]
+	bad.data = []byte{1, 2, 3}
+
+	calls = 0
+	err = r.WalkChunks(func(x, z int, c *Chunk) error {
+		calls++
+		return nil
+	})
+
+	if err == nil || calls != 1 {
+		t.Fatalf("expected a decode error after 1 call, have %v after %d calls", err, calls)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()
+	if len(xz) < 2 {
+		t.Fatalf("need at least two chunks, have %d", len(xz))
+	}
+
+	base := time.Unix(1000000000, 0)
+	for _, cd := range r.chunks {
+		if cd != nil {
+			cd.LastModified = base
+		}
+	}
+
+	if have := r.ChangedSince(base); len(have) != 0 {
+		t.Fatalf("unexpected changes: %v", have)
+	}
+
+	want := xz[1]
+	r.chunks[chunkIndex(want[0], want[1])].LastModified = base.Add(time.Second)
+
+	have := r.ChangedSince(base)
+	if len(have) != 1 || have[0] != want {
+		t.Fatalf("changed chunks mismatch: have %v, want [%v]", have, want)
+	}
+
+	if have := r.ChangedSince(base.Add(-time.Second)); len(have) != len(xz) {
+		t.Fatalf("changed chunk count mismatch: have %d, want %d", len(have), len(xz))
+	}
+}
+
+func TestChunkTimestamp(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "r.0.0.mca")
+	if !copyFile(file, "../testdata/newworld/region/r.0.0.mca") {
+		t.Fatal("copy failed")
+	}
+
+	r, err := LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()
+	if len(xz) < 2 {
+		t.Fatalf("need at least two chunks, have %d", len(xz))
+	}
+
+	x, z := xz[0][0], xz[0][1]
+	if _, ok := r.ChunkTimestamp(x, z); !ok {
+		t.Fatalf("c(%d %d): missing timestamp", x, z)
+	}
+
+	for ax := 0; ax < ChunksPerRegion; ax++ {
+		if r.HasChunk(ax, 31) {
+			continue
+		}
+
+		if _, ok := r.ChunkTimestamp(ax, 31); ok || r.SetChunkTimestamp(ax, 31, time.Now()) {
+			t.Fatalf("c(%d 31): timestamp for absent chunk", ax)
+		}
+	}
+
+	stamp := time.Unix(1234567890, 500)
+	if !r.SetChunkTimestamp(x, z, stamp) {
+		t.Fatalf("c(%d %d): SetChunkTimestamp failed", x, z)
+	}
+
+	// Writing a chunk marks it as modified now.
+	var c Chunk
+	wx, wz := xz[1][0], xz[1][1]
+	if !r.ReadChunk(wx, wz, &c) || !r.WriteChunk(wx, wz, &c) {
+		t.Fatalf("c(%d %d): rewrite failed", wx, wz)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if have, _ := r.ChunkTimestamp(wx, wz); have.Before(before) {
+		t.Fatalf("c(%d %d): stale timestamp %v", wx, wz, have)
+	}
+
+	err = r.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if have, _ := r.ChunkTimestamp(x, z); !have.Equal(time.Unix(1234567890, 0)) {
+		t.Fatalf("c(%d %d): timestamp mismatch: have %v", x, z, have)
+	}
+
+	if have, _ := r.ChunkTimestamp(wx, wz); have.Before(before.Truncate(time.Second)) {
+		t.Fatalf("c(%d %d): stale timestamp %v after save", wx, wz, have)
+	}
+}
+
+func TestDecodeChunk(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()[0]
+
+	var c Chunk
+	err = r.DecodeChunk(xz[0], xz[1], &c)
+	if err != nil {
+		t.Fatalf("DecodeChunk: %v", err)
+	}
+
+	if int(c.X)&31 != xz[0] || int(c.Z)&31 != xz[1] {
+		t.Fatalf("position mismatch: have (%d %d), want %v", c.X, c.Z, xz)
+	}
+
+	r.chunks[chunkIndex(xz[0], xz[1])].scheme = 7
+
+	err = r.DecodeChunk(xz[0], xz[1], &c)
+	if err == nil || err == ErrChunkAbsent {
+		t.Fatalf("expected decode error, have %v", err)
+	}
+
+	r.chunks[chunkIndex(xz[0], xz[1])] = nil
+
+	err = r.DecodeChunk(xz[0], xz[1], &c)
+	if err != ErrChunkAbsent {
+		t.Fatalf("have %v, want %v", err, ErrChunkAbsent)
+	}
+}
+
+func TestRegionBiomeSet(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()
+
+	var c Chunk
+	c.Init(xz[0][0], xz[0][1])
+	c.SetBiome(0, -30, 0, "minecraft:badlands")
+	c.SetBiome(0, 30, 0, "minecraft:desert")
+
+	if !r.WriteChunk(xz[0][0], xz[0][1], &c) {
+		t.Fatalf("WriteChunk failed")
+	}
+
+	set := map[string]bool{"minecraft:void": true}
+
+	err = r.BiomeSet(set)
+	if err != nil {
+		t.Fatalf("BiomeSet: %v", err)
+	}
+
+	want := map[string]bool{
+		"minecraft:void":     true,
+		"minecraft:badlands": true,
+		"minecraft:desert":   true,
+		DefaultBiome:         true,
+	}
+
+	if !reflect.DeepEqual(set, want) {
+		t.Fatalf("biome mismatch: have %v, want %v", set, want)
+	}
+}
+
+func TestChunkLengths(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()
+	for _, v := range xz {
+		payload, sectors, ok := r.ChunkLengths(v[0], v[1])
+		if !ok {
+			t.Fatalf("c(%d %d): chunk not found", v[0], v[1])
+		}
+
+		if payload < 1 || payload+4 > sectors*sectorSize {
+			t.Fatalf("c(%d %d): payload of %d bytes does not fit in %d sectors",
+				v[0], v[1], payload, sectors)
+		}
+	}
+
+	for n, cd := range r.chunks {
+		if cd != nil {
+			continue
+		}
+
+		if _, _, ok := r.ChunkLengths(n%ChunksPerRegion, n/ChunksPerRegion); ok {
+			t.Fatalf("unexpected lengths for missing chunk %d", n)
+		}
+		break
+	}
+
+	// Rewritten chunks report the sector count Save will use.
+	var c Chunk
+	if !r.ReadChunk(xz[0][0], xz[0][1], &c) {
+		t.Fatalf("ReadChunk failed")
+	}
+
+	if !r.WriteChunk(xz[0][0], xz[0][1], &c) {
+		t.Fatalf("WriteChunk failed")
+	}
+
+	cd := r.chunks[chunkIndex(xz[0][0], xz[0][1])]
+
+	payload, sectors, _ := r.ChunkLengths(xz[0][0], xz[0][1])
+	if payload != len(cd.data)+1 || sectors != cd.SectorCount() {
+		t.Fatalf("lengths mismatch: have (%d, %d), want (%d, %d)",
+			payload, sectors, len(cd.data)+1, cd.SectorCount())
+	}
+}
+
+func TestReadChunksParallel(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[[2]int]int)
+
+	r.ReadChunksParallel(4, func(x, z int, c *Chunk, err error) {
+		if err != nil || c == nil || int(c.X)&31 != x || int(c.Z)&31 != z {
+			t.Errorf("c(%d %d): unexpected result: %v", x, z, err)
+		}
+
+		mu.Lock()
+		seen[[2]int{x, z}]++
+		mu.Unlock()
+	})
+
+	if len(seen) != r.ChunkLen() {
+		t.Fatalf("chunk count mismatch: have %d, want %d", len(seen), r.ChunkLen())
+	}
+
+	for xz, n := range seen {
+		if n != 1 {
+			t.Fatalf("c(%d %d) seen %d times", xz[0], xz[1], n)
+		}
+	}
+}
+
+func BenchmarkReadChunks(b *testing.B) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		b.Fatalf("Load: %v", err)
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			r.EachChunk(func(x, z int, c *Chunk, err error) error { return err })
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			r.ReadChunksParallel(runtime.NumCPU(), func(x, z int, c *Chunk, err error) {})
+		}
+	})
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, scheme := range []byte{ZLib, LZ4} {
+		r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+		if err != nil {
+			b.Fatalf("Load: %v", err)
+		}
+
+		var size int64
+		for _, xz := range r.Chunks() {
+			cd := r.chunks[chunkIndex(xz[0], xz[1])]
+
+			data, err := cd.raw()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			cd.scheme = scheme
+			if err = cd.setRaw(data); err != nil {
+				b.Fatal(err)
+			}
+
+			size += int64(len(data))
+		}
+
+		b.Run(fmt.Sprintf("scheme=%d", scheme), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(size)
+
+			for i := 0; i < b.N; i++ {
+				for _, cd := range r.chunks {
+					if cd == nil {
+						continue
+					}
+
+					rc, err := cd.reader()
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					io.Copy(ioutil.Discard, rc)
+					rc.Close()
+				}
+			}
+		})
+	}
+}
+
+func TestReadChunkReuse(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// Decoding into a reused chunk must yield the same data as decoding
+	// into a fresh one. The encoded form is compared, because reused
+	// slices may be empty instead of nil.
+	var reused Chunk
+	for _, xz := range r.Chunks() {
+		var fresh Chunk
+
+		if !r.ReadChunk(xz[0], xz[1], &fresh) || !r.ReadChunk(xz[0], xz[1], &reused) {
+			t.Fatalf("c(%d %d): read failed", xz[0], xz[1])
+		}
+
+		var a, b bytes.Buffer
+		if nbt.Marshal(&a, fresh) != nil || nbt.Marshal(&b, reused) != nil {
+			t.Fatalf("c(%d %d): encode failed", xz[0], xz[1])
+		}
+
+		if !bytes.Equal(a.Bytes(), b.Bytes()) {
+			t.Fatalf("c(%d %d): reused chunk differs from fresh one", xz[0], xz[1])
+		}
+	}
+}
+
+func BenchmarkReadChunkReuse(b *testing.B) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		b.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			var c Chunk
+			r.ReadChunk(xz[i%len(xz)][0], xz[i%len(xz)][1], &c)
+		}
+	})
+
+	b.Run("reused", func(b *testing.B) {
+		b.ReportAllocs()
+
+		var c Chunk
+		for i := 0; i < b.N; i++ {
+			r.ReadChunk(xz[i%len(xz)][0], xz[i%len(xz)][1], &c)
+		}
+	})
+}
+
+func TestExportChunk(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "chunk.nbt")
+
+	ra, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := ra.Chunks()[0]
+
+	err = ra.ExportChunk(xz[0], xz[1], file)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	rb, err := CreateRegion(filepath.Join(dir, "r.0.0.mca"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	err = rb.ImportChunk(5, 7, file)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	var ca, cb Chunk
+	if !ra.ReadChunk(xz[0], xz[1], &ca) || !rb.ReadChunk(5, 7, &cb) {
+		t.Fatalf("read failed")
+	}
+
+	if !reflect.DeepEqual(ca, cb) {
+		t.Fatalf("imported chunk differs from exported one")
+	}
+
+	if rb.ExportChunk(0, 0, file) == nil {
+		t.Fatalf("expected error when exporting a missing chunk")
+	}
+
+	err = ioutil.WriteFile(file, []byte("not nbt"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rb.ImportChunk(0, 0, file) == nil {
+		t.Fatalf("expected error when importing invalid data")
+	}
+}
+
+func TestExternalChunk(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "r.1.-1.mca")
+	mcc := filepath.Join(dir, "c.35.-28.mcc")
+
+	r, err := CreateRegion(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.WriteChunk(0, 0, &Chunk{X: 32, Z: -32}) {
+		t.Fatal("WriteChunk failed")
+	}
+
+	// Random data does not compress, so this exceeds 255 sectors.
+	payload := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	var buf bytes.Buffer
+	err = nbt.Marshal(&buf, nbt.Compound{"data": nbt.ByteArray(payload)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := buf.Bytes()
+
+	big := &ChunkDescriptor{X: 3, Z: 4, scheme: ZLib}
+	err = big.setRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.chunks[chunkIndex(3, 4)] = big
+
+	err = r.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Size() != 4*sectorSize {
+		t.Fatalf("region size mismatch: have %d, want %d", fi.Size(), 4*sectorSize)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if n, sectors, ok := r.ChunkLengths(3, 4); !ok || n != 1 || sectors != 1 {
+		t.Fatalf("chunk lengths mismatch: have %d, %d, %v", n, sectors, ok)
+	}
+
+	have, err := r.chunks[chunkIndex(3, 4)].raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(have, raw) {
+		t.Fatal("external chunk data mismatch")
+	}
+
+	if errs := r.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// A missing external file makes the chunk unreadable, but it is kept
+	// when the region is saved.
+	err = os.Rename(mcc, mcc+".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if errs := r.Validate(); len(errs) != 1 || errs[0].X != 3 || errs[0].Z != 4 {
+		t.Fatalf("expected a single error for c(3 4), have %v", errs)
+	}
+
+	err = r.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	err = os.Rename(mcc+".bak", mcc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if errs := r.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// Once the chunk fits in the region again, the external file goes.
+	// Saving a copy elsewhere keeps it, since the region file still needs
+	// it, and does not write one for the copy.
+	if !r.WriteChunk(3, 4, &Chunk{X: 35, Z: -28}) {
+		t.Fatal("WriteChunk failed")
+	}
+
+	other := t.TempDir()
+	err = r.SaveAs(filepath.Join(other, "r.1.-1.mca"))
+	if err != nil {
+		t.Fatalf("SaveAs: %v", err)
+	}
+
+	if _, err = os.Stat(mcc); err != nil {
+		t.Fatalf("external file removed by SaveAs: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(other, "c.35.-28.mcc")); !os.IsNotExist(err) {
+		t.Fatalf("unexpected external file for copy: %v", err)
+	}
+
+	err = r.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if _, err = os.Stat(mcc); !os.IsNotExist(err) {
+		t.Fatalf("external file not removed: %v", err)
+	}
+
+	var c Chunk
+	r, err = LoadRegion(file)
+	if err != nil || !r.ReadChunk(3, 4, &c) || c.X != 35 {
+		t.Fatalf("reading rewritten chunk failed: %v", err)
+	}
+}
+
+func TestGrow(t *testing.T) {
+	src, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "anvil-grow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "r.0.0.mca")
+
+	r, err := CreateRegion(file)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	const reserved = 64
+
+	err = r.Grow(reserved)
+	if err != nil {
+		t.Fatalf("Grow: %v", err)
+	}
+
+	want := int64(2+reserved) * sectorSize
+	checkSize := func(when string) {
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Size() != want {
+			t.Fatalf("%s: file size mismatch: have %d, want %d", when, fi.Size(), want)
+		}
+	}
+
+	checkSize("grow")
+
+	// Chunks which fit in the reserved space do not grow the file.
+	var c Chunk
+	var used int
+
+	for _, xz := range src.Chunks() {
+		if !src.ReadChunk(xz[0], xz[1], &c) || !r.WriteChunk(xz[0], xz[1], &c) {
+			t.Fatalf("c(%d %d): copy failed", xz[0], xz[1])
+		}
+
+		_, sectors, _ := r.ChunkLengths(xz[0], xz[1])
+		if used+sectors > reserved {
+			r.chunks[chunkIndex(xz[0], xz[1])] = nil
+			break
+		}
+
+		used += sectors
+	}
+
+	err = r.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	checkSize("save")
+
+	r2, err := LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load saved: %v", err)
+	}
+
+	if r2.ChunkLen() != r.ChunkLen() || r2.ChunkLen() == 0 {
+		t.Fatalf("chunk count mismatch: have %d, want %d", r2.ChunkLen(), r.ChunkLen())
+	}
+
+	for _, xz := range r2.Chunks() {
+		if !r2.ReadChunk(xz[0], xz[1], &c) {
+			t.Fatalf("c(%d %d): read failed", xz[0], xz[1])
+		}
+	}
+}
+
+func TestDeleteChunk(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "anvil-delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "r.0.0.mca")
+
+	size := func() int64 {
+		err := r.SaveAs(file)
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return fi.Size()
+	}
+
+	var free [2]int
+	for r.HasChunk(free[0], free[1]) {
+		free[0]++
+	}
+
+	xz := r.Chunks()[0]
+	before := size()
+
+	var c Chunk
+	for i := 0; i < 10; i++ {
+		if !r.ReadChunk(xz[0], xz[1], &c) {
+			t.Fatalf("c(%d %d): read failed", xz[0], xz[1])
+		}
+
+		if !r.DeleteChunk(xz[0], xz[1]) {
+			t.Fatalf("c(%d %d): delete failed", xz[0], xz[1])
+		}
+
+		if r.HasChunk(xz[0], xz[1]) || r.DeleteChunk(xz[0], xz[1]) {
+			t.Fatalf("c(%d %d): chunk not deleted", xz[0], xz[1])
+		}
+
+		if !r.WriteChunk(free[0], free[1], &c) {
+			t.Fatalf("c(%d %d): write failed", free[0], free[1])
+		}
+
+		xz, free = free, xz
+	}
+
+	if after := size(); after > before {
+		t.Fatalf("file grew: have %d bytes, want at most %d", after, before)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if r.HasChunk(free[0], free[1]) || !r.HasChunk(xz[0], xz[1]) {
+		t.Fatalf("unexpected chunk set after reload")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	src, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "anvil-compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "r.0.0.mca")
+
+	err = src.SaveAs(file)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	r, err := LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// Leave gaps between chunks, and free space at the end.
+	err = r.Grow(64)
+	if err != nil {
+		t.Fatalf("Grow: %v", err)
+	}
+
+	for i, xz := range r.Chunks() {
+		if i%2 == 0 {
+			r.DeleteChunk(xz[0], xz[1])
+		}
+	}
+
+	want := map[[2]int][]byte{}
+	for _, xz := range r.Chunks() {
+		data, err := r.chunks[chunkIndex(xz[0], xz[1])].raw()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want[xz] = data
+	}
+
+	err = r.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	before, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Compact()
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	after, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have, max := after.Size(), int64(r.usedSectors())*sectorSize; have >= before.Size() || have != max {
+		t.Fatalf("size mismatch: have %d, want %d, was %d", have, max, before.Size())
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if r.ChunkLen() != len(want) {
+		t.Fatalf("chunk count mismatch: have %d, want %d", r.ChunkLen(), len(want))
+	}
+
+	for xz, data := range want {
+		cd := r.chunks[chunkIndex(xz[0], xz[1])]
+		if cd == nil {
+			t.Fatalf("c(%d %d): missing chunk", xz[0], xz[1])
+		}
+
+		have, err := cd.raw()
+		if err != nil {
+			t.Fatalf("c(%d %d): %v", xz[0], xz[1], err)
+		}
+
+		if !bytes.Equal(have, data) {
+			t.Fatalf("c(%d %d): chunk data mismatch", xz[0], xz[1])
+		}
+
+		if !cd.LastModified.Equal(src.chunks[chunkIndex(xz[0], xz[1])].LastModified) {
+			t.Fatalf("c(%d %d): timestamp mismatch", xz[0], xz[1])
+		}
+	}
+}
+
+func TestSetDataVersion(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()[0]
+
+	var c Chunk
+	if !r.ReadChunk(xz[0], xz[1], &c) {
+		t.Fatalf("c(%d %d): read failed", xz[0], xz[1])
+	}
+
+	check := func(want int32) {
+		var c Chunk
+		if !r.ReadChunk(xz[0], xz[1], &c) {
+			t.Fatalf("c(%d %d): read failed", xz[0], xz[1])
+		}
+
+		if c.DataVersion != want {
+			t.Fatalf("data version mismatch: have %d, want %d", c.DataVersion, want)
+		}
+	}
+
+	// Chunks without a version get the region's default.
+	c.DataVersion = 0
+	r.SetDataVersion(3465)
+
+	if !r.WriteChunk(xz[0], xz[1], &c) {
+		t.Fatalf("c(%d %d): write failed", xz[0], xz[1])
+	}
+
+	check(3465)
+
+	// A version set on the chunk itself is kept.
+	c.DataVersion = 1343
+
+	if !r.WriteChunk(xz[0], xz[1], &c) {
+		t.Fatalf("c(%d %d): write failed", xz[0], xz[1])
+	}
+
+	check(1343)
+}
+
+func TestSetDataVersionGenerated(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "r.0.0.mca")
+
+	r, err := CreateRegion(file)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	r.SetDataVersion(3465)
+
+	stone := BlockState{Name: "minecraft:stone"}
+
+	var c Chunk
+	c.Init(3, 4)
+	c.Y = -4
+	c.Status = "minecraft:full"
+	c.SetBlock(1, -60, 2, stone)
+	c.SetBlock(5, 70, 6, stone)
+
+	if !r.WriteChunk(3, 4, &c) {
+		t.Fatal("write failed")
+	}
+
+	if err = r.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	data, _, ok := r.ReadChunkRaw(3, 4)
+	if !ok {
+		t.Fatal("raw read failed")
+	}
+
+	var tree map[string]interface{}
+	if err = nbt.Unmarshal(bytes.NewReader(data), &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := tree["Level"]; ok {
+		t.Fatalf("1.18+ chunk written with a Level tag: %v", tree)
+	}
+
+	if tree["DataVersion"] != int32(3465) || tree["xPos"] != int32(3) || tree["yPos"] != int32(-4) {
+		t.Fatalf("unexpected tags: %v", tree)
+	}
+
+	var have Chunk
+	if !r.ReadChunk(3, 4, &have) {
+		t.Fatal("read failed")
+	}
+
+	if have.DataVersion != 3465 || have.Status != "minecraft:full" {
+		t.Fatalf("unexpected chunk: version %d, status %q", have.DataVersion, have.Status)
+	}
+
+	for _, p := range [][3]int{{1, -60, 2}, {5, 70, 6}} {
+		if b, ok := have.BlockState(p[0], p[1], p[2]); !ok || !b.Equal(stone) {
+			t.Errorf("BlockState(%d, %d, %d): have %+v %v", p[0], p[1], p[2], b, ok)
+		}
+	}
+}
+
+func TestReadChunkStats(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	for _, v := range r.Chunks() {
+		cd := r.chunks[chunkIndex(v[0], v[1])]
+
+		rc, err := cd.reader()
+		if err != nil {
+			t.Fatalf("c(%d %d): %v", v[0], v[1], err)
+		}
+
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("c(%d %d): %v", v[0], v[1], err)
+		}
+
+		compressed, decompressed, scheme, ok := r.ReadChunkStats(v[0], v[1])
+		if !ok {
+			t.Fatalf("c(%d %d): no stats", v[0], v[1])
+		}
+
+		if compressed != len(cd.data) || decompressed != len(data) || scheme != cd.scheme {
+			t.Fatalf("c(%d %d): have (%d, %d, %d), want (%d, %d, %d)", v[0], v[1],
+				compressed, decompressed, scheme, len(cd.data), len(data), cd.scheme)
+		}
+	}
+
+	xz := r.Chunks()[0]
+	cd := r.chunks[chunkIndex(xz[0], xz[1])]
+	cd.data = cd.data[:len(cd.data)/2]
+
+	compressed, _, scheme, ok := r.ReadChunkStats(xz[0], xz[1])
+	if ok || compressed != len(cd.data) || scheme != cd.scheme {
+		t.Fatalf("truncated chunk: have (%d, %d, %v)", compressed, scheme, ok)
+	}
+
+	r.chunks[chunkIndex(xz[0], xz[1])] = nil
+
+	if _, _, _, ok := r.ReadChunkStats(xz[0], xz[1]); ok {
+		t.Fatalf("unexpected stats for missing chunk")
+	}
+}
+
+func TestSetCompression(t *testing.T) {
+	src, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := src.Chunks()[0]
+
+	var want Chunk
+	if !src.ReadChunk(xz[0], xz[1], &want) {
+		t.Fatalf("c(%d %d): read failed", xz[0], xz[1])
+	}
+
+	file := filepath.Join(t.TempDir(), "r.0.0.mca")
+
+	for _, scheme := range []byte{GZip, ZLib, Uncompressed, LZ4} {
+		r, err := CreateRegion(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.SetCompression(scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !r.WriteChunk(xz[0], xz[1], &want) {
+			t.Fatalf("scheme %d: write failed", scheme)
+		}
+
+		err = r.Save()
+		if err != nil {
+			t.Fatalf("scheme %d: Save: %v", scheme, err)
+		}
+
+		r, err = LoadRegion(file)
+		if err != nil {
+			t.Fatalf("scheme %d: Load: %v", scheme, err)
+		}
+
+		if _, _, have, ok := r.ReadChunkStats(xz[0], xz[1]); !ok || have != scheme {
+			t.Fatalf("scheme %d: have scheme %d, ok %v", scheme, have, ok)
+		}
+
+		var have Chunk
+		err = r.DecodeChunk(xz[0], xz[1], &have)
+		if err != nil {
+			t.Fatalf("scheme %d: %v", scheme, err)
+		}
+
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("scheme %d: chunk mismatch", scheme)
+		}
+
+		os.Remove(file)
+	}
+
+	for _, scheme := range []byte{0, 5, ZLib | External} {
+		if src.SetCompression(scheme) == nil {
+			t.Fatalf("scheme %d: expected an error", scheme)
+		}
+	}
+
+	// Unknown schemes are reported when reading.
+	src.chunks[chunkIndex(xz[0], xz[1])].scheme = 9
+
+	var c Chunk
+	err = src.DecodeChunk(xz[0], xz[1], &c)
+	if err == nil || !strings.Contains(err.Error(), "unknown compression 9") {
+		t.Fatalf("expected an unknown compression error, have %v", err)
+	}
+}
+
+func TestChunkDataVersion(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	xz := r.Chunks()[0]
+
+	// The fixture predates data versions.
+	v, err := r.ChunkDataVersion(xz[0], xz[1])
+	if err != nil || v != 0 {
+		t.Fatalf("fixture: have %d, %v", v, err)
+	}
+
+	var c Chunk
+	if !r.ReadChunk(xz[0], xz[1], &c) {
+		t.Fatal("read failed")
+	}
+
+	c.DataVersion = 2975
+	if !r.WriteChunk(xz[0], xz[1], &c) {
+		t.Fatal("write failed")
+	}
+
+	v, err = r.ChunkDataVersion(xz[0], xz[1])
+	if err != nil || v != 2975 {
+		t.Fatalf("have %d, %v, want 2975", v, err)
+	}
+
+	var free [2]int
+	for r.HasChunk(free[0], free[1]) {
+		free[0]++
+	}
+
+	if _, err = r.ChunkDataVersion(free[0], free[1]); err != ErrChunkAbsent {
+		t.Fatalf("missing chunk: have %v", err)
+	}
+}
+
+func TestLazyReadError(t *testing.T) {
+	lazy, err := LoadRegionLazy("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks which have not been read yet can no longer be loaded.
+	lazy.Close()
+
+	xz := lazy.Chunks()[0]
+	cd := lazy.chunks[chunkIndex(xz[0], xz[1])]
+
+	if _, err = cd.isExternal(); err == nil {
+		t.Fatal("isExternal: expected an error")
+	}
+
+	if _, _, ok := lazy.ChunkLengths(xz[0], xz[1]); ok {
+		t.Fatal("ChunkLengths: expected failure")
+	}
+
+	if err = lazy.SaveAs(filepath.Join(t.TempDir(), "r.0.0.mca")); err == nil {
+		t.Fatal("SaveAs: expected an error")
+	}
+}
+
+func TestLoadRegionLazy(t *testing.T) {
+	const file = "../testdata/newworld/region/r.0.0.mca"
+
+	eager, err := LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if err = eager.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lazy, err := LoadRegionLazy(file)
+	if err != nil {
+		t.Fatalf("LoadLazy: %v", err)
+	}
+
+	defer lazy.Close()
+
+	if !reflect.DeepEqual(eager.Chunks(), lazy.Chunks()) {
+		t.Fatal("chunk list mismatch")
+	}
+
+	chunks := lazy.Chunks()
+	first := chunks[0]
+
+	var a, b Chunk
+	if err = eager.DecodeChunk(first[0], first[1], &a); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lazy.DecodeChunk(first[0], first[1], &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("chunk data mismatch")
+	}
+
+	for _, xz := range chunks[1:] {
+		if _, _, ok := lazy.ChunkLengths(xz[0], xz[1]); !ok {
+			t.Fatalf("c(%d %d): missing chunk", xz[0], xz[1])
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "anvil-lazy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fileA := filepath.Join(dir, "a", "r.0.0.mca")
+	fileB := filepath.Join(dir, "b", "r.0.0.mca")
+	os.Mkdir(filepath.Dir(fileA), 0755)
+	os.Mkdir(filepath.Dir(fileB), 0755)
+
+	if err = eager.SaveAs(fileA); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lazy.SaveAs(fileB); err != nil {
+		t.Fatal(err)
+	}
+
+	dataA, _ := ioutil.ReadFile(fileA)
+	dataB, _ := ioutil.ReadFile(fileB)
+	if !bytes.Equal(dataA, dataB) {
+		t.Fatal("saved regions differ")
+	}
+
+	// Once closed, a lazy region can only serve chunks it has read.
+	lazy, err = LoadRegionLazy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lazy.DecodeChunk(first[0], first[1], &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lazy.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lazy.DecodeChunk(first[0], first[1], &b); err != nil {
+		t.Fatalf("chunk read before Close: %v", err)
+	}
+
+	last := chunks[len(chunks)-1]
+	if err = lazy.DecodeChunk(last[0], last[1], &b); err == nil {
+		t.Fatal("expected error for chunk after Close")
+	}
+
+	if err = lazy.SaveAs(fileB); err == nil {
+		t.Fatal("expected error saving a closed lazy region")
+	}
+}
+
+func TestNewRegion(t *testing.T) {
+	r := NewRegion(-1, 2)
+
+	if r.ChunkLen() != 0 {
+		t.Fatalf("new region holds %d chunks", r.ChunkLen())
+	}
+
+	if err := r.Save(); err == nil {
+		t.Fatal("expected error saving a region without a file")
+	}
+
+	var c Chunk
+	c.Init(-29, 68)
+	c.SetBlock(1, 2, 3, BlockState{Name: "minecraft:stone"})
+	c.DataVersion = 3465
+
+	if !r.WriteChunk(3, 4, &c) {
+		t.Fatal("write failed")
+	}
+
+	dir, err := ioutil.TempDir("", "anvil-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "r.-1.2.mca")
+	if err = r.SaveAs(file); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Size()%sectorSize != 0 || fi.Size() < 3*sectorSize {
+		t.Fatalf("unexpected file size %d", fi.Size())
+	}
+
+	s, err := LoadRegion(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.X != -1 || s.Z != 2 || !reflect.DeepEqual(s.Chunks(), [][2]int{{3, 4}}) {
+		t.Fatalf("unexpected region r(%d %d) with chunks %v", s.X, s.Z, s.Chunks())
+	}
+
+	var d Chunk
+	if err = s.DecodeChunk(3, 4, &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, ok := d.BlockState(1, 2, 3); !ok || b.Name != "minecraft:stone" {
+		t.Fatalf("block mismatch: %+v %v", b, ok)
+	}
+
+	if d.X != -29 || d.Z != 68 || d.DataVersion != 3465 {
+		t.Fatalf("chunk mismatch: pos %d %d, version %d", d.X, d.Z, d.DataVersion)
+	}
+
+	if ts, ok := s.ChunkTimestamp(3, 4); !ok || ts.IsZero() || ts.Unix() == 0 {
+		t.Fatalf("missing timestamp: %v", ts)
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	const file = "../testdata/newworld/region/r.0.0.mca"
+	const readers = 32
+
+	eager, err := LoadRegion(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := eager.Chunks()
+	if len(chunks) < readers {
+		t.Fatalf("fixture holds only %d chunks", len(chunks))
+	}
+
+	want := make([]Chunk, readers)
+	for i := range want {
+		if err = eager.DecodeChunk(chunks[i][0], chunks[i][1], &want[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lazy, err := LoadRegionLazy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lazy.Close()
+
+	for _, r := range []*Region{eager, lazy} {
+		var wg sync.WaitGroup
+
+		// Every goroutine reads a chunk of its own, as well as the first
+		// chunk, which all of them share.
+		for i := 0; i < readers; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				for _, n := range []int{i, 0} {
+					var c Chunk
+					err := r.DecodeChunk(chunks[n][0], chunks[n][1], &c)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					if !reflect.DeepEqual(c, want[n]) {
+						t.Errorf("c(%d %d): data mismatch", chunks[n][0], chunks[n][1])
+					}
+
+					if _, _, ok := r.ChunkLengths(chunks[n][0], chunks[n][1]); !ok {
+						t.Errorf("c(%d %d): missing lengths", chunks[n][0], chunks[n][1])
+					}
+				}
+			}(i)
+		}
+
+		wg.Wait()
+	}
+}
+
+func TestRegionStats(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	st := r.Stats()
+
+	if st.Chunks != r.ChunkLen() {
+		t.Fatalf("chunk count mismatch: have %d, want %d", st.Chunks, r.ChunkLen())
+	}
+
+	if st.UsedSectors+st.FreeSectors != st.FileSectors || st.UsedSectors < 2+st.Chunks {
+		t.Fatalf("inconsistent sector counts: %+v", st)
+	}
+
+	if _, sectors, _ := r.ChunkLengths(st.Largest[0], st.Largest[1]); sectors != st.LargestSectors {
+		t.Fatalf("largest chunk mismatch: have %d sectors, stats say %d", sectors, st.LargestSectors)
+	}
+
+	// Saving packs all chunks, leaving no gaps.
+	dir, err := ioutil.TempDir("", "anvil-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	xz := r.Chunks()[0]
+	r.DeleteChunk(xz[0], xz[1])
+
+	if have := r.Stats(); have.Chunks != st.Chunks-1 {
+		t.Fatalf("chunk count after delete: have %d, want %d", have.Chunks, st.Chunks-1)
+	}
+
+	if err = r.SaveAs(filepath.Join(dir, "r.0.0.mca")); err != nil {
+		t.Fatal(err)
+	}
+
+	st = r.Stats()
+	if st.FreeSectors != 0 || st.UsedSectors != st.FileSectors {
+		t.Fatalf("saved region has free sectors: %+v", st)
+	}
+
+	n := NewRegion(0, 0)
+	if st = n.Stats(); st != (RegionStats{}) {
+		t.Fatalf("new region: %+v", st)
+	}
+}
+
+func TestHasChunk(t *testing.T) {
+	r, err := LoadRegionLazy("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	want := make(map[[2]int]bool)
+	for _, xz := range r.Chunks() {
+		want[xz] = true
+	}
+
+	for x := 0; x < ChunksPerRegion; x++ {
+		for z := 0; z < ChunksPerRegion; z++ {
+			if have := r.HasChunk(x, z); have != want[[2]int{x, z}] {
+				t.Fatalf("c(%d %d): have %v, want %v", x, z, have, !have)
+			}
+		}
+	}
+
+	// The chunk payloads are never read.
+	for _, cd := range r.chunks {
+		if cd != nil && cd.data != nil {
+			t.Fatalf("c(%d %d): data was loaded", cd.X, cd.Z)
+		}
+	}
+}
+
+func TestChunkPosition(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c Chunk
+	for _, xz := range r.Chunks() {
+		x, y, z, err := r.ChunkPosition(xz[0], xz[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if int(x) != r.X*ChunksPerRegion+xz[0] || int(z) != r.Z*ChunksPerRegion+xz[1] || y != 0 {
+			t.Fatalf("c(%d %d): unexpected position %d %d %d", xz[0], xz[1], x, y, z)
+		}
+
+		if err = r.DecodeChunk(xz[0], xz[1], &c); err != nil {
+			t.Fatal(err)
+		}
+
+		if cx, cy, cz := c.Position(); cx != x || cy != y || cz != z {
+			t.Fatalf("c(%d %d): decoded position %d %d %d, want %d %d %d", xz[0], xz[1], cx, cy, cz, x, y, z)
+		}
+	}
+
+	// A chunk written by Minecraft 1.18+, in a region with negative
+	// coordinates, at the last column of the region.
+	r = NewRegion(-1, 2)
+	raw := struct {
+		DataVersion int32 `nbt:"DataVersion"`
+		X           int32 `nbt:"xPos"`
+		Y           int32 `nbt:"yPos"`
+		Z           int32 `nbt:"zPos"`
+	}{2975, -1, -4, 64}
+
+	if err = r.writable(31, 0).encode(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	x, y, z, err := r.ChunkPosition(31, 0)
+	if err != nil || x != -1 || y != -4 || z != 64 {
+		t.Fatalf("unexpected position %d %d %d: %v", x, y, z, err)
+	}
+
+	if err = r.DecodeChunk(31, 0, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if x, y, z = c.Position(); x != -1 || y != -4 || z != 64 {
+		t.Fatalf("unexpected decoded position %d %d %d", x, y, z)
+	}
+
+	if _, _, _, err = r.ChunkPosition(0, 0); err != ErrChunkAbsent {
+		t.Fatalf("expected ErrChunkAbsent, have %v", err)
+	}
+
+	// Chunks without a position are reported.
+	if err = r.writable(1, 1).encode(struct{ V int8 }{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err = r.ChunkPosition(1, 1); err == nil {
+		t.Fatal("expected error for chunk without position")
+	}
+}
+
+func TestChunkRaw(t *testing.T) {
+	src, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xz := src.Chunks()[0]
+
+	data, scheme, ok := src.ReadChunkRaw(xz[0], xz[1])
+	if !ok {
+		t.Fatal("read failed")
+	}
+
+	if scheme != src.chunks[chunkIndex(xz[0], xz[1])].scheme&^External {
+		t.Fatalf("unexpected scheme %d", scheme)
+	}
+
+	var want Chunk
+	if err = src.DecodeChunk(xz[0], xz[1], &want); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegion(0, 0)
+	if _, _, ok = r.ReadChunkRaw(xz[0], xz[1]); ok {
+		t.Fatal("read of absent chunk succeeded")
+	}
+
+	for _, scheme := range []byte{GZip, ZLib, Uncompressed, LZ4} {
+		if !r.WriteChunkRaw(xz[0], xz[1], data, scheme) {
+			t.Fatalf("scheme %d: write failed", scheme)
+		}
+
+		have, haveScheme, ok := r.ReadChunkRaw(xz[0], xz[1])
+		if !ok || haveScheme != scheme || !bytes.Equal(have, data) {
+			t.Fatalf("scheme %d: raw mismatch: %v %d", scheme, ok, haveScheme)
+		}
+
+		var c Chunk
+		if err = r.DecodeChunk(xz[0], xz[1], &c); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(c, want) {
+			t.Fatalf("scheme %d: chunk mismatch", scheme)
+		}
+	}
+
+	// The data survives a save as it is.
+	file := filepath.Join(t.TempDir(), "r.0.0.mca")
+	if err = r.SaveAs(file); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have, scheme, ok := r.ReadChunkRaw(xz[0], xz[1]); !ok || scheme != LZ4 || !bytes.Equal(have, data) {
+		t.Fatalf("saved raw mismatch: %v %d", ok, scheme)
+	}
+
+	invalid := []struct {
+		Data   []byte
+		Scheme byte
+	}{
+		{data, 0},
+		{data, ZLib | External},
+		{data[:len(data)/2], ZLib},
+		{[]byte("not nbt"), ZLib},
+	}
+
+	for i, tc := range invalid {
+		if r.WriteChunkRaw(1, 1, tc.Data, tc.Scheme) || r.HasChunk(1, 1) {
+			t.Fatalf("invalid write %d succeeded", i)
+		}
+	}
+}
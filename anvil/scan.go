@@ -0,0 +1,172 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// WorldStats holds aggregate statistics for all chunks in a dimension.
+type WorldStats struct {
+	Regions       int   // Number of region files.
+	Chunks        int   // Number of generated chunks.
+	CorruptChunks int   // Number of chunks which could not be decoded.
+	Blocks        int64 // Number of blocks which are not air.
+	Entities      int   // Number of entities.
+	TileEntities  int   // Number of tile entities.
+
+	// Absolute chunk coordinates of the bounding box around all chunks.
+	// These are only valid if Chunks is not zero.
+	MinX, MinZ int
+	MaxX, MaxZ int
+}
+
+// add merges the statistics in o into s.
+func (s *WorldStats) add(o *WorldStats) {
+	if o.Chunks > 0 {
+		if s.Chunks == 0 {
+			s.MinX, s.MinZ, s.MaxX, s.MaxZ = o.MinX, o.MinZ, o.MaxX, o.MaxZ
+		} else {
+			s.include(o.MinX, o.MinZ)
+			s.include(o.MaxX, o.MaxZ)
+		}
+	}
+
+	s.Regions += o.Regions
+	s.Chunks += o.Chunks
+	s.CorruptChunks += o.CorruptChunks
+	s.Blocks += o.Blocks
+	s.Entities += o.Entities
+	s.TileEntities += o.TileEntities
+}
+
+// include grows the bounding box so it contains the given chunk.
+func (s *WorldStats) include(x, z int) {
+	if x < s.MinX {
+		s.MinX = x
+	}
+
+	if z < s.MinZ {
+		s.MinZ = z
+	}
+
+	if x > s.MaxX {
+		s.MaxX = x
+	}
+
+	if z > s.MaxZ {
+		s.MaxZ = z
+	}
+}
+
+// ScanWorld walks all regions and chunks of the given dimension in the
+// world at root and returns aggregate statistics for them. Regions are
+// scanned in parallel, using one worker per CPU.
+//
+// Chunks which can not be decoded are counted in WorldStats.CorruptChunks,
+// but do not abort the scan. An error is returned if a region file can
+// not be read at all.
+func ScanWorld(root string, dim Dimension) (WorldStats, error) {
+	return ScanWorldN(root, dim, runtime.NumCPU())
+}
+
+// ScanWorldN behaves like ScanWorld, but scans at most the given number
+// of regions at the same time.
+func ScanWorldN(root string, dim Dimension, workers int) (WorldStats, error) {
+	var stats WorldStats
+
+	files, err := filepath.Glob(filepath.Join(root, string(dim), "r.*"+RegionFileExtension))
+	if err != nil {
+		return stats, fmt.Errorf("anvil: scan world: %v", err)
+	}
+
+	if len(files) == 0 {
+		if _, err = os.Stat(filepath.Join(root, string(dim))); err != nil {
+			return stats, fmt.Errorf("anvil: scan world: %v", err)
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	queue := make(chan string)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for file := range queue {
+				rs, err := scanRegion(file)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				stats.add(&rs)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, file := range files {
+		if _, _, ok := RegionCoords(file); ok {
+			queue <- file
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+	return stats, firstErr
+}
+
+// scanRegion returns the statistics for a single region file.
+func scanRegion(file string) (WorldStats, error) {
+	var stats WorldStats
+
+	r, err := LoadRegion(file)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Regions = 1
+
+	err = r.EachChunk(func(x, z int, c *Chunk, err error) error {
+		cx := r.X*ChunksPerRegion + mod(x, ChunksPerRegion)
+		cz := r.Z*ChunksPerRegion + mod(z, ChunksPerRegion)
+
+		if stats.Chunks == 0 {
+			stats.MinX, stats.MinZ, stats.MaxX, stats.MaxZ = cx, cz, cx, cz
+		} else {
+			stats.include(cx, cz)
+		}
+
+		stats.Chunks++
+
+		if err != nil {
+			stats.CorruptChunks++
+			return nil
+		}
+
+		stats.Entities += len(c.Entities)
+		stats.TileEntities += len(c.TileEntities)
+
+		for i := range c.Sections {
+			stats.Blocks += int64(c.Sections[i].BlockCount())
+		}
+
+		return nil
+	})
+
+	return stats, err
+}
@@ -0,0 +1,59 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanWorld(t *testing.T) {
+	const File = "../testdata/newworld/region/r.0.0.mca"
+
+	root := t.TempDir()
+	dir := filepath.Join(root, string(DimensionOverworld))
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !copyFile(filepath.Join(dir, "r.0.0.mca"), File) ||
+		!copyFile(filepath.Join(dir, "r.-1.2.mca"), File) {
+		t.Fatal("copy region failed")
+	}
+
+	r, err := LoadRegion(File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ScanWorldN(root, DimensionOverworld, 1)
+	if err != nil {
+		t.Fatalf("scan 1: %v", err)
+	}
+
+	b, err := ScanWorld(root, DimensionOverworld)
+	if err != nil {
+		t.Fatalf("scan 2: %v", err)
+	}
+
+	if a != b {
+		t.Fatalf("parallel scan mismatch:\nHave: %+v\nWant: %+v", b, a)
+	}
+
+	if a.Regions != 2 || a.Chunks != 2*r.ChunkLen() || a.CorruptChunks != 0 {
+		t.Fatalf("unexpected counts: %+v", a)
+	}
+
+	if a.Blocks == 0 || a.MinX != -32 || a.MaxZ < 64 {
+		t.Fatalf("unexpected stats: %+v", a)
+	}
+
+	_, err = ScanWorld(root, DimensionNether)
+	if err == nil {
+		t.Fatal("expected error for missing dimension")
+	}
+}
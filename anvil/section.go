@@ -10,13 +10,22 @@ import "github.com/jteeuwen/mctools/anvil/item"
 // Each chunk is divided up into 16 equal sections.
 // Only generated sections will be saved to the world file.
 // This is done to save file space. Each section spans 16*16*16 blocks.
+//
+// Sections written by Minecraft 1.18+ do not use the numeric block ids.
+// They store their blocks in BlockStates instead. Minecraft 1.13 - 1.17
+// stores a palette in Palette, and the packed palette indices in
+// PackedStates.
 type Section struct {
-	Blocks     []uint8 `nbt:"Blocks"`        // Primary block IDs -- 8 bits per block.
-	Add        []uint8 `nbt:"Add,omitempty"` // Optional extra block ID information -- 4 bits per block.
-	Data       []uint8 `nbt:"Data"`          // Block data -- 4 bits per block.
-	BlockLight []uint8 `nbt:"BlockLight"`    // Amount of block-emitted light in each block -- 4 bits per block.
-	SkyLight   []uint8 `nbt:"SkyLight"`      // Amount of sunlight or moonlight hitting each block -- 4 bits per block.
-	Y          byte    `nbt:"Y"`             // Y index for this section.
+	BlockStates  *BlockStates  `nbt:"block_states"`          // Paletted block states (1.18+).
+	Biomes       *BiomePalette `nbt:"biomes"`                // Paletted biomes (1.18+).
+	Palette      []BlockState  `nbt:"Palette,omitempty"`     // Block palette (1.13 - 1.17).
+	PackedStates []int64       `nbt:"BlockStates,omitempty"` // Packed indices into Palette (1.13 - 1.17).
+	Blocks       []uint8       `nbt:"Blocks"`                // Primary block IDs -- 8 bits per block.
+	Add          []uint8       `nbt:"Add,omitempty"`         // Optional extra block ID information -- 4 bits per block.
+	Data         []uint8       `nbt:"Data"`                  // Block data -- 4 bits per block.
+	BlockLight   []uint8       `nbt:"BlockLight"`            // Amount of block-emitted light in each block -- 4 bits per block.
+	SkyLight     []uint8       `nbt:"SkyLight"`              // Amount of sunlight or moonlight hitting each block -- 4 bits per block.
+	Y            byte          `nbt:"Y"`                     // Y index for this section.
 }
 
 // Init initializes the section to default, empty settings.
@@ -88,6 +97,260 @@ func (s *Section) Read(x, y, z int, b *Block) bool {
 	return true
 }
 
+// BlockCount returns the number of blocks in this section which are not air.
+func (s *Section) BlockCount() int {
+	var n int
+
+	if s.BlockStates != nil {
+		bs := s.BlockStates
+		if len(bs.Palette) == 0 {
+			return 0
+		}
+
+		air := make([]bool, len(bs.Palette))
+		for i := range bs.Palette {
+			air[i] = isAir(bs.Palette[i].Name)
+		}
+
+		bits := bs.Bits()
+		for i := 0; i < sectionVolume; i++ {
+			if v := unpackIndex(bs.Data, bits, i); v >= len(air) || !air[v] {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	if len(s.Palette) > 0 {
+		for i := 0; i < sectionVolume; i++ {
+			if v := unpackLegacyIndex(s.PackedStates, len(s.Palette), i); v >= len(s.Palette) || !isAir(s.Palette[v].Name) {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	for i := range s.Blocks {
+		if s.Blocks[i] != 0 || (len(s.Add) > 0 && gnibble(s.Add, i) != 0) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// State returns the block state at the specified coordinates.
+// This only applies to paletted sections, as written by Minecraft 1.13+.
+//
+// Returns false if the coordinates are out of range or the section has no
+// block states.
+func (s *Section) State(x, y, z int) (BlockState, bool) {
+	index := y*16*16 + z*16 + x
+
+	if index < 0 || index >= sectionVolume {
+		return BlockState{}, false
+	}
+
+	if s.BlockStates != nil {
+		return s.BlockStates.Get(index), true
+	}
+
+	if len(s.Palette) == 0 {
+		return BlockState{}, false
+	}
+
+	n := unpackLegacyIndex(s.PackedStates, len(s.Palette), index)
+	if n >= len(s.Palette) {
+		return BlockState{Name: AirBlock}, true
+	}
+
+	return s.Palette[n], true
+}
+
+// SetState stores the given block state for the specified coordinates.
+//
+// A section without BlockStates is converted to them first, keeping all of
+// its blocks. This applies to the palette written by Minecraft 1.13 - 1.17,
+// as well as to numeric block ids, which are named through
+// LegacyBlockStates. The old block data is removed, as the section uses the
+// 1.18+ layout from then on.
+//
+// Returns false if the coordinates are out of range.
+func (s *Section) SetState(x, y, z int, b BlockState) bool {
+	index := y*16*16 + z*16 + x
+
+	if index < 0 || index >= sectionVolume {
+		return false
+	}
+
+	if s.BlockStates == nil {
+		s.convertStates()
+	}
+
+	s.BlockStates.Set(index, b)
+	return true
+}
+
+// convertStates stores the blocks of a section without BlockStates in a
+// new BlockStates value, and clears the block data they came from.
+func (s *Section) convertStates() {
+	switch {
+	case len(s.Palette) > 0:
+		s.BlockStates = s.paletteStates()
+	case s.IsLegacy():
+		s.BlockStates = s.LegacyBlockStates()
+	default:
+		s.BlockStates = new(BlockStates)
+	}
+
+	s.Palette = nil
+	s.PackedStates = nil
+	s.Blocks = nil
+	s.Add = nil
+	s.Data = nil
+}
+
+// paletteStates converts the palette of a section written by Minecraft
+// 1.13 - 1.17 into BlockStates. Indices which lie outside the palette
+// refer to air, as they do in State.
+func (s *Section) paletteStates() *BlockStates {
+	bs := &BlockStates{Palette: append([]BlockState(nil), s.Palette...)}
+	air := -1
+
+	for i := 0; i < sectionVolume; i++ {
+		if unpackLegacyIndex(s.PackedStates, len(s.Palette), i) >= len(s.Palette) {
+			air = len(bs.Palette)
+			bs.Palette = append(bs.Palette, BlockState{Name: AirBlock})
+			break
+		}
+	}
+
+	bits := bs.Bits()
+	if bits == 0 {
+		return bs
+	}
+
+	bs.Data = make([]int64, packedLen(bits, sectionVolume))
+
+	for i := 0; i < sectionVolume; i++ {
+		n := unpackLegacyIndex(s.PackedStates, len(s.Palette), i)
+		if n >= len(s.Palette) {
+			n = air
+		}
+
+		packIndex(bs.Data, bits, i, n)
+	}
+
+	return bs
+}
+
+// Biome returns the biome at the specified coordinates, in biome cells
+// (0-3). This only applies to sections written by Minecraft 1.18+.
+//
+// Returns false if the coordinates are out of range or the section has no
+// biome data.
+func (s *Section) Biome(x, y, z int) (string, bool) {
+	index := biomeIndex(x, y, z)
+
+	if s.Biomes == nil || index < 0 {
+		return "", false
+	}
+
+	return s.Biomes.Get(index), true
+}
+
+// SetBiome stores the given biome for the specified coordinates, in biome
+// cells (0-3).
+//
+// Returns false if the coordinates are out of range.
+func (s *Section) SetBiome(x, y, z int, biome string) bool {
+	index := biomeIndex(x, y, z)
+
+	if index < 0 {
+		return false
+	}
+
+	if s.Biomes == nil {
+		s.Biomes = new(BiomePalette)
+	}
+
+	s.Biomes.Set(index, biome)
+	return true
+}
+
+// BlockLightAt returns the light level emitted by blocks at the specified
+// coordinates, in the range 0-15 (MaxLight). Sections without block light
+// data, or with an array of the wrong size, report 0.
+//
+// Returns 0 if the coordinates are out of range.
+func (s *Section) BlockLightAt(x, y, z int) uint8 {
+	return lightAt(s.BlockLight, x, y, z, 0)
+}
+
+// SkyLightAt returns the light level of sunlight or moonlight at the
+// specified coordinates, in the range 0-15 (MaxLight). Sections without
+// sky light data, or with an array of the wrong size, report MaxLight,
+// like a section which is fully open to the sky.
+//
+// Returns 0 if the coordinates are out of range.
+func (s *Section) SkyLightAt(x, y, z int) uint8 {
+	return lightAt(s.SkyLight, x, y, z, MaxLight)
+}
+
+// lightAt returns the nibble for the given block from the light array arr.
+// Returns def if arr does not hold a full section.
+func lightAt(arr []uint8, x, y, z int, def uint8) uint8 {
+	index := blockIndex(x, y, z)
+
+	switch {
+	case index < 0:
+		return 0
+	case len(arr) != sectionVolume/2:
+		return def
+	}
+
+	return gnibble(arr, index)
+}
+
+// blockIndex returns the index of the given block in a section. Returns -1
+// if the coordinates are out of range.
+func blockIndex(x, y, z int) int {
+	const n = BlocksPerSection
+
+	if x < 0 || x >= n || y < 0 || y >= n || z < 0 || z >= n {
+		return -1
+	}
+
+	return (y*n+z)*n + x
+}
+
+// biomeIndex returns the palette index for the given biome cell.
+// Returns -1 if the coordinates are out of range.
+func biomeIndex(x, y, z int) int {
+	const n = BlocksPerSection / BiomeCellSize
+
+	if x < 0 || x >= n || y < 0 || y >= n || z < 0 || z >= n {
+		return -1
+	}
+
+	return (y*n+z)*n + x
+}
+
+// Compact removes unused entries from the section's block palette.
+// Refer to BlockStates.Compact for details.
+//
+// This only applies to BlockStates. The palette of a section written by
+// Minecraft 1.13 - 1.17 is left as it is, since its packing depends on the
+// version which wrote it. SetState converts such a section to BlockStates,
+// after which it can be compacted.
+func (s *Section) Compact() {
+	if s.BlockStates != nil {
+		s.BlockStates.Compact()
+	}
+}
+
 // gnibble returns either upper or lower 4-bits for a given index.
 func gnibble(arr []uint8, index int) uint8 {
 	if index%2 == 0 {
@@ -0,0 +1,168 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jteeuwen/mctools/anvil/nbt"
+)
+
+func TestSectionLight(t *testing.T) {
+	// Each byte holds the light of two neighbouring blocks along x. The
+	// low nibble belongs to the even block, the high one to the odd block.
+	blockLight := make([]byte, 2048)
+	blockLight[0] = 0x21    // x=0 y=0 z=0 is 1, x=1 is 2.
+	blockLight[8] = 0xf0    // x=1 y=0 z=1 is 15.
+	blockLight[128] = 0x07  // x=0 y=1 z=0 is 7.
+	blockLight[2047] = 0xc0 // x=15 y=15 z=15 is 12.
+
+	skyLight := make([]byte, 2048)
+	skyLight[1] = 0x0e // x=2 y=0 z=0 is 14.
+
+	raw := struct {
+		Y          int8   `nbt:"Y"`
+		BlockLight []byte `nbt:"BlockLight"`
+		SkyLight   []byte `nbt:"SkyLight"`
+	}{3, blockLight, skyLight}
+
+	var buf bytes.Buffer
+	if err := nbt.Marshal(&buf, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	var s Section
+	if err := nbt.Unmarshal(&buf, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		X, Y, Z  int
+		Block    uint8
+		Sky      uint8
+		Describe string
+	}{
+		{0, 0, 0, 1, 0, "low nibble"},
+		{1, 0, 0, 2, 0, "high nibble"},
+		{2, 0, 0, 0, 14, "second byte"},
+		{1, 0, 1, 15, 0, "next row"},
+		{0, 1, 0, 7, 0, "next layer"},
+		{15, 15, 15, 12, 0, "last block"},
+		{16, 0, 0, 0, 0, "out of range"},
+		{0, -1, 0, 0, 0, "out of range"},
+	}
+
+	for _, tc := range tests {
+		if have := s.BlockLightAt(tc.X, tc.Y, tc.Z); have != tc.Block {
+			t.Errorf("%s: block light at %d %d %d: have %d, want %d", tc.Describe, tc.X, tc.Y, tc.Z, have, tc.Block)
+		}
+
+		if have := s.SkyLightAt(tc.X, tc.Y, tc.Z); have != tc.Sky {
+			t.Errorf("%s: sky light at %d %d %d: have %d, want %d", tc.Describe, tc.X, tc.Y, tc.Z, have, tc.Sky)
+		}
+	}
+
+	// Sections without light data have no block light and full sky light.
+	var empty Section
+	if empty.BlockLightAt(4, 5, 6) != 0 || empty.SkyLightAt(4, 5, 6) != MaxLight {
+		t.Fatalf("unexpected default light %d %d", empty.BlockLightAt(4, 5, 6), empty.SkyLightAt(4, 5, 6))
+	}
+}
+
+func TestSectionLightFixture(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xz := r.Chunks()[0]
+
+	var c Chunk
+	if err = r.DecodeChunk(xz[0], xz[1], &c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Light read through Section.Read must agree.
+	var b Block
+	for i := range c.Sections {
+		s := &c.Sections[i]
+
+		for y := 0; y < BlocksPerSection; y++ {
+			for z := 0; z < BlocksPerChunk; z++ {
+				for x := 0; x < BlocksPerChunk; x++ {
+					if !s.Read(x, y, z, &b) {
+						t.Fatalf("section %d: read %d %d %d", s.Y, x, y, z)
+					}
+
+					if s.BlockLightAt(x, y, z) != b.BlockLight || s.SkyLightAt(x, y, z) != b.SkyLight {
+						t.Fatalf("section %d: light mismatch at %d %d %d", s.Y, x, y, z)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSectionWriteLegacy(t *testing.T) {
+	var c Chunk
+	c.Init(3, -2)
+	c.Sections = append(c.Sections, Section{Y: 1}, Section{})
+	c.Sections[1].Init(2)
+	c.Sections[1].Blocks[17] = 4
+	c.Sections[1].Data[8] = 0x30
+	c.Sections[1].SkyLight[2] = 0xf0
+
+	cd := ChunkDescriptor{X: 3, Z: -2, scheme: ZLib}
+	if !cd.Write(&c) {
+		t.Fatal("write failed")
+	}
+
+	data, err := cd.raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pre-1.13 sections keep their legacy arrays, even when empty.
+	var tree struct {
+		Level struct {
+			Sections []map[string]interface{} `nbt:"Sections"`
+		}
+	}
+
+	if err = nbt.Unmarshal(bytes.NewReader(data), &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tree.Level.Sections) != 2 {
+		t.Fatalf("sections: have %d, want 2", len(tree.Level.Sections))
+	}
+
+	for _, s := range tree.Level.Sections {
+		for _, name := range []string{"Blocks", "Data", "BlockLight", "SkyLight"} {
+			if _, ok := s[name]; !ok {
+				t.Errorf("section %v: %s missing", s["Y"], name)
+			}
+		}
+	}
+
+	var again Chunk
+	if err = cd.read(&again); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(again.Sections) != 2 {
+		t.Fatalf("sections: have %d, want 2", len(again.Sections))
+	}
+
+	for i := range c.Sections {
+		have, want := &again.Sections[i], &c.Sections[i]
+
+		if have.Y != want.Y || !bytes.Equal(have.Blocks, want.Blocks) ||
+			!bytes.Equal(have.Data, want.Data) || !bytes.Equal(have.BlockLight, want.BlockLight) ||
+			!bytes.Equal(have.SkyLight, want.SkyLight) {
+			t.Errorf("section %d: mismatch after round trip", want.Y)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ChunkError describes a problem with a single chunk in a region.
+type ChunkError struct {
+	X, Z int   // Chunk coordinates in the region.
+	Err  error // Description of the problem.
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("anvil: c(%d %d): %v", e.X, e.Z, e.Err)
+}
+
+// Validate checks every chunk in the region for damage which keeps it
+// from being read: an unknown compression scheme, data which does not
+// decompress, or a missing external .mcc file.
+//
+// Returns one error for each damaged chunk, in the order in which they
+// are stored in the region header. Returns nil if all chunks are fine.
+func (r *Region) Validate() []*ChunkError {
+	var errs []*ChunkError
+
+	for _, cd := range r.chunks {
+		if cd == nil {
+			continue
+		}
+
+		err := cd.validate()
+		if err != nil {
+			errs = append(errs, &ChunkError{X: cd.X, Z: cd.Z, Err: err})
+		}
+	}
+
+	return errs
+}
+
+// validate checks that the chunk's data can be decompressed.
+func (cd *ChunkDescriptor) validate() error {
+	if !validScheme(cd.scheme) {
+		return fmt.Errorf("chunk at (%d,%d): unknown compression %d", cd.X, cd.Z, cd.scheme)
+	}
+
+	r, err := cd.reader()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
@@ -0,0 +1,86 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package anvil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	r, err := LoadRegion("../testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if errs := r.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	xz := r.Chunks()
+	if len(xz) < 2 {
+		t.Fatalf("need at least two chunks, have %d", len(xz))
+	}
+
+	bad := xz[0]
+	r.chunks[chunkIndex(bad[0], bad[1])].scheme = 7
+
+	trunc := r.chunks[chunkIndex(xz[1][0], xz[1][1])]
+	trunc.data = trunc.data[:len(trunc.data)/2]
+
+	dir, err := ioutil.TempDir("", "anvil-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// The damage survives a round trip through a file.
+	file := filepath.Join(dir, "r.0.0.mca")
+
+	err = r.SaveAs(file)
+	if err != nil {
+		t.Fatalf("SaveAs: %v", err)
+	}
+
+	r, err = LoadRegion(file)
+	if err != nil {
+		t.Fatalf("Load damaged: %v", err)
+	}
+
+	errs := r.Validate()
+	if len(errs) != 2 {
+		t.Fatalf("error count mismatch: have %v, want 2", errs)
+	}
+
+	if errs[0].X != bad[0] || errs[0].Z != bad[1] {
+		t.Fatalf("error position mismatch: have (%d %d), want %v", errs[0].X, errs[0].Z, bad)
+	}
+
+	if !strings.Contains(errs[0].Error(), "unknown compression 7") {
+		t.Fatalf("unexpected error: %v", errs[0])
+	}
+
+	var c Chunk
+	if r.ReadChunk(bad[0], bad[1], &c) {
+		t.Fatalf("ReadChunk succeeded for invalid compression")
+	}
+}
+
+func TestValidScheme(t *testing.T) {
+	for _, scheme := range []byte{GZip, ZLib, Uncompressed, LZ4, GZip | External, ZLib | External} {
+		if !validScheme(scheme) {
+			t.Fatalf("scheme %d: expected valid", scheme)
+		}
+	}
+
+	for _, scheme := range []byte{0, 5, 0x7f, External, 0xff} {
+		if validScheme(scheme) {
+			t.Fatalf("scheme %d: expected invalid", scheme)
+		}
+	}
+}
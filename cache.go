@@ -0,0 +1,138 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package mctools
+
+import (
+	"container/list"
+
+	"github.com/jteeuwen/mctools/anvil"
+)
+
+// DefaultRegionCacheSize defines the default number of regions a world
+// keeps loaded when accessing chunks through World.Chunk.
+const DefaultRegionCacheSize = 16
+
+// regionKey uniquely identifies a region in a world.
+type regionKey struct {
+	dim  string
+	x, z int
+}
+
+// cachedRegion defines a single entry in the region cache.
+type cachedRegion struct {
+	key    regionKey
+	region *anvil.Region
+	dirty  bool // Region has unsaved chunk changes.
+}
+
+// regionCache holds a bounded set of loaded regions.
+// When it is full, the least recently used region is evicted
+// and saved if it has pending changes.
+type regionCache struct {
+	size    int
+	order   *list.List // Most recently used entries are at the front.
+	entries map[regionKey]*list.Element
+}
+
+// newRegionCache creates a new cache holding at most size regions.
+func newRegionCache(size int) *regionCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &regionCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[regionKey]*list.Element),
+	}
+}
+
+// get returns the cached entry for the given key and marks it as
+// most recently used. Returns nil if it is not cached.
+func (c *regionCache) get(key regionKey) *cachedRegion {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedRegion)
+}
+
+// add adds the region to the cache. If this exceeds the cache size,
+// the least recently used entries are evicted and saved if needed.
+//
+// An entry whose region can not be saved is kept, along with its changes,
+// so the cache may temporarily grow beyond its size. Saving it is tried
+// again on the next eviction, and by flush.
+func (c *regionCache) add(key regionKey, r *anvil.Region) *cachedRegion {
+	cr := &cachedRegion{key: key, region: r}
+	c.entries[key] = c.order.PushFront(cr)
+
+	for e := c.order.Back(); e != nil && c.order.Len() > c.size; {
+		prev := e.Prev()
+
+		if old := e.Value.(*cachedRegion); old != cr {
+			c.remove(old.key)
+		}
+
+		e = prev
+	}
+
+	return cr
+}
+
+// remove drops the given entry from the cache.
+// Its region is saved first if it has unsaved changes. If this fails, the
+// entry stays in the cache and the error is returned.
+func (c *regionCache) remove(key regionKey) error {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	cr := e.Value.(*cachedRegion)
+
+	if cr.dirty {
+		err := cr.region.Save()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.order.Remove(e)
+	delete(c.entries, key)
+	return nil
+}
+
+// discard drops the given entry from the cache without saving it.
+func (c *regionCache) discard(key regionKey) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	c.order.Remove(e)
+	delete(c.entries, key)
+}
+
+// flush saves all regions with unsaved changes and clears the cache.
+// It returns the first error encountered, but tries all regions. Entries
+// whose region could not be saved are kept, so flush can be retried.
+func (c *regionCache) flush() error {
+	var err error
+
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+
+		cr := el.Value.(*cachedRegion)
+		if e := c.remove(cr.key); e != nil && err == nil {
+			err = e
+		}
+
+		el = next
+	}
+
+	return err
+}
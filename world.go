@@ -4,10 +4,16 @@
 package mctools
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/jteeuwen/mctools/anvil"
 )
@@ -19,21 +25,29 @@ const (
 	DimensionEnd       = "DIM1/region"
 )
 
+// ErrReadOnly is returned when modifying a world opened through io/fs.
+var ErrReadOnly = errors.New("mctools: world is read-only")
+
+// ErrRegionAbsent is returned by World.Chunk for chunks whose region does
+// not exist, if absent errors are enabled. See World.SetAbsentErrors.
+var ErrRegionAbsent = errors.New("mctools: region not present")
+
 // World defines a single Minecraft world.
 type World struct {
 	*anvil.Level                     // level.dat contents.
 	root         string              // Directory with world data.
+	fsys         fs.FS               // File system holding root, if not the OS.
 	regions      map[string][][2]int // List of known regions in this world - grouped by dimension.
+	cache        *regionCache        // Regions loaded through World.Chunk.
+	absentErrors bool                // Report absent chunks as errors.
+	dataVersion  int32               // Data version for written chunks without one.
 }
 
 // Open opens a new world in the given root directory.
 func Open(root string) (*World, error) {
 	var err error
 
-	w := &World{
-		root:    root,
-		regions: make(map[string][][2]int),
-	}
+	w := newWorld(root, nil)
 
 	// Load level.dat
 	w.Level, err = anvil.LoadLevel(filepath.Join(root, "level.dat"))
@@ -41,22 +55,593 @@ func Open(root string) (*World, error) {
 		return nil, fmt.Errorf("mctools: load level.dat: %v", err)
 	}
 
-	// Find all regions in all dimensions.
-	w.regions[DimensionOverworld] = listFiles(root, DimensionOverworld)
-	w.regions[DimensionNether] = listFiles(root, DimensionNether)
-	w.regions[DimensionEnd] = listFiles(root, DimensionEnd)
-	return w, nil
+	return w, w.index()
+}
+
+// OpenFS opens the world at the root of fsys. This reads worlds from any
+// io/fs file system, like a zip archive, without extracting them first.
+// Use fs.Sub or NewWorldFS to open a world stored in a subdirectory.
+//
+// The world is read-only. Methods which modify it return ErrReadOnly.
+func OpenFS(fsys fs.FS) (*World, error) {
+	var err error
+
+	w := newWorld(".", fsys)
+
+	w.Level, err = anvil.LoadLevelFS(fsys, "level.dat")
+	if err != nil {
+		return nil, fmt.Errorf("mctools: load level.dat: %v", err)
+	}
+
+	return w, w.index()
+}
+
+// NewWorldFS creates a read-only world for the directory root in fsys.
+// The root is a slash separated path, as used by io/fs.
+//
+// Unlike OpenFS, this does not require a level.dat file. Level is nil if
+// the world has none, or it can not be read. This suits tools which only
+// look at chunk data, like those inspecting uploaded world archives,
+// which may hold no more than a few region files.
+//
+// Regions are read through the fs.FS interface. Files which do not support
+// seeking, like compressed zip entries, are read into memory first.
+// Methods which modify the world return ErrReadOnly.
+func NewWorldFS(fsys fs.FS, root string) *World {
+	w := newWorld(path.Clean(root), fsys)
+	w.Level, _ = anvil.LoadLevelFS(fsys, path.Join(w.root, "level.dat"))
+	w.index()
+	return w
+}
+
+// newWorld creates an empty world for the given root directory.
+// The directory is in fsys, if it is not nil.
+func newWorld(root string, fsys fs.FS) *World {
+	return &World{
+		root:    root,
+		fsys:    fsys,
+		regions: make(map[string][][2]int),
+		cache:   newRegionCache(DefaultRegionCacheSize),
+	}
+}
+
+// index finds all regions in all dimensions, including those defined
+// by datapacks. The entity and point of interest regions next to each
+// dimension's region directory are listed as dimensions of their own,
+// like "entities" or "DIM-1/poi", if there are any.
+func (w *World) index() error {
+	w.regions[DimensionOverworld] = w.listRegions(DimensionOverworld)
+	w.regions[DimensionNether] = w.listRegions(DimensionNether)
+	w.regions[DimensionEnd] = w.listRegions(DimensionEnd)
+
+	dims, err := anvil.ListDimensionsFS(w.files())
+	if err != nil {
+		return fmt.Errorf("mctools: list dimensions: %v", err)
+	}
+
+	for _, d := range dims {
+		w.regions[string(d)] = w.listRegions(string(d))
+
+		for _, dir := range []string{d.Entities(), d.Poi()} {
+			if regions := w.listRegions(dir); len(regions) > 0 {
+				w.regions[dir] = regions
+			}
+		}
+	}
+
+	return nil
+}
+
+// files returns the file system holding the world, along with the slash
+// separated path of the world's root directory in it.
+func (w *World) files() (fs.FS, string) {
+	if w.fsys != nil {
+		return w.fsys, w.root
+	}
+
+	if len(w.root) == 0 {
+		return os.DirFS("."), "."
+	}
+
+	return os.DirFS(w.root), "."
+}
+
+// Dimensions returns the ids of all dimensions in this world which have
+// a region directory, like "minecraft:overworld" or "mypack:mining".
+// This includes dimensions defined by datapacks.
+// Use World.Dimension to find the regions of a given dimension.
+func (w *World) Dimensions() []string {
+	dims, _ := anvil.ListDimensionsFS(w.files())
+
+	out := make([]string, len(dims))
+	for i, d := range dims {
+		out[i] = d.Id()
+	}
+
+	return out
+}
+
+// Dimension returns the dimension name, as used by World.Regions,
+// World.LoadRegion and friends, for the given dimension id.
+// Returns false if the world has no such dimension.
+func (w *World) Dimension(id string) (string, bool) {
+	d, ok := anvil.DimensionById(id)
+	if !ok {
+		return "", false
+	}
+
+	fsys, root := w.files()
+
+	fi, err := fs.Stat(fsys, path.Join(root, string(d)))
+	if err != nil || !fi.IsDir() {
+		return "", false
+	}
+
+	return string(d), true
 }
 
 // Save saves the level.dat information for this world.
 func (w *World) Save() error {
+	if w.fsys != nil {
+		return ErrReadOnly
+	}
+
 	return w.Level.Save(filepath.Join(w.root, "level.dat"))
 }
 
+// SaveAs writes a copy of the world into the new directory dst.
+//
+// Regions with pending changes made through World.WriteChunk are written
+// from memory. All other files, including level.dat and any non-region
+// data, are copied as they are. Each file is first written to a temporary
+// file, which is then renamed, so no partially written files are left
+// behind in dst.
+//
+// Pending changes are not written to the source world; they remain pending
+// until World.Close is called. Note that level.dat is copied from disk. Use
+// Level.Save to write a modified level into the new world.
+//
+// Returns an error if dst already exists or lies inside the world.
+func (w *World) SaveAs(dst string) error {
+	if w.fsys != nil {
+		return ErrReadOnly
+	}
+
+	src, err := filepath.Abs(w.root)
+	if err != nil {
+		return fmt.Errorf("mctools: save as: %v", err)
+	}
+
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return fmt.Errorf("mctools: save as: %v", err)
+	}
+
+	if rel, err := filepath.Rel(src, dst); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("mctools: save as: %q lies inside the world", dst)
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err == nil {
+		err = os.Mkdir(dst, 0755)
+	}
+
+	if err != nil {
+		return fmt.Errorf("mctools: save as: %v", err)
+	}
+
+	// Regions with pending changes, keyed by their path relative to the
+	// world root.
+	dirty := make(map[string]*cachedRegion)
+
+	for e := w.cache.order.Front(); e != nil; e = e.Next() {
+		cr := e.Value.(*cachedRegion)
+		if cr.dirty {
+			dirty[regionPath("", cr.key.dim, cr.key.x, cr.key.z)] = cr
+		}
+	}
+
+	err = filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case dirty[rel] != nil:
+			return nil // Written below.
+		case fi.Mode().IsRegular():
+			return copyFile(target, file, fi.Mode().Perm())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("mctools: save as: %v", err)
+	}
+
+	for rel, cr := range dirty {
+		target := filepath.Join(dst, rel)
+
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err == nil {
+			err = writeAtomic(target, 0644, cr.region.SaveAs)
+		}
+
+		if err != nil {
+			return fmt.Errorf("mctools: save as: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// copyFile atomically copies the file src to dst.
+func copyFile(dst, src string, mode os.FileMode) error {
+	return writeAtomic(dst, mode, func(tmp string) error {
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+
+		defer in.Close()
+
+		out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(out, in)
+		if err != nil {
+			out.Close()
+			return err
+		}
+
+		return out.Close()
+	})
+}
+
+// writeAtomic calls write with the name of a new, temporary file in the
+// same directory as file. Once write succeeds, the temporary file is
+// renamed to file. Otherwise it is removed.
+func writeAtomic(file string, mode os.FileMode, write func(tmp string) error) error {
+	fd, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	tmp := fd.Name()
+	fd.Close()
+
+	err = write(tmp)
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return err
+}
+
+// Close saves all regions with pending changes made through
+// World.WriteChunk and releases all cached regions.
+// The world can still be used afterwards; regions are loaded again
+// when needed. Regions which can not be saved stay cached, along with
+// their changes, so Close can be retried.
+func (w *World) Close() error {
+	err := w.cache.flush()
+	if err != nil {
+		return fmt.Errorf("mctools: close: %v", err)
+	}
+
+	return nil
+}
+
+// SetAbsentErrors determines how World.Chunk reports chunks which have not
+// been generated yet. By default, it returns a nil chunk without an error.
+// If enabled is true, it returns ErrRegionAbsent if the chunk's region does
+// not exist, or anvil.ErrChunkAbsent if the region does not hold the chunk.
+// This lets callers tell apart ungenerated areas from damaged chunks.
+func (w *World) SetAbsentErrors(enabled bool) { w.absentErrors = enabled }
+
+// Chunk returns the overworld chunk at the given, absolute chunk coordinates.
+// The region holding the chunk is loaded and cached as needed. At most
+// DefaultRegionCacheSize regions are kept loaded at any time.
+//
+// Returns nil without an error if the chunk has not been generated yet,
+// unless absent errors are enabled through World.SetAbsentErrors. Returns
+// an error if the chunk exists, but can not be decoded.
+func (w *World) Chunk(cx, cz int) (*anvil.Chunk, error) {
+	cr, err := w.cachedRegion(DimensionOverworld, cx, cz, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if cr == nil {
+		return nil, w.absent(ErrRegionAbsent)
+	}
+
+	var c anvil.Chunk
+
+	err = cr.region.DecodeChunk(cx, cz, &c)
+	switch {
+	case err == anvil.ErrChunkAbsent:
+		return nil, w.absent(err)
+	case err != nil:
+		return nil, fmt.Errorf("mctools: c(%d %d): %v", cx, cz, err)
+	}
+
+	return &c, nil
+}
+
+// ReadChunk reads the overworld chunk at the given, absolute chunk
+// coordinates into c. It is like World.Chunk, but reuses the slices held
+// by c, like anvil.Region.ReadChunk does.
+//
+// Returns false if the chunk has not been generated yet, or can not be
+// decoded.
+func (w *World) ReadChunk(cx, cz int, c *anvil.Chunk) bool {
+	cr, err := w.cachedRegion(DimensionOverworld, cx, cz, false)
+	if err != nil || cr == nil {
+		return false
+	}
+
+	return cr.region.ReadChunk(cx, cz, c)
+}
+
+// absent returns err if absent errors are enabled, and nil otherwise.
+func (w *World) absent(err error) error {
+	if w.absentErrors {
+		return err
+	}
+
+	return nil
+}
+
+// BiomeSet returns the names of all biomes used in the given dimension.
+// This only reads the biome palettes of paletted sections, so it is fast,
+// but a palette may hold biomes which are no longer used. Chunks written
+// through World.WriteChunk are included, even if they are not saved yet.
+func (w *World) BiomeSet(dim string) (map[string]bool, error) {
+	set := make(map[string]bool)
+
+	for _, xz := range w.regions[dim] {
+		region := w.cachedOnly(dim, xz[0], xz[1])
+
+		if region == nil {
+			var err error
+
+			region, err = w.LoadRegion(dim, xz[0], xz[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := region.BiomeSet(set)
+		if err != nil {
+			return nil, fmt.Errorf("mctools: biome set: %v", err)
+		}
+	}
+
+	return set, nil
+}
+
+// EachEntity calls fn for every entity in the given dimension, along with
+// the absolute coordinates of the chunk holding it. This reads the entity
+// regions written by Minecraft 1.17+. Older worlds keep their entities in
+// the chunks themselves; use World.Chunk for those.
+//
+// Iteration stops at the first error returned by fn, which is passed on.
+func (w *World) EachEntity(dim string, fn func(chunkPos [2]int, e anvil.Entity) error) error {
+	dir := anvil.Dimension(dim).Entities()
+	fsys, root := w.files()
+
+	for _, xz := range listFiles(fsys, path.Join(root, dir)) {
+		region, err := w.LoadRegion(dir, xz[0], xz[1])
+		if err != nil {
+			return err
+		}
+
+		for _, c := range region.Chunks() {
+			// The entities are handed to fn, so they are not reused.
+			var ec anvil.EntityChunk
+
+			err = region.DecodeEntities(c[0], c[1], &ec)
+			if err != nil {
+				return fmt.Errorf("mctools: each entity: %v", err)
+			}
+
+			pos := [2]int{
+				xz[0]*anvil.ChunksPerRegion + c[0],
+				xz[1]*anvil.ChunksPerRegion + c[1],
+			}
+
+			for _, e := range ec.Entities {
+				err = fn(pos, e)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// cachedOnly returns the given region if it is in the cache. This does not
+// change the order of the cache. Returns nil if it is not cached.
+func (w *World) cachedOnly(dim string, x, z int) *anvil.Region {
+	e, ok := w.cache.entries[regionKey{dim: dim, x: x, z: z}]
+	if !ok {
+		return nil
+	}
+
+	return e.Value.(*cachedRegion).region
+}
+
+// WriteChunk writes the given chunk to the overworld, at the given,
+// absolute chunk coordinates. The owning region is created if it does
+// not exist yet.
+//
+// The changes are persisted when the region is evicted from the cache,
+// or when World.Close is called.
+func (w *World) WriteChunk(cx, cz int, c *anvil.Chunk) error {
+	if w.fsys != nil {
+		return ErrReadOnly
+	}
+
+	cr, err := w.cachedRegion(DimensionOverworld, cx, cz, true)
+	if err != nil {
+		return err
+	}
+
+	cr.region.SetDataVersion(w.dataVersion)
+
+	if !cr.region.WriteChunk(cx, cz, c) {
+		return fmt.Errorf("mctools: c(%d %d): write chunk failed", cx, cz)
+	}
+
+	cr.dirty = true
+	return nil
+}
+
+// SetDataVersion sets the data version stamped into chunks written through
+// WriteChunk, if they have none of their own. Generated chunks should carry
+// the version of the game they are meant for; Minecraft upgrades chunks
+// with an older or missing version when it loads them.
+func (w *World) SetDataVersion(v int32) { w.dataVersion = v }
+
+// MoveChunk moves the chunk at the given, absolute, source coordinates to
+// the destination coordinates in the given dimension. These may be in
+// different regions. The destination region is created if it does not
+// exist yet. Refer to anvil.Region.MoveChunkTo for the position tags which
+// are rewritten.
+//
+// The changes are persisted when the regions are evicted from the cache,
+// or when World.Close is called.
+func (w *World) MoveChunk(dim string, srcX, srcZ, dstX, dstZ int) error {
+	if w.fsys != nil {
+		return ErrReadOnly
+	}
+
+	src, err := w.cachedRegion(dim, srcX, srcZ, false)
+	if err != nil {
+		return err
+	}
+
+	if src == nil || !src.region.HasChunk(srcX, srcZ) {
+		return fmt.Errorf("mctools: c(%d %d): %v", srcX, srcZ, anvil.ErrChunkAbsent)
+	}
+
+	dst, err := w.cachedRegion(dim, dstX, dstZ, true)
+	if err != nil {
+		return err
+	}
+
+	if !src.region.MoveChunkTo(dst.region, srcX, srcZ, dstX, dstZ) {
+		return fmt.Errorf("mctools: c(%d %d): move chunk failed", srcX, srcZ)
+	}
+
+	src.dirty = true
+	dst.dirty = true
+
+	// Loading the destination may have evicted the source region from
+	// the cache, before it was changed.
+	if w.cache.get(src.key) != src {
+		err = src.region.Save()
+		if err != nil {
+			return fmt.Errorf("mctools: save region: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// cachedRegion returns the cached region holding the given chunk.
+// The region is loaded if it is not in the cache. If it does not exist,
+// it is created when create is true. Otherwise nil is returned.
+func (w *World) cachedRegion(dim string, cx, cz int, create bool) (*cachedRegion, error) {
+	// There are 32 chunks per region along each axis.
+	key := regionKey{
+		dim: dim,
+		x:   cx >> 5,
+		z:   cz >> 5,
+	}
+
+	if cr := w.cache.get(key); cr != nil {
+		return cr, nil
+	}
+
+	var region *anvil.Region
+	var err error
+
+	switch {
+	case w.hasRegion(dim, key.x, key.z):
+		region, err = w.LoadRegion(dim, key.x, key.z)
+	case create:
+		region, err = w.CreateRegion(dim, key.x, key.z)
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return w.cache.add(key, region), nil
+}
+
+// hasRegion returns true if the given region exists.
+func (w *World) hasRegion(dim string, x, z int) bool {
+	for _, r := range w.regions[dim] {
+		if r[0] == x && r[1] == z {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Regions returns the coordinates for all regions in the world.
 // This yields a map which groups region X/Z pairs for each dimension.
 func (w *World) Regions() map[string][][2]int { return w.regions }
 
+// RegionFiles returns the paths of all region files in the world, sorted
+// by name. This includes the entity and point of interest regions. The
+// paths include the world's root directory. For worlds opened through
+// io/fs, they are slash separated paths in that file system.
+func (w *World) RegionFiles() []string {
+	var out []string
+
+	for dim, regions := range w.regions {
+		for _, xz := range regions {
+			if w.fsys != nil {
+				out = append(out, path.Join(w.root, dim, fmt.Sprintf("r.%d.%d.mca", xz[0], xz[1])))
+			} else {
+				out = append(out, w.regionFile(dim, xz[0], xz[1]))
+			}
+		}
+	}
+
+	sort.Strings(out)
+	return out
+}
+
 // DeleteRegion deletes the given region.
 //
 // If you have an open handle to this region, close it before calling this,
@@ -65,6 +650,12 @@ func (w *World) Regions() map[string][][2]int { return w.regions }
 // Note that this permanently deletes the region file from disk.
 // This operation can not be undone.
 func (w *World) DeleteRegion(dim string, x, z int) error {
+	if w.fsys != nil {
+		return ErrReadOnly
+	}
+
+	w.cache.discard(regionKey{dim: dim, x: x, z: z})
+
 	file := w.regionFile(dim, x, z)
 	err := os.Remove(file)
 
@@ -73,7 +664,7 @@ func (w *World) DeleteRegion(dim string, x, z int) error {
 	}
 
 	// Update region list.
-	w.regions[dim] = listFiles(w.root, dim)
+	w.regions[dim] = w.listRegions(dim)
 	return nil
 }
 
@@ -81,6 +672,10 @@ func (w *World) DeleteRegion(dim string, x, z int) error {
 // using the given coordinates.
 // Returns an error if the region already exists.
 func (w *World) CreateRegion(dim string, x, z int) (*anvil.Region, error) {
+	if w.fsys != nil {
+		return nil, ErrReadOnly
+	}
+
 	file := w.regionFile(dim, x, z)
 	region, err := anvil.CreateRegion(file)
 
@@ -89,15 +684,22 @@ func (w *World) CreateRegion(dim string, x, z int) (*anvil.Region, error) {
 	}
 
 	// Update region list.
-	w.regions[dim] = listFiles(w.root, dim)
+	w.regions[dim] = w.listRegions(dim)
 	return region, nil
 }
 
 // LoadRegion loads the given region in the specified dimension.
 // Returns nil if the region could not be loaded.
 func (w *World) LoadRegion(dim string, x, z int) (*anvil.Region, error) {
-	file := w.regionFile(dim, x, z)
-	region, err := anvil.LoadRegion(file)
+	var region *anvil.Region
+	var err error
+
+	if w.fsys != nil {
+		name := path.Join(w.root, dim, fmt.Sprintf("r.%d.%d.mca", x, z))
+		region, err = anvil.LoadRegionFS(w.fsys, name)
+	} else {
+		region, err = anvil.LoadRegion(w.regionFile(dim, x, z))
+	}
 
 	if err != nil {
 		return nil, fmt.Errorf("mctools: load region %d.%d: %v", x, z, err)
@@ -109,19 +711,25 @@ func (w *World) LoadRegion(dim string, x, z int) (*anvil.Region, error) {
 // regionFile returns the full region file path for the given dimension and
 // coordinates.
 func (w *World) regionFile(dim string, x, z int) string {
-	file := filepath.Join(w.root, dim)
-	return filepath.Join(file, fmt.Sprintf("r.%d.%d.mca", x, z))
+	return regionPath(w.root, dim, x, z)
 }
 
-func listFiles(root, dimension string) [][2]int {
-	fd, err := os.Open(filepath.Join(root, dimension))
-	if err != nil {
-		return nil
-	}
+// regionPath returns the path of the given region, relative to root.
+func regionPath(root, dim string, x, z int) string {
+	file := filepath.Join(root, dim)
+	return filepath.Join(file, fmt.Sprintf("r.%d.%d.mca", x, z))
+}
 
-	files, err := fd.Readdirnames(-1)
-	fd.Close()
+// listRegions returns the coordinates of all regions in the given dimension.
+func (w *World) listRegions(dim string) [][2]int {
+	fsys, root := w.files()
+	return listFiles(fsys, path.Join(root, dim))
+}
 
+// listFiles returns the coordinates of all regions in the given directory
+// of fsys.
+func listFiles(fsys fs.FS, dir string) [][2]int {
+	files, err := fs.ReadDir(fsys, dir)
 	if err != nil {
 		return nil
 	}
@@ -129,8 +737,8 @@ func listFiles(root, dimension string) [][2]int {
 	out := make([][2]int, 0, len(files))
 
 	for _, f := range files {
-		if path.Ext(f) == anvil.RegionFileExtension {
-			rx, rz, ok := anvil.RegionCoords(f)
+		if path.Ext(f.Name()) == anvil.RegionFileExtension {
+			rx, rz, ok := anvil.RegionCoords(f.Name())
 			if ok {
 				out = append(out, [2]int{rx, rz})
 			}
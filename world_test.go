@@ -0,0 +1,723 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package mctools
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jteeuwen/mctools/anvil"
+)
+
+func TestWorldChunk(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// Keep a single region loaded, so every region switch evicts.
+	w.cache = newRegionCache(1)
+
+	c, err := w.Chunk(0, 0)
+	if err != nil || c == nil {
+		t.Fatalf("Chunk(0, 0): %v %v", c, err)
+	}
+
+	c.X, c.Z = 40, -3
+	err = w.WriteChunk(40, -3, c)
+	if err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// Loading the first region again must save the new one.
+	_, err = w.Chunk(0, 0)
+	if err != nil {
+		t.Fatalf("Chunk(0, 0): %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(root, DimensionOverworld, "r.1.-1.mca"))
+	if err != nil {
+		t.Fatalf("evicted region not saved: %v", err)
+	}
+
+	c, err = w.Chunk(40, -3)
+	if err != nil || c == nil {
+		t.Fatalf("Chunk(40, -3): %v %v", c, err)
+	}
+
+	if c.X != 40 || c.Z != -3 {
+		t.Fatalf("position mismatch: have (%d %d), want (40 -3)", c.X, c.Z)
+	}
+
+	c, err = w.Chunk(-1000, 1000)
+	if err != nil || c != nil {
+		t.Fatalf("Chunk(-1000, 1000): want nil chunk, have %v %v", c, err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestWorldSaveError(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	w.cache = newRegionCache(1)
+
+	c, err := w.Chunk(0, 0)
+	if err != nil || c == nil {
+		t.Fatalf("Chunk(0, 0): %v %v", c, err)
+	}
+
+	c.X, c.Z = 40, -3
+	err = w.WriteChunk(40, -3, c)
+	if err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// A directory in place of the region file makes saving it fail.
+	file := filepath.Join(root, DimensionOverworld, "r.1.-1.mca")
+	os.Remove(file)
+	if err = os.Mkdir(file, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed eviction does not affect other regions.
+	c, err = w.Chunk(0, 0)
+	if err != nil || c == nil {
+		t.Fatalf("Chunk(0, 0): %v %v", c, err)
+	}
+
+	if err = w.Close(); err == nil {
+		t.Fatal("Close: expected an error")
+	}
+
+	// The changes are kept, so saving can be retried.
+	if err = os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	c, err = w.Chunk(40, -3)
+	if err != nil || c == nil || c.X != 40 || c.Z != -3 {
+		t.Fatalf("Chunk(40, -3): %v %v", c, err)
+	}
+}
+
+func TestWorldReadChunk(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	var c anvil.Chunk
+	if !w.ReadChunk(0, 0, &c) {
+		t.Fatalf("ReadChunk(0, 0) failed")
+	}
+
+	if c.X != 0 || c.Z != 0 {
+		t.Fatalf("position mismatch: have (%d %d), want (0 0)", c.X, c.Z)
+	}
+
+	c.X, c.Z = -1, 33
+	err = w.WriteChunk(-1, 33, &c)
+	if err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	if !w.ReadChunk(-1, 33, &c) || c.X != -1 || c.Z != 33 {
+		t.Fatalf("ReadChunk(-1, 33): have (%d %d), want (-1 33)", c.X, c.Z)
+	}
+
+	if w.ReadChunk(-1000, 1000, &c) {
+		t.Fatalf("ReadChunk(-1000, 1000): want false")
+	}
+}
+
+func TestWorldRegionFiles(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	for _, file := range []string{"entities/r.-1.2.mca", "poi/r.0.0.mca"} {
+		file = filepath.Join(root, filepath.FromSlash(file))
+
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := anvil.CreateRegion(file)
+		if err == nil {
+			err = r.Save()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	have := w.RegionFiles()
+	want := []string{
+		filepath.Join(root, "entities", "r.-1.2.mca"),
+		filepath.Join(root, "poi", "r.0.0.mca"),
+		filepath.Join(root, "region", "r.0.0.mca"),
+	}
+
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("region files mismatch:\nhave %q\nwant %q", have, want)
+	}
+
+	if regions := w.Regions()["entities"]; len(regions) != 1 || regions[0] != [2]int{-1, 2} {
+		t.Fatalf("unexpected entity regions: %v", regions)
+	}
+
+	if _, err = w.LoadRegion("poi", 0, 0); err != nil {
+		t.Fatalf("LoadRegion: %v", err)
+	}
+}
+
+func TestWorldAbsentErrors(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	r, err := w.LoadRegion(DimensionOverworld, 0, 0)
+	if err != nil {
+		t.Fatalf("LoadRegion: %v", err)
+	}
+
+	// Find a chunk which has not been generated.
+	var cx, cz int
+	for cx = 0; cx < anvil.ChunksPerRegion && r.HasChunk(cx, cz); cx++ {
+	}
+
+	if cx == anvil.ChunksPerRegion {
+		t.Fatalf("region has no absent chunks in row 0")
+	}
+
+	if c, err := w.Chunk(cx, cz); c != nil || err != nil {
+		t.Fatalf("Chunk(%d, %d): want nil chunk, have %v %v", cx, cz, c, err)
+	}
+
+	w.SetAbsentErrors(true)
+
+	if _, err := w.Chunk(cx, cz); err != anvil.ErrChunkAbsent {
+		t.Fatalf("Chunk(%d, %d): have %v, want %v", cx, cz, err, anvil.ErrChunkAbsent)
+	}
+
+	if _, err := w.Chunk(-1000, 1000); err != ErrRegionAbsent {
+		t.Fatalf("Chunk(-1000, 1000): have %v, want %v", err, ErrRegionAbsent)
+	}
+
+	if c, err := w.Chunk(0, 0); c == nil || err != nil {
+		t.Fatalf("Chunk(0, 0): %v %v", c, err)
+	}
+}
+
+func TestWorldBiomeSet(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	set, err := w.BiomeSet(DimensionOverworld)
+	if err != nil {
+		t.Fatalf("BiomeSet: %v", err)
+	}
+
+	// The test world predates paletted sections.
+	if len(set) != 0 {
+		t.Fatalf("unexpected biomes: %v", set)
+	}
+
+	c, err := w.Chunk(0, 0)
+	if err != nil || c == nil {
+		t.Fatalf("Chunk(0, 0): %v %v", c, err)
+	}
+
+	c.SetBiome(0, 200, 0, "minecraft:desert")
+
+	err = w.WriteChunk(0, 0, c)
+	if err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	want := map[string]bool{anvil.DefaultBiome: true, "minecraft:desert": true}
+
+	// Pending changes are included, as are saved ones.
+	for i := 0; i < 2; i++ {
+		set, err = w.BiomeSet(DimensionOverworld)
+		if err != nil {
+			t.Fatalf("BiomeSet: %v", err)
+		}
+
+		if !reflect.DeepEqual(set, want) {
+			t.Fatalf("biome mismatch: have %v, want %v", set, want)
+		}
+
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+}
+
+func TestWorldEachEntity(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+	dir := filepath.Join(root, "entities")
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := anvil.CreateRegion(filepath.Join(dir, "r.-1.2.mca"))
+	if err != nil {
+		t.Fatalf("CreateRegion: %v", err)
+	}
+
+	r.WriteEntities(0, 1, &anvil.EntityChunk{Entities: []anvil.Entity{{Id: "minecraft:cow"}, {Id: "minecraft:pig"}}})
+	r.WriteEntities(5, 0, &anvil.EntityChunk{Entities: []anvil.Entity{{Id: "minecraft:item"}}})
+
+	err = r.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	have := make(map[string][2]int)
+
+	err = w.EachEntity(DimensionOverworld, func(pos [2]int, e anvil.Entity) error {
+		have[e.Id] = pos
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachEntity: %v", err)
+	}
+
+	want := map[string][2]int{
+		"minecraft:cow":  {-32, 65},
+		"minecraft:pig":  {-32, 65},
+		"minecraft:item": {-27, 64},
+	}
+
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("entity mismatch: have %v, want %v", have, want)
+	}
+
+	// Errors returned by fn stop the iteration.
+	var n int
+
+	err = w.EachEntity(DimensionOverworld, func(pos [2]int, e anvil.Entity) error {
+		n++
+		return fs.ErrInvalid
+	})
+	if err != fs.ErrInvalid || n != 1 {
+		t.Fatalf("have %v after %d entities, want %v after 1", err, n, fs.ErrInvalid)
+	}
+
+	// Dimensions without entity regions have no entities.
+	err = w.EachEntity(DimensionNether, func(pos [2]int, e anvil.Entity) error {
+		t.Fatalf("unexpected entity %v", e.Id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachEntity: %v", err)
+	}
+}
+
+func TestWorldMoveChunk(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	for _, size := range []int{DefaultRegionCacheSize, 1} {
+		w, err := Open(root)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+
+		// A single entry cache evicts the source region when the
+		// destination is loaded.
+		w.cache = newRegionCache(size)
+
+		sx, sz := 0, 0
+		dx, dz := 40, -3
+
+		if size == 1 {
+			sx, sz, dx, dz = dx, dz, sx, sz
+		}
+
+		err = w.MoveChunk(DimensionOverworld, sx, sz, dx, dz)
+		if err != nil {
+			t.Fatalf("cache size %d: MoveChunk: %v", size, err)
+		}
+
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		w, err = Open(root)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+
+		c, err := w.Chunk(dx, dz)
+		if err != nil || c == nil {
+			t.Fatalf("cache size %d: Chunk(%d, %d): %v %v", size, dx, dz, c, err)
+		}
+
+		if int(c.X) != dx || int(c.Z) != dz {
+			t.Fatalf("cache size %d: position mismatch: have (%d %d)", size, c.X, c.Z)
+		}
+
+		c, err = w.Chunk(sx, sz)
+		if err != nil || c != nil {
+			t.Fatalf("cache size %d: source chunk still exists: %v", size, err)
+		}
+	}
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if err := w.MoveChunk(DimensionOverworld, 500, 500, 0, 0); err == nil {
+		t.Fatalf("expected an error for a missing chunk")
+	}
+}
+
+func TestWorldSaveAs(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	c, err := w.Chunk(0, 0)
+	if err != nil || c == nil {
+		t.Fatalf("Chunk(0, 0): %v %v", c, err)
+	}
+
+	c.X, c.Z = 40, -3
+	err = w.WriteChunk(40, -3, c)
+	if err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "out")
+
+	err = w.SaveAs(dst)
+	if err != nil {
+		t.Fatalf("SaveAs: %v", err)
+	}
+
+	// Unchanged files are copied as they are.
+	for _, f := range []string{"level.dat", filepath.Join(DimensionOverworld, "r.0.0.mca")} {
+		want, err := ioutil.ReadFile(filepath.Join(root, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		have, err := ioutil.ReadFile(filepath.Join(dst, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(have, want) {
+			t.Fatalf("%s: copy mismatch", f)
+		}
+	}
+
+	// The modified region is written from memory.
+	out, err := Open(dst)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	c, err = out.Chunk(40, -3)
+	if err != nil || c == nil {
+		t.Fatalf("Chunk(40, -3): %v %v", c, err)
+	}
+
+	if c.X != 40 || c.Z != -3 {
+		t.Fatalf("position mismatch: have (%d %d), want (40 -3)", c.X, c.Z)
+	}
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(filepath.Join(dst, DimensionOverworld))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("unexpected region files: %d", len(files))
+	}
+
+	// The destination must be new, and not inside the world.
+	if err = w.SaveAs(dst); err == nil {
+		t.Fatalf("expected error for existing destination")
+	}
+
+	for _, name := range []string{"copy", "..x"} {
+		if err = w.SaveAs(filepath.Join(root, name)); err == nil {
+			t.Fatalf("expected error for destination %q inside the world", name)
+		}
+
+		if _, err = os.Stat(filepath.Join(root, name)); err == nil {
+			t.Fatalf("destination %q was created", name)
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestWorldDimensions(t *testing.T) {
+	root := copyWorld(t, "testdata/newworld")
+
+	custom := filepath.Join(root, "dimensions", "mypack", "mining", "region")
+	err := os.MkdirAll(custom, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile("testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(custom, "r.-1.0.mca"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	ids := w.Dimensions()
+	if len(ids) != 2 || ids[0] != "minecraft:overworld" || ids[1] != "mypack:mining" {
+		t.Fatalf("unexpected dimensions: %q", ids)
+	}
+
+	dim, ok := w.Dimension("mypack:mining")
+	if !ok {
+		t.Fatalf("custom dimension not found")
+	}
+
+	regions := w.Regions()[dim]
+	if len(regions) != 1 || regions[0] != [2]int{-1, 0} {
+		t.Fatalf("unexpected regions: %v", regions)
+	}
+
+	r, err := w.LoadRegion(dim, -1, 0)
+	if err != nil {
+		t.Fatalf("LoadRegion: %v", err)
+	}
+
+	if r.ChunkLen() == 0 {
+		t.Fatalf("custom dimension region has no chunks")
+	}
+
+	if _, ok := w.Dimension("minecraft:the_end"); ok {
+		t.Fatalf("unexpected end dimension")
+	}
+}
+
+// copyWorld copies the level.dat and the r.0.0 overworld region of the
+// given world into a temporary directory. Returns the new world root.
+func TestOpenFS(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range []string{"level.dat", "region/r.0.0.mca"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata/newworld", f))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fw, err := zw.Create(f)
+		if err == nil {
+			_, err = fw.Write(data)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := OpenFS(zr)
+	if err != nil {
+		t.Fatalf("OpenFS: %v", err)
+	}
+
+	if w.Level == nil || len(w.Name) == 0 {
+		t.Fatalf("level.dat not loaded")
+	}
+
+	if regions := w.Regions()[DimensionOverworld]; len(regions) != 1 || regions[0] != [2]int{0, 0} {
+		t.Fatalf("unexpected regions: %v", regions)
+	}
+
+	if dims := w.Dimensions(); len(dims) != 1 || dims[0] != anvil.OverworldId {
+		t.Fatalf("unexpected dimensions: %v", dims)
+	}
+
+	c, err := w.Chunk(0, 0)
+	if err != nil || c == nil {
+		t.Fatalf("Chunk(0, 0): %v %v", c, err)
+	}
+
+	if err := w.WriteChunk(0, 0, c); err != ErrReadOnly {
+		t.Fatalf("WriteChunk: have %v, want %v", err, ErrReadOnly)
+	}
+
+	if err := w.Save(); err != ErrReadOnly {
+		t.Fatalf("Save: have %v, want %v", err, ErrReadOnly)
+	}
+
+	r, err := w.LoadRegion(DimensionOverworld, 0, 0)
+	if err != nil {
+		t.Fatalf("LoadRegion: %v", err)
+	}
+
+	if r.Save() == nil {
+		t.Fatalf("expected error saving read-only region")
+	}
+}
+
+func TestNewWorldFS(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/newworld/region/r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{
+		"saves/world/region/r.0.0.mca": &fstest.MapFile{Data: data},
+	}
+
+	// Regions are read the same, whether or not the files can seek.
+	for _, fsys := range []fs.FS{fsys, noSeekFS{fsys}} {
+		w := NewWorldFS(fsys, "saves/world/")
+
+		if w.Level != nil {
+			t.Fatalf("unexpected level: %v", w.Level)
+		}
+
+		if regions := w.Regions()[DimensionOverworld]; len(regions) != 1 {
+			t.Fatalf("unexpected regions: %v", regions)
+		}
+
+		c, err := w.Chunk(0, 0)
+		if err != nil || c == nil {
+			t.Fatalf("Chunk(0, 0): %v %v", c, err)
+		}
+
+		c, err = w.Chunk(100, 100)
+		if err != nil || c != nil {
+			t.Fatalf("Chunk(100, 100): want nil chunk, have %v %v", c, err)
+		}
+
+		if _, err := w.CreateRegion(DimensionOverworld, 1, 1); err != ErrReadOnly {
+			t.Fatalf("CreateRegion: have %v, want %v", err, ErrReadOnly)
+		}
+
+		if err := w.DeleteRegion(DimensionOverworld, 0, 0); err != ErrReadOnly {
+			t.Fatalf("DeleteRegion: have %v, want %v", err, ErrReadOnly)
+		}
+	}
+}
+
+// noSeekFS hides the Seek method of the files in the underlying fs.FS.
+type noSeekFS struct {
+	fsys fs.FS
+}
+
+func (n noSeekFS) Open(name string) (fs.File, error) {
+	f, err := n.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct{ fs.File }{f}, nil
+}
+
+func (n noSeekFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(n.fsys, name)
+}
+
+func copyWorld(t *testing.T, src string) string {
+	dst := t.TempDir()
+
+	files := []string{
+		"level.dat",
+		filepath.Join(DimensionOverworld, "r.0.0"+anvil.RegionFileExtension),
+	}
+
+	err := os.MkdirAll(filepath.Join(dst, DimensionOverworld), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(src, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dst, f), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dst
+}